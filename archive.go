@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 归档原因：manual 为管理员手动归档，inactive 为保留策略自动归档长期未上报的客户端
+const (
+	archiveManual   = "manual"
+	archiveInactive = "inactive"
+)
+
+// ClientArchive 客户端的归档信息。归档的客户端默认不出现在列表、导出与库存指标中，
+// 数据仍然保留，可以恢复；归档后再次上报的客户端会自动恢复
+type ClientArchive struct {
+	Reason     string    `json:"reason"`
+	Note       string    `json:"note,omitempty"`
+	ArchivedBy string    `json:"archived_by,omitempty"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// archiveSelect 读取归档信息的列（client_archive 别名为 a），与 archiveRow.dest 对应
+const archiveSelect = `IFNULL(a.reason, ''), IFNULL(a.note, ''), IFNULL(a.archived_by, ''), IFNULL(UNIX_TIMESTAMP(a.archived_at), 0)`
+
+// archiveRow 扫描 archiveSelect 的中间结果
+type archiveRow struct {
+	a  ClientArchive
+	at int64
+}
+
+func (r *archiveRow) dest() []interface{} {
+	return []interface{}{&r.a.Reason, &r.a.Note, &r.a.ArchivedBy, &r.at}
+}
+
+// value 未归档时返回 nil
+func (r *archiveRow) value() *ClientArchive {
+	if r.a.Reason == "" {
+		return nil
+	}
+	a := r.a
+	if r.at > 0 {
+		a.ArchivedAt = time.Unix(r.at, 0)
+	}
+	return &a
+}
+
+// ArchiveClient 手动归档客户端，已归档时保留原有的归档信息；客户端不存在时返回 sql.ErrNoRows
+func (db *Database) ArchiveClient(id int, note, actor string) (*ClientArchive, error) {
+	_, err := db.conn.Exec(`INSERT IGNORE INTO client_archive (client_id, reason, note, archived_by)
+		SELECT id, ?, ?, ? FROM client_info WHERE id = ?`, archiveManual, note, actor, id)
+	if err != nil {
+		return nil, fmt.Errorf("归档客户端失败: %v", err)
+	}
+	// 未插入且没有归档记录说明客户端不存在，此时返回 sql.ErrNoRows
+	return db.GetClientArchive(id)
+}
+
+// RestoreClient 恢复已归档的客户端，返回是否确实处于归档状态
+func (db *Database) RestoreClient(id int) (bool, error) {
+	res, err := db.conn.Exec(`DELETE FROM client_archive WHERE client_id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("恢复客户端失败: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetClientArchive 读取客户端的归档信息，未归档时返回 sql.ErrNoRows
+func (db *Database) GetClientArchive(id int) (*ClientArchive, error) {
+	var row archiveRow
+	err := db.conn.QueryRow(`SELECT `+archiveSelect+` FROM client_archive a WHERE a.client_id = ?`, id).Scan(row.dest()...)
+	if err != nil {
+		return nil, err
+	}
+	return row.value(), nil
+}
+
+// restoreOnReport 归档的客户端重新上报时自动恢复
+func (db *Database) restoreOnReport(id int) error {
+	restored, err := db.RestoreClient(id)
+	if err != nil {
+		return err
+	}
+	if restored {
+		slog.Info("已归档的客户端重新上报，已自动恢复", "client_id", id)
+	}
+	return nil
+}
+
+// archiveRequest POST /api/clients/{id}/archive 的请求体，可以省略
+type archiveRequest struct {
+	Note string `json:"note"`
+}
+
+// handleArchiveClient 手动归档客户端
+func handleArchiveClient(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleOperator)
+		if !ok {
+			return
+		}
+		var req archiveRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的JSON数据: %v", err))
+			return
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if utf8.RuneCountInString(req.Note) > maxMetadataFieldLen {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("note 不能超过 %d 个字符", maxMetadataFieldLen))
+			return
+		}
+		actor := adminActor(r)
+		a, err := db.ArchiveClient(id, req.Note, actor)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "客户端不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "归档客户端失败", "client_id", id, "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "已归档客户端", "client_id", id, "by", actor)
+		auditNote(r, "", req.Note)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// handleRestoreClient 恢复已归档的客户端
+func handleRestoreClient(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleOperator)
+		if !ok {
+			return
+		}
+		restored, err := db.RestoreClient(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "恢复客户端失败", "client_id", id, "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if !restored {
+			writeJSONError(w, http.StatusConflict, "客户端未归档")
+			return
+		}
+		slog.InfoContext(r.Context(), "已恢复客户端", "client_id", id, "by", adminActor(r))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "客户端已恢复"})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AuditEntry 审计日志记录
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Actor      string    `json:"actor"`
+	AuthMethod string    `json:"auth_method"`
+	Role       string    `json:"role"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Detail     string    `json:"detail"`
+	RemoteAddr string    `json:"remote_addr"`
+	RequestID  string    `json:"request_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AuditFilter 审计日志查询条件
+type AuditFilter struct {
+	Actor   string
+	AfterID int64
+	Limit   int
+}
+
+// auditSkipPaths 客户端上报接口，请求量大且不是管理操作，不记录审计日志
+var auditSkipPaths = map[string]bool{
+	"/api/client":        true,
+	"/api/update/events": true,
+}
+
+// auditAlwaysPaths 即使是只读请求也记录审计日志的接口
+var auditAlwaysPaths = map[string]bool{
+	"/api/export":    true,
+	oidcCallbackPath: true,
+}
+
+// auditKey 审计记录在 context 中的键，处理函数可通过 auditNote 补充操作人与说明
+type auditKey struct{}
+
+// auditNote 补充当前请求的审计记录：actor 非空时覆盖操作人（如登录时的用户名），detail 为说明
+func auditNote(r *http.Request, actor, detail string) {
+	if e, ok := r.Context().Value(auditKey{}).(*AuditEntry); ok {
+		if actor != "" {
+			e.Actor = actor
+		}
+		if detail != "" {
+			e.Detail = detail
+		}
+	}
+}
+
+// auditRequests 记录修改类请求（客户端上报除外）、导出与 OIDC 登录回调的审计日志，包括被拒绝的请求。
+// 需位于 authenticate 之后，以便取得请求的身份
+func auditRequests(db *Database) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			audited := !safeMethod(r.Method) && !auditSkipPaths[r.URL.Path] || auditAlwaysPaths[r.URL.Path]
+			if !audited {
+				next.ServeHTTP(w, r)
+				return
+			}
+			e := &AuditEntry{Actor: "-", Method: r.Method, Path: r.URL.RequestURI(), RemoteAddr: remoteHost(r),
+				RequestID: RequestID(r.Context())}
+			if p := principalFrom(r.Context()); p != nil {
+				e.Actor, e.AuthMethod, e.Role = p.Name, p.Method, p.Role
+			}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, e)))
+			e.Status = rec.status
+			if err := db.SaveAudit(e); err != nil {
+				slog.ErrorContext(r.Context(), "记录审计日志失败", "err", err)
+			}
+		})
+	}
+}
+
+// truncate 截断字符串到最多 n 个字符
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// SaveAudit 保存审计日志
+func (db *Database) SaveAudit(e *AuditEntry) error {
+	_, err := db.conn.Exec(`INSERT INTO audit_log (actor, auth_method, role, method, path, status, detail, remote_addr, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		truncate(e.Actor, 64), e.AuthMethod, e.Role, e.Method, truncate(e.Path, 255), e.Status,
+		truncate(e.Detail, 255), e.RemoteAddr, e.RequestID)
+	return err
+}
+
+// ListAudit 按条件查询审计日志
+func (db *Database) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	query := `SELECT id, actor, auth_method, IFNULL(role, ''), method, path, status, IFNULL(detail, ''),
+		IFNULL(remote_addr, ''), IFNULL(request_id, ''), UNIX_TIMESTAMP(created_at) FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if f.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if f.AfterID > 0 {
+		query += ` AND id > ? ORDER BY id LIMIT ?`
+		args = append(args, f.AfterID, f.Limit)
+	} else {
+		query += ` ORDER BY id DESC LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询审计日志失败: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var created int64
+		if err := rows.Scan(&e.ID, &e.Actor, &e.AuthMethod, &e.Role, &e.Method, &e.Path, &e.Status, &e.Detail,
+			&e.RemoteAddr, &e.RequestID, &created); err != nil {
+			return nil, fmt.Errorf("读取审计日志失败: %v", err)
+		}
+		e.CreatedAt = time.Unix(created, 0)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// handleListAudit 查询审计日志，支持 actor 筛选，after_id 用于增量轮询
+func handleListAudit(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		q := r.URL.Query()
+		f := AuditFilter{Actor: strings.TrimSpace(q.Get("actor")), Limit: 100}
+		if v := q.Get("after_id"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "无效的 after_id")
+				return
+			}
+			f.AfterID = n
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				writeJSONError(w, http.StatusBadRequest, "limit 需在 1-1000 之间")
+				return
+			}
+			f.Limit = n
+		}
+		entries, err := db.ListAudit(f)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询审计日志失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
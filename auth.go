@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"goup-server/internal/ldapauth"
+)
+
+// 角色，权限依次递增：viewer 可查看客户端、分组、事实并导出；operator 另可编辑元数据、维护静态分组成员与导入资产表；
+// admin 另可管理更新包、发布策略、分组定义、用户、API 密钥并查看审计日志
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+)
+
+// roleRanks 角色的权限等级
+var roleRanks = map[string]int{roleViewer: 1, roleOperator: 2, roleAdmin: 3}
+
+const (
+	// sessionCookie 网页登录会话的 Cookie 名称
+	sessionCookie = "goup_session"
+	// apiKeyPrefix API 密钥的前缀，完整格式为 gup_<8位查找前缀>_<密钥>
+	apiKeyPrefix = "gup_"
+	// bcryptCost 密码哈希的计算强度
+	bcryptCost = 12
+	// 密码长度限制，bcrypt 只使用前 72 字节
+	minPasswordLen = 8
+	maxPasswordLen = 72
+)
+
+// Principal 请求的身份
+type Principal struct {
+	UserID int    `json:"user_id,omitempty"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	// 可访问的分组，为空表示不限
+	Groups []int `json:"groups"`
+	// 认证方式：token（配置文件中的管理令牌）、api_key、session、anonymous
+	Method string `json:"method"`
+	// 使用 API 密钥时为密钥 ID
+	KeyID int `json:"key_id,omitempty"`
+}
+
+// Has 是否拥有指定角色的权限
+func (p *Principal) Has(role string) bool {
+	return roleRanks[p.Role] >= roleRanks[role]
+}
+
+// Scoped 是否只能访问部分分组
+func (p *Principal) Scoped() bool {
+	return len(p.Groups) > 0
+}
+
+// CanSeeGroup 是否可以访问指定分组
+func (p *Principal) CanSeeGroup(id int) bool {
+	if !p.Scoped() {
+		return true
+	}
+	for _, g := range p.Groups {
+		if g == id {
+			return true
+		}
+	}
+	return false
+}
+
+// principalKey 身份在 context 中的键
+type principalKey struct{}
+
+// principalFrom 返回 context 中的身份，未认证时为 nil
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// authenticate 识别请求的身份并放入 context。依次尝试 Authorization: Bearer 中的管理令牌、API 密钥与 OIDC JWT、
+// 会话 Cookie，都没有时按 auth.anonymous_role 视为匿名用户；提供了无效的 API 密钥或 JWT 时不回退为匿名。
+// 以 Cookie 认证的修改类请求必须带 X-Requested-With 请求头，防止跨站请求伪造
+func authenticate(db *Database, store *ConfigStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := db.resolvePrincipal(store, r)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "识别请求身份失败", "err", err)
+				http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+				return
+			}
+			if p != nil {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (db *Database) resolvePrincipal(store *ConfigStore, r *http.Request) (*Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if token := bearerToken(authorization); token != "" {
+		if store.CheckAdminToken(authorization) {
+			return &Principal{Name: tokenActor(r, token), Role: roleAdmin, Method: "token"}, nil
+		}
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			return db.apiKeyPrincipal(token)
+		}
+		if oc := &store.Get().Auth.OIDC; oc.provider != nil && len(oc.BearerAudiences) > 0 && isJWT(token) {
+			return oidcBearerPrincipal(r.Context(), oc, token), nil
+		}
+		// 其他令牌（如客户端上报令牌）由各接口自行校验
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		if safeMethod(r.Method) || r.Header.Get("X-Requested-With") != "" {
+			p, err := db.sessionPrincipal(c.Value)
+			if err != nil || p != nil {
+				return p, err
+			}
+		}
+	}
+	if role := store.Get().Auth.AnonymousRole; role != "" {
+		return &Principal{Name: "anonymous", Role: role, Method: "anonymous"}, nil
+	}
+	return nil, nil
+}
+
+// safeMethod 是否为只读的请求方法
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// bearerToken 取出 Authorization: Bearer 中的令牌
+func bearerToken(authorization string) string {
+	return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+// maxActorLen 操作人名称的最大长度
+const maxActorLen = 64
+
+// tokenActor 返回使用管理令牌的操作人：以令牌的指纹标识，不记录令牌本身。
+// X-Goup-User 请求头可由持有者任意填写，只作为未经验证的提示附在指纹之后
+func tokenActor(r *http.Request, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	actor := "token:" + hex.EncodeToString(sum[:4])
+	if u := strings.TrimSpace(r.Header.Get("X-Goup-User")); u != "" {
+		actor = truncate(actor+" ("+u, maxActorLen-1) + ")"
+	}
+	return actor
+}
+
+// adminActor 返回请求的操作人，用于记录变更
+func adminActor(r *http.Request) string {
+	if p := principalFrom(r.Context()); p != nil {
+		return p.Name
+	}
+	return "anonymous"
+}
+
+// requireRole 确认请求拥有指定角色的权限：未登录返回 401，权限不足返回 403
+func requireRole(w http.ResponseWriter, r *http.Request, role string) (*Principal, bool) {
+	p := principalFrom(r.Context())
+	if p == nil || p.Method == "anonymous" && !p.Has(role) {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !p.Has(role) {
+		http.Error(w, "权限不足，需要 "+role+" 角色", http.StatusForbidden)
+		return nil, false
+	}
+	return p, true
+}
+
+// requireUnscoped 拒绝只能访问部分分组的账号，用于作用于全部客户端的操作（如导入资产表）
+func requireUnscoped(w http.ResponseWriter, p *Principal) bool {
+	if p.Scoped() {
+		http.Error(w, "该操作需要不限分组的账号", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireClient 确认请求拥有指定角色的权限，并且路径中的客户端在其可访问的分组内；
+// 不在范围内时与客户端不存在一样返回 404
+func requireClient(db *Database, w http.ResponseWriter, r *http.Request, role string) (*Principal, int, bool) {
+	p, ok := requireRole(w, r, role)
+	if !ok {
+		return nil, 0, false
+	}
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	in, err := db.ClientInScope(id, p.Groups)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "查询客户端分组失败", "client_id", id, "err", err)
+		http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+		return nil, 0, false
+	}
+	if !in {
+		writeJSONError(w, http.StatusNotFound, "客户端不存在")
+		return nil, 0, false
+	}
+	return p, id, true
+}
+
+// scopeClause 返回把客户端限制在指定分组内的查询条件，column 为客户端 ID 列；groups 为空时不限制
+func scopeClause(column string, groups []int) (string, []interface{}) {
+	if len(groups) == 0 {
+		return "", nil
+	}
+	args := make([]interface{}, len(groups))
+	for i, g := range groups {
+		args[i] = g
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(groups)), ", ")
+	return ` AND ` + column + ` IN (SELECT client_id FROM client_group_members WHERE group_id IN (` + placeholders + `))`, args
+}
+
+// ClientInScope 客户端是否属于指定分组之一；groups 为空时只检查客户端是否存在
+func (db *Database) ClientInScope(clientID int, groups []int) (bool, error) {
+	clause, args := scopeClause("c.id", groups)
+	var id int
+	err := db.conn.QueryRow(`SELECT c.id FROM client_info c WHERE c.id = ?`+clause,
+		append([]interface{}{clientID}, args...)...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// parseScope 解析逗号分隔的分组 ID
+func parseScope(s string) []int {
+	var groups []int
+	for _, v := range strings.Split(s, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && id > 0 {
+			groups = append(groups, id)
+		}
+	}
+	return groups
+}
+
+// formatScope 以逗号连接分组 ID
+func formatScope(groups []int) string {
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		parts[i] = strconv.Itoa(g)
+	}
+	return strings.Join(parts, ",")
+}
+
+// validatePassword 检查密码长度
+func validatePassword(password string) error {
+	if len(password) < minPasswordLen {
+		return fmt.Errorf("密码至少 %d 个字符", minPasswordLen)
+	}
+	if len(password) > maxPasswordLen {
+		return fmt.Errorf("密码不能超过 %d 字节", maxPasswordLen)
+	}
+	return nil
+}
+
+// hashPassword 以 bcrypt 计算密码哈希
+func hashPassword(password string) (string, error) {
+	if err := validatePassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("计算密码哈希失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// checkPassword 校验密码；hash 为空（用户不存在或未设置密码）时仍计算一次哈希，避免通过响应时间判断用户名是否存在
+func checkPassword(hash, password string) bool {
+	if hash == "" {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("goup-dummy-password"), bcryptCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// newSecret 生成 n 字节的随机令牌（十六进制）
+func newSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机令牌失败: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecret 返回令牌的 SHA-256 摘要，会话与 API 密钥只保存摘要
+func hashSecret(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// remoteHost 返回请求的来源地址（不含端口）
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// CreateSession 创建登录会话并返回会话令牌，同时清理已过期的会话
+func (db *Database) CreateSession(userID int, ttl time.Duration, r *http.Request) (string, error) {
+	token, err := newSecret(32)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at < NOW()`); err != nil {
+		slog.Warn("清理过期会话失败", "err", err)
+	}
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	if _, err := db.conn.Exec(`INSERT INTO sessions (id, user_id, remote_addr, user_agent, expires_at)
+		VALUES (?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))`,
+		hashSecret(token), userID, remoteHost(r), ua, int64(ttl/time.Second)); err != nil {
+		return "", fmt.Errorf("保存会话失败: %v", err)
+	}
+	if _, err := db.conn.Exec(`UPDATE users SET last_login_at = NOW() WHERE id = ?`, userID); err != nil {
+		slog.Warn("更新登录时间失败", "user_id", userID, "err", err)
+	}
+	return token, nil
+}
+
+// DeleteSession 删除会话（退出登录）
+func (db *Database) DeleteSession(token string) error {
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE id = ?`, hashSecret(token)); err != nil {
+		return fmt.Errorf("删除会话失败: %v", err)
+	}
+	return nil
+}
+
+// sessionPrincipal 返回会话对应的身份，会话不存在、已过期或用户已停用时返回 nil
+func (db *Database) sessionPrincipal(token string) (*Principal, error) {
+	p := &Principal{Method: "session"}
+	var scope string
+	err := db.conn.QueryRow(`SELECT u.id, u.username, u.role, IFNULL(u.scope_groups, '')
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.id = ? AND s.expires_at > NOW() AND u.disabled = 0`, hashSecret(token)).Scan(&p.UserID, &p.Name, &p.Role, &scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询会话失败: %v", err)
+	}
+	p.Groups = parseScope(scope)
+	return p, nil
+}
+
+// apiKeyPrincipal 返回 API 密钥对应的身份，密钥无效、已过期或用户已停用时返回 nil。
+// 密钥可以限定更低的角色，实际角色取密钥与用户角色中较低者
+func (db *Database) apiKeyPrincipal(key string) (*Principal, error) {
+	rest := strings.TrimPrefix(key, apiKeyPrefix)
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 8 {
+		return nil, nil
+	}
+	p := &Principal{Method: "api_key"}
+	var hash, keyRole, scope string
+	err := db.conn.QueryRow(`SELECT k.id, k.key_hash, IFNULL(k.role, ''), u.id, u.username, u.role, IFNULL(u.scope_groups, '')
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = ? AND u.disabled = 0 AND (k.expires_at IS NULL OR k.expires_at > NOW())`, prefix).
+		Scan(&p.KeyID, &hash, &keyRole, &p.UserID, &p.Name, &p.Role, &scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询API密钥失败: %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashSecret(key))) != 1 {
+		return nil, nil
+	}
+	if keyRole != "" && roleRanks[keyRole] < roleRanks[p.Role] {
+		p.Role = keyRole
+	}
+	p.Groups = parseScope(scope)
+	// 最近使用时间每分钟最多更新一次
+	if _, err := db.conn.Exec(`UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)`, p.KeyID); err != nil {
+		slog.Warn("更新API密钥使用时间失败", "key_id", p.KeyID, "err", err)
+	}
+	return p, nil
+}
+
+// setSessionCookie 写入（ttl > 0）或清除会话 Cookie
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, ttl time.Duration) {
+	c := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if ttl > 0 {
+		c.MaxAge = int(ttl / time.Second)
+	} else {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// handleLogin 以用户名和密码登录，成功后写入会话 Cookie 并返回身份。启用 LDAP 时，没有本地密码的用户通过目录服务校验，
+// 首次登录自动创建本地用户，角色与分组按 auth.ldap.role_mappings 在每次登录时同步
+func handleLogin(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.Password == "" {
+			writeJSONError(w, http.StatusBadRequest, "请求体需为 {\"username\": ..., \"password\": ...}")
+			return
+		}
+		req.Username = strings.TrimSpace(req.Username)
+		auditNote(r, req.Username, "")
+		cfg := store.Get()
+		u, hash, err := db.userCredentials(req.Username)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		// 设置了本地密码的用户总是本地校验，目录服务不可用时仍可用本地管理员登录
+		method := "local"
+		valid := false
+		if hash != "" || cfg.Auth.LDAP.authenticator == nil || !usernamePattern.MatchString(req.Username) {
+			valid = checkPassword(hash, req.Password) && u != nil
+		} else {
+			method = "ldap"
+			u, err = db.ldapLogin(&cfg.Auth.LDAP, req.Username, req.Password)
+			switch {
+			case errors.Is(err, errNoRoleMapping), errors.Is(err, errIdentityConflict):
+				slog.WarnContext(r.Context(), "LDAP 登录被拒绝", "username", req.Username, "err", err)
+				auditNote(r, req.Username, "登录失败: ldap "+err.Error())
+				writeJSONError(w, http.StatusForbidden, err.Error())
+				return
+			case errors.Is(err, ldapauth.ErrInvalidCredentials):
+			case err != nil:
+				slog.ErrorContext(r.Context(), "LDAP 登录失败", "username", req.Username, "err", err)
+				auditNote(r, req.Username, "登录失败: 目录服务不可用")
+				writeJSONError(w, http.StatusServiceUnavailable, "目录服务不可用，请稍后重试")
+				return
+			default:
+				valid = true
+			}
+		}
+		if !valid || u.Disabled {
+			slog.WarnContext(r.Context(), "登录失败", "username", req.Username, "method", method, "remote", remoteHost(r))
+			auditNote(r, req.Username, "登录失败")
+			writeJSONError(w, http.StatusUnauthorized, "用户名或密码错误")
+			return
+		}
+		auditNote(r, "", method)
+		ttl := time.Duration(cfg.Auth.SessionTTL)
+		token, err := db.CreateSession(u.ID, ttl, r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "创建会话失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "用户已登录", "username", u.Username, "method", method)
+		setSessionCookie(w, r, token, ttl)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u.principal("session"))
+	}
+}
+
+// handleLogout 退出登录，删除会话并清除 Cookie
+func handleLogout(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+			if err := db.DeleteSession(c.Value); err != nil {
+				slog.ErrorContext(r.Context(), "退出登录失败", "err", err)
+				http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+				return
+			}
+		}
+		setSessionCookie(w, r, "", 0)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAuthProviders 返回可用的登录方式，供登录页面显示单点登录入口，无需登录
+func handleAuthProviders(store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := store.Get().Auth
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{
+			"ldap": auth.LDAP.authenticator != nil,
+			"oidc": auth.OIDC.provider != nil,
+		})
+	}
+}
+
+// handleWhoAmI 返回当前请求的身份，未登录且不允许匿名访问时返回 401
+func handleWhoAmI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// handleChangePassword 修改自己的密码，成功后该用户的其他会话失效
+func handleChangePassword(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		if p.UserID == 0 {
+			writeJSONError(w, http.StatusBadRequest, "当前身份不是用户账号")
+			return
+		}
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "无效的JSON数据")
+			return
+		}
+		u, hash, err := db.userCredentials(p.Name)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if u == nil || !checkPassword(hash, req.CurrentPassword) {
+			writeJSONError(w, http.StatusForbidden, "当前密码错误")
+			return
+		}
+		newHash, err := hashPassword(req.NewPassword)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		keep := ""
+		if c, err := r.Cookie(sessionCookie); err == nil && p.Method == "session" {
+			keep = hashSecret(c.Value)
+		}
+		if err := db.SetPassword(u.ID, newHash, keep); err != nil {
+			slog.ErrorContext(r.Context(), "修改密码失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "用户已修改密码", "username", u.Username)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
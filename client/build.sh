@@ -1,10 +1,11 @@
 #!/bin/bash
 
 # 客户端编译脚本
-# 支持设置更新检查URL
+# 支持设置更新检查URL，不设置时客户端从上报的服务器检查更新
 
 # 默认参数
-UPDATE_URL="https://raw.githubusercontent.com/LxnChan/info-receiver-go/refs/heads/main/update.json"
+UPDATE_URL=""
+UPDATE_KEY=""
 OUTPUT_DIR="dist"
 VERSION="1.3"
 
@@ -15,6 +16,10 @@ while [[ $# -gt 0 ]]; do
             UPDATE_URL="$2"
             shift 2
             ;;
+        -k|--update-key)
+            UPDATE_KEY="$2"
+            shift 2
+            ;;
         -o|--output)
             OUTPUT_DIR="$2"
             shift 2
@@ -26,7 +31,8 @@ while [[ $# -gt 0 ]]; do
         -h|--help)
             echo "用法: $0 [选项]"
             echo "选项:"
-            echo "  -u, --update-url URL    设置更新检查URL"
+            echo "  -u, --update-url URL    设置更新检查URL (默认: 使用上报服务器的 /api/update/manifest)"
+            echo "  -k, --update-key KEY    设置更新签名公钥 (base64，由 goup-server -gen-update-key 生成；未设置时拒绝安装更新)"
             echo "  -o, --output DIR        设置输出目录 (默认: dist)"
             echo "  -v, --version VERSION   设置版本号 (默认: 0.9)"
             echo "  -h, --help              显示此帮助信息"
@@ -59,11 +65,14 @@ compile_version() {
     local ext=$3
     
     echo "编译 $os-$arch 版本..."
+    local ldflags=""
     if [ -n "$UPDATE_URL" ]; then
-        GOOS=$os GOARCH=$arch go build -ldflags "-X main.updateCheckURL=$UPDATE_URL" -o "$OUTPUT_DIR/client-$os-$arch$ext" .
-    else
-        GOOS=$os GOARCH=$arch go build -o "$OUTPUT_DIR/client-$os-$arch$ext" .
+        ldflags="$ldflags -X main.updateCheckURL=$UPDATE_URL"
     fi
+    if [ -n "$UPDATE_KEY" ]; then
+        ldflags="$ldflags -X main.updatePublicKey=$UPDATE_KEY"
+    fi
+    GOOS=$os GOARCH=$arch go build -ldflags "$ldflags" -o "$OUTPUT_DIR/client-$os-$arch$ext" .
     
     if [ $? -eq 0 ]; then
         echo "✓ $os-$arch 版本编译成功: $OUTPUT_DIR/client-$os-$arch$ext"
@@ -77,7 +86,12 @@ compile_version() {
 if [ -n "$UPDATE_URL" ]; then
     echo "更新URL: $UPDATE_URL"
 else
-    echo "更新URL: 未设置 (将跳过更新检查)"
+    echo "更新URL: 未设置 (使用上报服务器的更新清单)"
+fi
+if [ -n "$UPDATE_KEY" ]; then
+    echo "更新公钥: $UPDATE_KEY"
+else
+    echo "更新公钥: 未设置 (客户端将拒绝安装任何更新)"
 fi
 
 # Linux版本
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector 单项采集器，每个采集器负责一部分信息（如 CPU、磁盘、网络）
+type Collector interface {
+	// Name 采集器名称，用于启用/禁用与错误上报
+	Name() string
+	// Timeout 默认超时时间，可被命令行覆盖
+	Timeout() time.Duration
+	// Collect 执行采集，返回键值结果；键与 SysInfo 字段同名时填充对应字段，其余作为结构化分段上报
+	Collect(ctx context.Context) (map[string]string, error)
+}
+
+// CollectorStatus 单个采集器的执行结果，随负载上报给服务端
+type CollectorStatus struct {
+	Status     string `json:"status"` // ok / error / timeout
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// CollectOptions 采集选项
+type CollectOptions struct {
+	// Enabled 非空时只运行列出的采集器
+	Enabled map[string]bool
+	// Disabled 不运行的采集器
+	Disabled map[string]bool
+	// Timeouts 按名称覆盖超时时间
+	Timeouts map[string]time.Duration
+}
+
+// CollectResult 全部采集器的汇总结果
+type CollectResult struct {
+	Info     SysInfo
+	Statuses map[string]CollectorStatus
+	Sections map[string]map[string]string
+}
+
+// collectorOutcome 单个采集器的执行产出
+type collectorOutcome struct {
+	name   string
+	fields map[string]string
+	status CollectorStatus
+}
+
+// funcCollector 以函数实现的采集器
+type funcCollector struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) (map[string]string, error)
+}
+
+func (c *funcCollector) Name() string           { return c.name }
+func (c *funcCollector) Timeout() time.Duration { return c.timeout }
+func (c *funcCollector) Collect(ctx context.Context) (map[string]string, error) {
+	return c.fn(ctx)
+}
+
+// newCollector 创建基于函数的采集器
+func newCollector(name string, timeout time.Duration, fn func(ctx context.Context) (map[string]string, error)) Collector {
+	return &funcCollector{name: name, timeout: timeout, fn: fn}
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []Collector
+)
+
+// registerCollector 注册采集器，名称重复时后注册的覆盖先注册的
+func registerCollector(c Collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for i, existing := range registry {
+		if existing.Name() == c.Name() {
+			registry[i] = c
+			return
+		}
+	}
+	registry = append(registry, c)
+}
+
+// registeredCollectors 返回已注册采集器的副本
+func registeredCollectors() []Collector {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	out := make([]Collector, len(registry))
+	copy(out, registry)
+	return out
+}
+
+// collectorNames 返回已注册采集器名称（排序后）
+func collectorNames() []string {
+	var names []string
+	for _, c := range registeredCollectors() {
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isKnownCollector 判断是否为已注册的采集器名称
+func isKnownCollector(name string) bool {
+	for _, c := range registeredCollectors() {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectSystemInfo 并发运行所有启用的采集器，单个采集器超时或失败不会阻塞其他采集器
+func CollectSystemInfo(opts CollectOptions) (*CollectResult, error) {
+	var active []Collector
+	for _, c := range registeredCollectors() {
+		if len(opts.Enabled) > 0 && !opts.Enabled[c.Name()] {
+			continue
+		}
+		if opts.Disabled[c.Name()] {
+			continue
+		}
+		active = append(active, c)
+	}
+
+	results := make(chan collectorOutcome, len(active))
+	for _, c := range active {
+		timeout := c.Timeout()
+		if t, ok := opts.Timeouts[c.Name()]; ok && t > 0 {
+			timeout = t
+		}
+		go func(c Collector, timeout time.Duration) {
+			results <- runCollector(c, timeout)
+		}(c, timeout)
+	}
+
+	res := &CollectResult{
+		Statuses: make(map[string]CollectorStatus),
+		Sections: make(map[string]map[string]string),
+	}
+	// 按注册顺序合并，保证同名字段的覆盖顺序稳定
+	byName := make(map[string]collectorOutcome, len(active))
+	for range active {
+		o := <-results
+		byName[o.name] = o
+	}
+	for _, c := range active {
+		o := byName[c.Name()]
+		res.Statuses[o.name] = o.status
+		for k, v := range o.fields {
+			if !applyField(&res.Info, k, v) {
+				if res.Sections[o.name] == nil {
+					res.Sections[o.name] = make(map[string]string)
+				}
+				res.Sections[o.name][k] = v
+			}
+		}
+	}
+
+	if res.Info.Name == "" && res.Info.CPU == "" {
+		return res, errors.New("未能成功采集关键字段")
+	}
+	return res, nil
+}
+
+// runCollector 在超时限制内执行单个采集器
+func runCollector(c Collector, timeout time.Duration) (o collectorOutcome) {
+	o.name = c.Name()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type ret struct {
+		fields map[string]string
+		err    error
+	}
+	done := make(chan ret, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- ret{err: fmt.Errorf("采集器异常: %v", r)}
+			}
+		}()
+		fields, err := c.Collect(ctx)
+		done <- ret{fields: fields, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		o.status.DurationMs = time.Since(start).Milliseconds()
+		o.fields = r.fields
+		if r.err != nil {
+			o.status.Status = "error"
+			o.status.Error = r.err.Error()
+		} else {
+			o.status.Status = "ok"
+		}
+	case <-ctx.Done():
+		o.status.DurationMs = time.Since(start).Milliseconds()
+		o.status.Status = "timeout"
+		o.status.Error = fmt.Sprintf("超过 %s 未完成", timeout)
+	}
+	return o
+}
+
+// applyField 将采集结果写入 SysInfo 对应字段，返回 false 表示不是已知字段
+func applyField(info *SysInfo, key, value string) bool {
+	switch key {
+	case "Name":
+		info.Name = value
+	case "CPU":
+		info.CPU = value
+	case "RAM":
+		info.RAM = value
+	case "Disk":
+		info.Disk = value
+	case "SN":
+		info.SN = value
+	case "MAC":
+		info.MAC = value
+	case "IP":
+		info.IP = value
+	case "Network":
+		info.Network = value
+	default:
+		return false
+	}
+	return true
+}
+
+// parseNameSet 解析逗号分隔的采集器名称列表
+func parseNameSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			set[part] = true
+		}
+	}
+	return set
+}
+
+// mergeNameSets 合并多个名称集合
+func mergeNameSets(sets ...map[string]bool) map[string]bool {
+	out := make(map[string]bool)
+	for _, set := range sets {
+		for name := range set {
+			out[name] = true
+		}
+	}
+	return out
+}
+
+// parseTimeouts 解析 "disk=3s,sn=5s" 形式的超时配置
+func parseTimeouts(s string) (map[string]time.Duration, error) {
+	out := make(map[string]time.Duration)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("无效的超时配置: %s", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("无效的超时配置 %s: %v", part, err)
+		}
+		out[strings.TrimSpace(kv[0])] = d
+	}
+	return out, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration 以 "10s"、"5m" 形式读写的时间间隔
+type Duration time.Duration
+
+// MarshalJSON 输出为字符串形式
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON 支持字符串（"10s"）或秒数
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err != nil {
+		return fmt.Errorf("无效的时间间隔: %s", string(b))
+	}
+	*d = Duration(time.Duration(secs * float64(time.Second)))
+	return nil
+}
+
+// TLSOptions 与服务端通信的 TLS 选项
+type TLSOptions struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// ClientConfig 客户端生效配置，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+type ClientConfig struct {
+	// 服务器地址，按顺序尝试直到上报成功
+	Servers []string `json:"servers"`
+	// 上报时携带的令牌（Authorization: Bearer）
+	Token   string   `json:"token,omitempty"`
+	Timeout Duration `json:"timeout"`
+	// 上报间隔，为 0 时只运行一次
+	Interval Duration `json:"interval"`
+	// HTTP 代理，为空时使用环境变量 HTTP(S)_PROXY
+	Proxy string     `json:"proxy,omitempty"`
+	TLS   TLSOptions `json:"tls"`
+
+	Collectors        []string            `json:"collectors,omitempty"`
+	DisableCollectors []string            `json:"disable_collectors,omitempty"`
+	CollectorTimeouts map[string]Duration `json:"collector_timeouts,omitempty"`
+
+	FactsDir     string   `json:"facts_dir"`
+	FactsTimeout Duration `json:"facts_timeout"`
+
+	UpdateURL     string `json:"update_url,omitempty"`
+	UpdateChannel string `json:"update_channel"`
+	// 自动更新状态文件，记录待确认的版本与上一版本的备份
+	StateFile string `json:"state_file"`
+
+	Comment string `json:"comment,omitempty"`
+}
+
+// defaultConfigPath 返回当前平台默认的配置文件路径
+func defaultConfigPath() string {
+	if runtime.GOOS == "windows" {
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "info-receiver", "client.json")
+	}
+	return "/etc/info-receiver/client.json"
+}
+
+// defaultClientConfig 默认配置
+func defaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Timeout:       Duration(10 * time.Second),
+		FactsDir:      defaultFactsDir(),
+		FactsTimeout:  Duration(10 * time.Second),
+		UpdateURL:     updateCheckURL,
+		UpdateChannel: "stable",
+		StateFile:     defaultStateFile(),
+	}
+}
+
+// loadConfigFile 读取 JSON 配置文件并覆盖到 cfg；required 为 false 时文件不存在不视为错误
+func loadConfigFile(cfg *ClientConfig, path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	return nil
+}
+
+// applyEnv 使用 INFO_RECEIVER_* 环境变量覆盖配置
+func applyEnv(cfg *ClientConfig) error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = splitList(v)
+		}
+	}
+	dur := func(name string, dst *Duration) error {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 无效: %v", name, err)
+			}
+			*dst = Duration(d)
+		}
+		return nil
+	}
+
+	list("INFO_RECEIVER_SERVERS", &cfg.Servers)
+	str("INFO_RECEIVER_TOKEN", &cfg.Token)
+	if err := dur("INFO_RECEIVER_TIMEOUT", &cfg.Timeout); err != nil {
+		return err
+	}
+	if err := dur("INFO_RECEIVER_INTERVAL", &cfg.Interval); err != nil {
+		return err
+	}
+	str("INFO_RECEIVER_PROXY", &cfg.Proxy)
+	str("INFO_RECEIVER_CA_FILE", &cfg.TLS.CAFile)
+	str("INFO_RECEIVER_CERT_FILE", &cfg.TLS.CertFile)
+	str("INFO_RECEIVER_KEY_FILE", &cfg.TLS.KeyFile)
+	if v, ok := os.LookupEnv("INFO_RECEIVER_INSECURE"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("环境变量 INFO_RECEIVER_INSECURE 无效: %v", err)
+		}
+		cfg.TLS.InsecureSkipVerify = b
+	}
+	list("INFO_RECEIVER_COLLECTORS", &cfg.Collectors)
+	list("INFO_RECEIVER_DISABLE_COLLECTORS", &cfg.DisableCollectors)
+	str("INFO_RECEIVER_FACTS_DIR", &cfg.FactsDir)
+	if err := dur("INFO_RECEIVER_FACTS_TIMEOUT", &cfg.FactsTimeout); err != nil {
+		return err
+	}
+	str("INFO_RECEIVER_UPDATE_URL", &cfg.UpdateURL)
+	str("INFO_RECEIVER_UPDATE_CHANNEL", &cfg.UpdateChannel)
+	str("INFO_RECEIVER_STATE_FILE", &cfg.StateFile)
+	str("INFO_RECEIVER_COMMENT", &cfg.Comment)
+	return nil
+}
+
+// splitList 拆分逗号分隔的列表并去除空项
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// flagSet 返回命令行中显式设置过的参数名
+func flagSet(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// Validate 检查配置是否完整有效
+func (cfg *ClientConfig) Validate() error {
+	if len(cfg.Servers) == 0 {
+		return fmt.Errorf("无服务器地址，请通过-s参数、INFO_RECEIVER_SERVERS 或配置文件指定")
+	}
+	for _, s := range cfg.Servers {
+		if _, err := url.Parse(s); err != nil {
+			return fmt.Errorf("无效的服务器地址 %s: %v", s, err)
+		}
+	}
+	if cfg.Proxy != "" {
+		if _, err := url.Parse(cfg.Proxy); err != nil {
+			return fmt.Errorf("无效的代理地址: %v", err)
+		}
+	}
+	for _, name := range append(append([]string{}, cfg.Collectors...), cfg.DisableCollectors...) {
+		if !isKnownCollector(name) {
+			return fmt.Errorf("未知的采集器: %s（可用: %s）", name, strings.Join(collectorNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// CollectOptions 根据配置生成采集选项
+func (cfg *ClientConfig) CollectOptions() CollectOptions {
+	opts := CollectOptions{
+		Enabled:  parseNameSet(strings.Join(cfg.Collectors, ",")),
+		Disabled: parseNameSet(strings.Join(cfg.DisableCollectors, ",")),
+		Timeouts: make(map[string]time.Duration),
+	}
+	for name, d := range cfg.CollectorTimeouts {
+		opts.Timeouts[name] = time.Duration(d)
+	}
+	return opts
+}
+
+// Endpoints 返回各服务器的上报接口地址
+func (cfg *ClientConfig) Endpoints() []string {
+	var out []string
+	for _, s := range cfg.Servers {
+		endpoint := strings.TrimSpace(s)
+		if !strings.HasSuffix(strings.ToLower(endpoint), "/api/client") {
+			endpoint = strings.TrimRight(endpoint, "/") + "/api/client"
+		}
+		out = append(out, endpoint)
+	}
+	return out
+}
+
+// HTTPClient 根据代理与 TLS 选项构建 HTTP 客户端
+func (cfg *ClientConfig) HTTPClient(timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("无效的代理地址: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConf := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA证书格式无效: %s", cfg.TLS.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConf
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// Redacted 返回隐藏敏感字段后的副本，用于 -print-config
+func (cfg ClientConfig) Redacted() ClientConfig {
+	if cfg.Token != "" {
+		cfg.Token = "******"
+	}
+	if u, err := url.Parse(cfg.Proxy); err == nil && u.User != nil {
+		u.User = url.User(u.User.Username())
+		cfg.Proxy = u.String()
+	}
+	return cfg
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 自定义事实的键/值长度上限，超出部分将被忽略或截断
+const (
+	maxFactKeyLen   = 128
+	maxFactValueLen = 1024
+)
+
+// factWaitDelay 事实脚本退出或超时被终止后，等待其输出关闭的最长时间。
+// 脚本启动的后台进程可能继承并一直占用标准输出，不能因此阻塞上报
+const factWaitDelay = 2 * time.Second
+
+// defaultFactsDir 返回当前平台默认的事实目录
+func defaultFactsDir() string {
+	if runtime.GOOS == "windows" {
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "info-receiver", "facts.d")
+	}
+	return "/etc/info-receiver/facts.d"
+}
+
+// LoadFacts 读取事实目录中的文件并运行其中的可执行程序，合并为一个键值表。
+// 文件按名称排序处理，同名键以后处理的为准；单个文件失败不影响其他文件。
+func LoadFacts(dir string, timeout time.Duration) (map[string]string, []error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, []error{fmt.Errorf("读取事实目录失败: %v", err)}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	facts := make(map[string]string)
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		fi, err := entry.Info()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", entry.Name(), err))
+			continue
+		}
+
+		var values map[string]string
+		if isExecutableFact(path, fi) {
+			values, err = runFactExecutable(path, timeout)
+		} else {
+			values, err = readFactFile(path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", entry.Name(), err))
+			continue
+		}
+		for k, v := range values {
+			if k == "" || len(k) > maxFactKeyLen {
+				continue
+			}
+			facts[k] = truncateUTF8(v, maxFactValueLen)
+		}
+	}
+	return facts, errs
+}
+
+// truncateUTF8 将 s 截断到不超过 max 个字节，不拆开多字节字符
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// isExecutableFact 判断事实文件是否应当作为程序执行
+func isExecutableFact(path string, fi os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".exe", ".bat", ".cmd", ".ps1":
+			return true
+		}
+		return false
+	}
+	return fi.Mode().IsRegular() && fi.Mode()&0111 != 0
+}
+
+// runFactExecutable 在超时限制内运行事实程序，输出可以是 JSON/YAML 对象或 key=value 行
+func runFactExecutable(path string, timeout time.Duration) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if strings.EqualFold(filepath.Ext(path), ".ps1") {
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", path)
+	} else {
+		cmd = exec.CommandContext(ctx, path)
+	}
+	cmd.WaitDelay = factWaitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("执行超时（%s）", timeout)
+	}
+	// 脚本本身已正常退出，只是输出仍被其启动的进程占用：使用已读到的输出
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("执行失败: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseStructuredFacts(trimmed, json.Unmarshal)
+	}
+	return parseKeyValueFacts(out)
+}
+
+// readFactFile 按扩展名解析事实文件
+func readFactFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseStructuredFacts(data, json.Unmarshal)
+	case ".yaml", ".yml":
+		return parseStructuredFacts(data, yaml.Unmarshal)
+	case ".ini":
+		return parseINIFacts(data)
+	case ".txt", ".conf", ".properties":
+		return parseKeyValueFacts(data)
+	default:
+		return nil, fmt.Errorf("不支持的事实文件类型")
+	}
+}
+
+// parseStructuredFacts 解析 JSON/YAML 对象，嵌套对象以 "." 连接展开
+func parseStructuredFacts(data []byte, unmarshal func([]byte, interface{}) error) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析失败: %v", err)
+	}
+	out := make(map[string]string)
+	flattenFacts("", raw, out)
+	return out, nil
+}
+
+// flattenFacts 展开嵌套结构
+func flattenFacts(prefix string, v interface{}, out map[string]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenFacts(key, child, out)
+		}
+	case nil:
+		out[prefix] = ""
+	case string:
+		out[prefix] = val
+	default:
+		if b, err := json.Marshal(val); err == nil {
+			out[prefix] = string(b)
+		} else {
+			out[prefix] = fmt.Sprint(val)
+		}
+	}
+}
+
+// parseKeyValueFacts 解析 key=value 行，忽略空行与 # 注释
+func parseKeyValueFacts(data []byte) (map[string]string, error) {
+	out := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		out[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return out, scanner.Err()
+}
+
+// parseINIFacts 解析 INI 文件，节名作为键前缀（section.key）
+func parseINIFacts(data []byte) (map[string]string, error) {
+	out := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if section != "" {
+			key = section + "." + key
+		}
+		out[key] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return out, scanner.Err()
+}
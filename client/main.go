@@ -3,14 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 )
@@ -27,48 +25,176 @@ type Payload struct {
 	UpVer   string  `json:"up_ver"`
 	Comment string  `json:"comment"`
     Network *string `json:"Network"`
+	// 各采集器执行状态，便于服务端定位哪部分采集失败
+	Collectors map[string]CollectorStatus `json:"collectors,omitempty"`
+	// 采集器返回的非标准字段，按采集器名称分段
+	Sections map[string]map[string]string `json:"sections,omitempty"`
+	// 自定义事实（来自事实目录中的文件与脚本）
+	Facts map[string]string `json:"facts"`
 }
 
 const clientVersion = "1.3"
 
-// UpdateInfo 更新信息结构体
-type UpdateInfo struct {
-	Version     string `json:"version"`
-	Description string `json:"description,omitempty"`
-	Downloads   map[string]DownloadInfo `json:"downloads"`
-}
-
-// DownloadInfo 下载信息结构体
-type DownloadInfo struct {
-	URL string `json:"url"`
-}
-
-// 编译时指定的更新检查URL，可以通过 -ldflags 参数动态设置
-var updateCheckURL = "https://example.com/update.json"
+// errReportFailed 所有服务器都上报失败，与采集等其他失败区分
+var errReportFailed = errors.New("上报失败")
 
 func main() {
     // Windows: 自动请求管理员（UAC）后再继续；其他平台无操作
 	// 暂时先不实装
     // ensureAdmin()
-	server := flag.String("s", "", "服务器地址，例如 http://host:8080 或完整接口 http://host:8080/api/client")
+	configPath := flag.String("config", "", "配置文件路径（默认 "+defaultConfigPath()+"，不存在则忽略）")
+	printConfig := flag.Bool("print-config", false, "输出生效配置后退出")
+	server := flag.String("s", "", "服务器地址，例如 http://host:8080 或完整接口 http://host:8080/api/client，多个以逗号分隔")
 	comment := flag.String("c", "", "备注 comment，可为空")
 	timeout := flag.Duration("t", 10*time.Second, "HTTP 超时时间")
+	token := flag.String("token", "", "上报令牌")
+	interval := flag.Duration("interval", 0, "上报间隔，为 0 时只运行一次")
+	proxy := flag.String("proxy", "", "HTTP 代理地址")
+	caFile := flag.String("ca-file", "", "服务端 CA 证书文件")
+	insecure := flag.Bool("insecure", false, "跳过服务端证书校验")
+	enabled := flag.String("collectors", "", "仅启用的采集器，逗号分隔（默认全部）")
+	disabled := flag.String("disable-collectors", "", "禁用的采集器，逗号分隔")
+	collectorTimeouts := flag.String("collector-timeouts", "", "采集器超时，例如 disk=3s,sn=5s")
+	factsDir := flag.String("facts-dir", defaultFactsDir(), "自定义事实目录，为空则不读取")
+	factsTimeout := flag.Duration("facts-timeout", 10*time.Second, "单个事实脚本的执行超时")
+	updateURL := flag.String("update-url", "", "更新检查地址")
+	updateChannel := flag.String("update-channel", "", "更新通道，例如 stable、beta")
+	stateFile := flag.String("state-file", defaultStateFile(), "自动更新状态文件")
+	selftestMode := flag.Bool("selftest", false, "自检模式：检查配置与采集器后输出版本并退出（自动更新时使用）")
+	trialMode := flag.Bool("trial", false, "试运行模式：采集并上报一次后退出，成功上报即确认本版本（自动更新替换程序后使用）")
+	listCollectors := flag.Bool("list-collectors", false, "列出可用的采集器后退出")
 	flag.Parse()
 
-	if *server == "" {
-		fmt.Fprintln(os.Stderr, "无服务器地址，请通过-s参数指定")
+	if *listCollectors {
+		for _, c := range registeredCollectors() {
+			fmt.Printf("%s\t%s\n", c.Name(), c.Timeout())
+		}
+		return
+	}
+
+	// 配置优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+	cfg := defaultClientConfig()
+	path, required := defaultConfigPath(), false
+	if v, ok := os.LookupEnv("INFO_RECEIVER_CONFIG"); ok {
+		path, required = v, true
+	}
+	if *configPath != "" {
+		path, required = *configPath, true
+	}
+	if err := loadConfigFile(&cfg, path, required); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := applyEnv(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
 	}
+	set := flagSet(flag.CommandLine)
+	if set["s"] {
+		cfg.Servers = splitList(*server)
+	}
+	if set["c"] {
+		cfg.Comment = *comment
+	}
+	if set["t"] {
+		cfg.Timeout = Duration(*timeout)
+	}
+	if set["token"] {
+		cfg.Token = *token
+	}
+	if set["interval"] {
+		cfg.Interval = Duration(*interval)
+	}
+	if set["proxy"] {
+		cfg.Proxy = *proxy
+	}
+	if set["ca-file"] {
+		cfg.TLS.CAFile = *caFile
+	}
+	if set["insecure"] {
+		cfg.TLS.InsecureSkipVerify = *insecure
+	}
+	if set["collectors"] {
+		cfg.Collectors = splitList(*enabled)
+	}
+	if set["disable-collectors"] {
+		cfg.DisableCollectors = splitList(*disabled)
+	}
+	if set["collector-timeouts"] {
+		timeouts, err := parseTimeouts(*collectorTimeouts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		cfg.CollectorTimeouts = make(map[string]Duration)
+		for name, d := range timeouts {
+			cfg.CollectorTimeouts[name] = Duration(d)
+		}
+	}
+	if set["facts-dir"] {
+		cfg.FactsDir = *factsDir
+	}
+	if set["facts-timeout"] {
+		cfg.FactsTimeout = Duration(*factsTimeout)
+	}
+	if set["update-url"] {
+		cfg.UpdateURL = *updateURL
+	}
+	if set["update-channel"] {
+		cfg.UpdateChannel = *updateChannel
+	}
+	if set["state-file"] {
+		cfg.StateFile = *stateFile
+	}
 
-	endpoint := strings.TrimSpace(*server)
-	if !strings.HasSuffix(strings.ToLower(endpoint), "/api/client") {
-		endpoint = strings.TrimRight(endpoint, "/") + "/api/client"
+	if *printConfig {
+		out, _ := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		fmt.Printf("# 配置文件: %s\n%s\n", path, out)
+		return
 	}
 
-	info, err := CollectSystemInfo()
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *selftestMode {
+		os.Exit(selftest(&cfg))
+	}
+	if *trialMode {
+		os.Exit(trial(&cfg))
+	}
+
+	// 检查上次自动更新是否已生效。每个进程只检查一次：常驻运行时替换程序后，
+	// 本进程仍是旧版本，直到重新启动才运行新版本
+	guardPendingUpdate(&cfg)
+
+	for {
+		err := runOnce(&cfg, true)
+		if cfg.Interval <= 0 {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		time.Sleep(time.Duration(cfg.Interval))
+	}
+}
+
+// runOnce 执行一次采集与上报，checkUpdate 为 true 时随后检查更新
+func runOnce(cfg *ClientConfig, checkUpdate bool) error {
+	result, err := CollectSystemInfo(cfg.CollectOptions())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "采集信息失败: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("采集信息失败: %v", err)
+	}
+	info := result.Info
+	for name, st := range result.Statuses {
+		if st.Status != "ok" {
+			fmt.Fprintf(os.Stderr, "采集器 %s %s: %s\n", name, st.Status, st.Error)
+		}
 	}
 
 	// 统一规范 MAC 格式为 xxxx.xxxx.xxxx
@@ -92,43 +218,102 @@ func main() {
 		MAC:     info.MAC,
 		IP:      info.IP,
 		UpVer:   clientVersion,
-		Comment: *comment,
+		Comment: cfg.Comment,
         Network: networkPtr,
+		Collectors: result.Statuses,
+	}
+	if len(result.Sections) > 0 {
+		p.Sections = result.Sections
+	}
+
+	// 自定义事实，失败信息作为 facts 采集器状态上报
+	if cfg.FactsDir != "" {
+		start := time.Now()
+		facts, errs := LoadFacts(cfg.FactsDir, time.Duration(cfg.FactsTimeout))
+		// 事实目录存在时总是上报（可以为空），服务端据此删除已移除的事实；目录不存在时不上报，保留原有事实
+		if facts != nil {
+			p.Facts = facts
+		}
+		st := CollectorStatus{Status: "ok", DurationMs: time.Since(start).Milliseconds()}
+		if len(errs) > 0 {
+			var msgs []string
+			for _, e := range errs {
+				fmt.Fprintf(os.Stderr, "读取自定义事实失败: %v\n", e)
+				msgs = append(msgs, e.Error())
+			}
+			st.Status = "error"
+			st.Error = strings.Join(msgs, "; ")
+		}
+		if len(facts) > 0 || len(errs) > 0 {
+			p.Collectors["facts"] = st
+		}
 	}
 
 	body, err := json.Marshal(p)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "编码JSON失败: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("编码JSON失败: %v", err)
+	}
+
+	client, err := cfg.HTTPClient(time.Duration(cfg.Timeout))
+	if err != nil {
+		return err
 	}
 
-	client := &http.Client{Timeout: *timeout}
+	// 依次尝试各服务器，任一成功即视为上报完成
+	var lastErr error
+	reportedTo := ""
+	for _, endpoint := range cfg.Endpoints() {
+		if err := postReport(client, endpoint, cfg.Token, body); err != nil {
+			fmt.Fprintf(os.Stderr, "上报到 %s 失败: %v\n", endpoint, err)
+			lastErr = err
+			continue
+		}
+		reportedTo = endpoint
+		break
+	}
+	if reportedTo == "" {
+		return fmt.Errorf("%w: %v", errReportFailed, lastErr)
+	}
+
+	fmt.Println("上报完成")
+
+	// 新版本成功上报即视为更新成功，随后上报本地记录的更新事件
+	confirmPendingUpdate(cfg)
+	flushUpdateEvents(cfg, client, reportedTo, p.SN, p.MAC)
+	if !checkUpdate {
+		return nil
+	}
+
+	// 信息上报完成后，检查更新
+	if err := checkAndUpdate(cfg, reportedTo, p.SN, p.MAC); err != nil {
+		fmt.Fprintf(os.Stderr, "更新检查失败: %v\n", err)
+		// 更新失败不影响主流程，只记录错误
+	}
+	return nil
+}
+
+// postReport 向单个服务器接口发送上报数据
+func postReport(client *http.Client, endpoint, token string, body []byte) error {
 	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "创建请求失败: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("创建请求失败: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "请求失败: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("请求失败: %v", err)
 	}
 	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
 
 	if resp.StatusCode/100 != 2 {
-		fmt.Fprintf(os.Stderr, "服务器返回错误状态: %s\n", resp.Status)
-		os.Exit(1)
-	}
-
-	fmt.Println("上报完成")
-	
-	// 信息上报完成后，检查更新
-	if err := checkAndUpdate(); err != nil {
-		fmt.Fprintf(os.Stderr, "更新检查失败: %v\n", err)
-		// 更新失败不影响主流程，只记录错误
+		return fmt.Errorf("服务器返回错误状态: %s", resp.Status)
 	}
+	return nil
 }
 
 // formatMacXXXX 将任意常见 MAC 字符串（aa:bb:cc:dd:ee:ff / aa-bb-... / aabb.ccdd.eeff）
@@ -152,197 +337,3 @@ func formatMacXXXX(mac string) string {
     if len(cleaned) != 12 { return mac }
     return cleaned[0:4] + "." + cleaned[4:8] + "." + cleaned[8:12]
 }
-
-// checkAndUpdate 检查并执行自动更新
-func checkAndUpdate() error {
-	// 如果更新URL为空或默认值，跳过更新检查
-	if updateCheckURL == "" || updateCheckURL == "https://raw.githubusercontent.com/LxnChan/info-receiver-go/refs/heads/main/update.json" {
-		return nil
-	}
-	
-	fmt.Println("正在检查更新...")
-	
-	// 获取更新信息
-	updateInfo, err := fetchUpdateInfo(updateCheckURL)
-	if err != nil {
-		return fmt.Errorf("获取更新信息失败: %v", err)
-	}
-	
-	// 比较版本
-	if !isNewerVersion(updateInfo.Version, clientVersion) {
-		fmt.Println("当前已是最新版本")
-		return nil
-	}
-	
-	fmt.Printf("发现新版本 %s，正在下载更新...\n", updateInfo.Version)
-	
-	// 获取当前平台对应的下载链接
-	downloadURL, err := getDownloadURL(updateInfo)
-	if err != nil {
-		return fmt.Errorf("获取下载链接失败: %v", err)
-	}
-	
-	// 下载并替换
-	if err := downloadAndReplace(downloadURL); err != nil {
-		return fmt.Errorf("下载更新失败: %v", err)
-	}
-	
-	fmt.Println("更新完成，程序将在下次运行时使用新版本")
-	return nil
-}
-
-// fetchUpdateInfo 从URL获取更新信息
-func fetchUpdateInfo(url string) (*UpdateInfo, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP状态码: %d", resp.StatusCode)
-	}
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	
-	var updateInfo UpdateInfo
-	if err := json.Unmarshal(body, &updateInfo); err != nil {
-		return nil, err
-	}
-	
-	if updateInfo.Version == "" || updateInfo.Downloads == nil {
-		return nil, fmt.Errorf("更新信息格式错误")
-	}
-	
-	return &updateInfo, nil
-}
-
-// isNewerVersion 比较版本号，返回true表示newVersion比currentVersion更新
-func isNewerVersion(newVersion, currentVersion string) bool {
-	// 简单的版本比较，支持 x.y.z 格式
-	newParts := strings.Split(newVersion, ".")
-	currentParts := strings.Split(currentVersion, ".")
-	
-	maxLen := len(newParts)
-	if len(currentParts) > maxLen {
-		maxLen = len(currentParts)
-	}
-	
-	for i := 0; i < maxLen; i++ {
-		var newNum, currentNum int
-		
-		if i < len(newParts) {
-			fmt.Sscanf(newParts[i], "%d", &newNum)
-		}
-		if i < len(currentParts) {
-			fmt.Sscanf(currentParts[i], "%d", &currentNum)
-		}
-		
-		if newNum > currentNum {
-			return true
-		} else if newNum < currentNum {
-			return false
-		}
-	}
-	
-	return false // 版本相同
-}
-
-// getDownloadURL 根据当前平台获取对应的下载链接
-func getDownloadURL(updateInfo *UpdateInfo) (string, error) {
-	// 构建平台标识符
-	platform := runtime.GOOS + "-" + runtime.GOARCH
-	
-	// 查找对应的下载链接
-	if downloadInfo, exists := updateInfo.Downloads[platform]; exists {
-		return downloadInfo.URL, nil
-	}
-	
-	// 如果没找到精确匹配，尝试查找通用版本
-	if downloadInfo, exists := updateInfo.Downloads[runtime.GOOS]; exists {
-		return downloadInfo.URL, nil
-	}
-	
-	return "", fmt.Errorf("未找到平台 %s 对应的下载链接", platform)
-}
-
-// downloadAndReplace 下载新版本并替换当前程序
-func downloadAndReplace(downloadURL string) error {
-	// 获取当前程序路径
-	currentPath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("获取当前程序路径失败: %v", err)
-	}
-	
-	// 创建临时文件
-	tempFile := currentPath + ".tmp"
-	
-	// 下载文件
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Get(downloadURL)
-	if err != nil {
-		return fmt.Errorf("下载失败: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("下载失败，HTTP状态码: %d", resp.StatusCode)
-	}
-	
-	// 创建临时文件
-	file, err := os.Create(tempFile)
-	if err != nil {
-		return fmt.Errorf("创建临时文件失败: %v", err)
-	}
-	defer file.Close()
-	
-	// 复制数据
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		os.Remove(tempFile) // 清理临时文件
-		return fmt.Errorf("保存文件失败: %v", err)
-	}
-	file.Close()
-	
-	// 设置执行权限
-	if err := os.Chmod(tempFile, 0755); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("设置执行权限失败: %v", err)
-	}
-	
-	// 在Windows上，需要先删除原文件再重命名
-	if runtime.GOOS == "windows" {
-		// 创建批处理文件来执行替换
-		batchContent := fmt.Sprintf(`@echo off
-timeout /t 2 /nobreak >nul
-del "%s"
-move "%s" "%s"
-del "%~f0"
-`, currentPath, tempFile, currentPath)
-		
-		batchFile := filepath.Join(filepath.Dir(currentPath), "update.bat")
-		if err := os.WriteFile(batchFile, []byte(batchContent), 0644); err != nil {
-			os.Remove(tempFile)
-			return fmt.Errorf("创建更新脚本失败: %v", err)
-		}
-		
-		// 执行批处理文件
-		cmd := exec.Command("cmd", "/C", batchFile)
-		cmd.Start() // 异步执行，不等待完成
-		
-	} else {
-		// Unix系统直接替换
-		if err := os.Rename(tempFile, currentPath); err != nil {
-			os.Remove(tempFile)
-			return fmt.Errorf("替换文件失败: %v", err)
-		}
-	}
-	
-	return nil
-}
-
-
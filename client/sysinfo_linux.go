@@ -5,6 +5,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -13,67 +14,105 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func CollectSystemInfo() (SysInfo, error) {
-	var info SysInfo
+func init() {
+	registerCollector(newCollector("hostname", 2*time.Second, collectHostname))
+	registerCollector(newCollector("cpu", 2*time.Second, collectCPU))
+	registerCollector(newCollector("ram", 2*time.Second, collectRAM))
+	registerCollector(newCollector("disk", 5*time.Second, collectDisk))
+	registerCollector(newCollector("sn", 5*time.Second, collectSN))
+	registerCollector(newCollector("network", 5*time.Second, collectNetwork))
+}
 
-	// 主机名
-	if h, err := os.Hostname(); err == nil {
-		info.Name = h
+// collectHostname 主机名
+func collectHostname(ctx context.Context) (map[string]string, error) {
+	h, err := os.Hostname()
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{"Name": h}, nil
+}
 
-	// CPU 型号
-	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			if strings.HasPrefix(strings.ToLower(line), "model name") {
-				parts := strings.SplitN(line, ":", 2)
-				if len(parts) == 2 { info.CPU = strings.TrimSpace(parts[1]) }
-				break
-			}
+// collectCPU CPU 型号
+func collectCPU(ctx context.Context) (map[string]string, error) {
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.ToLower(line), "model name") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 { return map[string]string{"CPU": strings.TrimSpace(parts[1])}, nil }
+			break
 		}
 	}
+	return nil, errors.New("未找到 model name")
+}
 
-	// 内存容量（总量，四舍五入到MB/GB字符串）
-	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
-		var kB int64
-		for _, line := range strings.Split(string(data), "\n") {
-			if strings.HasPrefix(line, "MemTotal:") {
-				fields := strings.Fields(line)
-				if len(fields) >= 2 {
-					if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil { kB = v }
-				}
-				break
+// collectRAM 内存容量（总量，四舍五入到MB/GB字符串）
+func collectRAM(ctx context.Context) (map[string]string, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	var kB int64
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "MemTotal:") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil { kB = v }
 			}
+			break
 		}
-		if kB > 0 { info.RAM = humanSize(int64(kB) * 1024) }
 	}
+	if kB <= 0 {
+		return nil, errors.New("未找到 MemTotal")
+	}
+	return map[string]string{"RAM": humanSize(int64(kB) * 1024)}, nil
+}
 
-	// 磁盘（根分区大小）
-	if out, err := exec.Command("df", "-k", "/").Output(); err == nil {
-		scanner := bufio.NewScanner(bytes.NewReader(out))
-		// 跳过表头
-		if scanner.Scan() {}
-		if scanner.Scan() {
-			line := scanner.Text()
-			fs := strings.Fields(line)
-			if len(fs) >= 2 {
-				if blocks, err := strconv.ParseInt(fs[1], 10, 64); err == nil {
-					info.Disk = humanSize(blocks * 1024) // 1K-blocks
-				}
+// collectDisk 磁盘（根分区大小）
+func collectDisk(ctx context.Context) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, "df", "-k", "/").Output()
+	if err != nil {
+		return nil, fmt.Errorf("df执行失败: %v", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	// 跳过表头
+	if scanner.Scan() {}
+	if scanner.Scan() {
+		line := scanner.Text()
+		fs := strings.Fields(line)
+		if len(fs) >= 2 {
+			if blocks, err := strconv.ParseInt(fs[1], 10, 64); err == nil {
+				return map[string]string{"Disk": humanSize(blocks * 1024)}, nil // 1K-blocks
 			}
 		}
 	}
+	return nil, errors.New("无法解析df输出")
+}
 
-	// 序列号（尝试从 /sys；需要root可能更稳定）
+// collectSN 序列号（尝试从 /sys；需要root可能更稳定）
+func collectSN(ctx context.Context) (map[string]string, error) {
 	if data, err := os.ReadFile("/sys/class/dmi/id/product_serial"); err == nil {
-		info.SN = strings.TrimSpace(string(data))
-	} else if out, err := exec.Command("dmidecode", "-s", "system-serial-number").Output(); err == nil {
-		info.SN = strings.TrimSpace(string(out))
+		return map[string]string{"SN": strings.TrimSpace(string(data))}, nil
+	}
+	out, err := exec.CommandContext(ctx, "dmidecode", "-s", "system-serial-number").Output()
+	if err != nil {
+		return nil, fmt.Errorf("dmidecode执行失败: %v", err)
 	}
+	return map[string]string{"SN": strings.TrimSpace(string(out))}, nil
+}
 
-	// MAC 与 IP：取一个非回环、非虚拟接口
-	ifaces, _ := net.Interfaces()
+// collectNetwork MAC 与 IP：取一个非回环、非虚拟接口
+func collectNetwork(ctx context.Context) (map[string]string, error) {
+	var info SysInfo
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
 	for _, nic := range ifaces {
 		name := strings.ToLower(nic.Name)
 		// 排除回环与常见虚拟接口
@@ -109,11 +148,10 @@ func CollectSystemInfo() (SysInfo, error) {
 		}
 		if info.IP != "" && info.MAC != "" { break }
 	}
-
-	if info.Name == "" && info.CPU == "" {
-		return info, errors.New("未能成功采集关键字段")
+	if info.MAC == "" && info.IP == "" {
+		return nil, errors.New("未找到可用的物理网卡")
 	}
-	return info, nil
+	return map[string]string{"MAC": info.MAC, "IP": info.IP, "Network": info.Network}, nil
 }
 
 func isVirtualIface(name string) bool {
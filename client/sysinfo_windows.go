@@ -3,82 +3,119 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"time"
 )
 
-func CollectSystemInfo() (SysInfo, error) {
-	var info SysInfo
+func init() {
+	registerCollector(newCollector("hostname", 2*time.Second, collectHostname))
+	registerCollector(newCollector("cpu", 15*time.Second, collectCPU))
+	registerCollector(newCollector("ram", 15*time.Second, collectRAM))
+	registerCollector(newCollector("disk", 15*time.Second, collectDisk))
+	registerCollector(newCollector("sn", 15*time.Second, collectSN))
+	registerCollector(newCollector("network", 30*time.Second, collectNetwork))
+}
 
-	// 计算机名
-	info.Name = os.Getenv("COMPUTERNAME")
-	if info.Name == "" { if h, _ := os.Hostname(); h != "" { info.Name = h } }
+// collectHostname 计算机名
+func collectHostname(ctx context.Context) (map[string]string, error) {
+	name := os.Getenv("COMPUTERNAME")
+	if name == "" { if h, _ := os.Hostname(); h != "" { name = h } }
+	if name == "" {
+		return nil, errors.New("无法获取计算机名")
+	}
+	return map[string]string{"Name": name}, nil
+}
 
-	// CPU 型号
-	if out, err := runPwsh("Get-CimInstance Win32_Processor | Select-Object -ExpandProperty Name"); err == nil {
-		info.CPU = firstLine(out)
+// collectCPU CPU 型号
+func collectCPU(ctx context.Context) (map[string]string, error) {
+	out, err := runPwsh(ctx, "Get-CimInstance Win32_Processor | Select-Object -ExpandProperty Name")
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{"CPU": firstLine(out)}, nil
+}
 
-	// 内存容量（总物理内存）
-	if out, err := runPwsh("(Get-CimInstance Win32_ComputerSystem).TotalPhysicalMemory"); err == nil {
-		info.RAM = humanSize(parseInt64(firstLine(out)))
+// collectRAM 内存容量（总物理内存）
+func collectRAM(ctx context.Context) (map[string]string, error) {
+	out, err := runPwsh(ctx, "(Get-CimInstance Win32_ComputerSystem).TotalPhysicalMemory")
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{"RAM": humanSize(parseInt64(firstLine(out)))}, nil
+}
 
-	// 系统盘大小（系统卷）
-	if out, err := runPwsh(`$sys=(Get-CimInstance Win32_OperatingSystem).SystemDrive; (Get-CimInstance Win32_LogicalDisk | Where-Object {$_.DeviceID -eq $sys}).Size`); err == nil {
-		info.Disk = humanSize(parseInt64(firstLine(out)))
+// collectDisk 系统盘大小（系统卷）
+func collectDisk(ctx context.Context) (map[string]string, error) {
+	out, err := runPwsh(ctx, `$sys=(Get-CimInstance Win32_OperatingSystem).SystemDrive; (Get-CimInstance Win32_LogicalDisk | Where-Object {$_.DeviceID -eq $sys}).Size`)
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{"Disk": humanSize(parseInt64(firstLine(out)))}, nil
+}
 
-	// 序列号（避免 wmic，使用 CIM）
-	if out, err := runPwsh("(Get-CimInstance Win32_BIOS).SerialNumber"); err == nil {
-		info.SN = strings.TrimSpace(firstLine(out))
+// collectSN 序列号（避免 wmic，使用 CIM）
+func collectSN(ctx context.Context) (map[string]string, error) {
+	out, err := runPwsh(ctx, "(Get-CimInstance Win32_BIOS).SerialNumber")
+	if err != nil {
+		return nil, err
 	}
+	return map[string]string{"SN": strings.TrimSpace(firstLine(out))}, nil
+}
 
-    // 仅选择物理适配器且状态为Up
-    if out, err := runPwsh(`Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1 -ExpandProperty MacAddress`); err == nil {
+// collectNetwork 仅选择物理适配器且状态为Up，采集 MAC、IP 与网络类型
+func collectNetwork(ctx context.Context) (map[string]string, error) {
+	var info SysInfo
+	var firstErr error
+	if out, err := runPwsh(ctx, `Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1 -ExpandProperty MacAddress`); err == nil {
 		info.MAC = strings.TrimSpace(firstLine(out))
+	} else {
+		firstErr = err
 	}
-    if out, err := runPwsh(`$n=(Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1 -ExpandProperty Name); (Get-NetIPConfiguration -InterfaceAlias $n).IPv4Address.IPAddress`); err == nil {
+	if out, err := runPwsh(ctx, `$n=(Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1 -ExpandProperty Name); (Get-NetIPConfiguration -InterfaceAlias $n).IPv4Address.IPAddress`); err == nil {
 		info.IP = strings.TrimSpace(firstLine(out))
+	} else if firstErr == nil {
+		firstErr = err
+	}
+
+	// 判定 Network：以当前选择的物理网卡的 NdisPhysicalMedium 来判断
+	if out, err := runPwsh(ctx, `(Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1).NdisPhysicalMedium`); err == nil {
+		medium := strings.ToLower(strings.TrimSpace(firstLine(out)))
+		switch medium {
+		case "802.3", "ethernet", "native_802_3":
+			info.Network = "ETHERNET"
+		case "native_802_11", "wireless", "802.11", "wifi":
+			info.Network = "WIFI"
+		default:
+			// 再用 InterfaceDescription 兜底
+			if out2, err2 := runPwsh(ctx, `(Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1).InterfaceDescription`); err2 == nil {
+				desc := strings.ToLower(strings.TrimSpace(firstLine(out2)))
+				if strings.Contains(desc, "wireless") || strings.Contains(desc, "wifi") || strings.Contains(desc, "wlan") {
+					info.Network = "WIFI"
+				} else if strings.Contains(desc, "ethernet") || strings.Contains(desc, "gigabit") || strings.Contains(desc, "10/100") {
+					info.Network = "ETHERNET"
+				}
+			}
+		}
 	}
 
-    // 判定 Network：以当前选择的物理网卡的 NdisPhysicalMedium 来判断
-    if out, err := runPwsh(`(Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1).NdisPhysicalMedium`); err == nil {
-        medium := strings.ToLower(strings.TrimSpace(firstLine(out)))
-        switch medium {
-        case "802.3", "ethernet", "native_802_3":
-            info.Network = "ETHERNET"
-        case "native_802_11", "wireless", "802.11", "wifi":
-            info.Network = "WIFI"
-        default:
-            // 再用 InterfaceDescription 兜底
-            if out2, err2 := runPwsh(`(Get-NetAdapter | Where-Object { $_.Status -eq 'Up' -and $_.Virtual -eq $false -and $_.HardwareInterface -eq $true } | Select-Object -First 1).InterfaceDescription`); err2 == nil {
-                desc := strings.ToLower(strings.TrimSpace(firstLine(out2)))
-                if strings.Contains(desc, "wireless") || strings.Contains(desc, "wifi") || strings.Contains(desc, "wlan") {
-                    info.Network = "WIFI"
-                } else if strings.Contains(desc, "ethernet") || strings.Contains(desc, "gigabit") || strings.Contains(desc, "10/100") {
-                    info.Network = "ETHERNET"
-                }
-            }
-        }
-    }
-
-	if info.Name == "" && info.CPU == "" {
-		return info, errors.New("未能成功采集关键字段")
+	if info.MAC == "" && info.IP == "" && firstErr != nil {
+		return nil, firstErr
 	}
-	return info, nil
+	return map[string]string{"MAC": info.MAC, "IP": info.IP, "Network": info.Network}, nil
 }
 
-func runPwsh(script string) (string, error) {
+func runPwsh(ctx context.Context, script string) (string, error) {
 	// 优先使用 pwsh，其次 powershell
-	cmd := exec.Command("pwsh", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd := exec.CommandContext(ctx, "pwsh", "-NoProfile", "-NonInteractive", "-Command", script)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
 		out, err = cmd.CombinedOutput()
 		if err != nil {
 			return "", fmt.Errorf("powershell执行失败: %v, output=%s", err, string(out))
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"goup-server/internal/semver"
+	"goup-server/internal/updatesig"
+)
+
+// UpdateInfo 更新信息结构体
+type UpdateInfo struct {
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+	Downloads   map[string]DownloadInfo `json:"downloads"`
+	// 发布通道与适用的客户端版本范围（可选）
+	Channel     string `json:"channel,omitempty"`
+	MinVersion  string `json:"min_version,omitempty"`
+	MaxVersion  string `json:"max_version,omitempty"`
+	// 清单的 Ed25519 签名（base64），覆盖版本、说明与全部下载信息
+	Signature   string `json:"signature,omitempty"`
+}
+
+// DownloadInfo 下载信息结构体
+type DownloadInfo struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// 编译时指定的更新检查URL，可以通过 -ldflags 参数动态设置；
+// 为空时使用上报服务器提供的更新清单（/api/update/manifest）
+var updateCheckURL = ""
+
+// 编译时内置的更新签名公钥（base64），通过 -ldflags "-X main.updatePublicKey=..." 设置；
+// 未设置时不检查更新
+var updatePublicKey = ""
+
+// noPublicKeyOnce 未内置公钥的提示只输出一次
+var noPublicKeyOnce sync.Once
+
+// legacyUpdateURL 旧版本编译脚本默认的更新地址，视同未设置
+const legacyUpdateURL = "https://raw.githubusercontent.com/LxnChan/info-receiver-go/refs/heads/main/update.json"
+
+// errNoUpdate 服务器没有可用的更新
+var errNoUpdate = errors.New("没有可用的更新")
+
+// checkAndUpdate 检查并执行自动更新，server 为本次上报成功的服务器地址，
+// sn 与 mac 用于服务器识别设备以决定分批发布与冻结规则
+func checkAndUpdate(cfg *ClientConfig, server, sn, mac string) error {
+	// 没有公钥无法校验任何清单，不必每次都下载清单再记录失败
+	if updatePublicKey == "" {
+		noPublicKeyOnce.Do(func() {
+			fmt.Println("客户端未内置更新公钥（编译时通过 -X main.updatePublicKey 指定），不检查更新")
+		})
+		return nil
+	}
+	
+	// 上次更新尚未确认时不再安装新的版本
+	state := loadUpdateState(cfg.StateFile)
+	if state.Status == "pending" {
+		fmt.Printf("版本 %s 尚未确认，跳过更新检查\n", state.Version)
+		return nil
+	}
+	
+	fmt.Println("正在检查更新...")
+	
+	// 获取更新信息：未指定更新地址时使用上报服务器的更新清单
+	checkURL := cfg.UpdateURL
+	if checkURL == "" || checkURL == legacyUpdateURL {
+		checkURL = serverManifestURL(server, sn, mac)
+	}
+	if cfg.UpdateChannel != "" {
+		if u, err := url.Parse(checkURL); err == nil {
+			q := u.Query()
+			q.Set("channel", cfg.UpdateChannel)
+			u.RawQuery = q.Encode()
+			checkURL = u.String()
+		}
+	}
+	client, err := cfg.HTTPClient(30 * time.Second)
+	if err != nil {
+		return err
+	}
+	updateInfo, err := fetchUpdateInfo(client, checkURL, serverToken(checkURL, server, cfg.Token))
+	if err == errNoUpdate {
+		fmt.Println("当前已是最新版本")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("获取更新信息失败: %v", err)
+	}
+	
+	// 通道与版本范围：服务器已按客户端筛选，这里同样适用于独立托管的清单
+	if !updateApplies(updateInfo, cfg.UpdateChannel) {
+		fmt.Printf("版本 %s 不适用于当前客户端（通道 %s，版本 %s）\n", updateInfo.Version, cfg.UpdateChannel, clientVersion)
+		return nil
+	}
+	
+	// 比较版本
+	if !isNewerVersion(updateInfo.Version, clientVersion) {
+		fmt.Println("当前已是最新版本")
+		return nil
+	}
+	if updateInfo.Version == state.FailedVersion {
+		fmt.Printf("版本 %s 曾安装失败或被回滚（%s），跳过\n", updateInfo.Version, state.Error)
+		return nil
+	}
+	
+	// 校验清单签名，未签名或签名不符时拒绝更新
+	if err := verifyUpdateInfo(updateInfo); err != nil {
+		err = fmt.Errorf("拒绝安装版本 %s: %v", updateInfo.Version, err)
+		recordUpdateFailure(cfg.StateFile, updateInfo.Version, err, false)
+		return err
+	}
+	
+	fmt.Printf("发现新版本 %s，正在下载更新...\n", updateInfo.Version)
+	
+	// 获取当前平台对应的下载信息，相对地址以更新清单地址为基准
+	download, err := getDownloadInfo(updateInfo)
+	if err != nil {
+		return fmt.Errorf("获取下载链接失败: %v", err)
+	}
+	if base, err := url.Parse(checkURL); err == nil {
+		if ref, err := url.Parse(download.URL); err == nil {
+			download.URL = base.ResolveReference(ref).String()
+		}
+	}
+	
+	// 下载并替换
+	downloadClient, err := cfg.HTTPClient(5 * time.Minute)
+	if err != nil {
+		return err
+	}
+	if err := downloadAndReplace(downloadClient, download, serverToken(download.URL, server, cfg.Token), updateInfo.Version, cfg.StateFile); err != nil {
+		return fmt.Errorf("安装更新失败: %v", err)
+	}
+	
+	fmt.Println("更新完成，程序将在下次运行时使用新版本")
+	return nil
+}
+
+// serverBase 根据上报接口地址得到服务器根地址
+func serverBase(server string) string {
+	base := strings.TrimRight(strings.TrimSpace(server), "/")
+	if strings.HasSuffix(strings.ToLower(base), "/api/client") {
+		base = base[:len(base)-len("/api/client")]
+	}
+	return base
+}
+
+// serverManifestURL 根据上报接口地址生成服务器更新清单地址
+func serverManifestURL(server, sn, mac string) string {
+	base := serverBase(server)
+	q := url.Values{}
+	q.Set("os", runtime.GOOS)
+	q.Set("arch", runtime.GOARCH)
+	q.Set("version", clientVersion)
+	if sn != "" {
+		q.Set("sn", sn)
+	}
+	if mac != "" {
+		q.Set("mac", mac)
+	}
+	return base + "/api/update/manifest?" + q.Encode()
+}
+
+// serverToken 只有地址与上报服务器的协议和主机相同时才返回上报令牌，
+// 避免把令牌发送给独立托管的更新清单或下载地址
+func serverToken(rawURL, server, token string) string {
+	if token == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	s, err := url.Parse(serverBase(server))
+	if err != nil || s.Host == "" {
+		return ""
+	}
+	if !strings.EqualFold(u.Scheme, s.Scheme) || !strings.EqualFold(u.Host, s.Host) {
+		return ""
+	}
+	return token
+}
+
+// getWithToken 发送 GET 请求，token 不为空时携带 Authorization 头
+func getWithToken(client *http.Client, url, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(req)
+}
+
+// fetchUpdateInfo 从URL获取更新信息
+func fetchUpdateInfo(client *http.Client, url, token string) (*UpdateInfo, error) {
+	resp, err := getWithToken(client, url, token)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return nil, errNoUpdate
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP状态码: %d", resp.StatusCode)
+	}
+	
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	
+	var updateInfo UpdateInfo
+	if err := json.Unmarshal(body, &updateInfo); err != nil {
+		return nil, err
+	}
+	
+	if updateInfo.Version == "" || updateInfo.Downloads == nil {
+		return nil, fmt.Errorf("更新信息格式错误")
+	}
+	
+	return &updateInfo, nil
+}
+
+// isNewerVersion 按语义化版本比较，返回true表示newVersion比currentVersion更新；
+// 无法解析的版本号视为低于任何有效版本，因此格式错误的清单不会被安装
+func isNewerVersion(newVersion, currentVersion string) bool {
+	return semver.CompareStrings(newVersion, currentVersion) > 0
+}
+
+// verifyUpdateInfo 使用内置公钥校验更新清单签名
+func verifyUpdateInfo(info *UpdateInfo) error {
+	if updatePublicKey == "" {
+		return errors.New("客户端未内置更新公钥（编译时通过 -X main.updatePublicKey 指定）")
+	}
+	pub, err := updatesig.ParsePublicKey(updatePublicKey)
+	if err != nil {
+		return fmt.Errorf("内置更新公钥无效: %v", err)
+	}
+	downloads := make([]updatesig.Download, 0, len(info.Downloads))
+	for platform, d := range info.Downloads {
+		downloads = append(downloads, updatesig.Download{Platform: platform, URL: d.URL, SHA256: d.SHA256, Size: d.Size})
+	}
+	return updatesig.Verify(pub, updatesig.Manifest{
+		Version:     info.Version,
+		Description: info.Description,
+		Channel:     info.Channel,
+		MinVersion:  info.MinVersion,
+		MaxVersion:  info.MaxVersion,
+		Downloads:   downloads,
+	}, info.Signature)
+}
+
+// updateApplies 判断更新是否适用于当前客户端：stable 通道的版本对所有客户端可用，
+// 其他通道的版本只对同一通道的客户端可用；当前版本需在 min_version 与 max_version 之间
+func updateApplies(info *UpdateInfo, channel string) bool {
+	if info.Channel != "" && info.Channel != "stable" && info.Channel != channel {
+		return false
+	}
+	if info.MinVersion != "" && isNewerVersion(info.MinVersion, clientVersion) {
+		return false
+	}
+	if info.MaxVersion != "" && isNewerVersion(clientVersion, info.MaxVersion) {
+		return false
+	}
+	return true
+}
+
+// getDownloadInfo 根据当前平台获取对应的下载信息，缺少校验和或大小时视为无效
+func getDownloadInfo(updateInfo *UpdateInfo) (DownloadInfo, error) {
+	// 构建平台标识符
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	
+	// 查找对应的下载链接，如果没找到精确匹配，尝试查找通用版本
+	downloadInfo, exists := updateInfo.Downloads[platform]
+	if !exists {
+		downloadInfo, exists = updateInfo.Downloads[runtime.GOOS]
+	}
+	if !exists {
+		return DownloadInfo{}, fmt.Errorf("未找到平台 %s 对应的下载链接", platform)
+	}
+	
+	if sum, err := hex.DecodeString(downloadInfo.SHA256); err != nil || len(sum) != sha256.Size {
+		return DownloadInfo{}, fmt.Errorf("平台 %s 的下载信息缺少有效的 sha256", platform)
+	}
+	if downloadInfo.Size <= 0 {
+		return DownloadInfo{}, fmt.Errorf("平台 %s 的下载信息缺少有效的 size", platform)
+	}
+	return downloadInfo, nil
+}
+
+// downloadAndReplace 下载新版本，校验大小与 SHA-256 并通过自检后，备份当前程序再替换，
+// 同时写入状态文件。替换后由本进程（Windows 上由更新脚本）试运行新版本，启动失败时立即回滚
+func downloadAndReplace(client *http.Client, download DownloadInfo, token, version, stateFile string) error {
+	// 获取当前程序路径
+	currentPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("获取当前程序路径失败: %v", err)
+	}
+	
+	// 新版本先下载到同目录的临时文件，保留扩展名以便在 Windows 上执行自检
+	ext := filepath.Ext(currentPath)
+	tempFile := strings.TrimSuffix(currentPath, ext) + ".new" + ext
+	if err := downloadVerified(client, download, token, tempFile); err != nil {
+		recordUpdateFailure(stateFile, version, err, false)
+		return err
+	}
+	
+	// 运行新版本自检，失败的版本不再重复安装
+	if err := runSelftest(tempFile, version); err != nil {
+		os.Remove(tempFile)
+		recordUpdateFailure(stateFile, version, err, true)
+		return err
+	}
+	
+	// 备份当前程序，供回滚使用
+	prevPath := currentPath + ".prev"
+	if err := copyFile(currentPath, prevPath); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("备份当前程序失败: %v", err)
+	}
+	
+	st := loadUpdateState(stateFile)
+	st.Status, st.Version, st.PreviousVersion, st.PreviousPath = "pending", version, clientVersion, prevPath
+	st.InstalledAt, st.Error = time.Now(), ""
+	if err := st.save(stateFile); err != nil {
+		// 没有状态文件就无法确认或回滚，放弃本次更新
+		os.Remove(tempFile)
+		return err
+	}
+	
+	if err := replaceExecutable(tempFile, currentPath, prevPath); err != nil {
+		st.Status, st.Error = "failed", err.Error()
+		st.addEvent("failed", clientVersion, version, err)
+		st.saveOrWarn(stateFile)
+		return err
+	}
+	st.addEvent("installed", clientVersion, version, nil)
+	st.saveOrWarn(stateFile)
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	// 新版本作为常驻程序运行之前，由仍在运行的旧版本试运行并决定是否回滚
+	return trialUpdate(currentPath, version, stateFile)
+}
+
+// downloadVerified 下载文件到 dst，大小或 SHA-256 与清单不符时删除文件并返回错误
+func downloadVerified(client *http.Client, download DownloadInfo, token, dst string) error {
+	// 下载文件
+	resp, err := getWithToken(client, download.URL, token)
+	if err != nil {
+		return fmt.Errorf("下载失败: %v", err)
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载失败，HTTP状态码: %d", resp.StatusCode)
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength != download.Size {
+		return fmt.Errorf("文件大小不符: 期望 %d 字节，服务器返回 %d 字节", download.Size, resp.ContentLength)
+	}
+	
+	// 创建临时文件
+	file, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	
+	// 复制数据，同时计算 SHA-256；最多读取 size+1 字节以发现超长的响应
+	h := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, h), io.LimitReader(resp.Body, download.Size+1))
+	if err != nil {
+		file.Close()
+		os.Remove(dst) // 清理临时文件
+		return fmt.Errorf("保存文件失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("保存文件失败: %v", err)
+	}
+	
+	// 校验大小与 SHA-256，不一致时拒绝安装
+	if written != download.Size {
+		os.Remove(dst)
+		return fmt.Errorf("文件大小不符: 期望 %d 字节，实际 %d 字节", download.Size, written)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, download.SHA256) {
+		os.Remove(dst)
+		return fmt.Errorf("SHA-256 校验失败: 期望 %s，实际 %s", strings.ToLower(download.SHA256), sum)
+	}
+	
+	// 设置执行权限
+	if err := os.Chmod(dst, 0755); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("设置执行权限失败: %v", err)
+	}
+	return nil
+}
+
+// replaceExecutable 用 src 替换正在运行的程序 dst。
+// Windows 上由更新脚本在本进程退出后替换；prev 非空时脚本随后以 -trial 试运行新程序，
+// 除无法上报（trialUnreportedExit）外的失败都立即用 prev 恢复上一版本，下次启动时记为安装失败
+func replaceExecutable(src, dst, prev string) error {
+	// 在Windows上，需要先删除原文件再重命名
+	if runtime.GOOS == "windows" {
+		// 创建批处理文件来执行替换
+		trial := ""
+		if prev != "" {
+			trial = fmt.Sprintf(`%s -trial%s
+if %%errorlevel%% equ 0 goto done
+if %%errorlevel%% equ %d goto done
+copy /y %s %s >nul
+`, batchQuote(dst), batchArgs(os.Args[1:]), trialUnreportedExit, batchQuote(prev), batchQuote(dst))
+		}
+		batchContent := fmt.Sprintf(`@echo off
+timeout /t 2 /nobreak >nul
+del %s
+move %s %s >nul || goto done
+%s:done
+del "%%~f0"
+`, batchQuote(dst), batchQuote(src), batchQuote(dst), trial)
+		
+		batchFile := filepath.Join(filepath.Dir(dst), "update.bat")
+		if err := os.WriteFile(batchFile, []byte(batchContent), 0644); err != nil {
+			os.Remove(src)
+			return fmt.Errorf("创建更新脚本失败: %v", err)
+		}
+		
+		// 执行批处理文件
+		cmd := exec.Command("cmd", "/C", batchFile)
+		cmd.Start() // 异步执行，不等待完成
+		return nil
+	}
+	
+	// Unix系统直接替换
+	if err := os.Rename(src, dst); err != nil {
+		os.Remove(src)
+		return fmt.Errorf("替换文件失败: %v", err)
+	}
+	return nil
+}
+
+// batchQuote 将参数加上引号写入批处理文件，% 需要写成 %% 才不会被展开为变量
+func batchQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, "%", "%%") + `"`
+}
+
+// batchArgs 将命令行参数依次加上引号，以空格开头
+func batchArgs(args []string) string {
+	var b strings.Builder
+	for _, a := range args {
+		b.WriteString(" " + batchQuote(a))
+	}
+	return b.String()
+}
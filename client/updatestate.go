@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// selftestTimeout 新版本自检的超时时间
+const selftestTimeout = 60 * time.Second
+
+// trialTimeout 替换后试运行新版本（采集并上报一次）的超时时间
+const trialTimeout = 5 * time.Minute
+
+// trialUnreportedExit 试运行时新版本已完成采集，但无法上报到任何服务器的退出码。
+// 网络或服务器故障不能说明新版本有问题，不回滚
+const trialUnreportedExit = 3
+
+// maxQueuedEvents 本地最多保留的未上报更新事件数
+const maxQueuedEvents = 20
+
+// UpdateState 自动更新状态，保存在状态文件中，用于确认新版本可用或回滚
+type UpdateState struct {
+	// pending：已替换、等待新版本成功上报；confirmed：新版本已确认；reverted：已回滚；failed：安装失败
+	Status          string    `json:"status,omitempty"`
+	Version         string    `json:"version,omitempty"`
+	PreviousVersion string    `json:"previous_version,omitempty"`
+	PreviousPath    string    `json:"previous_path,omitempty"`
+	InstalledAt     time.Time `json:"installed_at,omitempty"`
+	// 自检失败或被回滚的版本，不再重复安装
+	FailedVersion string `json:"failed_version,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// 尚未上报给服务器的更新事件
+	Events []UpdateEvent `json:"events,omitempty"`
+}
+
+// UpdateEvent 上报给服务器的更新结果
+type UpdateEvent struct {
+	// installed/confirmed/failed/reverted
+	Status      string    `json:"status"`
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// defaultStateFile 返回当前平台默认的更新状态文件路径
+func defaultStateFile() string {
+	return filepath.Join(filepath.Dir(defaultConfigPath()), "update-state.json")
+}
+
+// loadUpdateState 读取更新状态，文件不存在或损坏时返回空状态
+func loadUpdateState(path string) *UpdateState {
+	st := &UpdateState{}
+	if path == "" {
+		return st
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		fmt.Fprintf(os.Stderr, "更新状态文件 %s 已损坏，将重新创建: %v\n", path, err)
+		return &UpdateState{}
+	}
+	return st
+}
+
+// save 原子地写入更新状态
+func (st *UpdateState) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建状态目录失败: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入更新状态失败: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// addEvent 记录待上报的更新事件
+func (st *UpdateState) addEvent(status, from, to string, err error) {
+	ev := UpdateEvent{Status: status, FromVersion: from, ToVersion: to, Time: time.Now()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	st.Events = append(st.Events, ev)
+	if len(st.Events) > maxQueuedEvents {
+		st.Events = st.Events[len(st.Events)-maxQueuedEvents:]
+	}
+}
+
+// saveOrWarn 保存状态，失败时只输出警告
+func (st *UpdateState) saveOrWarn(path string) {
+	if err := st.save(path); err != nil {
+		fmt.Fprintf(os.Stderr, "保存更新状态失败: %v\n", err)
+	}
+}
+
+// recordUpdateFailure 记录安装失败；skip 为 true 时该版本不再重复安装
+func recordUpdateFailure(path, version string, err error, skip bool) {
+	st := loadUpdateState(path)
+	st.addEvent("failed", clientVersion, version, err)
+	if skip {
+		st.FailedVersion = version
+		st.Error = err.Error()
+	}
+	st.saveOrWarn(path)
+}
+
+// guardPendingUpdate 在进程启动时检查尚未确认的更新：替换后启动的仍是旧版本时记录安装失败。
+// 这包括 Windows 更新脚本替换失败，以及试运行失败后恢复了上一版本的情况。
+// 新版本是否可用由替换它的旧版本（或更新脚本）试运行判断，不依赖新版本自身
+func guardPendingUpdate(cfg *ClientConfig) {
+	st := loadUpdateState(cfg.StateFile)
+	if st.Status != "pending" || st.Version == clientVersion {
+		return
+	}
+	err := fmt.Errorf("替换后运行的版本为 %s，新版本 %s 未生效或试运行失败", clientVersion, st.Version)
+	fmt.Fprintf(os.Stderr, "更新失败: %v\n", err)
+	st.Status, st.Error, st.FailedVersion = "failed", err.Error(), st.Version
+	st.addEvent("failed", st.PreviousVersion, st.Version, err)
+	st.saveOrWarn(cfg.StateFile)
+}
+
+// trialUpdate 以 -trial 模式运行刚替换的新版本（沿用当前命令行参数）。新版本上报成功后自行确认；
+// 无法上报时保持待确认，由新版本下次成功上报后确认；其他失败（包括启动即崩溃与超时）立即回滚到上一版本
+func trialUpdate(path, version, stateFile string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), trialTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, append([]string{"-trial"}, os.Args[1:]...)...)
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		fmt.Printf("新版本 %s 试运行成功\n", version)
+		return nil
+	case ctx.Err() == nil && errors.As(err, &exit) && exit.ExitCode() == trialUnreportedExit:
+		fmt.Printf("新版本 %s 试运行时无法上报，将在成功上报后确认\n", version)
+		return nil
+	case ctx.Err() != nil:
+		err = fmt.Errorf("新版本试运行超时（%s）", trialTimeout)
+	default:
+		err = fmt.Errorf("新版本试运行失败: %v: %s", err, lastLine(out))
+	}
+
+	st := loadUpdateState(stateFile)
+	if rerr := revertUpdate(st); rerr != nil {
+		st.Status, st.Error = "failed", rerr.Error()
+		st.addEvent("failed", st.PreviousVersion, version, err)
+		st.saveOrWarn(stateFile)
+		return fmt.Errorf("%v，回滚到版本 %s 失败: %v", err, st.PreviousVersion, rerr)
+	}
+	st.Status, st.Error, st.FailedVersion = "reverted", err.Error(), version
+	st.addEvent("reverted", version, st.PreviousVersion, err)
+	st.saveOrWarn(stateFile)
+	return fmt.Errorf("%v，已回滚到版本 %s", err, st.PreviousVersion)
+}
+
+// confirmPendingUpdate 新版本成功上报后确认更新，保留上一版本的备份
+func confirmPendingUpdate(cfg *ClientConfig) {
+	st := loadUpdateState(cfg.StateFile)
+	if st.Status != "pending" || st.Version != clientVersion {
+		return
+	}
+	st.Status, st.Error = "confirmed", ""
+	st.addEvent("confirmed", st.PreviousVersion, st.Version, nil)
+	st.saveOrWarn(cfg.StateFile)
+	fmt.Printf("版本 %s 已确认可用\n", clientVersion)
+}
+
+// revertUpdate 用备份的上一版本替换当前程序
+func revertUpdate(st *UpdateState) error {
+	if st.PreviousPath == "" {
+		return fmt.Errorf("没有上一版本的备份")
+	}
+	currentPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("获取当前程序路径失败: %v", err)
+	}
+	// 保留备份本身，回滚使用其副本
+	restore := currentPath + ".restore"
+	if err := copyFile(st.PreviousPath, restore); err != nil {
+		return fmt.Errorf("读取备份失败: %v", err)
+	}
+	if err := replaceExecutable(restore, currentPath, ""); err != nil {
+		os.Remove(restore)
+		return err
+	}
+	return nil
+}
+
+// flushUpdateEvents 将本地记录的更新事件上报到服务器，成功后清空
+func flushUpdateEvents(cfg *ClientConfig, client *http.Client, server, sn, mac string) {
+	st := loadUpdateState(cfg.StateFile)
+	if len(st.Events) == 0 {
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{"sn": sn, "mac": mac, "events": st.Events})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, serverBase(server)+"/api/update/events", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "上报更新事件失败: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		fmt.Fprintf(os.Stderr, "上报更新事件失败: 服务器返回 %s\n", resp.Status)
+		return
+	}
+	st.Events = nil
+	st.saveOrWarn(cfg.StateFile)
+}
+
+// runSelftest 以 -selftest 模式运行新版本（沿用当前命令行参数），确认其能正常启动、
+// 加载配置并完成采集，且报告的版本与清单一致
+func runSelftest(path, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), selftestTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, append([]string{"-selftest"}, os.Args[1:]...)...)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("新版本自检超时（%s）", selftestTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("新版本自检失败: %v: %s", err, lastLine(out))
+	}
+	if want := "selftest ok version=" + version; !strings.Contains(string(out), want) {
+		return fmt.Errorf("新版本自检输出与清单版本 %s 不符: %s", version, lastLine(out))
+	}
+	return nil
+}
+
+// selftest -selftest 模式：检查配置与采集器可用后输出版本并退出
+func selftest(cfg *ClientConfig) int {
+	if _, err := cfg.HTTPClient(time.Duration(cfg.Timeout)); err != nil {
+		fmt.Fprintf(os.Stderr, "selftest failed: %v\n", err)
+		return 1
+	}
+	if _, err := CollectSystemInfo(cfg.CollectOptions()); err != nil {
+		fmt.Fprintf(os.Stderr, "selftest failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("selftest ok version=%s\n", clientVersion)
+	return 0
+}
+
+// trial -trial 模式：采集并上报一次（不检查更新），成功上报即确认本版本。
+// 采集或上报以外的失败返回 1；只是无法上报时返回 trialUnreportedExit
+func trial(cfg *ClientConfig) int {
+	err := runOnce(cfg, false)
+	if err == nil {
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, err)
+	if errors.Is(err, errReportFailed) {
+		return trialUnreportedExit
+	}
+	return 1
+}
+
+// lastLine 返回输出的最后一个非空行，用于错误信息
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// copyFile 复制文件并设置可执行权限
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
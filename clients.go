@@ -0,0 +1,589 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goup-server/internal/semver"
+)
+
+// ClientSummary 客户端列表中的一行
+type ClientSummary struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	SN      string    `json:"sn"`
+	MAC     string    `json:"mac"`
+	IP      string    `json:"ip"`
+	UpVer   string    `json:"up_ver"`
+	Network string    `json:"network"`
+	Comment string    `json:"comment"`
+	PostAt  time.Time `json:"post_at"`
+	Online  bool      `json:"online"`
+	// 管理员维护的负责人、部门、位置、标签与备注
+	Metadata ClientMetadata `json:"metadata"`
+	// 归档信息，未归档时省略
+	Archived *ClientArchive `json:"archived,omitempty"`
+}
+
+// ClientDetail 客户端详情，包含全部字段、自定义事实与采集器状态
+type ClientDetail struct {
+	ClientSummary
+	CPU        string                     `json:"cpu"`
+	RAM        string                     `json:"ram"`
+	Disk       string                     `json:"disk"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	UpdatedAt  time.Time                  `json:"updated_at"`
+	Facts      map[string]string          `json:"facts"`
+	Collectors map[string]CollectorStatus `json:"collectors"`
+	// 导入的资产属性，不会被客户端上报覆盖
+	Attributes map[string]string `json:"attributes"`
+	// 所属的静态与动态分组
+	Groups []GroupRef `json:"groups"`
+}
+
+// ClientChange 变更时间线中的一条记录：client_changes 中的上报记录，或 change_type 为 metadata 的
+// 管理员编辑记录（ChangedBy 为操作人）。Changed 为与同类的上一条记录相比发生变化的字段
+type ClientChange struct {
+	ID        int               `json:"id"`
+	Type      string            `json:"change_type"`
+	ChangedAt time.Time         `json:"changed_at"`
+	ChangedBy string            `json:"changed_by,omitempty"`
+	Fields    map[string]string `json:"fields"`
+	Changed   []string          `json:"changed"`
+}
+
+// changeFields client_changes 中记录的字段，按显示顺序排列
+var changeFields = []string{"name", "cpu", "ram", "disk", "sn", "mac", "ip", "up_ver", "comment", "network"}
+
+// ClientFilter 客户端列表的筛选与排序条件，零值表示不限
+type ClientFilter struct {
+	// 在主机名、SN、MAC、IP、备注以及负责人、部门、位置、标签中模糊搜索
+	Query   string
+	Network string
+	// 按管理员元数据精确筛选，Tags 需全部包含
+	Owner      string
+	Department string
+	Location   string
+	Tags       []string
+	// 只列出该分组的成员
+	Group int
+	// 只列出这些分组的成员，用于限定分组的账号，不从查询参数读取
+	Scope []int
+	// online 或 offline，按 post_at 是否在 inventory.offline_after 之内判断
+	Status string
+	// 归档的客户端：空字符串表示排除，include 表示包含，only 表示只列出归档的客户端
+	Archived string
+	// 客户端版本早于/晚于指定版本（按语义化版本比较，无法解析的版本视为最旧）
+	OlderThan string
+	NewerThan string
+	// 只列出版本低于最新版本的客户端，最新版本取已上传的更新包中最高的版本
+	Outdated bool
+	// 排序字段（见 clientSorters）与方向
+	Sort string
+	Desc bool
+}
+
+// clientSorters 支持的排序字段
+var clientSorters = map[string]func(a, b *ClientSummary) int{
+	"id": func(a, b *ClientSummary) int { return a.ID - b.ID },
+	"name": func(a, b *ClientSummary) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	},
+	"sn":      func(a, b *ClientSummary) int { return strings.Compare(a.SN, b.SN) },
+	"mac":     func(a, b *ClientSummary) int { return strings.Compare(a.MAC, b.MAC) },
+	"ip":      func(a, b *ClientSummary) int { return compareIP(a.IP, b.IP) },
+	"up_ver":  func(a, b *ClientSummary) int { return semver.CompareStrings(a.UpVer, b.UpVer) },
+	"network": func(a, b *ClientSummary) int { return strings.Compare(a.Network, b.Network) },
+	"comment": func(a, b *ClientSummary) int { return strings.Compare(a.Comment, b.Comment) },
+	"post_at": func(a, b *ClientSummary) int { return a.PostAt.Compare(b.PostAt) },
+	"owner":   func(a, b *ClientSummary) int { return strings.Compare(a.Metadata.Owner, b.Metadata.Owner) },
+	"department": func(a, b *ClientSummary) int {
+		return strings.Compare(a.Metadata.Department, b.Metadata.Department)
+	},
+	"location": func(a, b *ClientSummary) int { return strings.Compare(a.Metadata.Location, b.Metadata.Location) },
+}
+
+// compareIP 按数值比较 IPv4 地址，其他格式按字符串比较
+func compareIP(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	if len(pa) == 4 && len(pb) == 4 {
+		for i := range pa {
+			x, errX := strconv.Atoi(pa[i])
+			y, errY := strconv.Atoi(pb[i])
+			if errX != nil || errY != nil {
+				break
+			}
+			if x != y {
+				return x - y
+			}
+			if i == 3 {
+				return 0
+			}
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// clientFilterFromQuery 解析客户端列表的查询参数：q、network、status、archived、older_than、newer_than、
+// outdated、owner、department、location、tag（可重复）、group（分组 ID）、sort（字段名，前缀 "-" 表示倒序）
+func clientFilterFromQuery(q url.Values) (ClientFilter, error) {
+	f := ClientFilter{
+		Query:     strings.TrimSpace(q.Get("q")),
+		Network:   strings.TrimSpace(q.Get("network")),
+		Status:    strings.ToLower(strings.TrimSpace(q.Get("status"))),
+		Archived:  strings.ToLower(strings.TrimSpace(q.Get("archived"))),
+		OlderThan: strings.TrimSpace(q.Get("older_than")),
+		NewerThan: strings.TrimSpace(q.Get("newer_than")),
+		Sort:      strings.TrimSpace(q.Get("sort")),
+
+		Owner:      strings.TrimSpace(q.Get("owner")),
+		Department: strings.TrimSpace(q.Get("department")),
+		Location:   strings.TrimSpace(q.Get("location")),
+	}
+	for _, t := range q["tag"] {
+		if t = strings.TrimSpace(t); t != "" {
+			f.Tags = append(f.Tags, t)
+		}
+	}
+	for _, v := range []string{f.OlderThan, f.NewerThan} {
+		if v == "" {
+			continue
+		}
+		if _, err := semver.Parse(v); err != nil {
+			return f, err
+		}
+	}
+	if f.Status != "" && f.Status != "online" && f.Status != "offline" {
+		return f, fmt.Errorf("无效的 status: %s（可选 online、offline）", f.Status)
+	}
+	if f.Archived != "" && f.Archived != "include" && f.Archived != "only" {
+		return f, fmt.Errorf("无效的 archived: %s（可选 include、only）", f.Archived)
+	}
+	if v := q.Get("group"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return f, fmt.Errorf("无效的 group: %s", v)
+		}
+		f.Group = id
+	}
+	if v := q.Get("outdated"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("无效的 outdated: %s", v)
+		}
+		f.Outdated = b
+	}
+	if strings.HasPrefix(f.Sort, "-") {
+		f.Sort, f.Desc = f.Sort[1:], true
+	}
+	if f.Sort == "" {
+		f.Sort = "up_ver"
+	}
+	if clientSorters[f.Sort] == nil {
+		return f, fmt.Errorf("无效的排序字段: %s", f.Sort)
+	}
+	return f, nil
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// LatestVersion 返回已上传的更新包中最高的版本，没有更新包时返回上报过的最高客户端版本
+func (db *Database) LatestVersion() (string, error) {
+	artifacts, err := db.ListArtifacts("", "")
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, a := range artifacts {
+		if semver.CompareStrings(a.Version, latest) > 0 {
+			latest = a.Version
+		}
+	}
+	if latest != "" {
+		return latest, nil
+	}
+	rows, err := db.conn.Query(`SELECT DISTINCT IFNULL(up_ver, '') FROM client_info`)
+	if err != nil {
+		return "", fmt.Errorf("查询客户端版本失败: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return "", fmt.Errorf("读取客户端版本失败: %v", err)
+		}
+		if semver.Valid(v) && semver.CompareStrings(v, latest) > 0 {
+			latest = v
+		}
+	}
+	return latest, rows.Err()
+}
+
+// clientSelect 读取客户端字段的列（client_info 别名为 c），顺序与 queryClients、GetClient 的 Scan 对应，
+// 之后依次为 metadataSelect 与 archiveSelect
+const clientSelect = `c.id, IFNULL(c.name, ''), IFNULL(c.sn, ''), IFNULL(c.mac, ''), IFNULL(c.ip, ''), IFNULL(c.up_ver, ''),
+	IFNULL(c.network, ''), IFNULL(c.comment, ''), IFNULL(c.cpu, ''), IFNULL(c.ram, ''), IFNULL(c.disk, ''),
+	IFNULL(UNIX_TIMESTAMP(c.post_at), 0), IFNULL(UNIX_TIMESTAMP(c.created_at), 0), IFNULL(UNIX_TIMESTAMP(c.updated_at), 0)`
+
+// clientJoins 客户端查询的表，client_metadata 别名为 m，client_archive 别名为 a
+const clientJoins = `client_info c LEFT JOIN client_metadata m ON m.client_id = c.id LEFT JOIN client_archive a ON a.client_id = c.id`
+
+// clientSortColumns 可以直接在 SQL 中排序的字段，其余字段（版本号、IP）需在内存中排序
+var clientSortColumns = map[string]string{
+	"id": "c.id", "name": "c.name", "sn": "c.sn", "mac": "c.mac", "network": "c.network", "comment": "c.comment",
+	"post_at": "c.post_at", "owner": "m.owner", "department": "m.department", "location": "m.location",
+}
+
+// EachClient 按条件逐行读取客户端（包含 CPU、内存、硬盘等全部字段），用于导出大量数据；
+// 排序字段可在 SQL 中完成时不缓存结果，否则先读取全部再排序
+func (db *Database) EachClient(f ClientFilter, offlineAfter time.Duration, fn func(*ClientDetail) error) error {
+	column, ok := clientSortColumns[f.Sort]
+	if !ok {
+		clients, err := db.listClientDetails(f, offlineAfter)
+		if err != nil {
+			return err
+		}
+		for i := range clients {
+			if err := fn(&clients[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	order := column
+	if f.Desc {
+		order += " DESC"
+	}
+	return db.queryClients(f, offlineAfter, order+", c.id", fn)
+}
+
+// ListClients 按条件列出客户端并排序
+func (db *Database) ListClients(f ClientFilter, offlineAfter time.Duration) ([]ClientSummary, error) {
+	details, err := db.listClientDetails(f, offlineAfter)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]ClientSummary, len(details))
+	for i := range details {
+		clients[i] = details[i].ClientSummary
+	}
+	return clients, nil
+}
+
+// listClientDetails 读取符合条件的全部客户端并在内存中排序
+func (db *Database) listClientDetails(f ClientFilter, offlineAfter time.Duration) ([]ClientDetail, error) {
+	clients := []ClientDetail{}
+	err := db.queryClients(f, offlineAfter, "c.id", func(c *ClientDetail) error {
+		clients = append(clients, *c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	less := clientSorters[f.Sort]
+	if less == nil {
+		less = clientSorters["up_ver"]
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		c := less(&clients[i].ClientSummary, &clients[j].ClientSummary)
+		if f.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	return clients, nil
+}
+
+// queryClients 按条件与 SQL 排序逐行读取客户端，版本条件在读取后筛选
+func (db *Database) queryClients(f ClientFilter, offlineAfter time.Duration, order string, fn func(*ClientDetail) error) error {
+	cutoff := time.Now().Add(-offlineAfter)
+	query := `SELECT ` + clientSelect + `, ` + metadataSelect + `, ` + archiveSelect + `
+		FROM ` + clientJoins + ` WHERE 1 = 1`
+	var args []interface{}
+	if f.Query != "" {
+		like := "%" + escapeLike(f.Query) + "%"
+		query += ` AND (c.name LIKE ? OR c.sn LIKE ? OR c.mac LIKE ? OR c.ip LIKE ? OR c.comment LIKE ?
+			OR m.owner LIKE ? OR m.department LIKE ? OR m.location LIKE ? OR m.tags LIKE ?)`
+		for i := 0; i < 9; i++ {
+			args = append(args, like)
+		}
+	}
+	if f.Network != "" {
+		query += ` AND c.network = ?`
+		args = append(args, f.Network)
+	}
+	for _, c := range []struct{ column, value string }{
+		{"m.owner", f.Owner}, {"m.department", f.Department}, {"m.location", f.Location},
+	} {
+		if c.value != "" {
+			query += ` AND ` + c.column + ` = ?`
+			args = append(args, c.value)
+		}
+	}
+	for _, t := range f.Tags {
+		query += ` AND FIND_IN_SET(?, m.tags) > 0`
+		args = append(args, t)
+	}
+	if f.Group > 0 {
+		query += ` AND c.id IN (SELECT client_id FROM client_group_members WHERE group_id = ?)`
+		args = append(args, f.Group)
+	}
+	if clause, scopeArgs := scopeClause("c.id", f.Scope); clause != "" {
+		query += clause
+		args = append(args, scopeArgs...)
+	}
+	switch f.Archived {
+	case "":
+		query += ` AND a.client_id IS NULL`
+	case "only":
+		query += ` AND a.client_id IS NOT NULL`
+	}
+	switch f.Status {
+	case "online":
+		query += ` AND c.post_at >= FROM_UNIXTIME(?)`
+		args = append(args, cutoff.Unix())
+	case "offline":
+		query += ` AND (c.post_at IS NULL OR c.post_at < FROM_UNIXTIME(?))`
+		args = append(args, cutoff.Unix())
+	}
+	query += ` ORDER BY ` + order
+
+	olderThan := f.OlderThan
+	if f.Outdated {
+		latest, err := db.LatestVersion()
+		if err != nil {
+			return err
+		}
+		if latest == "" {
+			return nil
+		}
+		if olderThan == "" || semver.CompareStrings(latest, olderThan) < 0 {
+			olderThan = latest
+		}
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("查询客户端失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c ClientDetail
+		var postAt, created, updated int64
+		var meta metadataRow
+		var archive archiveRow
+		dest := append([]interface{}{&c.ID, &c.Name, &c.SN, &c.MAC, &c.IP, &c.UpVer, &c.Network, &c.Comment,
+			&c.CPU, &c.RAM, &c.Disk, &postAt, &created, &updated}, meta.dest()...)
+		if err := rows.Scan(append(dest, archive.dest()...)...); err != nil {
+			return fmt.Errorf("读取客户端失败: %v", err)
+		}
+		c.Metadata = meta.value()
+		c.Archived = archive.value()
+		// 版本比较无法在 SQL 中完成，查询后在内存中筛选
+		if olderThan != "" && semver.CompareStrings(c.UpVer, olderThan) >= 0 {
+			continue
+		}
+		if f.NewerThan != "" && semver.CompareStrings(c.UpVer, f.NewerThan) <= 0 {
+			continue
+		}
+		if postAt > 0 {
+			c.PostAt = time.Unix(postAt, 0)
+			c.Online = c.PostAt.After(cutoff)
+		}
+		if created > 0 {
+			c.CreatedAt = time.Unix(created, 0)
+		}
+		if updated > 0 {
+			c.UpdatedAt = time.Unix(updated, 0)
+		}
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetClient 读取客户端详情，客户端不存在时返回 sql.ErrNoRows
+func (db *Database) GetClient(id int, offlineAfter time.Duration) (*ClientDetail, error) {
+	d := &ClientDetail{}
+	var postAt, created, updated int64
+	var meta metadataRow
+	var archive archiveRow
+	dest := append([]interface{}{&d.ID, &d.Name, &d.SN, &d.MAC, &d.IP, &d.UpVer, &d.Network, &d.Comment,
+		&d.CPU, &d.RAM, &d.Disk, &postAt, &created, &updated}, meta.dest()...)
+	err := db.conn.QueryRow(`SELECT `+clientSelect+`, `+metadataSelect+`, `+archiveSelect+`
+		FROM `+clientJoins+` WHERE c.id = ?`, id).Scan(append(dest, archive.dest()...)...)
+	if err != nil {
+		return nil, err
+	}
+	d.Metadata = meta.value()
+	d.Archived = archive.value()
+	if postAt > 0 {
+		d.PostAt = time.Unix(postAt, 0)
+		d.Online = d.PostAt.After(time.Now().Add(-offlineAfter))
+	}
+	if created > 0 {
+		d.CreatedAt = time.Unix(created, 0)
+	}
+	if updated > 0 {
+		d.UpdatedAt = time.Unix(updated, 0)
+	}
+	if d.Facts, err = db.GetFacts(id); err != nil {
+		return nil, err
+	}
+	if d.Attributes, err = db.GetAttributes(id); err != nil {
+		return nil, err
+	}
+	if d.Groups, err = db.GroupsOf(id); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT collector, status, IFNULL(error, ''), duration_ms FROM client_collector_status WHERE client_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("查询采集器状态失败: %v", err)
+	}
+	defer rows.Close()
+	d.Collectors = make(map[string]CollectorStatus)
+	for rows.Next() {
+		var name string
+		var st CollectorStatus
+		if err := rows.Scan(&name, &st.Status, &st.Error, &st.DurationMs); err != nil {
+			return nil, fmt.Errorf("读取采集器状态失败: %v", err)
+		}
+		d.Collectors[name] = st
+	}
+	return d, rows.Err()
+}
+
+// ListClientChanges 返回客户端的变更记录（最新的在前），包括上报记录与管理员对元数据的编辑，
+// 并标出每条记录相对同类上一条变化的字段
+func (db *Database) ListClientChanges(id int) ([]ClientChange, error) {
+	rows, err := db.conn.Query(`SELECT id, change_type, UNIX_TIMESTAMP(changed_at), IFNULL(name, ''), IFNULL(cpu, ''),
+		IFNULL(ram, ''), IFNULL(disk, ''), IFNULL(sn, ''), IFNULL(mac, ''), IFNULL(ip, ''), IFNULL(up_ver, ''),
+		IFNULL(comment, ''), IFNULL(network, '') FROM client_changes WHERE client_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("查询变更记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	changes := []ClientChange{}
+	var prev map[string]string
+	for rows.Next() {
+		var c ClientChange
+		var at int64
+		values := make([]string, len(changeFields))
+		dest := []interface{}{&c.ID, &c.Type, &at}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("读取变更记录失败: %v", err)
+		}
+		c.ChangedAt = time.Unix(at, 0)
+		c.Fields = make(map[string]string, len(changeFields))
+		c.Changed = []string{}
+		for i, name := range changeFields {
+			c.Fields[name] = values[i]
+			if prev == nil && values[i] != "" || prev != nil && prev[name] != values[i] {
+				c.Changed = append(c.Changed, name)
+			}
+		}
+		prev = c.Fields
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	edits, err := db.listMetadataChanges(id)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, edits...)
+	// 两类记录各自按先后排列，合并后按时间排序再整体倒序
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].ChangedAt.Before(changes[j].ChangedAt) })
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, nil
+}
+
+// handleListClients 列出客户端，筛选与排序参数见 clientFilterFromQuery，
+// 例如 /api/clients?older_than=1.4 查询仍未升级到 1.4 的客户端
+func handleListClients(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		f, err := clientFilterFromQuery(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.Scope = p.Groups
+		clients, err := db.ListClients(f, time.Duration(store.Get().Inventory.OfflineAfter))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询客户端失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clients)
+	}
+}
+
+// handleGetClient 返回客户端详情
+func handleGetClient(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleViewer)
+		if !ok {
+			return
+		}
+		d, err := db.GetClient(id, time.Duration(store.Get().Inventory.OfflineAfter))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "客户端不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询客户端详情失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// handleClientChanges 返回客户端的变更时间线
+func handleClientChanges(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleViewer)
+		if !ok {
+			return
+		}
+		changes, err := db.ListClientChanges(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询变更记录失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListClientsStatus(t *testing.T) {
+	db := testDatabase(t)
+	now := time.Now()
+	ids := make(map[string]int)
+	// 离线判断为 6 小时：cutoff 按驱动时区绑定时会偏移会话时区的 8 小时，12 小时前上报的客户端被误判为在线
+	for name, age := range map[string]time.Duration{"online": 2 * time.Hour, "offline": 12 * time.Hour} {
+		id := testClient(t, db, t.Name()+"-"+name)
+		if _, err := db.conn.Exec(`UPDATE client_info SET network = ?, post_at = FROM_UNIXTIME(?) WHERE id = ?`,
+			t.Name(), now.Add(-age).Unix(), id); err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+
+	for _, status := range []string{"online", "offline"} {
+		clients, err := db.ListClients(ClientFilter{Network: t.Name(), Status: status}, 6*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clients) != 1 || clients[0].ID != ids[status] || clients[0].Online != (status == "online") {
+			t.Fatalf("status=%s: clients = %+v, want client %d", status, clients, ids[status])
+		}
+	}
+}
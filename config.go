@@ -0,0 +1,589 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"goup-server/internal/updatesig"
+)
+
+// Duration 以 "10s"、"5m" 形式读写的时间间隔
+type Duration time.Duration
+
+// MarshalJSON 输出为字符串形式
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON 支持字符串（"10s"）或秒数
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err != nil {
+		return fmt.Errorf("无效的时间间隔: %s", string(b))
+	}
+	*d = Duration(time.Duration(secs * float64(time.Second)))
+	return nil
+}
+
+// DatabaseConfig 数据库配置
+type DatabaseConfig struct {
+	DSN string `json:"dsn,omitempty"`
+	// 从文件读取 DSN，避免密码出现在命令行或配置文件中
+	DSNFile         string   `json:"dsn_file,omitempty"`
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+}
+
+// TLSConfig HTTPS 证书配置，证书文件可在 SIGHUP 时重新加载
+type TLSConfig struct {
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// AuthConfig 认证配置
+type AuthConfig struct {
+	// 客户端上报令牌，非空时 /api/client 需携带 Authorization: Bearer <token>
+	IngestTokens []string `json:"ingest_tokens,omitempty"`
+	// 从文件读取上报令牌，每行一个
+	IngestTokensFile string `json:"ingest_tokens_file,omitempty"`
+	// 管理令牌，持有者拥有 admin 角色；也可以改用用户账号与 API 密钥
+	AdminTokens []string `json:"admin_tokens,omitempty"`
+	// 从文件读取管理令牌，每行一个
+	AdminTokensFile string `json:"admin_tokens_file,omitempty"`
+	// 指标令牌，持有者只能读取 /metrics，供 Prometheus 抓取使用
+	MetricsTokens []string `json:"metrics_tokens,omitempty"`
+	// 从文件读取指标令牌，每行一个
+	MetricsTokensFile string `json:"metrics_tokens_file,omitempty"`
+	// 未登录请求的角色：空字符串（默认，所有接口都需要登录）或 viewer（客户端列表等只读接口无需登录，需显式开启）
+	AnonymousRole string `json:"anonymous_role"`
+	// 网页登录会话的有效期
+	SessionTTL Duration `json:"session_ttl"`
+	// LDAP/Active Directory 登录
+	LDAP LDAPConfig `json:"ldap"`
+	// OpenID Connect 登录与 Bearer JWT
+	OIDC OIDCConfig `json:"oidc"`
+}
+
+// InventoryConfig 库存统计配置
+type InventoryConfig struct {
+	// 超过该时间未上报的客户端视为离线
+	OfflineAfter Duration `json:"offline_after"`
+}
+
+// ServerConfig 服务端配置，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+type ServerConfig struct {
+	Listen    string          `json:"listen"`
+	Log       LogConfig       `json:"log"`
+	HTTP      HTTPConfig      `json:"http"`
+	Database  DatabaseConfig  `json:"database"`
+	TLS       TLSConfig       `json:"tls"`
+	Auth      AuthConfig      `json:"auth"`
+	Inventory InventoryConfig `json:"inventory"`
+	Health    HealthConfig    `json:"health"`
+	Update    UpdateConfig    `json:"update"`
+	Retention RetentionConfig `json:"retention"`
+	Heartbeat HeartbeatConfig `json:"heartbeat"`
+	Ingest    IngestConfig    `json:"ingest"`
+}
+
+// defaultServerConfig 默认配置
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Listen:    ":8080",
+		HTTP:      defaultHTTPConfig(),
+		Log:       defaultLogConfig(),
+		Health:    defaultHealthConfig(),
+		Update:    defaultUpdateConfig(),
+		Retention: defaultRetentionConfig(),
+		Heartbeat: defaultHeartbeatConfig(),
+		Ingest:    defaultIngestConfig(),
+		Inventory: InventoryConfig{
+			OfflineAfter: Duration(24 * time.Hour),
+		},
+		Auth: AuthConfig{
+			SessionTTL: Duration(12 * time.Hour),
+			LDAP:       defaultLDAPConfig(),
+			OIDC:       defaultOIDCConfig(),
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: Duration(30 * time.Minute),
+		},
+	}
+}
+
+// ConfigOverrides 命令行中显式设置的参数
+type ConfigOverrides struct {
+	DSN       *string
+	Port      *string
+	LogDir    *string
+	LogLevel  *string
+	LogFormat *string
+}
+
+// LoadServerConfig 依次合并默认值、配置文件、环境变量与命令行参数，并解析密钥文件
+func LoadServerConfig(path string, overrides ConfigOverrides) (*ServerConfig, error) {
+	cfg := defaultServerConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+	}
+	if err := applyServerEnv(&cfg); err != nil {
+		return nil, err
+	}
+	if overrides.DSN != nil {
+		cfg.Database.DSN = *overrides.DSN
+	}
+	if overrides.Port != nil {
+		cfg.Listen = ":" + *overrides.Port
+	}
+	if overrides.LogDir != nil {
+		cfg.Log.Dir = *overrides.LogDir
+	}
+	if overrides.LogLevel != nil {
+		cfg.Log.Level = *overrides.LogLevel
+	}
+	if overrides.LogFormat != nil {
+		cfg.Log.Format = *overrides.LogFormat
+	}
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyServerEnv 使用 GOUP_* 环境变量覆盖配置
+func applyServerEnv(cfg *ServerConfig) error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) error {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 无效: %v", name, err)
+			}
+			*dst = n
+		}
+		return nil
+	}
+
+	str("GOUP_LISTEN", &cfg.Listen)
+	str("GOUP_LOG_DIR", &cfg.Log.Dir)
+	str("GOUP_LOG_LEVEL", &cfg.Log.Level)
+	str("GOUP_LOG_FORMAT", &cfg.Log.Format)
+	str("GOUP_DSN", &cfg.Database.DSN)
+	str("GOUP_DSN_FILE", &cfg.Database.DSNFile)
+	if err := num("GOUP_DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns); err != nil {
+		return err
+	}
+	if err := num("GOUP_DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns); err != nil {
+		return err
+	}
+	str("GOUP_TLS_CERT_FILE", &cfg.TLS.CertFile)
+	str("GOUP_TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	if v, ok := os.LookupEnv("GOUP_INGEST_TOKENS"); ok {
+		cfg.Auth.IngestTokens = splitList(v)
+	}
+	str("GOUP_INGEST_TOKENS_FILE", &cfg.Auth.IngestTokensFile)
+	if v, ok := os.LookupEnv("GOUP_ADMIN_TOKENS"); ok {
+		cfg.Auth.AdminTokens = splitList(v)
+	}
+	str("GOUP_ADMIN_TOKENS_FILE", &cfg.Auth.AdminTokensFile)
+	if v, ok := os.LookupEnv("GOUP_METRICS_TOKENS"); ok {
+		cfg.Auth.MetricsTokens = splitList(v)
+	}
+	str("GOUP_METRICS_TOKENS_FILE", &cfg.Auth.MetricsTokensFile)
+	str("GOUP_ANONYMOUS_ROLE", &cfg.Auth.AnonymousRole)
+	str("GOUP_LDAP_URL", &cfg.Auth.LDAP.URL)
+	str("GOUP_LDAP_BIND_DN", &cfg.Auth.LDAP.BindDN)
+	str("GOUP_LDAP_BIND_PASSWORD_FILE", &cfg.Auth.LDAP.BindPasswordFile)
+	str("GOUP_OIDC_ISSUER", &cfg.Auth.OIDC.Issuer)
+	str("GOUP_OIDC_CLIENT_ID", &cfg.Auth.OIDC.ClientID)
+	str("GOUP_OIDC_CLIENT_SECRET_FILE", &cfg.Auth.OIDC.ClientSecretFile)
+	str("GOUP_UPDATE_DIR", &cfg.Update.StorageDir)
+	str("GOUP_UPDATE_SIGNING_KEY_FILE", &cfg.Update.SigningKeyFile)
+	if v, ok := os.LookupEnv("GOUP_OFFLINE_AFTER"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("环境变量 GOUP_OFFLINE_AFTER 无效: %v", err)
+		}
+		cfg.Inventory.OfflineAfter = Duration(d)
+	}
+	return nil
+}
+
+// resolveSecrets 从 *_file 指定的文件中读取密钥
+func (cfg *ServerConfig) resolveSecrets() error {
+	if cfg.Database.DSNFile != "" {
+		data, err := os.ReadFile(cfg.Database.DSNFile)
+		if err != nil {
+			return fmt.Errorf("读取 DSN 文件失败: %v", err)
+		}
+		cfg.Database.DSN = strings.TrimSpace(string(data))
+	}
+	if cfg.Auth.IngestTokensFile != "" {
+		tokens, err := readTokenFile(cfg.Auth.IngestTokensFile)
+		if err != nil {
+			return fmt.Errorf("读取上报令牌文件失败: %v", err)
+		}
+		cfg.Auth.IngestTokens = append(cfg.Auth.IngestTokens, tokens...)
+	}
+	if cfg.Auth.AdminTokensFile != "" {
+		tokens, err := readTokenFile(cfg.Auth.AdminTokensFile)
+		if err != nil {
+			return fmt.Errorf("读取管理令牌文件失败: %v", err)
+		}
+		cfg.Auth.AdminTokens = append(cfg.Auth.AdminTokens, tokens...)
+	}
+	if cfg.Auth.MetricsTokensFile != "" {
+		tokens, err := readTokenFile(cfg.Auth.MetricsTokensFile)
+		if err != nil {
+			return fmt.Errorf("读取指标令牌文件失败: %v", err)
+		}
+		cfg.Auth.MetricsTokens = append(cfg.Auth.MetricsTokens, tokens...)
+	}
+	if cfg.Update.SigningKeyFile != "" {
+		data, err := os.ReadFile(cfg.Update.SigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("读取更新签名私钥失败: %v", err)
+		}
+		cfg.Update.SigningKey = strings.TrimSpace(string(data))
+	}
+	if cfg.Update.SigningKey != "" {
+		key, err := updatesig.ParsePrivateKey([]byte(cfg.Update.SigningKey))
+		if err != nil {
+			return fmt.Errorf("更新签名私钥无效: %v", err)
+		}
+		cfg.Update.signer = key
+	}
+	if cfg.Auth.LDAP.BindPasswordFile != "" {
+		data, err := os.ReadFile(cfg.Auth.LDAP.BindPasswordFile)
+		if err != nil {
+			return fmt.Errorf("读取 LDAP 服务账号密码失败: %v", err)
+		}
+		cfg.Auth.LDAP.BindPassword = strings.TrimSpace(string(data))
+	}
+	if cfg.Auth.LDAP.URL != "" {
+		a, err := cfg.Auth.LDAP.newAuthenticator()
+		if err != nil {
+			return err
+		}
+		cfg.Auth.LDAP.authenticator = a
+	}
+	if cfg.Auth.OIDC.Issuer != "" {
+		p, err := cfg.Auth.OIDC.newProvider()
+		if err != nil {
+			return err
+		}
+		cfg.Auth.OIDC.provider = p
+	}
+	return nil
+}
+
+// readTokenFile 读取令牌文件，每行一个，忽略空行与 # 注释
+func readTokenFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	return tokens, nil
+}
+
+// Validate 检查配置是否完整有效
+func (cfg *ServerConfig) Validate() error {
+	if cfg.Database.DSN == "" {
+		return fmt.Errorf("必须指定数据库连接字符串（-dsn、GOUP_DSN、GOUP_DSN_FILE 或配置文件 database.dsn/dsn_file）")
+	}
+	if cfg.Listen == "" {
+		return fmt.Errorf("监听地址不能为空")
+	}
+	if _, err := parseLogLevel(cfg.Log.Level); err != nil {
+		return err
+	}
+	if f := strings.ToLower(cfg.Log.Format); f != "json" && f != "text" {
+		return fmt.Errorf("无效的日志格式: %s（可选 json、text）", cfg.Log.Format)
+	}
+	if cfg.Inventory.OfflineAfter <= 0 {
+		return fmt.Errorf("inventory.offline_after 必须大于 0")
+	}
+	if cfg.Health.DBTimeout <= 0 {
+		return fmt.Errorf("health.db_timeout 必须大于 0")
+	}
+	if cfg.HTTP.ShutdownTimeout <= 0 {
+		return fmt.Errorf("http.shutdown_timeout 必须大于 0")
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file 与 tls.key_file 必须同时指定")
+	}
+	if cfg.Update.StorageDir == "" {
+		return fmt.Errorf("update.storage_dir 不能为空")
+	}
+	if cfg.Auth.AnonymousRole != "" && cfg.Auth.AnonymousRole != roleViewer {
+		return fmt.Errorf("无效的 auth.anonymous_role: %s（可选 viewer 或空字符串）", cfg.Auth.AnonymousRole)
+	}
+	if cfg.Auth.SessionTTL <= 0 {
+		return fmt.Errorf("auth.session_ttl 必须大于 0")
+	}
+	if err := cfg.Auth.LDAP.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Auth.OIDC.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Retention.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Heartbeat.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Ingest.validate(); err != nil {
+		return err
+	}
+	if cfg.Retention.ArchiveAfter > 0 && cfg.Retention.ArchiveAfter < cfg.Inventory.OfflineAfter {
+		return fmt.Errorf("retention.archive_after 不能小于 inventory.offline_after")
+	}
+	return nil
+}
+
+// Redacted 返回隐藏敏感字段后的副本
+func (cfg ServerConfig) Redacted() ServerConfig {
+	if cfg.Database.DSN != "" {
+		cfg.Database.DSN = redactDSN(cfg.Database.DSN)
+	}
+	if len(cfg.Auth.IngestTokens) > 0 {
+		cfg.Auth.IngestTokens = []string{fmt.Sprintf("****** (%d)", len(cfg.Auth.IngestTokens))}
+	}
+	if cfg.Update.SigningKey != "" {
+		cfg.Update.SigningKey = "******"
+	}
+	if len(cfg.Auth.AdminTokens) > 0 {
+		cfg.Auth.AdminTokens = []string{fmt.Sprintf("****** (%d)", len(cfg.Auth.AdminTokens))}
+	}
+	if len(cfg.Auth.MetricsTokens) > 0 {
+		cfg.Auth.MetricsTokens = []string{fmt.Sprintf("****** (%d)", len(cfg.Auth.MetricsTokens))}
+	}
+	if cfg.Auth.LDAP.BindPassword != "" {
+		cfg.Auth.LDAP.BindPassword = "******"
+	}
+	if cfg.Auth.OIDC.ClientSecret != "" {
+		cfg.Auth.OIDC.ClientSecret = "******"
+	}
+	return cfg
+}
+
+// redactDSN 隐藏 DSN 中的密码部分（user:password@...）
+func redactDSN(dsn string) string {
+	at := strings.LastIndex(dsn, "@")
+	if at < 0 {
+		return dsn
+	}
+	cred := dsn[:at]
+	if colon := strings.Index(cred, ":"); colon >= 0 {
+		return cred[:colon] + ":******" + dsn[at:]
+	}
+	return dsn
+}
+
+// splitList 拆分逗号分隔的列表并去除空项
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// ConfigStore 保存当前生效配置，支持运行时替换
+type ConfigStore struct {
+	mu   sync.RWMutex
+	cfg  *ServerConfig
+	cert *tls.Certificate
+}
+
+// NewConfigStore 创建配置存储并加载 TLS 证书
+func NewConfigStore(cfg *ServerConfig) (*ConfigStore, error) {
+	s := &ConfigStore{cfg: cfg}
+	if err := s.loadCertificate(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 返回当前配置，调用方不得修改返回值
+func (s *ConfigStore) Get() *ServerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// loadCertificate 读取证书文件并替换当前证书
+func (s *ConfigStore) loadCertificate(cfg *ServerConfig) error {
+	if cfg.TLS.CertFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载TLS证书失败: %v", err)
+	}
+	s.mu.Lock()
+	s.cert = &cert
+	s.mu.Unlock()
+	return nil
+}
+
+// GetCertificate 供 tls.Config 使用，始终返回最近一次加载的证书
+func (s *ConfigStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, fmt.Errorf("未配置TLS证书")
+	}
+	return s.cert, nil
+}
+
+// CheckIngestToken 校验上报令牌；未配置令牌时允许所有请求
+func (s *ConfigStore) CheckIngestToken(authorization string) bool {
+	tokens := s.Get().Auth.IngestTokens
+	if len(tokens) == 0 {
+		return true
+	}
+	return matchToken(tokens, authorization)
+}
+
+// CheckMetricsToken 校验指标令牌；未配置令牌时拒绝所有请求
+func (s *ConfigStore) CheckMetricsToken(authorization string) bool {
+	return matchToken(s.Get().Auth.MetricsTokens, authorization)
+}
+
+// CheckAdminToken 校验管理令牌；未配置令牌时拒绝所有请求
+func (s *ConfigStore) CheckAdminToken(authorization string) bool {
+	return matchToken(s.Get().Auth.AdminTokens, authorization)
+}
+
+// matchToken 以常量时间比较 Authorization: Bearer 令牌
+func matchToken(tokens []string, authorization string) bool {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if token == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload 重新加载配置，只应用可以安全热更新的部分：上报、管理与指标令牌、匿名角色、LDAP 与 OIDC 登录、更新签名私钥、数据库连接池、TLS证书、
+// 数据保留策略与心跳记录、上报限流与抖动检测、请求体大小上限、关闭等待时间与日志级别。监听地址、DSN、更新包存储目录、日志目录与连接超时的变化需要重启才能生效。
+func (s *ConfigStore) Reload(path string, overrides ConfigOverrides, db *Database) error {
+	next, err := LoadServerConfig(path, overrides)
+	if err != nil {
+		return err
+	}
+	cur := s.Get()
+	if next.Listen != cur.Listen {
+		slog.Warn("监听地址变更需要重启才能生效", "from", cur.Listen, "to", next.Listen)
+		next.Listen = cur.Listen
+	}
+	if next.Database.DSN != cur.Database.DSN {
+		slog.Warn("数据库连接字符串变更需要重启才能生效")
+		next.Database.DSN = cur.Database.DSN
+	}
+	// 更新包存储目录在启动时创建，已上传的文件也不会随之迁移
+	if next.Update.StorageDir != cur.Update.StorageDir {
+		slog.Warn("更新包存储目录变更需要重启才能生效", "from", cur.Update.StorageDir, "to", next.Update.StorageDir)
+		next.Update.StorageDir = cur.Update.StorageDir
+	}
+	if next.Log.Dir != cur.Log.Dir || next.Log.Format != cur.Log.Format || next.Log.MaxSizeMB != cur.Log.MaxSizeMB ||
+		next.Log.RotateEvery != cur.Log.RotateEvery || next.Log.MaxBackups != cur.Log.MaxBackups ||
+		next.Log.MaxAge != cur.Log.MaxAge || next.Log.Compress != cur.Log.Compress {
+		slog.Warn("日志目录、格式与轮转设置变更需要重启才能生效")
+		level := next.Log.Level
+		next.Log = cur.Log
+		next.Log.Level = level
+	}
+	if next.HTTP.ReadTimeout != cur.HTTP.ReadTimeout || next.HTTP.ReadHeaderTimeout != cur.HTTP.ReadHeaderTimeout ||
+		next.HTTP.WriteTimeout != cur.HTTP.WriteTimeout || next.HTTP.IdleTimeout != cur.HTTP.IdleTimeout ||
+		next.HTTP.MaxHeaderBytes != cur.HTTP.MaxHeaderBytes {
+		slog.Warn("HTTP 超时与请求头大小变更需要重启才能生效")
+		next.HTTP.ReadTimeout, next.HTTP.ReadHeaderTimeout = cur.HTTP.ReadTimeout, cur.HTTP.ReadHeaderTimeout
+		next.HTTP.WriteTimeout, next.HTTP.IdleTimeout = cur.HTTP.WriteTimeout, cur.HTTP.IdleTimeout
+		next.HTTP.MaxHeaderBytes = cur.HTTP.MaxHeaderBytes
+	}
+	if (next.TLS.CertFile == "") != (cur.TLS.CertFile == "") {
+		slog.Warn("启用或关闭TLS需要重启才能生效")
+		next.TLS = cur.TLS
+	}
+	if err := s.loadCertificate(next); err != nil {
+		return err
+	}
+	db.ApplyPoolConfig(next.Database)
+	if level, err := parseLogLevel(next.Log.Level); err == nil {
+		logLevel.Set(level)
+	}
+
+	s.mu.Lock()
+	s.cfg = next
+	s.mu.Unlock()
+	// 正在进行的登录仍可使用旧认证器完成，完成后连接随之关闭
+	if cur.Auth.LDAP.authenticator != nil {
+		cur.Auth.LDAP.authenticator.Close()
+	}
+	return nil
+}
+
+// watchReload 收到 SIGHUP 时重新加载配置
+func watchReload(store *ConfigStore, path string, overrides ConfigOverrides, db *Database) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := store.Reload(path, overrides, db); err != nil {
+				slog.Error("重新加载配置失败，继续使用原配置", "err", err)
+				continue
+			}
+			slog.Info("配置已重新加载")
+		}
+	}()
+}
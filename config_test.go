@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadKeepsStorageDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	write := func(storage string) {
+		t.Helper()
+		data := `{"database": {"dsn": "goup@tcp(127.0.0.1:3306)/goup"}, "update": {"storage_dir": "` + filepath.ToSlash(storage) + `"}}`
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "artifacts"))
+	cfg, err := LoadServerConfig(path, ConfigOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewConfigStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只设置连接池参数，不会连接数据库
+	conn, err := sql.Open("mysql", cfg.Database.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 存储目录只在启动时创建，重新加载时保留原目录
+	write(filepath.Join(dir, "moved"))
+	if err := store.Reload(path, ConfigOverrides{}, &Database{conn: conn}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := store.Get().Update.StorageDir, cfg.Update.StorageDir; got != want {
+		t.Fatalf("storage_dir after reload = %s, want %s", got, want)
+	}
+}
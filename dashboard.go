@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles 内置的资产面板页面，不依赖外部 CDN
+//
+//go:embed web
+var webFiles embed.FS
+
+// dashboardPath 资产面板的访问路径
+const dashboardPath = "/ui/"
+
+// handleDashboard 提供内置资产面板的静态文件
+func handleDashboard() http.Handler {
+	sub, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix(dashboardPath, http.FileServer(http.FS(sub)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 页面与脚本随服务端升级变化，要求浏览器每次校验
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// testDatabase 连接 GOUP_TEST_DSN 指定的 MySQL 测试库并建表，未设置时跳过测试。
+// 会话时区固定为 +08:00，与驱动默认的 UTC 不一致，绑定 time.Time 与 TIMESTAMP 列比较的写法会在测试中出错
+func testDatabase(t *testing.T) *Database {
+	t.Helper()
+	dsn := os.Getenv("GOUP_TEST_DSN")
+	if dsn == "" {
+		t.Skip("GOUP_TEST_DSN 未设置，跳过需要 MySQL 的测试")
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("parse GOUP_TEST_DSN: %v", err)
+	}
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	cfg.Params["time_zone"] = "'+08:00'"
+	db, err := NewDatabase(cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testClient 插入一个测试客户端，测试结束时删除它及其全部记录
+func testClient(t *testing.T, db *Database, name string) int {
+	t.Helper()
+	res, err := db.conn.Exec(`INSERT INTO client_info (name, sn, mac) VALUES (?, ?, '')`, name, "TEST-"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanupClient(t, db, int(id))
+	return int(id)
+}
+
+// cleanupClient 测试结束时删除客户端及其全部记录
+func cleanupClient(t *testing.T, db *Database, id int) {
+	t.Cleanup(func() {
+		for _, table := range clientDataTables {
+			db.conn.Exec(`DELETE FROM `+table+` WHERE client_id = ?`, id)
+		}
+		db.conn.Exec(`DELETE FROM client_archive WHERE client_id = ?`, id)
+		db.conn.Exec(`DELETE FROM client_info WHERE id = ?`, id)
+	})
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushRows 流式导出时每写入多少行刷新一次输出
+const exportFlushRows = 500
+
+// exportColumn 可导出的列
+type exportColumn struct {
+	Key   string
+	ZH    string
+	EN    string
+	value func(c *ClientDetail) interface{}
+}
+
+// exportColumns 可导出的全部列，未指定 columns 时按此顺序导出
+var exportColumns = []exportColumn{
+	{"id", "ID", "ID", func(c *ClientDetail) interface{} { return c.ID }},
+	{"name", "主机名", "Hostname", func(c *ClientDetail) interface{} { return c.Name }},
+	{"cpu", "CPU", "CPU", func(c *ClientDetail) interface{} { return c.CPU }},
+	{"ram", "内存", "RAM", func(c *ClientDetail) interface{} { return c.RAM }},
+	{"disk", "硬盘", "Disk", func(c *ClientDetail) interface{} { return c.Disk }},
+	{"sn", "序列号", "Serial Number", func(c *ClientDetail) interface{} { return c.SN }},
+	{"mac", "MAC 地址", "MAC Address", func(c *ClientDetail) interface{} { return c.MAC }},
+	{"ip", "IP 地址", "IP Address", func(c *ClientDetail) interface{} { return c.IP }},
+	{"up_ver", "客户端版本", "Agent Version", func(c *ClientDetail) interface{} { return c.UpVer }},
+	{"network", "网络类型", "Network", func(c *ClientDetail) interface{} { return c.Network }},
+	{"comment", "备注", "Comment", func(c *ClientDetail) interface{} { return c.Comment }},
+	{"online", "在线", "Online", func(c *ClientDetail) interface{} { return c.Online }},
+	{"post_at", "最近上报", "Last Report", func(c *ClientDetail) interface{} { return c.PostAt }},
+	{"created_at", "首次登记", "First Seen", func(c *ClientDetail) interface{} { return c.CreatedAt }},
+	{"updated_at", "最近变更", "Last Changed", func(c *ClientDetail) interface{} { return c.UpdatedAt }},
+}
+
+// exportFormats 支持的导出格式及其 Content-Type
+var exportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+}
+
+// ExportOptions 导出参数
+type ExportOptions struct {
+	Format  string
+	Columns []exportColumn
+	// 表头语言：zh 或 en（json/ndjson 始终使用字段名）
+	Lang   string
+	Filter ClientFilter
+}
+
+// parseExportColumns 解析逗号分隔的列名，为空时返回全部列
+func parseExportColumns(s string) ([]exportColumn, error) {
+	if strings.TrimSpace(s) == "" {
+		return exportColumns, nil
+	}
+	var cols []exportColumn
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, c := range exportColumns {
+			if c.Key == name {
+				cols = append(cols, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("未知的列: %s", name)
+		}
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("未指定导出列")
+	}
+	return cols, nil
+}
+
+// newExportOptions 校验格式、列与语言并生成导出参数
+func newExportOptions(format, columns, lang string, f ClientFilter) (ExportOptions, error) {
+	opts := ExportOptions{Format: strings.ToLower(strings.TrimSpace(format)), Lang: strings.ToLower(strings.TrimSpace(lang)), Filter: f}
+	if opts.Format == "" {
+		opts.Format = "csv"
+	}
+	if _, ok := exportFormats[opts.Format]; !ok {
+		return opts, fmt.Errorf("不支持的导出格式: %s（可选 csv、xlsx、json、ndjson）", opts.Format)
+	}
+	if opts.Lang == "" {
+		opts.Lang = "zh"
+	}
+	if opts.Lang != "zh" && opts.Lang != "en" {
+		return opts, fmt.Errorf("不支持的表头语言: %s（可选 zh、en）", opts.Lang)
+	}
+	cols, err := parseExportColumns(columns)
+	if err != nil {
+		return opts, err
+	}
+	opts.Columns = cols
+	return opts, nil
+}
+
+// exportOptionsFromQuery 解析 /api/export 的参数：format、columns、lang 以及与 /api/clients 相同的筛选参数；
+// 未指定 sort 时按 ID 排序，以便流式输出
+func exportOptionsFromQuery(q url.Values) (ExportOptions, error) {
+	if q.Get("sort") == "" {
+		q.Set("sort", "id")
+	}
+	f, err := clientFilterFromQuery(q)
+	if err != nil {
+		return ExportOptions{}, err
+	}
+	return newExportOptions(q.Get("format"), q.Get("columns"), q.Get("lang"), f)
+}
+
+// exportFilename 导出文件名
+func exportFilename(format string) string {
+	return "clients-" + time.Now().Format("20060102-150405") + "." + format
+}
+
+// exportWriter 按行写出导出数据
+type exportWriter interface {
+	WriteRow(c *ClientDetail) error
+	// Flush 将已写入的数据刷新到输出
+	Flush() error
+	// Close 写出结尾并刷新，不关闭底层输出
+	Close() error
+}
+
+// newExportWriter 创建对应格式的写出器并写出表头
+func newExportWriter(w io.Writer, opts ExportOptions) (exportWriter, error) {
+	switch opts.Format {
+	case "csv":
+		return newCSVExport(w, opts)
+	case "xlsx":
+		return newXLSXExport(w, opts)
+	case "json", "ndjson":
+		return newJSONExport(w, opts), nil
+	}
+	return nil, fmt.Errorf("不支持的导出格式: %s", opts.Format)
+}
+
+// ExportClients 按导出参数从数据库读取客户端并写出，返回导出的行数
+func (db *Database) ExportClients(w io.Writer, opts ExportOptions, offlineAfter time.Duration) (int, error) {
+	ew, err := newExportWriter(w, opts)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = db.EachClient(opts.Filter, offlineAfter, func(c *ClientDetail) error {
+		if err := ew.WriteRow(c); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushRows == 0 {
+			return ew.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, ew.Close()
+}
+
+// header 返回表头文字
+func (opts ExportOptions) header() []string {
+	out := make([]string, len(opts.Columns))
+	for i, c := range opts.Columns {
+		if opts.Lang == "en" {
+			out[i] = c.EN
+		} else {
+			out[i] = c.ZH
+		}
+	}
+	return out
+}
+
+// formatCell 将单元格的值格式化为表格中的文字，客户端上报的文字经 escapeFormula 处理
+func (opts ExportOptions) formatCell(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return escapeFormula(x)
+	case int:
+		return strconv.Itoa(x)
+	case bool:
+		switch {
+		case opts.Lang == "en" && x:
+			return "yes"
+		case opts.Lang == "en":
+			return "no"
+		case x:
+			return "是"
+		default:
+			return "否"
+		}
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		return x.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// escapeFormula 以 =、+、-、@（以及制表符、回车）开头的文字会被 Excel 等表格软件当作公式执行，
+// 在前面加上单引号使其按文字显示
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvExport CSV 写出器；中文表头时写入 UTF-8 BOM，使 Excel 能正确识别编码
+type csvExport struct {
+	opts ExportOptions
+	bw   *bufio.Writer
+	cw   *csv.Writer
+}
+
+func newCSVExport(w io.Writer, opts ExportOptions) (*csvExport, error) {
+	bw := bufio.NewWriter(w)
+	if opts.Lang == "zh" {
+		bw.WriteString("\uFEFF")
+	}
+	e := &csvExport{opts: opts, bw: bw, cw: csv.NewWriter(bw)}
+	if err := e.cw.Write(opts.header()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvExport) WriteRow(c *ClientDetail) error {
+	record := make([]string, len(e.opts.Columns))
+	for i, col := range e.opts.Columns {
+		record[i] = e.opts.formatCell(col.value(c))
+	}
+	return e.cw.Write(record)
+}
+
+func (e *csvExport) Flush() error {
+	e.cw.Flush()
+	if err := e.cw.Error(); err != nil {
+		return err
+	}
+	return e.bw.Flush()
+}
+
+func (e *csvExport) Close() error { return e.Flush() }
+
+// jsonExport JSON 数组或 NDJSON 写出器，使用字段名作为键，时间为 RFC 3339 格式（空值为 null）
+type jsonExport struct {
+	opts  ExportOptions
+	bw    *bufio.Writer
+	rows  int
+	lines bool
+}
+
+func newJSONExport(w io.Writer, opts ExportOptions) *jsonExport {
+	return &jsonExport{opts: opts, bw: bufio.NewWriter(w), lines: opts.Format == "ndjson"}
+}
+
+func (e *jsonExport) WriteRow(c *ClientDetail) error {
+	// 按列的顺序写出对象，避免 map 打乱字段顺序
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, col := range e.opts.Columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(col.Key)
+		b.Write(key)
+		b.WriteByte(':')
+		v := col.value(c)
+		if t, ok := v.(time.Time); ok && t.IsZero() {
+			v = nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+	}
+	b.WriteByte('}')
+
+	switch {
+	case e.lines:
+	case e.rows == 0:
+		e.bw.WriteString("[\n")
+	default:
+		e.bw.WriteString(",\n")
+	}
+	e.bw.WriteString(b.String())
+	if e.lines {
+		e.bw.WriteByte('\n')
+	}
+	e.rows++
+	return nil
+}
+
+func (e *jsonExport) Flush() error { return e.bw.Flush() }
+
+func (e *jsonExport) Close() error {
+	if !e.lines {
+		if e.rows == 0 {
+			e.bw.WriteString("[]\n")
+		} else {
+			e.bw.WriteString("\n]\n")
+		}
+	}
+	return e.bw.Flush()
+}
+
+// xlsxExport 最小的 XLSX（Office Open XML）写出器：单个工作表，字符串以内联方式写入，
+// 工作表在 zip 中流式写出，不需要在内存中保留全部数据
+type xlsxExport struct {
+	opts  ExportOptions
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`
+
+// xlsxStyles 样式 1 为加粗的表头
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`
+
+func newXLSXExport(w io.Writer, opts ExportOptions) (*xlsxExport, error) {
+	zw := zip.NewWriter(w)
+	sheetName := "客户端"
+	if opts.Lang == "en" {
+		sheetName = "Clients"
+	}
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, sheetName)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return nil, err
+		}
+	}
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e := &xlsxExport{opts: opts, zw: zw, sheet: bufio.NewWriter(f)}
+	e.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData>`)
+	header := opts.header()
+	cells := make([]interface{}, len(header))
+	for i, h := range header {
+		cells[i] = h
+	}
+	e.writeCells(cells, 1)
+	return e, nil
+}
+
+// writeCells 写出一行，数字写为数值单元格，其余写为内联字符串
+func (e *xlsxExport) writeCells(cells []interface{}, style int) {
+	e.row++
+	fmt.Fprintf(e.sheet, `<row r="%d">`, e.row)
+	for i, v := range cells {
+		ref := xlsxColumn(i) + strconv.Itoa(e.row)
+		s := ""
+		if style > 0 {
+			s = fmt.Sprintf(` s="%d"`, style)
+		}
+		if n, ok := v.(int); ok {
+			fmt.Fprintf(e.sheet, `<c r="%s"%s><v>%d</v></c>`, ref, s, n)
+			continue
+		}
+		text := e.opts.formatCell(v)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(e.sheet, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, s)
+		xml.EscapeText(e.sheet, []byte(xmlSafe(text)))
+		e.sheet.WriteString(`</t></is></c>`)
+	}
+	e.sheet.WriteString(`</row>`)
+}
+
+func (e *xlsxExport) WriteRow(c *ClientDetail) error {
+	cells := make([]interface{}, len(e.opts.Columns))
+	for i, col := range e.opts.Columns {
+		cells[i] = col.value(c)
+	}
+	e.writeCells(cells, 0)
+	return nil
+}
+
+func (e *xlsxExport) Flush() error {
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Flush()
+}
+
+func (e *xlsxExport) Close() error {
+	e.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
+
+// xlsxColumn 将从 0 开始的列号转换为 A、B、…、AA 形式
+func xlsxColumn(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+// xmlSafe 去除 XML 1.0 不允许的控制字符
+func xmlSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// handleExport 按 /api/clients 的筛选条件导出客户端，支持 csv、xlsx、json、ndjson，数据边读边写
+func handleExport(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		opts, err := exportOptionsFromQuery(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Filter.Scope = p.Groups
+		cfg := store.Get()
+		extendDeadlines(w, time.Duration(cfg.Update.TransferTimeout))
+		w.Header().Set("Content-Type", exportFormats[opts.Format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(opts.Format)))
+
+		start := time.Now()
+		n, err := db.ExportClients(w, opts, time.Duration(cfg.Inventory.OfflineAfter))
+		if err != nil {
+			// 数据已开始写出，只能记录日志；客户端会收到不完整的文件
+			slog.ErrorContext(r.Context(), "导出客户端失败", "err", err, "format", opts.Format, "rows", n)
+			return
+		}
+		slog.InfoContext(r.Context(), "已导出客户端", "format", opts.Format, "rows", n, "duration", time.Since(start))
+	}
+}
+
+// runExportCommand 实现 export 子命令：直接从数据库导出，参数与 /api/export 一致
+func runExportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("GOUP_CONFIG"), "配置文件路径")
+	dsn := fs.String("dsn", "", "数据库连接字符串（默认取自配置文件或环境变量）")
+	format := fs.String("format", "csv", "导出格式: csv/xlsx/json/ndjson")
+	columns := fs.String("columns", "", "导出的列，逗号分隔（默认全部）")
+	lang := fs.String("lang", "zh", "表头语言: zh/en")
+	output := fs.String("o", "", "输出文件（默认标准输出）")
+	query := url.Values{}
+	for _, name := range []string{"q", "network", "status", "archived", "older_than", "newer_than", "outdated",
+		"owner", "department", "location", "tag", "group", "sort"} {
+		name := name
+		fs.Func(name, "筛选条件，与 /api/clients 的 "+name+" 参数相同", func(v string) error {
+			// tag 可重复指定，其余参数以最后一次为准
+			if name == "tag" {
+				query.Add(name, v)
+			} else {
+				query.Set(name, v)
+			}
+			return nil
+		})
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s export [参数]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	query.Set("format", *format)
+	query.Set("columns", *columns)
+	query.Set("lang", *lang)
+	opts, err := exportOptionsFromQuery(query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 2
+	}
+
+	var overrides ConfigOverrides
+	if *dsn != "" {
+		overrides.DSN = dsn
+	}
+	cfg, err := LoadServerConfig(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 1
+	}
+	db, err := NewDatabase(cfg.Database.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "数据库连接失败: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	n, err := db.ExportClients(out, opts, time.Duration(cfg.Inventory.OfflineAfter))
+	if err == nil {
+		if f, ok := out.(*os.File); ok && f != os.Stdout {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出失败: %v\n", err)
+		return 1
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "已导出 %d 台客户端到 %s\n", n, *output)
+	}
+	return 0
+}
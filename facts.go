@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// FactMatch 按事实查询到的客户端
+type FactMatch struct {
+	ClientID int    `json:"client_id"`
+	Name     string `json:"name"`
+	MAC      string `json:"mac"`
+	SN       string `json:"sn"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+}
+
+// saveFacts 以本次上报为准更新客户端的自定义事实：只写入新增或变化的键，删除本次没有上报的键。
+// facts 为 nil（上报中没有 facts 字段）时保留原有的事实
+func (db *Database) saveFacts(clientID int, facts map[string]string) error {
+	if facts == nil {
+		return nil
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT fact_key, fact_value FROM client_facts WHERE client_id = ? FOR UPDATE`, clientID)
+	if err != nil {
+		return fmt.Errorf("查询自定义事实失败: %v", err)
+	}
+	existing := make(map[string]sql.NullString)
+	for rows.Next() {
+		var k string
+		var v sql.NullString
+		if err := rows.Scan(&k, &v); err != nil {
+			rows.Close()
+			return fmt.Errorf("读取自定义事实失败: %v", err)
+		}
+		existing[k] = v
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("读取自定义事实失败: %v", err)
+	}
+
+	for k := range existing {
+		if _, ok := facts[k]; ok {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM client_facts WHERE client_id = ? AND fact_key = ?`, clientID, k); err != nil {
+			return fmt.Errorf("删除自定义事实失败: %v", err)
+		}
+	}
+	for k, v := range facts {
+		if k == "" || len(k) > 128 {
+			continue
+		}
+		if cur, ok := existing[k]; ok && cur.Valid && cur.String == v {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO client_facts (client_id, fact_key, fact_value) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE fact_value = VALUES(fact_value), updated_at = CURRENT_TIMESTAMP`, clientID, k, v); err != nil {
+			return fmt.Errorf("保存自定义事实失败: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交自定义事实失败: %v", err)
+	}
+	return nil
+}
+
+// GetFacts 读取客户端的全部自定义事实
+func (db *Database) GetFacts(clientID int) (map[string]string, error) {
+	rows, err := db.conn.Query(`SELECT fact_key, fact_value FROM client_facts WHERE client_id = ?`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("查询自定义事实失败: %v", err)
+	}
+	defer rows.Close()
+
+	facts := make(map[string]string)
+	for rows.Next() {
+		var k string
+		var v *string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("读取自定义事实失败: %v", err)
+		}
+		if v != nil {
+			facts[k] = *v
+		} else {
+			facts[k] = ""
+		}
+	}
+	return facts, rows.Err()
+}
+
+// SearchFacts 按事实键（及可选的值）查找客户端，scope 非空时只查找这些分组内的客户端
+func (db *Database) SearchFacts(key, value string, hasValue bool, scope []int) ([]FactMatch, error) {
+	query := `
+	SELECT f.client_id, IFNULL(c.name, ''), IFNULL(c.mac, ''), IFNULL(c.sn, ''), f.fact_key, IFNULL(f.fact_value, '')
+	FROM client_facts f JOIN client_info c ON c.id = f.client_id
+	WHERE f.fact_key = ?`
+	args := []interface{}{key}
+	if hasValue {
+		query += ` AND f.fact_value = ?`
+		args = append(args, value)
+	}
+	clause, scopeArgs := scopeClause("f.client_id", scope)
+	query += clause + ` ORDER BY f.client_id`
+	args = append(args, scopeArgs...)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("按事实查询失败: %v", err)
+	}
+	defer rows.Close()
+
+	matches := []FactMatch{}
+	for rows.Next() {
+		var m FactMatch
+		if err := rows.Scan(&m.ClientID, &m.Name, &m.MAC, &m.SN, &m.Key, &m.Value); err != nil {
+			return nil, fmt.Errorf("读取查询结果失败: %v", err)
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// handleClientFacts 返回指定客户端的自定义事实
+func handleClientFacts(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleViewer)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		facts, err := db.GetFacts(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询自定义事实失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(facts)
+	}
+}
+
+// handleFactSearch 按 key（必需）与 value（可选）查询拥有该事实的客户端
+func handleFactSearch(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query()
+		key := q.Get("key")
+		if key == "" {
+			http.Error(w, "缺少 key 参数", http.StatusBadRequest)
+			return
+		}
+		_, hasValue := q["value"]
+		matches, err := db.SearchFacts(key, q.Get("value"), hasValue, p.Groups)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "按事实查询失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(matches)
+	}
+}
@@ -0,0 +1,799 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"goup-server/internal/rules"
+)
+
+// maxGroupNameLen 分组名称的最大长度
+const maxGroupNameLen = 64
+
+// Group 客户端分组：static 分组的成员由管理员指定，dynamic 分组按规则（见 internal/rules）自动计算，
+// 每次上报、修改元数据与导入资产属性后重新判断
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Rule        string    `json:"rule,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Members     int       `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GroupRef 客户端详情中列出的所属分组
+type GroupRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// GroupEvent 分组成员变化事件，供告警与更新发布等外部流程订阅
+type GroupEvent struct {
+	ID         int    `json:"id"`
+	GroupID    int    `json:"group_id"`
+	GroupName  string `json:"group_name"`
+	ClientID   int    `json:"client_id"`
+	ClientName string `json:"client_name"`
+	// join 加入；leave 离开
+	Action string `json:"action"`
+	// rule：规则计算；manual：管理员添加或移除；deleted：分组被删除
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupEventFilter 分组事件查询条件，零值表示不限；AfterID 大于 0 时按 ID 从小到大返回其后的事件，便于轮询
+type GroupEventFilter struct {
+	GroupID  int
+	ClientID int
+	AfterID  int
+	Limit    int
+	// 只返回这些分组的事件，为空表示不限
+	Groups []int
+}
+
+// compiledGroup 已解析规则的动态分组
+type compiledGroup struct {
+	ID   int
+	Name string
+	rule *rules.Rule
+}
+
+// membershipChange 待写入的成员变化
+type membershipChange struct {
+	GroupID  int
+	ClientID int
+	Join     bool
+}
+
+// validate 检查分组定义：有规则的为动态分组，没有规则的为静态分组
+func (g *Group) validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	g.Rule = strings.TrimSpace(g.Rule)
+	if g.Name == "" {
+		return fmt.Errorf("分组名称不能为空")
+	}
+	if utf8.RuneCountInString(g.Name) > maxGroupNameLen {
+		return fmt.Errorf("分组名称超过 %d 个字符", maxGroupNameLen)
+	}
+	switch g.Type {
+	case "":
+		g.Type = "static"
+		if g.Rule != "" {
+			g.Type = "dynamic"
+		}
+	case "static", "dynamic":
+	default:
+		return fmt.Errorf("无效的分组类型: %s（可选 static、dynamic）", g.Type)
+	}
+	if g.Type == "static" && g.Rule != "" {
+		return fmt.Errorf("静态分组不能设置规则")
+	}
+	if g.Type == "dynamic" {
+		if _, err := rules.Parse(g.Rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDuplicateKeyError 判断是否为唯一索引冲突
+func isDuplicateKeyError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Duplicate entry")
+}
+
+// ListGroups 列出全部分组及成员数量
+func (db *Database) ListGroups() ([]Group, error) {
+	rows, err := db.conn.Query(`SELECT g.id, g.name, g.type, IFNULL(g.rule, ''), IFNULL(g.description, ''),
+		(SELECT COUNT(*) FROM client_group_members gm WHERE gm.group_id = g.id),
+		UNIX_TIMESTAMP(g.created_at), UNIX_TIMESTAMP(g.updated_at)
+		FROM client_groups g ORDER BY g.name`)
+	if err != nil {
+		return nil, fmt.Errorf("查询分组失败: %v", err)
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		var g Group
+		var created, updated int64
+		if err := rows.Scan(&g.ID, &g.Name, &g.Type, &g.Rule, &g.Description, &g.Members, &created, &updated); err != nil {
+			return nil, fmt.Errorf("读取分组失败: %v", err)
+		}
+		g.CreatedAt, g.UpdatedAt = time.Unix(created, 0), time.Unix(updated, 0)
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroup 读取分组，不存在时返回 sql.ErrNoRows
+func (db *Database) GetGroup(id int) (*Group, error) {
+	var g Group
+	var created, updated int64
+	err := db.conn.QueryRow(`SELECT g.id, g.name, g.type, IFNULL(g.rule, ''), IFNULL(g.description, ''),
+		(SELECT COUNT(*) FROM client_group_members gm WHERE gm.group_id = g.id),
+		UNIX_TIMESTAMP(g.created_at), UNIX_TIMESTAMP(g.updated_at)
+		FROM client_groups g WHERE g.id = ?`, id).Scan(&g.ID, &g.Name, &g.Type, &g.Rule, &g.Description, &g.Members, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	g.CreatedAt, g.UpdatedAt = time.Unix(created, 0), time.Unix(updated, 0)
+	return &g, nil
+}
+
+// SaveGroup 新建（ID 为 0）或修改已存在的分组；动态分组的规则变化后立即对全部客户端重新计算成员
+func (db *Database) SaveGroup(g *Group) error {
+	if g.ID == 0 {
+		res, err := db.conn.Exec(`INSERT INTO client_groups (name, type, rule, description) VALUES (?, ?, ?, ?)`,
+			g.Name, g.Type, g.Rule, g.Description)
+		if err != nil {
+			return fmt.Errorf("保存分组失败: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		g.ID = int(id)
+	} else {
+		if _, err := db.conn.Exec(`UPDATE client_groups SET name = ?, rule = ?, description = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, g.Name, g.Rule, g.Description, g.ID); err != nil {
+			return fmt.Errorf("保存分组失败: %v", err)
+		}
+	}
+	if g.Type == "dynamic" {
+		if err := db.evaluateGroup(g); err != nil {
+			return err
+		}
+	}
+	saved, err := db.GetGroup(g.ID)
+	if err != nil {
+		return fmt.Errorf("读取分组失败: %v", err)
+	}
+	*g = *saved
+	return nil
+}
+
+// DeleteGroup 删除分组，成员以 deleted 原因记录离开事件；返回是否存在
+func (db *Database) DeleteGroup(id int) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM client_groups WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("删除分组失败: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`INSERT INTO client_group_events (group_id, client_id, action, reason)
+		SELECT group_id, client_id, 'leave', 'deleted' FROM client_group_members WHERE group_id = ?`, id); err != nil {
+		return false, fmt.Errorf("记录分组事件失败: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM client_group_members WHERE group_id = ?`, id); err != nil {
+		return false, fmt.Errorf("删除分组成员失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("提交删除分组失败: %v", err)
+	}
+	return true, nil
+}
+
+// groupHoldCount 返回引用分组的冻结/固定规则数量
+func (db *Database) groupHoldCount(id int) (int, error) {
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM update_holds WHERE scope = 'group' AND target = ?`, strconv.Itoa(id)).Scan(&n); err != nil {
+		return 0, fmt.Errorf("查询冻结规则失败: %v", err)
+	}
+	return n, nil
+}
+
+// SetStaticMembers 向静态分组添加或移除客户端，返回实际发生变化的数量
+func (db *Database) SetStaticMembers(groupID int, clientIDs []int, join bool) (int, error) {
+	var changes []membershipChange
+	for _, id := range clientIDs {
+		changes = append(changes, membershipChange{GroupID: groupID, ClientID: id, Join: join})
+	}
+	return db.applyMembership(changes, "manual")
+}
+
+// GroupsOf 返回客户端所属的分组
+func (db *Database) GroupsOf(clientID int) ([]GroupRef, error) {
+	rows, err := db.conn.Query(`SELECT g.id, g.name, g.type FROM client_group_members gm
+		JOIN client_groups g ON g.id = gm.group_id WHERE gm.client_id = ? ORDER BY g.name`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("查询所属分组失败: %v", err)
+	}
+	defer rows.Close()
+	groups := []GroupRef{}
+	for rows.Next() {
+		var g GroupRef
+		if err := rows.Scan(&g.ID, &g.Name, &g.Type); err != nil {
+			return nil, fmt.Errorf("读取所属分组失败: %v", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// dynamicGroups 读取并解析全部动态分组的规则；已保存的规则无法解析时跳过该分组
+func (db *Database) dynamicGroups() ([]compiledGroup, error) {
+	rows, err := db.conn.Query(`SELECT id, name, IFNULL(rule, '') FROM client_groups WHERE type = 'dynamic'`)
+	if err != nil {
+		return nil, fmt.Errorf("查询动态分组失败: %v", err)
+	}
+	defer rows.Close()
+	var groups []compiledGroup
+	for rows.Next() {
+		var g compiledGroup
+		var src string
+		if err := rows.Scan(&g.ID, &g.Name, &src); err != nil {
+			return nil, fmt.Errorf("读取动态分组失败: %v", err)
+		}
+		if g.rule, err = rules.Parse(src); err != nil {
+			slog.Warn("动态分组规则无效，已跳过", "group", g.Name, "err", err)
+			continue
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// groupFields 读取规则所需的客户端字段；clientID 为 0 时读取全部客户端。
+// 只有规则引用了 fact.* 或 attr.* 时才读取自定义事实与资产属性
+func (db *Database) groupFields(clientID int, groups []compiledGroup) (map[int]rules.Fields, error) {
+	needFacts, needAttrs := false, false
+	for _, g := range groups {
+		for _, ref := range g.rule.References() {
+			needFacts = needFacts || strings.HasPrefix(ref, "fact.")
+			needAttrs = needAttrs || strings.HasPrefix(ref, "attr.")
+		}
+	}
+	where, args := "", []interface{}{}
+	if clientID > 0 {
+		where, args = " WHERE c.id = ?", append(args, clientID)
+	}
+
+	rows, err := db.conn.Query(`SELECT c.id, IFNULL(c.name, ''), IFNULL(c.cpu, ''), IFNULL(c.ram, ''), IFNULL(c.disk, ''),
+		IFNULL(c.sn, ''), IFNULL(c.mac, ''), IFNULL(c.ip, ''), IFNULL(c.up_ver, ''), IFNULL(c.comment, ''),
+		IFNULL(c.network, ''), IFNULL(m.owner, ''), IFNULL(m.department, ''), IFNULL(m.location, ''), IFNULL(m.tags, '')
+		FROM client_info c LEFT JOIN client_metadata m ON m.client_id = c.id`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询客户端失败: %v", err)
+	}
+	defer rows.Close()
+	names := []string{"name", "cpu", "ram", "disk", "sn", "mac", "ip", "up_ver", "comment", "network", "owner", "department", "location"}
+	all := make(map[int]rules.Fields)
+	for rows.Next() {
+		var id int
+		var tags string
+		values := make([]string, len(names))
+		dest := []interface{}{&id}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(append(dest, &tags)...); err != nil {
+			return nil, fmt.Errorf("读取客户端失败: %v", err)
+		}
+		f := make(rules.Fields, len(names)+1)
+		for i, name := range names {
+			f[name] = []string{values[i]}
+		}
+		f["tag"] = splitTags(tags)
+		all[id] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	extra := []struct {
+		need   bool
+		prefix string
+		query  string
+	}{
+		{needFacts, "fact.", `SELECT client_id, fact_key, IFNULL(fact_value, '') FROM client_facts`},
+		{needAttrs, "attr.", `SELECT client_id, attr_key, IFNULL(attr_value, '') FROM client_attributes`},
+	}
+	for _, e := range extra {
+		if !e.need {
+			continue
+		}
+		query := e.query
+		if clientID > 0 {
+			query += ` WHERE client_id = ?`
+		}
+		if err := db.scanKeyValues(query, args, func(id int, k, v string) {
+			if f, ok := all[id]; ok {
+				f[e.prefix+strings.ToLower(k)] = []string{v}
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
+
+// scanKeyValues 逐行读取 (client_id, key, value) 查询结果
+func (db *Database) scanKeyValues(query string, args []interface{}, fn func(id int, k, v string)) error {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("查询客户端属性失败: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var k, v string
+		if err := rows.Scan(&id, &k, &v); err != nil {
+			return fmt.Errorf("读取客户端属性失败: %v", err)
+		}
+		fn(id, k, v)
+	}
+	return rows.Err()
+}
+
+// memberPairs 读取 (group_id, client_id) 查询结果
+func (db *Database) memberPairs(query string, args ...interface{}) (map[[2]int]bool, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询分组成员失败: %v", err)
+	}
+	defer rows.Close()
+	members := make(map[[2]int]bool)
+	for rows.Next() {
+		var groupID, clientID int
+		if err := rows.Scan(&groupID, &clientID); err != nil {
+			return nil, fmt.Errorf("读取分组成员失败: %v", err)
+		}
+		members[[2]int{groupID, clientID}] = true
+	}
+	return members, rows.Err()
+}
+
+// RefreshGroups 重新判断客户端属于哪些动态分组，在每次上报、修改元数据与导入资产属性后调用
+func (db *Database) RefreshGroups(clientID int) error {
+	groups, err := db.dynamicGroups()
+	if err != nil || len(groups) == 0 {
+		return err
+	}
+	fields, err := db.groupFields(clientID, groups)
+	if err != nil {
+		return err
+	}
+	f, ok := fields[clientID]
+	if !ok {
+		return nil
+	}
+	current, err := db.memberPairs(`SELECT group_id, client_id FROM client_group_members
+		WHERE client_id = ? AND source = 'rule'`, clientID)
+	if err != nil {
+		return err
+	}
+	var changes []membershipChange
+	for _, g := range groups {
+		want, have := g.rule.Match(f), current[[2]int{g.ID, clientID}]
+		if want != have {
+			changes = append(changes, membershipChange{GroupID: g.ID, ClientID: clientID, Join: want})
+		}
+	}
+	_, err = db.applyMembership(changes, "rule")
+	return err
+}
+
+// evaluateGroup 按规则对全部客户端重新计算动态分组的成员
+func (db *Database) evaluateGroup(g *Group) error {
+	rule, err := rules.Parse(g.Rule)
+	if err != nil {
+		return err
+	}
+	compiled := []compiledGroup{{ID: g.ID, Name: g.Name, rule: rule}}
+	fields, err := db.groupFields(0, compiled)
+	if err != nil {
+		return err
+	}
+	current, err := db.memberPairs(`SELECT group_id, client_id FROM client_group_members WHERE group_id = ?`, g.ID)
+	if err != nil {
+		return err
+	}
+	var changes []membershipChange
+	for id, f := range fields {
+		if rule.Match(f) && !current[[2]int{g.ID, id}] {
+			changes = append(changes, membershipChange{GroupID: g.ID, ClientID: id, Join: true})
+		}
+	}
+	for key := range current {
+		if f, ok := fields[key[1]]; !ok || !rule.Match(f) {
+			changes = append(changes, membershipChange{GroupID: g.ID, ClientID: key[1], Join: false})
+		}
+	}
+	_, err = db.applyMembership(changes, "rule")
+	return err
+}
+
+// applyMembership 在一个事务中写入成员变化并记录事件，已是目标状态的变化会被忽略；返回实际变化的数量
+func (db *Database) applyMembership(changes []membershipChange, reason string) (int, error) {
+	if len(changes) == 0 {
+		return 0, nil
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	source := "static"
+	if reason == "rule" {
+		source = "rule"
+	}
+	var applied []membershipChange
+	for _, c := range changes {
+		var res sql.Result
+		action := "leave"
+		if c.Join {
+			action = "join"
+			res, err = tx.Exec(`INSERT IGNORE INTO client_group_members (group_id, client_id, source) VALUES (?, ?, ?)`,
+				c.GroupID, c.ClientID, source)
+		} else {
+			res, err = tx.Exec(`DELETE FROM client_group_members WHERE group_id = ? AND client_id = ?`, c.GroupID, c.ClientID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("更新分组成员失败: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO client_group_events (group_id, client_id, action, reason) VALUES (?, ?, ?, ?)`,
+			c.GroupID, c.ClientID, action, reason); err != nil {
+			return 0, fmt.Errorf("记录分组事件失败: %v", err)
+		}
+		applied = append(applied, c)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("提交分组成员失败: %v", err)
+	}
+	for _, c := range applied {
+		if c.Join {
+			slog.Info("客户端加入分组", "group_id", c.GroupID, "client_id", c.ClientID, "reason", reason)
+		} else {
+			slog.Info("客户端离开分组", "group_id", c.GroupID, "client_id", c.ClientID, "reason", reason)
+		}
+	}
+	return len(applied), nil
+}
+
+// ListGroupEvents 按条件查询分组成员变化事件
+func (db *Database) ListGroupEvents(f GroupEventFilter) ([]GroupEvent, error) {
+	query := `SELECT e.id, e.group_id, IFNULL(g.name, ''), e.client_id, IFNULL(c.name, ''), e.action, e.reason,
+		UNIX_TIMESTAMP(e.created_at)
+		FROM client_group_events e LEFT JOIN client_groups g ON g.id = e.group_id
+		LEFT JOIN client_info c ON c.id = e.client_id WHERE 1 = 1`
+	var args []interface{}
+	if f.GroupID > 0 {
+		query += ` AND e.group_id = ?`
+		args = append(args, f.GroupID)
+	}
+	if f.ClientID > 0 {
+		query += ` AND e.client_id = ?`
+		args = append(args, f.ClientID)
+	}
+	if len(f.Groups) > 0 {
+		query += ` AND e.group_id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(f.Groups)), ", ") + `)`
+		for _, g := range f.Groups {
+			args = append(args, g)
+		}
+	}
+	if f.AfterID > 0 {
+		query += ` AND e.id > ? ORDER BY e.id LIMIT ?`
+		args = append(args, f.AfterID, f.Limit)
+	} else {
+		query += ` ORDER BY e.id DESC LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询分组事件失败: %v", err)
+	}
+	defer rows.Close()
+
+	events := []GroupEvent{}
+	for rows.Next() {
+		var ev GroupEvent
+		var created int64
+		if err := rows.Scan(&ev.ID, &ev.GroupID, &ev.GroupName, &ev.ClientID, &ev.ClientName, &ev.Action, &ev.Reason, &created); err != nil {
+			return nil, fmt.Errorf("读取分组事件失败: %v", err)
+		}
+		ev.CreatedAt = time.Unix(created, 0)
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// handleListGroups 列出分组，限定分组的账号只能看到可访问的分组
+func handleListGroups(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		groups, err := db.ListGroups()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if p.Scoped() {
+			visible := groups[:0]
+			for _, g := range groups {
+				if p.CanSeeGroup(g.ID) {
+					visible = append(visible, g)
+				}
+			}
+			groups = visible
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// handleGetGroup 返回分组定义
+func handleGetGroup(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		g, err := db.GetGroup(id)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !p.CanSeeGroup(id) {
+			writeJSONError(w, http.StatusNotFound, "分组不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g)
+	}
+}
+
+// handleSaveGroup 新建（POST /api/groups）或修改（PUT /api/groups/{id}）分组；分组类型创建后不能修改
+func handleSaveGroup(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		var g Group
+		if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "无效的JSON数据")
+			return
+		}
+		status := http.StatusCreated
+		if v, ok := mux.Vars(r)["id"]; ok {
+			g.ID, _ = strconv.Atoi(v)
+			cur, err := db.GetGroup(g.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "分组不存在")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "查询分组失败", "err", err)
+				http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+				return
+			}
+			if g.Type != "" && g.Type != cur.Type {
+				writeJSONError(w, http.StatusBadRequest, "分组类型创建后不能修改")
+				return
+			}
+			g.Type = cur.Type
+			status = http.StatusOK
+		}
+		if err := g.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		err := db.SaveGroup(&g)
+		if isDuplicateKeyError(err) {
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("分组名称已存在: %s", g.Name))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "保存分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "已保存分组", "id", g.ID, "name", g.Name, "type", g.Type, "rule", g.Rule, "members", g.Members)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(g)
+	}
+}
+
+// handleDeleteGroup 删除分组；仍被冻结/固定规则引用的分组不能删除
+func handleDeleteGroup(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		holds, err := db.groupHoldCount(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if holds > 0 {
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("分组仍被 %d 条冻结/固定规则引用，请先删除这些规则", holds))
+			return
+		}
+		found, err := db.DeleteGroup(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "分组不存在", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "已删除分组", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGroupMembers 向静态分组添加（POST）或移除（DELETE）客户端，请求体为 {"client_ids": [1, 2]}。
+// 限定分组的账号只能修改可访问的分组，并且只能添加其可访问的客户端
+func handleGroupMembers(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleOperator)
+		if !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		g, err := db.GetGroup(id)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && !p.CanSeeGroup(id) {
+			writeJSONError(w, http.StatusNotFound, "分组不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if g.Type != "static" {
+			writeJSONError(w, http.StatusBadRequest, "动态分组的成员由规则计算，不能手动修改")
+			return
+		}
+		var req struct {
+			ClientIDs []int `json:"client_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.ClientIDs) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "请求体需为 {\"client_ids\": [...]}")
+			return
+		}
+		join := r.Method == http.MethodPost
+		if join {
+			// 只允许添加已存在（且可访问）的客户端
+			for _, cid := range req.ClientIDs {
+				in, err := db.ClientInScope(cid, p.Groups)
+				if err == nil && !in {
+					writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("客户端不存在: %d", cid))
+					return
+				}
+				if err != nil {
+					slog.ErrorContext(r.Context(), "查询客户端失败", "err", err)
+					http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+		n, err := db.SetStaticMembers(id, req.ClientIDs, join)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "修改分组成员失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "已修改分组成员", "id", id, "join", join, "changed", n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"changed": n})
+	}
+}
+
+// handleGroupClients 列出分组成员，支持与 /api/clients 相同的筛选与排序参数
+func handleGroupClients(db *Database, store *ConfigStore) http.HandlerFunc {
+	list := handleListClients(db, store)
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		if _, err := db.GetGroup(id); errors.Is(err, sql.ErrNoRows) || err == nil && !p.CanSeeGroup(id) {
+			writeJSONError(w, http.StatusNotFound, "分组不存在")
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "查询分组失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		q.Set("group", strconv.Itoa(id))
+		r.URL.RawQuery = q.Encode()
+		list(w, r)
+	}
+}
+
+// handleListGroupEvents 查询分组成员变化事件，after_id 用于增量轮询；限定分组的账号只能看到可访问分组的事件
+func handleListGroupEvents(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleOperator)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		f := GroupEventFilter{Limit: 100, Groups: p.Groups}
+		for _, p := range []struct {
+			name string
+			dst  *int
+		}{
+			{"group_id", &f.GroupID}, {"client_id", &f.ClientID}, {"after_id", &f.AfterID},
+		} {
+			if v := q.Get(p.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					writeJSONError(w, http.StatusBadRequest, "无效的 "+p.name)
+					return
+				}
+				*p.dst = n
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				writeJSONError(w, http.StatusBadRequest, "limit 需在 1-1000 之间")
+				return
+			}
+			f.Limit = n
+		}
+		events, err := db.ListGroupEvents(f)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询分组事件失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
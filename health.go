@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthConfig 就绪检查配置
+type HealthConfig struct {
+	// 数据库探测超时
+	DBTimeout Duration `json:"db_timeout"`
+	// 日志等目录所在磁盘的最小剩余空间（字节）
+	MinFreeBytes int64 `json:"min_free_bytes"`
+}
+
+// defaultHealthConfig 默认就绪检查配置
+func defaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		DBTimeout:    Duration(2 * time.Second),
+		MinFreeBytes: 100 << 20,
+	}
+}
+
+// Workers 后台任务心跳登记，任务在每轮执行后调用 Beat，
+// 超过两个周期未心跳的任务在就绪检查中视为异常
+type Workers struct {
+	mu      sync.Mutex
+	entries map[string]*workerEntry
+}
+
+type workerEntry struct {
+	interval time.Duration
+	lastBeat time.Time
+	lastErr  string
+}
+
+// NewWorkers 创建后台任务登记表
+func NewWorkers() *Workers {
+	return &Workers{entries: make(map[string]*workerEntry)}
+}
+
+// Register 登记后台任务及其执行周期
+func (w *Workers) Register(name string, interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries[name] = &workerEntry{interval: interval, lastBeat: time.Now()}
+}
+
+// Beat 记录任务完成一轮执行，err 非空时记录最近一次错误
+func (w *Workers) Beat(name string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e, ok := w.entries[name]
+	if !ok {
+		return
+	}
+	e.lastBeat = time.Now()
+	e.lastErr = ""
+	if err != nil {
+		e.lastErr = err.Error()
+	}
+}
+
+// Check 返回各任务的状态
+func (w *Workers) Check() map[string]CheckResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make(map[string]CheckResult, len(w.entries))
+	for name, e := range w.entries {
+		res := CheckResult{Status: "ok", Detail: fmt.Sprintf("上次执行于 %s", e.lastBeat.Format(time.RFC3339))}
+		if since := time.Since(e.lastBeat); since > 2*e.interval {
+			res.Status = "fail"
+			res.Error = fmt.Sprintf("已 %s 未执行（周期 %s）", since.Round(time.Second), e.interval)
+		} else if e.lastErr != "" {
+			res.Error = e.lastErr
+		}
+		out[name] = res
+	}
+	return out
+}
+
+// CheckResult 单项检查结果
+type CheckResult struct {
+	Status     string `json:"status"` // ok / fail
+	Error      string `json:"error,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Readiness 就绪检查所需的依赖
+type Readiness struct {
+	db        *Database
+	store     *ConfigStore
+	lifecycle *Lifecycle
+	workers   *Workers
+}
+
+// NewReadiness 创建就绪检查
+func NewReadiness(db *Database, store *ConfigStore, lifecycle *Lifecycle, workers *Workers) *Readiness {
+	return &Readiness{db: db, store: store, lifecycle: lifecycle, workers: workers}
+}
+
+// Run 执行全部检查，返回检查结果与是否就绪
+func (rd *Readiness) Run(ctx context.Context) (map[string]CheckResult, bool) {
+	cfg := rd.store.Get()
+	checks := make(map[string]CheckResult)
+
+	timed := func(fn func() (string, error)) CheckResult {
+		start := time.Now()
+		detail, err := fn()
+		res := CheckResult{Status: "ok", Detail: detail, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			res.Status = "fail"
+			res.Error = err.Error()
+		}
+		return res
+	}
+
+	if rd.lifecycle.Draining() {
+		checks["shutdown"] = CheckResult{Status: "fail", Error: "服务正在关闭"}
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Health.DBTimeout))
+	defer cancel()
+	checks["database"] = timed(func() (string, error) {
+		return "", rd.db.conn.PingContext(dbCtx)
+	})
+	checks["migrations"] = timed(func() (string, error) {
+		version, err := rd.db.SchemaVersion(dbCtx)
+		if err != nil {
+			return "", err
+		}
+		if version < schemaVersion {
+			return "", fmt.Errorf("数据库结构版本 %d 低于期望版本 %d", version, schemaVersion)
+		}
+		if version > schemaVersion {
+			return "", fmt.Errorf("数据库结构版本 %d 高于程序支持的版本 %d，请升级服务端", version, schemaVersion)
+		}
+		return fmt.Sprintf("版本 %d", version), nil
+	})
+
+	for name, res := range rd.workers.Check() {
+		checks["worker:"+name] = res
+	}
+
+	for _, dir := range readinessDirs(cfg) {
+		dir := dir
+		checks["disk:"+dir] = timed(func() (string, error) {
+			free, err := diskFree(dir)
+			if err != nil {
+				return "", fmt.Errorf("读取剩余空间失败: %v", err)
+			}
+			detail := fmt.Sprintf("剩余 %d MB", free>>20)
+			if cfg.Health.MinFreeBytes > 0 && int64(free) < cfg.Health.MinFreeBytes {
+				return detail, fmt.Errorf("剩余空间不足 %d MB", cfg.Health.MinFreeBytes>>20)
+			}
+			return detail, nil
+		})
+	}
+
+	ready := true
+	for _, res := range checks {
+		if res.Status != "ok" {
+			ready = false
+		}
+	}
+	return checks, ready
+}
+
+// readinessDirs 需要检查剩余空间的目录
+func readinessDirs(cfg *ServerConfig) []string {
+	var dirs []string
+	if cfg.Log.Dir != "" {
+		dirs = append(dirs, cfg.Log.Dir)
+	}
+	if cfg.Update.StorageDir != "" {
+		dirs = append(dirs, cfg.Update.StorageDir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// handleReady 就绪检查：数据库、数据库结构版本、后台任务与磁盘空间全部正常时返回 200，否则 503
+func handleReady(rd *Readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks, ready := rd.Run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		status := "ready"
+		if !ready {
+			status = "not ready"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status,
+			"checks": checks,
+		})
+	}
+}
+
+// SchemaVersion 返回已应用的数据库结构版本
+func (db *Database) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.conn.QueryRowContext(ctx, `SELECT IFNULL(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("读取数据库结构版本失败: %v", err)
+	}
+	return version, nil
+}
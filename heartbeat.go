@@ -0,0 +1,400 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 在线率查询的限制：时段不能短于 minAvailabilityBucket，单次查询最多 maxAvailabilityBuckets 个时段
+const (
+	minAvailabilityBucket   = time.Minute
+	maxAvailabilityBuckets  = 100000
+	defaultAvailabilitySpan = 30 * 24 * time.Hour
+)
+
+// HeartbeatConfig 上报心跳配置
+type HeartbeatConfig struct {
+	// 记录每次上报（包括无变化的上报）的时间、来源 IP 与客户端版本
+	Enabled bool `json:"enabled"`
+	// 原始心跳的保留时长，超过后只保留按小时汇总的上报次数；0 表示不清理（如按分区自行删除）
+	RawRetention Duration `json:"raw_retention"`
+	// 按小时汇总的保留时长，0 表示不清理
+	RollupRetention Duration `json:"rollup_retention"`
+}
+
+// defaultHeartbeatConfig 默认记录心跳，原始心跳保留 30 天，汇总长期保留
+func defaultHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{
+		Enabled:      true,
+		RawRetention: Duration(30 * 24 * time.Hour),
+	}
+}
+
+// validate 检查心跳配置
+func (c *HeartbeatConfig) validate() error {
+	if c.RawRetention < 0 || c.RollupRetention < 0 {
+		return fmt.Errorf("heartbeat 的保留时长不能为负数")
+	}
+	if c.RollupRetention > 0 && (c.RawRetention == 0 || c.RollupRetention < c.RawRetention) {
+		return fmt.Errorf("heartbeat.rollup_retention 不能小于 heartbeat.raw_retention")
+	}
+	return nil
+}
+
+// RecordHeartbeat 记录一次上报并累加当前小时的汇总
+func (db *Database) RecordHeartbeat(clientID int, sourceIP, version string) error {
+	if _, err := db.conn.Exec(`INSERT IGNORE INTO client_heartbeats (client_id, reported_at, source_ip, up_ver)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?)`, clientID, truncate(sourceIP, 45), truncate(version, 64)); err != nil {
+		return fmt.Errorf("记录心跳失败: %v", err)
+	}
+	if _, err := db.conn.Exec(`INSERT INTO client_heartbeat_hourly (client_id, hour, reports)
+		VALUES (?, FROM_UNIXTIME(UNIX_TIMESTAMP() DIV 3600 * 3600), 1)
+		ON DUPLICATE KEY UPDATE reports = reports + 1`, clientID); err != nil {
+		return fmt.Errorf("汇总心跳失败: %v", err)
+	}
+	return nil
+}
+
+// Heartbeat 一次上报的记录
+type Heartbeat struct {
+	ReportedAt time.Time `json:"reported_at"`
+	SourceIP   string    `json:"source_ip"`
+	UpVer      string    `json:"up_ver"`
+}
+
+// ListHeartbeats 返回客户端在 to 之前（from 非零时还需不早于 from）的原始心跳，最新的在前，最多 limit 条
+func (db *Database) ListHeartbeats(clientID int, from, to time.Time, limit int) ([]Heartbeat, error) {
+	query := `SELECT UNIX_TIMESTAMP(reported_at), IFNULL(source_ip, ''), IFNULL(up_ver, '')
+		FROM client_heartbeats WHERE client_id = ? AND reported_at < FROM_UNIXTIME(?)`
+	args := []interface{}{clientID, to.Unix()}
+	if !from.IsZero() {
+		query += ` AND reported_at >= FROM_UNIXTIME(?)`
+		args = append(args, from.Unix())
+	}
+	rows, err := db.conn.Query(query+` ORDER BY reported_at DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("查询心跳失败: %v", err)
+	}
+	defer rows.Close()
+	beats := []Heartbeat{}
+	for rows.Next() {
+		var h Heartbeat
+		var at int64
+		if err := rows.Scan(&at, &h.SourceIP, &h.UpVer); err != nil {
+			return nil, fmt.Errorf("读取心跳失败: %v", err)
+		}
+		h.ReportedAt = time.Unix(at, 0)
+		beats = append(beats, h)
+	}
+	return beats, rows.Err()
+}
+
+// AvailabilityGap 连续没有上报的时段
+type AvailabilityGap struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Duration Duration  `json:"duration"`
+}
+
+// Availability 客户端在时间范围内的在线率：范围按 bucket 划分为时段，有上报的时段视为在线。
+// 客户端登记之前的时段不计入
+type Availability struct {
+	ClientID int       `json:"client_id"`
+	Name     string    `json:"name,omitempty"`
+	PostAt   time.Time `json:"post_at"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Bucket   Duration  `json:"bucket"`
+	// 数据来源：raw 为原始心跳，hourly 为按小时汇总
+	Source string `json:"source"`
+	// 统计的时段数与其中有上报的时段数
+	Buckets   int `json:"buckets"`
+	UpBuckets int `json:"up_buckets"`
+	// 在线率（百分比，保留两位小数）
+	Percent float64 `json:"availability"`
+	Reports int64   `json:"reports"`
+	// 不短于 min_gap 的离线时段，只在单个客户端的查询中返回
+	Gaps []AvailabilityGap `json:"gaps,omitempty"`
+}
+
+// AvailabilityQuery 在线率查询的时间范围与时段长度
+type AvailabilityQuery struct {
+	From   time.Time
+	To     time.Time
+	Bucket time.Duration
+	MinGap time.Duration
+	// 使用原始心跳（时段不是整小时）还是按小时汇总
+	raw bool
+}
+
+// availabilityQueryFromValues 解析 from、to（RFC 3339 或本地日期 2006-01-02，默认最近 30 天）、
+// bucket（默认 1h）与 min_gap（默认与 bucket 相同）。整小时的时段使用按小时汇总，其余使用原始心跳，
+// 后者只能查询 raw_retention 之内的范围
+func availabilityQueryFromValues(q url.Values, hb HeartbeatConfig, now time.Time) (AvailabilityQuery, error) {
+	aq := AvailabilityQuery{To: now, Bucket: time.Hour}
+	var err error
+	if v := q.Get("to"); v != "" {
+		if aq.To, err = parseTimeParam(v); err != nil {
+			return aq, fmt.Errorf("无效的 to: %s", v)
+		}
+	}
+	aq.From = aq.To.Add(-defaultAvailabilitySpan)
+	if v := q.Get("from"); v != "" {
+		if aq.From, err = parseTimeParam(v); err != nil {
+			return aq, fmt.Errorf("无效的 from: %s", v)
+		}
+	}
+	if v := q.Get("bucket"); v != "" {
+		if aq.Bucket, err = time.ParseDuration(v); err != nil || aq.Bucket < minAvailabilityBucket {
+			return aq, fmt.Errorf("无效的 bucket: %s（不能短于 %s）", v, minAvailabilityBucket)
+		}
+	}
+	aq.MinGap = aq.Bucket
+	if v := q.Get("min_gap"); v != "" {
+		if aq.MinGap, err = time.ParseDuration(v); err != nil || aq.MinGap < 0 {
+			return aq, fmt.Errorf("无效的 min_gap: %s", v)
+		}
+	}
+	if aq.To.After(now) {
+		aq.To = now
+	}
+	aq.raw = aq.Bucket%time.Hour != 0
+	if !aq.raw {
+		// 汇总按整点记录，起点对齐到整点
+		aq.From = aq.From.Truncate(time.Hour)
+	}
+	if !aq.From.Before(aq.To) {
+		return aq, fmt.Errorf("from 必须早于 to")
+	}
+	if aq.To.Sub(aq.From)/aq.Bucket > maxAvailabilityBuckets {
+		return aq, fmt.Errorf("时段过多，请缩小范围或增大 bucket")
+	}
+	if aq.raw && hb.RawRetention > 0 && aq.From.Before(now.Add(-time.Duration(hb.RawRetention))) {
+		return aq, fmt.Errorf("原始心跳只保留 %s，更早的范围请使用整小时的 bucket", time.Duration(hb.RawRetention))
+	}
+	return aq, nil
+}
+
+// parseTimeParam 解析 RFC 3339 时间或本地日期
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
+// bucketCounts 返回时间范围内各时段（从 0 开始的序号）的上报次数，clientID 为 0 时返回全部客户端
+func (db *Database) bucketCounts(clientID int, aq AvailabilityQuery) (map[int]map[int]int64, error) {
+	table, column, count := "client_heartbeat_hourly", "hour", "SUM(reports)"
+	if aq.raw {
+		table, column, count = "client_heartbeats", "reported_at", "COUNT(*)"
+	}
+	// 时间以 Unix 秒绑定，由 FROM_UNIXTIME 按会话时区转换，与 TIMESTAMP 列的比较不受驱动与会话时区不一致的影响
+	query := `SELECT client_id, FLOOR((UNIX_TIMESTAMP(` + column + `) - ?) / ?), ` + count + `
+		FROM ` + table + ` WHERE ` + column + ` >= FROM_UNIXTIME(?) AND ` + column + ` < FROM_UNIXTIME(?)`
+	args := []interface{}{aq.From.Unix(), int64(aq.Bucket / time.Second), aq.From.Unix(), aq.To.Unix()}
+	if clientID > 0 {
+		query += ` AND client_id = ?`
+		args = append(args, clientID)
+	}
+	rows, err := db.conn.Query(query+` GROUP BY 1, 2`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("统计心跳失败: %v", err)
+	}
+	defer rows.Close()
+	out := make(map[int]map[int]int64)
+	for rows.Next() {
+		var id, bucket int
+		var n int64
+		if err := rows.Scan(&id, &bucket, &n); err != nil {
+			return nil, fmt.Errorf("读取心跳统计失败: %v", err)
+		}
+		if out[id] == nil {
+			out[id] = make(map[int]int64)
+		}
+		out[id][bucket] = n
+	}
+	return out, rows.Err()
+}
+
+// computeAvailability 根据各时段的上报次数计算在线率；created 之前的时段不计入，withGaps 为 true 时列出离线时段
+func computeAvailability(aq AvailabilityQuery, created time.Time, counts map[int]int64, withGaps bool) Availability {
+	a := Availability{From: aq.From, To: aq.To, Bucket: Duration(aq.Bucket), Source: "hourly"}
+	if aq.raw {
+		a.Source = "raw"
+	}
+	total := int((aq.To.Sub(aq.From) + aq.Bucket - 1) / aq.Bucket)
+	first := 0
+	if created.After(aq.From) {
+		first = int(created.Sub(aq.From) / aq.Bucket)
+	}
+	if first > total {
+		first = total
+	}
+	a.Buckets = total - first
+	for b, n := range counts {
+		a.Reports += n
+		if b >= first && b < total && n > 0 {
+			a.UpBuckets++
+		}
+	}
+	if a.Buckets > 0 {
+		a.Percent = math.Round(float64(a.UpBuckets)*10000/float64(a.Buckets)) / 100
+	}
+	if !withGaps {
+		return a
+	}
+	a.Gaps = []AvailabilityGap{}
+	for b := first; b < total; {
+		if counts[b] > 0 {
+			b++
+			continue
+		}
+		end := b
+		for end < total && counts[end] == 0 {
+			end++
+		}
+		gap := AvailabilityGap{From: aq.From.Add(time.Duration(b) * aq.Bucket), To: aq.From.Add(time.Duration(end) * aq.Bucket)}
+		if gap.To.After(aq.To) {
+			gap.To = aq.To
+		}
+		gap.Duration = Duration(gap.To.Sub(gap.From))
+		if time.Duration(gap.Duration) >= aq.MinGap {
+			a.Gaps = append(a.Gaps, gap)
+		}
+		b = end
+	}
+	return a
+}
+
+// handleClientAvailability 返回单个客户端在时间范围内的在线率与离线时段，
+// 例如 /api/clients/1/availability?from=2024-05-01&to=2024-06-01&bucket=24h 统计 5 月份有上报的天数
+func handleClientAvailability(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleViewer)
+		if !ok {
+			return
+		}
+		cfg := store.Get()
+		aq, err := availabilityQueryFromValues(r.URL.Query(), cfg.Heartbeat, time.Now())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		c, err := db.GetClient(id, time.Duration(cfg.Inventory.OfflineAfter))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "客户端不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询客户端详情失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		counts, err := db.bucketCounts(id, aq)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "统计在线率失败", "client_id", id, "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		a := computeAvailability(aq, c.CreatedAt, counts[id], true)
+		a.ClientID, a.Name, a.PostAt = c.ID, c.Name, c.PostAt
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// handleListAvailability 按 /api/clients 的筛选条件列出客户端的在线率，从低到高排列，不含离线时段
+func handleListAvailability(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		cfg := store.Get()
+		aq, err := availabilityQueryFromValues(r.URL.Query(), cfg.Heartbeat, time.Now())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f, err := clientFilterFromQuery(r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.Scope = p.Groups
+		f.Sort = "id"
+		counts, err := db.bucketCounts(0, aq)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "统计在线率失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		list := []Availability{}
+		err = db.EachClient(f, time.Duration(cfg.Inventory.OfflineAfter), func(c *ClientDetail) error {
+			a := computeAvailability(aq, c.CreatedAt, counts[c.ID], false)
+			a.ClientID, a.Name, a.PostAt = c.ID, c.Name, c.PostAt
+			list = append(list, a)
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询客户端失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Percent < list[j].Percent })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// handleClientHeartbeats 返回客户端最近的原始心跳，参数 from、to 同在线率查询，limit 默认 100、最多 1000
+func handleClientHeartbeats(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleViewer)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		to, from := time.Now(), time.Time{}
+		var err error
+		if v := q.Get("to"); v != "" {
+			if to, err = parseTimeParam(v); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的 to: %s", v))
+				return
+			}
+		}
+		if v := q.Get("from"); v != "" {
+			if from, err = parseTimeParam(v); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的 from: %s", v))
+				return
+			}
+		}
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				writeJSONError(w, http.StatusBadRequest, "limit 需在 1-1000 之间")
+				return
+			}
+			limit = n
+		}
+		beats, err := db.ListHeartbeats(id, from, to, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询心跳失败", "client_id", id, "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(beats)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatTimeRange(t *testing.T) {
+	db := testDatabase(t)
+	id := testClient(t, db, t.Name())
+
+	to := time.Now().Truncate(time.Hour).Add(time.Hour)
+	from := to.Add(-12 * time.Hour)
+	at := []time.Time{to.Add(-30 * time.Minute), to.Add(-150 * time.Minute), to.Add(-330 * time.Minute)}
+	for _, ts := range at {
+		if _, err := db.conn.Exec(`INSERT INTO client_heartbeats (client_id, reported_at) VALUES (?, FROM_UNIXTIME(?))`, id, ts.Unix()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 会话时区与驱动不一致时，各心跳仍落在按 Unix 时间划分的时段内
+	counts, err := db.bucketCounts(id, AvailabilityQuery{From: from, To: to, Bucket: time.Hour, raw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int64{11: 1, 9: 1, 6: 1}
+	if !reflect.DeepEqual(counts[id], want) {
+		t.Fatalf("bucketCounts = %v, want %v", counts[id], want)
+	}
+
+	beats, err := db.ListHeartbeats(id, to.Add(-3*time.Hour), to, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(beats) != 2 || !beats[0].ReportedAt.Equal(at[0]) || !beats[1].ReportedAt.Equal(at[1]) {
+		t.Fatalf("ListHeartbeats = %+v, want reports at %v and %v", beats, at[0], at[1])
+	}
+}
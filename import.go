@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// importPath 导入接口路径，请求体上限为 http.max_import_bytes
+const importPath = "/api/import"
+
+// maxAttributeKeyLen 资产属性名的最大长度
+const maxAttributeKeyLen = 64
+
+// importHeaderAliases 识别为设备标识与主机名的表头（不区分大小写），包括导出文件的中英文表头；
+// 其余列均作为资产属性保存
+var importHeaderAliases = map[string]string{
+	"sn": "sn", "serial": "sn", "serial_number": "sn", "serial number": "sn", "序列号": "sn",
+	"mac": "mac", "mac_address": "mac", "mac address": "mac", "mac 地址": "mac", "mac地址": "mac",
+	"name": "name", "hostname": "name", "主机名": "name",
+}
+
+// ImportRow 导入文件中的一行：sn/mac 用于匹配设备，name 仅用于新建的占位记录
+type ImportRow struct {
+	Line       int
+	SN         string
+	MAC        string
+	Name       string
+	Attributes map[string]string
+}
+
+// ImportResult 单行的导入结果
+type ImportResult struct {
+	Line int    `json:"line"`
+	SN   string `json:"sn,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+	// matched：匹配到已有设备；created：新建占位记录；conflict：SN 与 MAC 指向不同设备；error：数据无效
+	Action   string `json:"action"`
+	ClientID int    `json:"client_id,omitempty"`
+	// 新增或变化的属性数
+	Changed int    `json:"changed"`
+	Message string `json:"message,omitempty"`
+}
+
+// ImportReport 导入报告；dry_run 时数据库不会被修改，created 行的 client_id 为空
+type ImportReport struct {
+	DryRun    bool           `json:"dry_run"`
+	Total     int            `json:"total"`
+	Matched   int            `json:"matched"`
+	Created   int            `json:"created"`
+	Conflicts int            `json:"conflicts"`
+	Errors    int            `json:"errors"`
+	Rows      []ImportResult `json:"rows"`
+}
+
+// normalizeMAC 将常见的 MAC 写法规范化为客户端上报的 xxxx.xxxx.xxxx 形式，无法识别时返回错误
+func normalizeMAC(mac string) (string, error) {
+	var hex strings.Builder
+	for _, r := range strings.ToLower(mac) {
+		switch {
+		case r >= '0' && r <= '9' || r >= 'a' && r <= 'f':
+			hex.WriteRune(r)
+		case r == ':' || r == '-' || r == '.' || r == ' ':
+		default:
+			return "", fmt.Errorf("无效的 MAC 地址: %s", mac)
+		}
+	}
+	s := hex.String()
+	if len(s) != 12 {
+		return "", fmt.Errorf("无效的 MAC 地址: %s", mac)
+	}
+	return s[0:4] + "." + s[4:8] + "." + s[8:12], nil
+}
+
+// normalizeAttributeKey 规范化资产属性名：去除首尾空白，ASCII 字母转小写，空格替换为下划线
+func normalizeAttributeKey(key string) string {
+	key = strings.TrimSpace(strings.TrimPrefix(key, "\uFEFF"))
+	var b strings.Builder
+	for _, r := range key {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			b.WriteRune(r + 'a' - 'A')
+		case r == ' ':
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isReportedColumn 判断表头是否为客户端上报的字段（导出文件中的列），这些列在导入时忽略，
+// 避免把上报数据的快照保存为资产属性
+func isReportedColumn(header string) bool {
+	for _, c := range exportColumns {
+		if strings.EqualFold(header, c.Key) || header == c.ZH || strings.EqualFold(header, c.EN) {
+			return true
+		}
+	}
+	return false
+}
+
+// newImportRow 根据列名与取值生成一行，识别设备标识列，其余非空列作为属性
+func newImportRow(line int, fields map[string]string) ImportRow {
+	row := ImportRow{Line: line, Attributes: make(map[string]string)}
+	for k, v := range fields {
+		v = strings.TrimSpace(v)
+		header := strings.TrimSpace(strings.TrimPrefix(k, "\uFEFF"))
+		alias := importHeaderAliases[strings.ToLower(header)]
+		if alias == "" && isReportedColumn(header) {
+			continue
+		}
+		switch alias {
+		case "sn":
+			row.SN = v
+		case "mac":
+			row.MAC = v
+		case "name":
+			row.Name = v
+		default:
+			if key := normalizeAttributeKey(k); key != "" && v != "" {
+				row.Attributes[key] = v
+			}
+		}
+	}
+	return row
+}
+
+// parseImportCSV 解析带表头的 CSV
+func parseImportCSV(r io.Reader) ([]ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV 文件为空")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取 CSV 表头失败: %v", err)
+	}
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取 CSV 第 %d 行失败: %v", line, err)
+		}
+		fields := make(map[string]string, len(header))
+		empty := true
+		for i, v := range record {
+			if i < len(header) {
+				fields[header[i]] = v
+			}
+			if strings.TrimSpace(v) != "" {
+				empty = false
+			}
+		}
+		if !empty {
+			rows = append(rows, newImportRow(line, fields))
+		}
+	}
+	return rows, nil
+}
+
+// parseImportJSON 解析对象数组，值可以是字符串、数字或布尔值
+func parseImportJSON(r io.Reader) ([]ImportRow, error) {
+	var items []map[string]interface{}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&items); err != nil {
+		return nil, fmt.Errorf("解析 JSON 失败（需要对象数组）: %v", err)
+	}
+	rows := make([]ImportRow, 0, len(items))
+	for i, item := range items {
+		fields := make(map[string]string, len(item))
+		for k, v := range item {
+			switch x := v.(type) {
+			case nil:
+			case string:
+				fields[k] = x
+			case json.Number:
+				fields[k] = x.String()
+			case bool:
+				fields[k] = strconv.FormatBool(x)
+			default:
+				return nil, fmt.Errorf("第 %d 个对象的字段 %s 不是字符串或数字", i+1, k)
+			}
+		}
+		rows = append(rows, newImportRow(i+1, fields))
+	}
+	return rows, nil
+}
+
+// parseImport 按格式解析导入数据
+func parseImport(r io.Reader, format string) ([]ImportRow, error) {
+	switch format {
+	case "csv":
+		return parseImportCSV(r)
+	case "json":
+		return parseImportJSON(r)
+	}
+	return nil, fmt.Errorf("不支持的导入格式: %s（可选 csv、json）", format)
+}
+
+// ImportClients 将导入数据匹配到设备：按与 CheckExistingRecord 相同的规则用 MAC 或 SN 查找，
+// 未找到时新建占位记录（post_at 为空，设备首次上报时自动合并），属性写入 client_attributes。
+// 全部操作在一个事务中完成，dryRun 时回滚，因此报告与实际导入的结果一致
+func (db *Database) ImportClients(rows []ImportRow, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun, Total: len(rows), Rows: make([]ImportResult, 0, len(rows))}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, row := range rows {
+		res := importRow(tx, row)
+		switch res.Action {
+		case "fatal":
+			return nil, errors.New(res.Message)
+		case "matched":
+			report.Matched++
+		case "created":
+			report.Created++
+			if dryRun {
+				res.ClientID = 0
+			}
+		case "conflict":
+			report.Conflicts++
+		case "error":
+			report.Errors++
+		}
+		report.Rows = append(report.Rows, res)
+	}
+	if dryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("提交导入失败: %v", err)
+	}
+	// 资产属性可能被动态分组规则引用，导入已提交，重新计算失败时只记录日志
+	for _, res := range report.Rows {
+		if res.Action == "created" || res.Action == "matched" && res.Changed > 0 {
+			if err := db.RefreshGroups(res.ClientID); err != nil {
+				slog.Warn("重新计算动态分组失败", "client_id", res.ClientID, "err", err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// normalizeImportRow 规范化一行的 MAC 并校验；数据无效时返回的 Action 为 error，否则为空
+func normalizeImportRow(row ImportRow) (ImportRow, ImportResult) {
+	res := ImportResult{Line: row.Line, SN: row.SN, MAC: row.MAC}
+	if row.MAC != "" {
+		mac, err := normalizeMAC(row.MAC)
+		if err != nil {
+			res.Action, res.Message = "error", err.Error()
+			return row, res
+		}
+		row.MAC, res.MAC = mac, mac
+	}
+	if row.SN == "" && row.MAC == "" {
+		res.Action, res.Message = "error", "缺少 sn 或 mac"
+		return row, res
+	}
+	for k := range row.Attributes {
+		if utf8.RuneCountInString(k) > maxAttributeKeyLen {
+			res.Action, res.Message = "error", fmt.Sprintf("属性名过长: %s", k)
+			return row, res
+		}
+	}
+	return row, res
+}
+
+// decideImport 根据按 MAC 与 SN 查到的客户端（0 表示未找到）决定如何导入一行：
+// 二者指向不同客户端时为 conflict；找到其一时合并到该客户端（matched，MAC 优先）；都未找到时新建占位记录（created）
+func decideImport(res ImportResult, byMAC, bySN int) ImportResult {
+	switch {
+	case byMAC > 0 && bySN > 0 && byMAC != bySN:
+		res.Action = "conflict"
+		res.Message = fmt.Sprintf("MAC 匹配客户端 %d，SN 匹配客户端 %d", byMAC, bySN)
+	case byMAC > 0:
+		res.ClientID, res.Action = byMAC, "matched"
+	case bySN > 0:
+		res.ClientID, res.Action = bySN, "matched"
+	default:
+		res.Action = "created"
+	}
+	return res
+}
+
+// importRow 在事务中导入一行；数据库错误以 fatal 返回，终止整个导入
+func importRow(tx *sql.Tx, row ImportRow) ImportResult {
+	fatal := func(err error) ImportResult {
+		return ImportResult{Line: row.Line, Action: "fatal", Message: fmt.Sprintf("第 %d 行: %v", row.Line, err)}
+	}
+	row, res := normalizeImportRow(row)
+	if res.Action != "" {
+		return res
+	}
+
+	// 与 CheckExistingRecord 相同的匹配规则，分别查询以发现 SN 与 MAC 指向不同设备的冲突
+	lookup := func(column, value string) (int, error) {
+		if value == "" {
+			return 0, nil
+		}
+		var id int
+		err := tx.QueryRow(`SELECT id FROM client_info WHERE `+column+` = ? AND `+column+` != '' ORDER BY id LIMIT 1`, value).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return id, err
+	}
+	byMAC, err := lookup("mac", row.MAC)
+	if err != nil {
+		return fatal(err)
+	}
+	bySN, err := lookup("sn", row.SN)
+	if err != nil {
+		return fatal(err)
+	}
+	res = decideImport(res, byMAC, bySN)
+	switch res.Action {
+	case "conflict":
+		return res
+	case "created":
+		// 新建占位记录，不写 post_at，设备首次上报时按 SN/MAC 合并到这条记录；
+		// 上报的其他字段写入空字符串，与客户端上报空值时一致
+		r, err := tx.Exec(`INSERT INTO client_info (name, cpu, ram, disk, sn, mac, ip, up_ver, comment, network)
+			VALUES (?, '', '', '', ?, ?, '', '', '', '')`, row.Name, row.SN, row.MAC)
+		if err != nil {
+			return fatal(fmt.Errorf("新建占位记录失败: %v", err))
+		}
+		id, _ := r.LastInsertId()
+		res.ClientID = int(id)
+		if _, err := tx.Exec(`INSERT INTO client_changes (client_id, change_type, name, sn, mac) VALUES (?, 'import', ?, ?, ?)`,
+			id, row.Name, row.SN, row.MAC); err != nil {
+			return fatal(fmt.Errorf("记录变更失败: %v", err))
+		}
+	}
+
+	changed, err := saveAttributes(tx, res.ClientID, row.Attributes, "import")
+	if err != nil {
+		return fatal(err)
+	}
+	res.Changed = changed
+	return res
+}
+
+// saveAttributes 写入资产属性（只新增或覆盖，不删除未出现的属性），返回新增或变化的数量
+func saveAttributes(tx *sql.Tx, clientID int, attrs map[string]string, source string) (int, error) {
+	changed := 0
+	for k, v := range attrs {
+		// ON DUPLICATE KEY UPDATE 在值不变时影响行数为 0，新增为 1，修改为 2
+		r, err := tx.Exec(`INSERT INTO client_attributes (client_id, attr_key, attr_value, source) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE source = IF(attr_value <=> VALUES(attr_value), source, VALUES(source)),
+			attr_value = VALUES(attr_value)`, clientID, k, v, source)
+		if err != nil {
+			return changed, fmt.Errorf("保存资产属性失败: %v", err)
+		}
+		if n, _ := r.RowsAffected(); n > 0 {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// GetAttributes 读取客户端的资产属性
+func (db *Database) GetAttributes(clientID int) (map[string]string, error) {
+	rows, err := db.conn.Query(`SELECT attr_key, IFNULL(attr_value, '') FROM client_attributes WHERE client_id = ?`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("查询资产属性失败: %v", err)
+	}
+	defer rows.Close()
+	attrs := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("读取资产属性失败: %v", err)
+		}
+		attrs[k] = v
+	}
+	return attrs, rows.Err()
+}
+
+// importFormat 根据 format 参数、Content-Type 或文件扩展名判断导入格式
+func importFormat(format, contentType, filename string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch {
+	case strings.Contains(contentType, "json"):
+		return "json"
+	case strings.Contains(contentType, "csv"):
+		return "csv"
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return "json"
+	default:
+		return "csv"
+	}
+}
+
+// handleImport 导入资产表：请求体为 CSV（带表头）或 JSON 对象数组，dry_run=1 时只返回匹配报告
+func handleImport(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleOperator)
+		if !ok || !requireUnscoped(w, p) {
+			return
+		}
+		q := r.URL.Query()
+		dryRun := false
+		if v := q.Get("dry_run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "无效的 dry_run 参数")
+				return
+			}
+			dryRun = b
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("导入文件超过 %d 字节", maxErr.Limit))
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, "读取请求体失败")
+			return
+		}
+		rows, err := parseImport(bytes.NewReader(body), importFormat(q.Get("format"), r.Header.Get("Content-Type"), ""))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		report, err := db.ImportClients(rows, dryRun)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "导入资产表失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "已导入资产表", "dry_run", dryRun, "total", report.Total, "matched", report.Matched,
+			"created", report.Created, "conflicts", report.Conflicts, "errors", report.Errors)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// runImportCommand 实现 import 子命令：直接将资产表导入数据库，默认只输出匹配报告
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("GOUP_CONFIG"), "配置文件路径")
+	dsn := fs.String("dsn", "", "数据库连接字符串（默认取自配置文件或环境变量）")
+	format := fs.String("format", "", "导入格式: csv/json（默认按扩展名判断）")
+	apply := fs.Bool("apply", false, "写入数据库；不指定时只输出匹配报告（dry run）")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s import [参数] <文件>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	file := fs.Arg(0)
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	rows, err := parseImport(f, importFormat(*format, "", file))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 2
+	}
+
+	var overrides ConfigOverrides
+	if *dsn != "" {
+		overrides.DSN = dsn
+	}
+	cfg, err := LoadServerConfig(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 1
+	}
+	db, err := NewDatabase(cfg.Database.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "数据库连接失败: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	if err := db.CreateTable(); err != nil {
+		fmt.Fprintf(os.Stderr, "创建数据表失败: %v\n", err)
+		return 1
+	}
+
+	start := time.Now()
+	report, err := db.ImportClients(rows, !*apply)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+		return 1
+	}
+	for _, res := range report.Rows {
+		if res.Action == "conflict" || res.Action == "error" {
+			fmt.Fprintf(os.Stderr, "第 %d 行 %s: %s\n", res.Line, res.Action, res.Message)
+		}
+	}
+	mode := "已导入"
+	if report.DryRun {
+		mode = "试运行（未写入，使用 -apply 导入）"
+	}
+	fmt.Fprintf(os.Stderr, "%s: 共 %d 行，匹配 %d，新建 %d，冲突 %d，错误 %d，用时 %s\n", mode, report.Total,
+		report.Matched, report.Created, report.Conflicts, report.Errors, time.Since(start).Round(time.Millisecond))
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestImportRowDecision(t *testing.T) {
+	// 已有客户端：1 的 MAC 与 SN，2 只有 SN
+	existing := map[string]int{"mac:0011.2233.4455": 1, "sn:SN-1": 1, "sn:SN-2": 2}
+	tests := []struct {
+		name   string
+		row    ImportRow
+		action string
+		id     int
+		mac    string
+	}{
+		{"match by mac", ImportRow{MAC: "00-11-22-33-44-55"}, "matched", 1, "0011.2233.4455"},
+		{"match by sn", ImportRow{SN: "SN-2"}, "matched", 2, ""},
+		{"mac and sn agree", ImportRow{SN: "SN-1", MAC: "00:11:22:33:44:55"}, "matched", 1, "0011.2233.4455"},
+		{"mac wins over unknown sn", ImportRow{SN: "SN-9", MAC: "0011.2233.4455"}, "matched", 1, "0011.2233.4455"},
+		{"sn matches, new mac", ImportRow{SN: "SN-2", MAC: "aa:bb:cc:dd:ee:ff"}, "matched", 2, "aabb.ccdd.eeff"},
+		{"mac and sn conflict", ImportRow{SN: "SN-2", MAC: "0011.2233.4455"}, "conflict", 0, "0011.2233.4455"},
+		{"unknown device", ImportRow{SN: "SN-9", MAC: "aa-bb-cc-dd-ee-ff"}, "created", 0, "aabb.ccdd.eeff"},
+		{"invalid mac", ImportRow{SN: "SN-1", MAC: "00:11:22"}, "error", 0, "00:11:22"},
+		{"no identifier", ImportRow{Name: "desk-01"}, "error", 0, ""},
+		{"overlong attribute", ImportRow{SN: "SN-1", Attributes: map[string]string{strings.Repeat("k", maxAttributeKeyLen+1): "x"}}, "error", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, res := normalizeImportRow(tt.row)
+			if res.Action == "" {
+				res = decideImport(res, existing["mac:"+row.MAC], existing["sn:"+row.SN])
+			}
+			if res.Action != tt.action || res.ClientID != tt.id || res.MAC != tt.mac {
+				t.Fatalf("result = %+v, want %s client %d mac %q", res, tt.action, tt.id, tt.mac)
+			}
+			if (res.Action == "conflict" || res.Action == "error") && res.Message == "" {
+				t.Fatalf("%s without message", res.Action)
+			}
+		})
+	}
+}
+
+// clientColumns 读取 client_info 中的一行，NULL 的列 Valid 为 false
+func clientColumns(t *testing.T, db *Database, id int) map[string]sql.NullString {
+	t.Helper()
+	cols := []string{"name", "cpu", "ram", "disk", "sn", "mac", "ip", "up_ver", "comment", "network", "post_at"}
+	vals := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := db.conn.QueryRow(`SELECT name, cpu, ram, disk, sn, mac, ip, up_ver, comment, network, post_at
+		FROM client_info WHERE id = ?`, id).Scan(dest...); err != nil {
+		t.Fatal(err)
+	}
+	row := make(map[string]sql.NullString, len(cols))
+	for i, c := range cols {
+		row[c] = vals[i]
+	}
+	return row
+}
+
+func TestImportThenFirstReport(t *testing.T) {
+	db := testDatabase(t)
+	sn := "SN-" + t.Name()
+	rows := []ImportRow{newImportRow(1, map[string]string{"sn": sn, "mac": "02-11-22-33-44-55", "name": "前台电脑"})}
+	report, err := db.ImportClients(rows, false)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if report.Created != 1 || report.Rows[0].Action != "created" {
+		t.Fatalf("report = %+v, want one created row", report)
+	}
+	id := report.Rows[0].ClientID
+	cleanupClient(t, db, id)
+	for col, v := range clientColumns(t, db, id) {
+		if !v.Valid && col != "post_at" {
+			t.Errorf("placeholder column %s is NULL", col)
+		}
+	}
+
+	// 设备首次上报合并到占位记录
+	info := &ClientInfo{
+		Name: "desk-01", CPU: "Intel i5", RAM: "16GB", Disk: "512GB",
+		SN: sn, MAC: "0211.2233.4455", IP: "10.0.0.8", UpVer: "1.4.0", Network: "office",
+	}
+	result, gotID, err := db.InsertOrUpdateClientInfo(info, nil)
+	if err != nil {
+		t.Fatalf("first report: %v", err)
+	}
+	if result != "update" || gotID != id {
+		t.Fatalf("first report = %s client %d, want update client %d", result, gotID, id)
+	}
+	row := clientColumns(t, db, id)
+	if row["cpu"].String != "Intel i5" || row["up_ver"].String != "1.4.0" || !row["post_at"].Valid {
+		t.Fatalf("row after first report = %v", row)
+	}
+
+	// 数据不变的再次上报只刷新 post_at
+	if result, _, err := db.InsertOrUpdateClientInfo(info, nil); err != nil || result != "nochange" {
+		t.Fatalf("second report = %s, %v; want nochange", result, err)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goup-server/internal/ratelimit"
+)
+
+// ingestPath 客户端上报接口
+const ingestPath = "/api/client"
+
+// flapSweepInterval 清理已恢复的抖动记录的间隔
+const flapSweepInterval = time.Minute
+
+// IngestConfig 上报接口的限流与防滥用设置
+type IngestConfig struct {
+	// 上报请求体大小上限（字节），0 表示使用 http.max_body_bytes
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// 按来源 IP 与按设备（序列号，没有时为 MAC）的令牌桶限流，per_minute 或 burst 为 0 时不限流。
+	// 经过 NAT 或代理时大量客户端共用一个来源 IP，因此默认只按设备限流
+	PerIP     ratelimit.Limit `json:"per_ip"`
+	PerDevice ratelimit.Limit `json:"per_device"`
+	// 可信的反向代理（IP 或 CIDR），来自这些地址的请求按 X-Forwarded-For 取客户端 IP
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// 拒绝包含未知字段的上报，默认忽略未知字段以兼容更新版本的客户端
+	RejectUnknownFields bool `json:"reject_unknown_fields"`
+	// 严格校验：MAC、IP、版本号无法识别或字段超长的上报返回 422 与各字段的错误；
+	// 默认只规范化能识别的字段，其余原样保存（超长的截断）
+	Strict bool `json:"strict"`
+	// flap_window 内数据变化超过 flap_threshold 次的设备视为抖动，之后的变化只更新当前数据、不写变更记录，
+	// 直到一个完整的 flap_window 内没有变化；flap_threshold 为 0 时不检测
+	FlapWindow    Duration `json:"flap_window"`
+	FlapThreshold int      `json:"flap_threshold"`
+
+	// 解析后的 trusted_proxies
+	proxies []netip.Prefix
+}
+
+// defaultIngestConfig 默认的上报限制
+func defaultIngestConfig() IngestConfig {
+	return IngestConfig{
+		MaxBodyBytes:  256 << 10,
+		PerDevice:     ratelimit.Limit{PerMinute: 2, Burst: 5},
+		FlapWindow:    Duration(10 * time.Minute),
+		FlapThreshold: 5,
+	}
+}
+
+func (c *IngestConfig) validate() error {
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("ingest.max_body_bytes 不能为负数")
+	}
+	for name, l := range map[string]ratelimit.Limit{"per_ip": c.PerIP, "per_device": c.PerDevice} {
+		if l.PerMinute < 0 || l.Burst < 0 {
+			return fmt.Errorf("ingest.%s 不能为负数", name)
+		}
+	}
+	if c.FlapThreshold < 0 || c.FlapWindow < 0 {
+		return fmt.Errorf("ingest.flap_threshold 与 flap_window 不能为负数")
+	}
+	if c.FlapThreshold > 0 && c.FlapWindow == 0 {
+		return fmt.Errorf("启用抖动检测时必须配置 ingest.flap_window")
+	}
+	c.proxies = nil
+	for _, s := range c.TrustedProxies {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return fmt.Errorf("ingest.trusted_proxies 中的地址无效: %s", s)
+			}
+			p = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		c.proxies = append(c.proxies, p.Masked())
+	}
+	return nil
+}
+
+// trusted 地址是否为可信代理
+func (c *IngestConfig) trusted(s string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 上报客户端的 IP：直接连接的地址是可信代理时，从 X-Forwarded-For 的末尾向前
+// 跳过可信代理，取第一个不可信的地址；其余情况使用直接连接的地址，不信任客户端自行填写的请求头
+func (c *IngestConfig) clientIP(r *http.Request) string {
+	peer := remoteHost(r)
+	if !c.trusted(peer) {
+		return peer
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if c.trusted(hop) {
+			continue
+		}
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		return hop
+	}
+	return peer
+}
+
+// flapState 设备最近的数据变化
+type flapState struct {
+	// flap_window 之内的变化时间
+	changes []time.Time
+	// 开始抖动的时间，未抖动时为零值
+	since time.Time
+	// 抖动期间未写入变更记录的次数
+	suppressed int
+}
+
+// prune 丢弃 window 之前的变化
+func (s *flapState) prune(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(s.changes) && now.Sub(s.changes[i]) >= window {
+		i++
+	}
+	s.changes = s.changes[i:]
+}
+
+// FlappingClient 正在抖动的设备
+type FlappingClient struct {
+	ClientID int       `json:"client_id"`
+	Name     string    `json:"name,omitempty"`
+	Since    time.Time `json:"since"`
+	// flap_window 之内的变化次数
+	Changes int `json:"changes"`
+	// 未写入变更记录的变化次数
+	Suppressed int `json:"suppressed"`
+}
+
+// IngestGuard 上报接口的限流与抖动检测，状态只保存在内存中，重启后重新计算
+type IngestGuard struct {
+	store   *ConfigStore
+	metrics *Metrics
+	ips     *ratelimit.Limiter
+	devices *ratelimit.Limiter
+
+	mu      sync.Mutex
+	flaps   map[int]*flapState
+	sweptAt time.Time
+}
+
+// NewIngestGuard 创建上报限制
+func NewIngestGuard(store *ConfigStore, metrics *Metrics) *IngestGuard {
+	return &IngestGuard{
+		store:   store,
+		metrics: metrics,
+		ips:     ratelimit.New(),
+		devices: ratelimit.New(),
+		flaps:   make(map[int]*flapState),
+	}
+}
+
+// deviceKey 用于按设备限流的标识，与 CheckExistingRecord 一样以序列号或 MAC 识别设备
+func deviceKey(info *ClientInfo) string {
+	if info.SN != "" {
+		return "sn:" + info.SN
+	}
+	if info.MAC != "" {
+		return "mac:" + info.MAC
+	}
+	return ""
+}
+
+// allowIP 按来源 IP 限流，超出时返回 429
+func (g *IngestGuard) allowIP(w http.ResponseWriter, r *http.Request) bool {
+	cfg := g.store.Get().Ingest
+	ok, wait := g.ips.Allow(cfg.clientIP(r), cfg.PerIP, time.Now())
+	if !ok {
+		g.reject(w, r, "ip_rate", wait)
+	}
+	return ok
+}
+
+// allowDevice 按设备限流，超出时返回 429；无法识别设备的上报只受来源 IP 限制
+func (g *IngestGuard) allowDevice(w http.ResponseWriter, r *http.Request, info *ClientInfo) bool {
+	key := deviceKey(info)
+	if key == "" {
+		return true
+	}
+	ok, wait := g.devices.Allow(key, g.store.Get().Ingest.PerDevice, time.Now())
+	if !ok {
+		g.reject(w, r, "device_rate", wait, "sn", info.SN, "mac", info.MAC)
+	}
+	return ok
+}
+
+// reject 返回 429 与 Retry-After（秒，向上取整）
+func (g *IngestGuard) reject(w http.ResponseWriter, r *http.Request, reason string, wait time.Duration, attrs ...any) {
+	g.metrics.ObserveIngestRejected(reason)
+	slog.WarnContext(r.Context(), "上报过于频繁", append([]any{"reason", reason, "remote", g.store.Get().Ingest.clientIP(r), "retry_after", wait}, attrs...)...)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "请求过于频繁", http.StatusTooManyRequests)
+}
+
+// AllowChange 记录设备的一次数据变化，返回是否写入变更记录。guard 为 nil 或未启用抖动检测时总是写入
+func (g *IngestGuard) AllowChange(clientID int, now time.Time) bool {
+	if g == nil {
+		return true
+	}
+	cfg := g.store.Get().Ingest
+	if cfg.FlapThreshold <= 0 {
+		return true
+	}
+	window := time.Duration(cfg.FlapWindow)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.sweptAt) >= flapSweepInterval {
+		g.sweep(now, window)
+	}
+	s, ok := g.flaps[clientID]
+	if !ok {
+		s = &flapState{}
+		g.flaps[clientID] = s
+	}
+	s.prune(now, window)
+	if !s.since.IsZero() && len(s.changes) == 0 {
+		g.recovered(clientID, s)
+	}
+	s.changes = append(s.changes, now)
+	if s.since.IsZero() && len(s.changes) > cfg.FlapThreshold {
+		s.since = now
+		slog.Warn("设备数据频繁变化，暂停写入变更记录", "client_id", clientID, "changes", len(s.changes), "window", window)
+	}
+	if s.since.IsZero() {
+		return true
+	}
+	s.suppressed++
+	g.metrics.ObserveSuppressedChange()
+	return false
+}
+
+// recovered 一个完整的 flap_window 内没有变化，结束抖动状态
+func (g *IngestGuard) recovered(clientID int, s *flapState) {
+	slog.Info("设备数据恢复稳定，恢复写入变更记录", "client_id", clientID, "since", s.since, "suppressed", s.suppressed)
+	s.since = time.Time{}
+	s.suppressed = 0
+}
+
+// sweep 删除 window 内没有变化的设备，需持有 g.mu
+func (g *IngestGuard) sweep(now time.Time, window time.Duration) {
+	for id, s := range g.flaps {
+		s.prune(now, window)
+		if len(s.changes) == 0 {
+			if !s.since.IsZero() {
+				g.recovered(id, s)
+			}
+			delete(g.flaps, id)
+		}
+	}
+	g.sweptAt = now
+}
+
+// Flapping 当前正在抖动的设备，按开始时间排列
+func (g *IngestGuard) Flapping(now time.Time) []FlappingClient {
+	window := time.Duration(g.store.Get().Ingest.FlapWindow)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := []FlappingClient{}
+	for id, s := range g.flaps {
+		if s.since.IsZero() {
+			continue
+		}
+		s.prune(now, window)
+		if len(s.changes) == 0 {
+			continue
+		}
+		out = append(out, FlappingClient{ClientID: id, Since: s.since, Changes: len(s.changes), Suppressed: s.suppressed})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Since.Equal(out[j].Since) {
+			return out[i].Since.Before(out[j].Since)
+		}
+		return out[i].ClientID < out[j].ClientID
+	})
+	return out
+}
+
+// handleFlappingClients 列出正在抖动的设备
+func handleFlappingClients(db *Database, store *ConfigStore, guard *IngestGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleAdmin)
+		if !ok || !requireUnscoped(w, p) {
+			return
+		}
+		cfg := store.Get()
+		list := guard.Flapping(time.Now())
+		for i := range list {
+			// 客户端可能已被删除，此时只返回 ID
+			if c, err := db.GetClient(list[i].ClientID, time.Duration(cfg.Inventory.OfflineAfter)); err == nil {
+				list[i].Name = c.Name
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"window":    cfg.Ingest.FlapWindow,
+			"threshold": cfg.Ingest.FlapThreshold,
+			"clients":   list,
+		})
+	}
+}
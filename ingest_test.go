@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goup-server/internal/ratelimit"
+)
+
+func newTestGuard(t *testing.T, ingest IngestConfig) *IngestGuard {
+	t.Helper()
+	if err := ingest.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultServerConfig()
+	cfg.Ingest = ingest
+	store, err := NewConfigStore(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewIngestGuard(store, NewMetrics())
+}
+
+func reportFrom(peer, forwardedFor string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, ingestPath, nil)
+	r.RemoteAddr = peer
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	return r
+}
+
+func TestAllowIPBehindTrustedProxy(t *testing.T) {
+	ingest := defaultIngestConfig()
+	ingest.PerIP = ratelimit.Limit{PerMinute: 1, Burst: 1}
+	ingest.TrustedProxies = []string{"10.0.0.0/24", "192.0.2.1"}
+	g := newTestGuard(t, ingest)
+
+	allow := func(r *http.Request) bool {
+		return g.allowIP(httptest.NewRecorder(), r)
+	}
+	// 同一代理后的两个客户端各有各的令牌桶
+	if !allow(reportFrom("10.0.0.5:4000", "203.0.113.1")) {
+		t.Fatal("first client behind proxy rejected")
+	}
+	if !allow(reportFrom("10.0.0.5:4001", "203.0.113.2")) {
+		t.Fatal("second client behind the same proxy throttled together with the first")
+	}
+	if allow(reportFrom("10.0.0.6:4002", "203.0.113.1")) {
+		t.Fatal("repeated report from the first client allowed")
+	}
+	// 多级可信代理：从末尾跳过可信地址，客户端伪造的最左侧地址不生效
+	if !allow(reportFrom("10.0.0.5:4003", "198.51.100.9, 203.0.113.3, 192.0.2.1")) {
+		t.Fatal("client behind two proxies rejected")
+	}
+	if allow(reportFrom("10.0.0.5:4004", "203.0.113.1, 203.0.113.3")) {
+		t.Fatal("spoofed leftmost address bypassed the limit")
+	}
+}
+
+func TestClientIPUntrustedPeer(t *testing.T) {
+	ingest := defaultIngestConfig()
+	ingest.TrustedProxies = []string{"10.0.0.1"}
+	if err := ingest.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		peer, forwarded, want string
+	}{
+		{"198.51.100.7:5000", "203.0.113.1", "198.51.100.7"},
+		{"10.0.0.1:5000", "203.0.113.1", "203.0.113.1"},
+		{"10.0.0.1:5000", "", "10.0.0.1"},
+		{"10.0.0.1:5000", "not-an-ip", "10.0.0.1"},
+		{"[::ffff:10.0.0.1]:5000", "2001:db8::1", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		if got := ingest.clientIP(reportFrom(tt.peer, tt.forwarded)); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.peer, tt.forwarded, got, tt.want)
+		}
+	}
+	bad := defaultIngestConfig()
+	bad.TrustedProxies = []string{"10.0.0.0/33"}
+	if err := bad.validate(); err == nil {
+		t.Fatal("invalid trusted proxy accepted")
+	}
+}
@@ -0,0 +1,240 @@
+// Package ldapauth 通过 LDAP/Active Directory 校验用户名与密码：先以服务账号查找用户条目，
+// 再以用户的 DN 与密码绑定，成功后返回用户所属的组。服务账号的连接放在连接池中复用。
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials 用户不存在或密码错误
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// Config 目录服务配置
+type Config struct {
+	// ldap://host:389 或 ldaps://host:636
+	URL string
+	// 以 ldap:// 连接后通过 StartTLS 加密
+	StartTLS bool
+	// TLS 配置，为空时使用系统根证书；未设置 ServerName 时取 URL 中的主机名
+	TLSConfig *tls.Config
+	// 查找用户的服务账号，为空时匿名查找
+	BindDN       string
+	BindPassword string
+	// 在该 DN 下查找用户
+	BaseDN string
+	// 查找用户的过滤器，%s 替换为转义后的用户名，例如 (sAMAccountName=%s)
+	UserFilter string
+	// 用户条目中列出所属组 DN 的属性，通常为 memberOf
+	GroupAttribute string
+	// 连接池中保留的空闲连接数
+	PoolSize int
+	// 连接与单个请求的超时时间
+	Timeout time.Duration
+}
+
+// Entry 认证成功的用户
+type Entry struct {
+	DN     string
+	Groups []string
+}
+
+// Authenticator LDAP 认证器，可并发使用
+type Authenticator struct {
+	cfg    Config
+	pool   chan *ldap.Conn
+	closed atomic.Bool
+}
+
+// New 创建认证器，不会立即连接目录服务
+func New(cfg Config) (*Authenticator, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 LDAP 地址: %v", err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("LDAP 地址必须以 ldap:// 或 ldaps:// 开头: %s", cfg.URL)
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, fmt.Errorf("ldaps:// 已加密，不能同时启用 StartTLS")
+	}
+	if !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, fmt.Errorf("用户过滤器需包含 %%s: %s", cfg.UserFilter)
+	}
+	if cfg.TLSConfig == nil {
+		cfg.TLSConfig = &tls.Config{}
+	}
+	if cfg.TLSConfig.ServerName == "" {
+		cfg.TLSConfig = cfg.TLSConfig.Clone()
+		cfg.TLSConfig.ServerName = u.Hostname()
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 1
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return &Authenticator{cfg: cfg, pool: make(chan *ldap.Conn, cfg.PoolSize)}, nil
+}
+
+// dial 建立连接并以服务账号绑定
+func (a *Authenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.cfg.Timeout}),
+		ldap.DialWithTLSConfig(a.cfg.TLSConfig))
+	if err != nil {
+		return nil, fmt.Errorf("连接 LDAP 失败: %w", err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(a.cfg.TLSConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS 失败: %v", err)
+		}
+	}
+	if err := a.bindService(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// bindService 以服务账号绑定，未配置服务账号时不绑定（匿名）
+func (a *Authenticator) bindService(conn *ldap.Conn) error {
+	if a.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+		return fmt.Errorf("LDAP 服务账号绑定失败: %w", err)
+	}
+	return nil
+}
+
+// get 从连接池取出连接，没有空闲连接时新建
+func (a *Authenticator) get() (*ldap.Conn, error) {
+	select {
+	case conn := <-a.pool:
+		if !conn.IsClosing() {
+			return conn, nil
+		}
+		conn.Close()
+	default:
+	}
+	return a.dial()
+}
+
+// put 将连接放回连接池，连接池已满或连接已关闭时关闭连接
+func (a *Authenticator) put(conn *ldap.Conn) {
+	if conn.IsClosing() || a.closed.Load() {
+		conn.Close()
+		return
+	}
+	select {
+	case a.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Close 关闭连接池中的空闲连接，正在使用的连接在用完后关闭。Close 之后仍可认证，但不再复用连接
+func (a *Authenticator) Close() {
+	a.closed.Store(true)
+	for {
+		select {
+		case conn := <-a.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Authenticate 校验用户名与密码。用户不存在、不唯一或密码错误时返回 ErrInvalidCredentials，
+// 目录服务不可用等其他错误原样返回
+func (a *Authenticator) Authenticate(username, password string) (*Entry, error) {
+	// 空密码的简单绑定在 LDAP 中是匿名绑定，总会成功
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	entry, err := a.authenticate(username, password)
+	if err != nil && isNetworkError(err) {
+		// 池中的连接可能已被服务端关闭，换新连接重试一次
+		entry, err = a.authenticate(username, password)
+	}
+	return entry, err
+}
+
+func (a *Authenticator) authenticate(username, password string) (*Entry, error) {
+	conn, err := a.get()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := a.lookup(conn, username)
+	if err != nil {
+		a.discardOnError(conn, err)
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			err = ErrInvalidCredentials
+		} else {
+			err = fmt.Errorf("LDAP 用户绑定失败: %w", err)
+		}
+		a.release(conn)
+		return nil, err
+	}
+	a.release(conn)
+	return entry, nil
+}
+
+// lookup 以服务账号查找用户条目
+func (a *Authenticator) lookup(conn *ldap.Conn, username string) (*Entry, error) {
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "%s", ldap.EscapeFilter(username))
+	req := ldap.NewSearchRequest(a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(a.cfg.Timeout/time.Second), false, filter, []string{a.cfg.GroupAttribute}, nil)
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("查找 LDAP 用户失败: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	e := res.Entries[0]
+	return &Entry{DN: e.DN, Groups: e.GetEqualFoldAttributeValues(a.cfg.GroupAttribute)}, nil
+}
+
+// release 用户绑定后恢复为服务账号再放回连接池；未配置服务账号（无法恢复为匿名）或恢复失败时关闭连接
+func (a *Authenticator) release(conn *ldap.Conn) {
+	if a.cfg.BindDN == "" {
+		conn.Close()
+		return
+	}
+	if err := a.bindService(conn); err != nil {
+		conn.Close()
+		return
+	}
+	a.put(conn)
+}
+
+// discardOnError 出错后仍可复用的连接放回连接池
+func (a *Authenticator) discardOnError(conn *ldap.Conn, err error) {
+	if errors.Is(err, ErrInvalidCredentials) {
+		a.put(conn)
+		return
+	}
+	conn.Close()
+}
+
+// isNetworkError 是否为连接层面的错误
+func isNetworkError(err error) bool {
+	var lerr *ldap.Error
+	return errors.As(err, &lerr) && lerr.ResultCode == ldap.ErrorNetwork
+}
@@ -0,0 +1,257 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"goup-server/internal/ldapauth/ldaptest"
+)
+
+var directory = []ldaptest.Entry{
+	{DN: "cn=svc,ou=system,dc=example,dc=com", Password: "svc-secret"},
+	{DN: "cn=alice,ou=people,dc=example,dc=com", Password: "alice-pw", Attributes: map[string][]string{
+		"objectClass":    {"user"},
+		"sAMAccountName": {"alice"},
+		"memberOf":       {"cn=IT,ou=groups,dc=example,dc=com", "cn=Staff,ou=groups,dc=example,dc=com"},
+	}},
+	{DN: "cn=bob,ou=people,dc=example,dc=com", Password: "bob-pw", Attributes: map[string][]string{
+		"objectClass":    {"user"},
+		"sAMAccountName": {"bob"},
+	}},
+	// 两个条目同名，查找结果不唯一时拒绝登录
+	{DN: "cn=dup1,ou=people,dc=example,dc=com", Password: "dup-pw", Attributes: map[string][]string{
+		"objectClass": {"user"}, "sAMAccountName": {"dup"},
+	}},
+	{DN: "cn=dup2,ou=people,dc=example,dc=com", Password: "dup-pw", Attributes: map[string][]string{
+		"objectClass": {"user"}, "sAMAccountName": {"dup"},
+	}},
+}
+
+func testConfig(url string) Config {
+	return Config{
+		URL:            url,
+		BindDN:         "cn=svc,ou=system,dc=example,dc=com",
+		BindPassword:   "svc-secret",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(&(objectClass=user)(sAMAccountName=%s))",
+		GroupAttribute: "memberOf",
+		PoolSize:       2,
+		Timeout:        2 * time.Second,
+	}
+}
+
+func newAuthenticator(t *testing.T, cfg Config) *Authenticator {
+	t.Helper()
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(a.Close)
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv, err := ldaptest.NewServer(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	a := newAuthenticator(t, testConfig(srv.URL()))
+
+	entry, err := a.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatalf("Authenticate(alice): %v", err)
+	}
+	if entry.DN != "cn=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("DN = %q", entry.DN)
+	}
+	wantGroups := []string{"cn=IT,ou=groups,dc=example,dc=com", "cn=Staff,ou=groups,dc=example,dc=com"}
+	if !reflect.DeepEqual(entry.Groups, wantGroups) {
+		t.Errorf("Groups = %v, want %v", entry.Groups, wantGroups)
+	}
+
+	entry, err = a.Authenticate("bob", "bob-pw")
+	if err != nil || len(entry.Groups) != 0 {
+		t.Errorf("Authenticate(bob) = %v, %v", entry, err)
+	}
+
+	for _, tc := range []struct{ user, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"", "alice-pw"},
+		{"nobody", "x"},
+		{"dup", "dup-pw"},
+		{"*", "alice-pw"},
+		{"alice)(objectClass=*", "alice-pw"},
+	} {
+		if _, err := a.Authenticate(tc.user, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) err = %v, want ErrInvalidCredentials", tc.user, tc.password, err)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	srv, err := ldaptest.NewServer(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	a := newAuthenticator(t, testConfig(srv.URL()))
+
+	for i := 0; i < 5; i++ {
+		if _, err := a.Authenticate("alice", "alice-pw"); err != nil {
+			t.Fatalf("Authenticate #%d: %v", i, err)
+		}
+		if _, err := a.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate wrong #%d: %v", i, err)
+		}
+	}
+	if n := srv.Connections(); n != 1 {
+		t.Errorf("connections = %d, want 1 (pooled)", n)
+	}
+
+	// 服务端断开后重新连接
+	srv.DropConnections()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := a.Authenticate("alice", "alice-pw"); err != nil {
+		t.Fatalf("Authenticate after drop: %v", err)
+	}
+	if n := srv.Connections(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+
+	// 关闭后仍可认证，但不再复用连接
+	a.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := a.Authenticate("alice", "alice-pw"); err != nil {
+			t.Fatalf("Authenticate after Close: %v", err)
+		}
+	}
+	if n := srv.Connections(); n != 4 {
+		t.Errorf("connections = %d, want 4", n)
+	}
+}
+
+func TestAnonymousSearch(t *testing.T) {
+	srv, err := ldaptest.NewServer(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cfg := testConfig(srv.URL())
+	cfg.BindDN, cfg.BindPassword = "", ""
+	a := newAuthenticator(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.Authenticate("alice", "alice-pw"); err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+	}
+	// 用户绑定后的连接无法恢复为匿名，不能放回连接池
+	if n := srv.Connections(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
+
+func TestServiceBindFailure(t *testing.T) {
+	srv, err := ldaptest.NewServer(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cfg := testConfig(srv.URL())
+	cfg.BindPassword = "wrong"
+	a := newAuthenticator(t, cfg)
+
+	_, err = a.Authenticate("alice", "alice-pw")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want service bind error", err)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	srv, err := ldaptest.NewServer(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := srv.URL()
+	srv.Close()
+	a := newAuthenticator(t, testConfig(url))
+	if _, err := a.Authenticate("alice", "alice-pw"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want connection error", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	serverTLS, roots, err := ldaptest.SelfSignedTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ldaps", func(t *testing.T) {
+		srv, err := ldaptest.NewTLSServer(directory, serverTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		cfg := testConfig(srv.URL())
+		cfg.TLSConfig = &tls.Config{RootCAs: roots}
+		if _, err := newAuthenticator(t, cfg).Authenticate("alice", "alice-pw"); err != nil {
+			t.Errorf("Authenticate: %v", err)
+		}
+
+		// 不信任服务端证书时连接失败
+		cfg.TLSConfig = nil
+		if _, err := newAuthenticator(t, cfg).Authenticate("alice", "alice-pw"); err == nil {
+			t.Error("untrusted certificate accepted")
+		}
+	})
+
+	t.Run("starttls", func(t *testing.T) {
+		srv, err := ldaptest.NewServer(directory, serverTLS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		cfg := testConfig(srv.URL())
+		cfg.StartTLS = true
+		cfg.TLSConfig = &tls.Config{RootCAs: roots}
+		a := newAuthenticator(t, cfg)
+		for i := 0; i < 3; i++ {
+			if _, err := a.Authenticate("alice", "alice-pw"); err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+		}
+		if n := srv.Connections(); n != 1 {
+			t.Errorf("connections = %d, want 1", n)
+		}
+	})
+
+	t.Run("starttls unsupported", func(t *testing.T) {
+		srv, err := ldaptest.NewServer(directory, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		cfg := testConfig(srv.URL())
+		cfg.StartTLS = true
+		if _, err := newAuthenticator(t, cfg).Authenticate("alice", "alice-pw"); err == nil {
+			t.Error("StartTLS failure ignored")
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	for _, cfg := range []Config{
+		{URL: "http://dc:389", UserFilter: "(uid=%s)"},
+		{URL: "ldaps://dc:636", StartTLS: true, UserFilter: "(uid=%s)"},
+		{URL: "ldap://dc:389", UserFilter: "(uid=alice)"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
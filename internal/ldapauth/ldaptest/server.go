@@ -0,0 +1,374 @@
+// Package ldaptest 进程内的最小 LDAP 服务，用于测试 LDAP 认证而不依赖真实的目录服务。
+// 只实现简单绑定、查找（and/or/not、等值与存在性过滤器）与 StartTLS。
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP 协议中的操作与结果码
+const (
+	opBind           = 0
+	opBindResponse   = 1
+	opUnbind         = 2
+	opSearch         = 3
+	opSearchEntry    = 4
+	opSearchDone     = 5
+	opExtended       = 23
+	opExtendedResult = 24
+
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// Entry 目录中的条目，Password 为空的条目不能绑定
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server 进程内 LDAP 服务
+type Server struct {
+	ln       net.Listener
+	scheme   string
+	entries  []Entry
+	startTLS *tls.Config
+	conns    atomic.Int64
+	binds    atomic.Int64
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	open     map[net.Conn]bool
+}
+
+// NewServer 在 127.0.0.1 的随机端口启动 ldap:// 服务；startTLS 非空时支持 StartTLS
+func NewServer(entries []Entry, startTLS *tls.Config) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return start(ln, "ldap", entries, startTLS), nil
+}
+
+// NewTLSServer 在 127.0.0.1 的随机端口启动 ldaps:// 服务
+func NewTLSServer(entries []Entry, config *tls.Config) (*Server, error) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		return nil, err
+	}
+	return start(ln, "ldaps", entries, nil), nil
+}
+
+func start(ln net.Listener, scheme string, entries []Entry, startTLS *tls.Config) *Server {
+	s := &Server{ln: ln, scheme: scheme, entries: entries, startTLS: startTLS, open: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// URL 服务地址，如 ldap://127.0.0.1:38901
+func (s *Server) URL() string {
+	return s.scheme + "://" + s.ln.Addr().String()
+}
+
+// Connections 已接受的连接数
+func (s *Server) Connections() int {
+	return int(s.conns.Load())
+}
+
+// Binds 收到的绑定请求数
+func (s *Server) Binds() int {
+	return int(s.binds.Load())
+}
+
+// DropConnections 断开所有已建立的连接，模拟目录服务重启
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.open {
+		c.Close()
+	}
+}
+
+// Close 停止服务并断开所有连接
+func (s *Server) Close() {
+	s.ln.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.conns.Add(1)
+		s.mu.Lock()
+		s.open[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.open, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id, _ := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case opBind:
+			if len(op.Children) < 3 {
+				return
+			}
+			s.binds.Add(1)
+			code := int64(resultInvalidCredentials)
+			name, password := str(op.Children[1]), str(op.Children[2])
+			if name == "" && password == "" {
+				code = resultSuccess
+			} else if e := s.find(name); e != nil && e.Password != "" && e.Password == password {
+				code = resultSuccess
+			}
+			if !write(conn, id, result(opBindResponse, code)) {
+				return
+			}
+		case opUnbind:
+			return
+		case opSearch:
+			if len(op.Children) < 8 || !s.search(conn, id, op) {
+				return
+			}
+		case opExtended:
+			if len(op.Children) < 1 {
+				return
+			}
+			if str(op.Children[0]) != startTLSOID || s.startTLS == nil {
+				if !write(conn, id, result(opExtendedResult, resultProtocolError)) {
+					return
+				}
+				continue
+			}
+			if !write(conn, id, result(opExtendedResult, resultSuccess)) {
+				return
+			}
+			tlsConn := tls.Server(conn, s.startTLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			delete(s.open, conn)
+			s.open[tlsConn] = true
+			s.mu.Unlock()
+			conn = tlsConn
+		default:
+			return
+		}
+	}
+}
+
+// search 处理查找请求，只支持子树范围
+func (s *Server) search(conn net.Conn, id int64, op *ber.Packet) bool {
+	base := str(op.Children[0])
+	limit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attrs []string
+	for _, a := range op.Children[7].Children {
+		attrs = append(attrs, str(a))
+	}
+	sent := 0
+	for i := range s.entries {
+		e := &s.entries[i]
+		if !under(e.DN, base) || !match(filter, e) {
+			continue
+		}
+		if limit > 0 && int64(sent) >= limit {
+			return write(conn, id, result(opSearchDone, resultSizeLimitExceeded))
+		}
+		if !write(conn, id, entryPacket(e, attrs)) {
+			return false
+		}
+		sent++
+	}
+	return write(conn, id, result(opSearchDone, resultSuccess))
+}
+
+func (s *Server) find(dn string) *Entry {
+	for i := range s.entries {
+		if strings.EqualFold(s.entries[i].DN, dn) {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+// under 判断 dn 是否位于 base 之下（含 base 本身）
+func under(dn, base string) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+// match 计算过滤器：0 and、1 or、2 not、3 等值、7 存在
+func match(f *ber.Packet, e *Entry) bool {
+	switch f.Tag {
+	case 0:
+		for _, c := range f.Children {
+			if !match(c, e) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, c := range f.Children {
+			if match(c, e) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return len(f.Children) == 1 && !match(f.Children[0], e)
+	case 3:
+		if len(f.Children) != 2 {
+			return false
+		}
+		want := str(f.Children[1])
+		for _, v := range values(e, str(f.Children[0])) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case 7:
+		return len(values(e, f.Data.String())) > 0
+	}
+	return false
+}
+
+// values 不区分大小写地读取属性
+func values(e *Entry, name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// str 读取字符串值，非通用类的值只保存在 Data 中
+func str(p *ber.Packet) string {
+	if s, ok := p.Value.(string); ok {
+		return s
+	}
+	if p.Data != nil {
+		return p.Data.String()
+	}
+	return ""
+}
+
+func result(tag ber.Tag, code int64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return op
+}
+
+func entryPacket(e *Entry, attrs []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "objectName"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, vals := range e.Attributes {
+		if len(attrs) > 0 && !containsFold(attrs, name) {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range vals {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attr.AppendChild(set)
+		list.AppendChild(attr)
+	}
+	op.AppendChild(list)
+	return op
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func write(conn net.Conn, id int64, op *ber.Packet) bool {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	p.AppendChild(op)
+	_, err := conn.Write(p.Bytes())
+	return err == nil
+}
+
+// SelfSignedTLS 生成 127.0.0.1 与 localhost 的自签名证书，返回服务端 TLS 配置与信任该证书的根证书池
+func SelfSignedTLS() (*tls.Config, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("生成证书失败: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, pool, nil
+}
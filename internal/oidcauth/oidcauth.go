@@ -0,0 +1,452 @@
+// Package oidcauth 实现 OpenID Connect 授权码登录（PKCE）与 JWT 校验：通过发现文档取得端点，
+// 按 JWKS 校验签名并缓存公钥，遇到未知的 kid 时（密钥轮换）限频刷新。
+package oidcauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken 令牌无效：签名、签发者、受众、有效期或 nonce 校验失败
+var ErrInvalidToken = errors.New("无效的令牌")
+
+// ErrUnavailable 无法访问身份提供方（发现文档、JWKS 或令牌端点）
+var ErrUnavailable = errors.New("身份提供方不可用")
+
+// validMethods 接受的签名算法，不接受 none 与 HMAC
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Config 身份提供方配置
+type Config struct {
+	// 签发者地址，发现文档位于 <Issuer>/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// 授权完成后的回调地址，需在身份提供方登记
+	RedirectURL string
+	// 请求的 scope，总会包含 openid
+	Scopes []string
+	// 访问身份提供方使用的 HTTP 客户端，为空时使用 10 秒超时的默认客户端
+	HTTPClient *http.Client
+	// JWKS 缓存时间，默认 1 小时
+	JWKSCacheTTL time.Duration
+	// 遇到未知 kid 时刷新 JWKS 的最小间隔，默认 1 分钟
+	JWKSRefreshInterval time.Duration
+	// 允许的时钟偏差，默认 1 分钟
+	Leeway time.Duration
+}
+
+// metadata 发现文档中用到的字段
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider 身份提供方，可并发使用
+type Provider struct {
+	cfg Config
+
+	mu        sync.Mutex
+	meta      *metadata
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+	refreshAt time.Time
+}
+
+// New 创建身份提供方，首次使用时才读取发现文档
+func New(cfg Config) (*Provider, error) {
+	u, err := url.Parse(cfg.Issuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("无效的签发者地址: %s", cfg.Issuer)
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("client_id 不能为空")
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.JWKSCacheTTL <= 0 {
+		cfg.JWKSCacheTTL = time.Hour
+	}
+	if cfg.JWKSRefreshInterval <= 0 {
+		cfg.JWKSRefreshInterval = time.Minute
+	}
+	if cfg.Leeway <= 0 {
+		cfg.Leeway = time.Minute
+	}
+	return &Provider{cfg: cfg}, nil
+}
+
+// Issuer 签发者地址（不含末尾的 /）
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// discover 读取并缓存发现文档
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+	meta = &metadata{}
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: 发现文档中的签发者 %s 与配置不一致", ErrUnavailable, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: 发现文档缺少端点", ErrUnavailable)
+	}
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s 返回 %s", ErrUnavailable, u, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return fmt.Errorf("%w: 解析 %s 失败: %v", ErrUnavailable, u, err)
+	}
+	return nil
+}
+
+// AuthRequest 一次授权请求的随机参数，需保存到回调时使用
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewAuthRequest 生成 state、nonce 与 PKCE code_verifier
+func NewAuthRequest() (*AuthRequest, error) {
+	var req AuthRequest
+	for _, s := range []*string{&req.State, &req.Nonce, &req.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		*s = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &req, nil
+}
+
+// Challenge 按 S256 计算 PKCE code_challenge
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL 返回跳转到身份提供方的授权地址
+func (p *Provider) AuthCodeURL(ctx context.Context, req *AuthRequest) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := []string{"openid"}
+	for _, s := range p.cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {Challenge(req.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange 以授权码换取令牌，校验 ID 令牌（受众为 client_id，nonce 与授权请求一致）并返回其声明
+func (p *Provider) Exchange(ctx context.Context, code string, req *AuthRequest) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {req.Verifier},
+		"client_id":     {p.cfg.ClientID},
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	hreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hreq.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		hreq.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.cfg.HTTPClient.Do(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: 解析令牌响应失败（%s）: %v", ErrUnavailable, resp.Status, err)
+	}
+	if body.Error != "" {
+		// 授权码无效、过期或 code_verifier 不匹配
+		return nil, fmt.Errorf("%w: %s %s", ErrInvalidToken, body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("%w: 令牌端点返回 %s 且没有 id_token", ErrUnavailable, resp.Status)
+	}
+	claims, err := p.Verify(ctx, body.IDToken, p.cfg.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.String("nonce") != req.Nonce {
+		return nil, fmt.Errorf("%w: nonce 不匹配", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// Verify 校验 JWT 的签名、签发者、有效期与受众（aud 包含 audiences 之一）并返回声明
+func (p *Provider) Verify(ctx context.Context, raw string, audiences ...string) (Claims, error) {
+	if len(audiences) == 0 {
+		return nil, fmt.Errorf("%w: 未指定受众", ErrInvalidToken)
+	}
+	var fetchErr error
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		keys, err := p.verificationKeys(ctx, kid)
+		if err != nil {
+			fetchErr = err
+		}
+		return keys, err
+	}, jwt.WithValidMethods(validMethods), jwt.WithIssuer(p.cfg.Issuer), jwt.WithAudience(audiences...),
+		jwt.WithExpirationRequired(), jwt.WithLeeway(p.cfg.Leeway))
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if Claims(claims).String("sub") == "" {
+		return nil, fmt.Errorf("%w: 缺少 sub", ErrInvalidToken)
+	}
+	return Claims(claims), nil
+}
+
+// verificationKeys 返回用于校验的公钥：指定 kid 时返回对应的公钥，未指定时返回全部公钥。
+// 缓存过期或 kid 未知时刷新 JWKS，按 JWKSRefreshInterval 限频，避免伪造的 kid 导致频繁请求
+func (p *Provider) verificationKeys(ctx context.Context, kid string) (jwt.VerificationKeySet, error) {
+	p.mu.Lock()
+	keys, fresh := p.keys, time.Since(p.keysAt) < p.cfg.JWKSCacheTTL
+	_, known := keys[kid]
+	canRefresh := time.Since(p.refreshAt) >= p.cfg.JWKSRefreshInterval
+	p.mu.Unlock()
+
+	if keys == nil || (!fresh || kid != "" && !known) && canRefresh {
+		refreshed, err := p.refreshKeys(ctx)
+		switch {
+		case err == nil:
+			keys = refreshed
+		case keys == nil:
+			return jwt.VerificationKeySet{}, err
+		}
+		// 刷新失败时继续使用缓存的公钥，身份提供方短暂不可用不影响已签发的令牌
+	}
+	var set jwt.VerificationKeySet
+	if kid != "" {
+		if key, ok := keys[kid]; ok {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
+	}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
+
+// refreshKeys 重新读取 JWKS
+func (p *Provider) refreshKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.refreshAt = time.Now()
+	p.mu.Unlock()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// 忽略不支持的密钥类型，不影响其他密钥
+			continue
+		}
+		kid := k.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		keys[kid] = key
+	}
+	p.mu.Lock()
+	p.keys, p.keysAt = keys, time.Now()
+	p.mu.Unlock()
+	return keys, nil
+}
+
+// jsonWebKey JWKS 中的一个公钥（RFC 7517）
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("无效的 RSA 指数")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("公钥不在曲线上")
+		}
+		return key, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("不支持的 OKP 公钥")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// Claims JWT 声明
+type Claims map[string]interface{}
+
+// lookup 按以 . 分隔的路径读取声明，例如 realm_access.roles
+func (c Claims) lookup(path string) interface{} {
+	var v interface{} = map[string]interface{}(c)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+// String 读取字符串声明，不存在或不是字符串时返回空字符串
+func (c Claims) String(path string) string {
+	s, _ := c.lookup(path).(string)
+	return s
+}
+
+// Strings 读取字符串数组声明；单个字符串视为只有一个元素的数组
+func (c Claims) Strings(path string) []string {
+	switch v := c.lookup(path).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package oidcauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"goup-server/internal/oidcauth/oidctest"
+)
+
+const redirectURL = "https://goup.example.com/api/auth/oidc/callback"
+
+func newIssuer(t *testing.T) *oidctest.Issuer {
+	t.Helper()
+	iss, err := oidctest.NewIssuer("goup", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func newProvider(t *testing.T, cfg Config) *Provider {
+	t.Helper()
+	if cfg.ClientID == "" {
+		cfg.ClientID, cfg.ClientSecret = "goup", "s3cret"
+	}
+	cfg.RedirectURL = redirectURL
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// authorize 访问授权地址并返回回调中的授权码与 state
+func authorize(t *testing.T, p *Provider, req *AuthRequest) (code, state string) {
+	t.Helper()
+	u, err := p.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %s", resp.Status)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loc.Scheme + "://" + loc.Host + loc.Path; got != redirectURL {
+		t.Fatalf("redirected to %s", got)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	iss := newIssuer(t)
+	iss.SetUser(map[string]interface{}{
+		"sub":                "u-1001",
+		"preferred_username": "alice",
+		"groups":             []string{"goup-admins", "staff"},
+		"realm_access":       map[string]interface{}{"roles": []string{"viewer"}},
+	})
+	p := newProvider(t, Config{Issuer: iss.URL() + "/", Scopes: []string{"profile", "openid", "groups"}})
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := authorize(t, p, req)
+	if state != req.State {
+		t.Fatalf("state = %q, want %q", state, req.State)
+	}
+	claims, err := p.Exchange(context.Background(), code, req)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.String("sub") != "u-1001" || claims.String("preferred_username") != "alice" {
+		t.Errorf("claims = %v", claims)
+	}
+	if got := claims.Strings("groups"); !reflect.DeepEqual(got, []string{"goup-admins", "staff"}) {
+		t.Errorf("groups = %v", got)
+	}
+	if got := claims.Strings("realm_access.roles"); !reflect.DeepEqual(got, []string{"viewer"}) {
+		t.Errorf("realm_access.roles = %v", got)
+	}
+
+	// 授权码只能使用一次
+	if _, err := p.Exchange(context.Background(), code, req); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("reused code err = %v", err)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	iss := newIssuer(t)
+	iss.SetUser(map[string]interface{}{"sub": "u-1"})
+	p := newProvider(t, Config{Issuer: iss.URL()})
+	ctx := context.Background()
+
+	// code_verifier 不匹配
+	req, _ := NewAuthRequest()
+	code, _ := authorize(t, p, req)
+	other := *req
+	other.Verifier = "wrong-verifier"
+	if _, err := p.Exchange(ctx, code, &other); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("wrong verifier err = %v", err)
+	}
+
+	// nonce 不匹配
+	req, _ = NewAuthRequest()
+	code, _ = authorize(t, p, req)
+	other = *req
+	other.Nonce = "other-nonce"
+	if _, err := p.Exchange(ctx, code, &other); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("nonce mismatch err = %v", err)
+	}
+
+	// 客户端密钥错误
+	bad := newProvider(t, Config{Issuer: iss.URL(), ClientID: "goup", ClientSecret: "wrong"})
+	req, _ = NewAuthRequest()
+	code, _ = authorize(t, bad, req)
+	if _, err := bad.Exchange(ctx, code, req); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("bad client secret err = %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	iss := newIssuer(t)
+	p := newProvider(t, Config{Issuer: iss.URL()})
+	ctx := context.Background()
+	now := time.Now()
+
+	valid := iss.Token(map[string]interface{}{"sub": "svc-export", "aud": []string{"goup-api", "other"}})
+	claims, err := p.Verify(ctx, valid, "goup-api")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.String("sub") != "svc-export" {
+		t.Errorf("sub = %q", claims.String("sub"))
+	}
+
+	for name, token := range map[string]string{
+		"wrong audience": iss.Token(map[string]interface{}{"sub": "x", "aud": "someone-else"}),
+		"no audience":    iss.Token(map[string]interface{}{"sub": "x"}),
+		"expired":        iss.Token(map[string]interface{}{"sub": "x", "aud": "goup-api", "exp": now.Add(-time.Hour).Unix()}),
+		"not yet valid":  iss.Token(map[string]interface{}{"sub": "x", "aud": "goup-api", "nbf": now.Add(time.Hour).Unix()}),
+		"wrong issuer":   iss.Token(map[string]interface{}{"sub": "x", "aud": "goup-api", "iss": "https://evil.example.com"}),
+		"no sub":         iss.Token(map[string]interface{}{"aud": "goup-api"}),
+		"unsigned":       "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4IiwiYXVkIjoiZ291cC1hcGkifQ.",
+		"garbage":        "not-a-jwt",
+	} {
+		if _, err := p.Verify(ctx, token, "goup-api"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	// 其他身份提供方签发的令牌
+	other := newIssuer(t)
+	forged := other.Token(map[string]interface{}{"sub": "x", "aud": "goup-api", "iss": iss.URL()})
+	if _, err := p.Verify(ctx, forged, "goup-api"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("forged: err = %v", err)
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	iss := newIssuer(t)
+	p := newProvider(t, Config{Issuer: iss.URL(), JWKSRefreshInterval: 50 * time.Millisecond})
+	ctx := context.Background()
+	claims := map[string]interface{}{"sub": "svc", "aud": "goup-api"}
+
+	for i := 0; i < 5; i++ {
+		if _, err := p.Verify(ctx, iss.Token(claims), "goup-api"); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+	}
+	if n := iss.JWKSRequests(); n != 1 {
+		t.Errorf("JWKS requests = %d, want 1 (cached)", n)
+	}
+
+	// 密钥轮换后遇到未知 kid 时刷新
+	time.Sleep(60 * time.Millisecond)
+	if err := iss.RotateKey(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Verify(ctx, iss.Token(claims), "goup-api"); err != nil {
+		t.Fatalf("Verify after rotation: %v", err)
+	}
+	if n := iss.JWKSRequests(); n != 2 {
+		t.Errorf("JWKS requests = %d, want 2", n)
+	}
+
+	// 未知 kid 的刷新受限频
+	if err := iss.RotateKey(false); err != nil {
+		t.Fatal(err)
+	}
+	token := iss.Token(claims)
+	for i := 0; i < 3; i++ {
+		p.Verify(ctx, token, "goup-api")
+	}
+	if n := iss.JWKSRequests(); n != 3 {
+		t.Errorf("JWKS requests = %d, want 3 (rate limited)", n)
+	}
+}
+
+func TestJWKSStale(t *testing.T) {
+	iss := newIssuer(t)
+	p := newProvider(t, Config{Issuer: iss.URL(), JWKSCacheTTL: 10 * time.Millisecond, JWKSRefreshInterval: time.Millisecond})
+	ctx := context.Background()
+	token := iss.Token(map[string]interface{}{"sub": "svc", "aud": "goup-api"})
+	if _, err := p.Verify(ctx, token, "goup-api"); err != nil {
+		t.Fatal(err)
+	}
+	// 身份提供方不可用时继续使用过期的缓存
+	iss.Close()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := p.Verify(ctx, token, "goup-api"); err != nil {
+		t.Errorf("Verify with stale JWKS: %v", err)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	iss := newIssuer(t)
+	u := iss.URL()
+	token := iss.Token(map[string]interface{}{"sub": "svc", "aud": "goup-api"})
+	iss.Close()
+	p := newProvider(t, Config{Issuer: u})
+	if _, err := p.Verify(context.Background(), token, "goup-api"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v, want ErrUnavailable", err)
+	}
+	if _, err := p.AuthCodeURL(context.Background(), &AuthRequest{}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AuthCodeURL err = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestClaims(t *testing.T) {
+	c := Claims{"groups": "admins", "n": 3.0, "nested": map[string]interface{}{"list": []interface{}{"a", 1.0, "b"}}}
+	if got := c.Strings("groups"); !reflect.DeepEqual(got, []string{"admins"}) {
+		t.Errorf("groups = %v", got)
+	}
+	if got := c.Strings("nested.list"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("nested.list = %v", got)
+	}
+	if c.String("n") != "" || c.Strings("missing.path") != nil || c.String("groups.x") != "" {
+		t.Error("unexpected value for missing or non-string claims")
+	}
+}
@@ -0,0 +1,257 @@
+// Package oidctest 进程内的模拟 OpenID Connect 身份提供方，用于测试授权码登录与 JWT 校验。
+// 授权端点不显示登录页面，直接以 SetUser 设置的声明签发授权码。
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuer 模拟身份提供方
+type Issuer struct {
+	srv          *httptest.Server
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   string
+	old   []*signingKey
+	user  map[string]interface{}
+	codes map[string]*grant
+	seq   int
+
+	jwksRequests atomic.Int64
+}
+
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// grant 已签发的授权码
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      map[string]interface{}
+}
+
+// NewIssuer 启动模拟身份提供方，只接受指定的客户端
+func NewIssuer(clientID, clientSecret string) (*Issuer, error) {
+	i := &Issuer{clientID: clientID, clientSecret: clientSecret, codes: make(map[string]*grant)}
+	if err := i.RotateKey(false); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.handleDiscovery)
+	mux.HandleFunc("/jwks", i.handleJWKS)
+	mux.HandleFunc("/authorize", i.handleAuthorize)
+	mux.HandleFunc("/token", i.handleToken)
+	i.srv = httptest.NewServer(mux)
+	return i, nil
+}
+
+// URL 签发者地址
+func (i *Issuer) URL() string {
+	return i.srv.URL
+}
+
+// Close 停止服务
+func (i *Issuer) Close() {
+	i.srv.Close()
+}
+
+// JWKSRequests JWKS 端点被请求的次数
+func (i *Issuer) JWKSRequests() int {
+	return int(i.jwksRequests.Load())
+}
+
+// SetUser 设置之后授权的用户声明，至少应包含 sub
+func (i *Issuer) SetUser(claims map[string]interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.user = claims
+}
+
+// RotateKey 生成新的签名密钥；keepOld 为 true 时旧公钥仍保留在 JWKS 中
+func (i *Issuer) RotateKey(keepOld bool) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.key != nil && keepOld {
+		i.old = append(i.old, &signingKey{kid: i.kid, key: i.key})
+	} else {
+		i.old = nil
+	}
+	i.seq++
+	i.key, i.kid = key, fmt.Sprintf("key-%d", i.seq)
+	return nil
+}
+
+// Token 以当前密钥签发 JWT，未提供的 iss、iat、exp 使用默认值（exp 为 1 小时后）
+func (i *Issuer) Token(claims map[string]interface{}) string {
+	i.mu.Lock()
+	key, kid := i.key, i.kid
+	i.mu.Unlock()
+	return i.sign(key, kid, claims)
+}
+
+func (i *Issuer) sign(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	c := jwt.MapClaims{"iss": i.srv.URL, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range claims {
+		c[k] = v
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	t.Header["kid"] = kid
+	s, err := t.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (i *Issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.srv.URL,
+		"authorization_endpoint":                i.srv.URL + "/authorize",
+		"token_endpoint":                        i.srv.URL + "/token",
+		"jwks_uri":                              i.srv.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *Issuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	i.jwksRequests.Add(1)
+	i.mu.Lock()
+	keys := append([]*signingKey{{kid: i.kid, key: i.key}}, i.old...)
+	i.mu.Unlock()
+	var list []map[string]string
+	for _, k := range keys {
+		list = append(list, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": k.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": list})
+}
+
+// handleAuthorize 直接以当前用户签发授权码并跳转回客户端
+func (i *Issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != i.clientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE S256 required", http.StatusBadRequest)
+		return
+	}
+	i.mu.Lock()
+	user := i.user
+	i.mu.Unlock()
+	if user == nil {
+		http.Error(w, "access_denied", http.StatusForbidden)
+		return
+	}
+	code := randomString()
+	i.mu.Lock()
+	i.codes[code] = &grant{redirectURI: q.Get("redirect_uri"), challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: user}
+	i.mu.Unlock()
+
+	u, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	v := u.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	u.RawQuery = v.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// handleToken 校验客户端、授权码与 PKCE code_verifier 后签发 ID 令牌，授权码只能使用一次
+func (i *Issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id != i.clientID || secret != i.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	code := r.PostFormValue("code")
+	i.mu.Lock()
+	g := i.codes[code]
+	delete(i.codes, code)
+	key, kid := i.key, i.kid
+	i.mu.Unlock()
+	if g == nil || g.redirectURI != r.PostFormValue("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+	claims := map[string]interface{}{"aud": i.clientID}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     i.sign(key, kid, claims),
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,100 @@
+// Package ratelimit 按键（来源 IP、设备标识等）区分的令牌桶限流。
+//
+// 每个键一个令牌桶：桶容量为 Burst，按 PerMinute 的速率补充令牌，每个请求消耗一个令牌。
+// 速率与容量在每次调用时传入，配置热更新后立即生效；长时间未使用、已经补满的桶会被定期清理，
+// 因此键的数量只与最近活跃的来源有关。
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval 清理空闲令牌桶的间隔
+const sweepInterval = time.Minute
+
+// Limit 限流参数，PerMinute 或 Burst 不大于 0 时不限流
+type Limit struct {
+	// 每分钟补充的令牌数（平均每分钟允许的请求数）
+	PerMinute float64 `json:"per_minute"`
+	// 桶容量（允许的突发请求数）
+	Burst int `json:"burst"`
+}
+
+// Enabled 是否限流
+func (l Limit) Enabled() bool {
+	return l.PerMinute > 0 && l.Burst > 0
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Limiter 按键区分的令牌桶集合，可并发使用
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+// New 创建限流器
+func New() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow 为 key 消耗一个令牌。令牌不足时返回 false 以及需要等待的时间
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) (bool, time.Duration) {
+	if !limit.Enabled() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.sweptAt) >= sweepInterval {
+		l.sweep(limit, now)
+	}
+	rate := limit.PerMinute / float64(time.Minute)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), at: now}
+		l.buckets[key] = b
+	}
+	b.refill(limit, rate, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / rate))
+	return false, wait
+}
+
+// Len 当前保存的令牌桶数量
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// refill 按经过的时间补充令牌，不超过桶容量（容量调小后多余的令牌也会被截断）
+func (b *bucket) refill(limit Limit, rate float64, now time.Time) {
+	if elapsed := now.Sub(b.at); elapsed > 0 {
+		b.tokens += float64(elapsed) * rate
+		b.at = now
+	}
+	if max := float64(limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+}
+
+// sweep 删除已经补满的令牌桶，它们与新建的桶没有区别
+func (l *Limiter) sweep(limit Limit, now time.Time) {
+	rate := limit.PerMinute / float64(time.Minute)
+	for key, b := range l.buckets {
+		b.refill(limit, rate, now)
+		if b.tokens >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.sweptAt = now
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	l := New()
+	limit := Limit{PerMinute: 60, Burst: 3}
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a", limit, now); !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+	ok, wait := l.Allow("a", limit, now)
+	if ok {
+		t.Fatal("request beyond burst allowed")
+	}
+	if wait != time.Second {
+		t.Fatalf("wait = %s, want 1s", wait)
+	}
+	// 其他键不受影响
+	if ok, _ := l.Allow("b", limit, now); !ok {
+		t.Fatal("independent key rejected")
+	}
+	// 一秒后补充一个令牌
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a", limit, now); !ok {
+		t.Fatal("request rejected after refill")
+	}
+	if ok, _ := l.Allow("a", limit, now); ok {
+		t.Fatal("second request allowed after refilling one token")
+	}
+}
+
+func TestAllowDisabled(t *testing.T) {
+	l := New()
+	for _, limit := range []Limit{{}, {PerMinute: 10}, {Burst: 5}} {
+		for i := 0; i < 100; i++ {
+			if ok, _ := l.Allow("a", limit, time.Now()); !ok {
+				t.Fatalf("limit %+v rejected a request", limit)
+			}
+		}
+	}
+	if l.Len() != 0 {
+		t.Fatalf("disabled limit created %d buckets", l.Len())
+	}
+}
+
+func TestBurstShrink(t *testing.T) {
+	l := New()
+	now := time.Unix(1700000000, 0)
+	l.Allow("a", Limit{PerMinute: 60, Burst: 10}, now)
+	// 容量调小后剩余令牌被截断
+	small := Limit{PerMinute: 60, Burst: 1}
+	if ok, _ := l.Allow("a", small, now); !ok {
+		t.Fatal("first request after shrink rejected")
+	}
+	if ok, _ := l.Allow("a", small, now); ok {
+		t.Fatal("request beyond shrunk burst allowed")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New()
+	limit := Limit{PerMinute: 60, Burst: 2}
+	now := time.Unix(1700000000, 0)
+	l.Allow("idle", limit, now)
+	l.Allow("busy", limit, now)
+	now = now.Add(sweepInterval)
+	l.Allow("busy", limit, now)
+	l.Allow("busy", limit, now)
+	l.Allow("busy", limit, now.Add(sweepInterval-time.Second))
+	if l.Len() != 1 {
+		t.Fatalf("len = %d after sweep, want 1", l.Len())
+	}
+}
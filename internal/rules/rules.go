@@ -0,0 +1,363 @@
+// Package rules 动态分组使用的规则表达式：对客户端的库存字段做比较，用 and/or/not 与括号组合。
+//
+// 条件的格式为 "字段 运算符 值"，例如：
+//
+//	ip in 10.2.0.0/16
+//	network == WIFI and up_ver < 1.3
+//	cpu contains "i5" or (tag == 财务 and not location == 总部)
+//
+// 字段名不区分大小写；值包含空格或括号时用单引号或双引号括起来。
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"unicode"
+
+	"goup-server/internal/semver"
+)
+
+// MaxLength 规则表达式的最大长度
+const MaxLength = 4096
+
+// Fields 客户端的字段取值，键为小写字段名。可有多个取值的字段（如标签）只要有一个取值满足条件即匹配，
+// != 要求所有取值都不相等；字段不存在时只有 != 匹配
+type Fields map[string][]string
+
+// knownFields 可用于规则的字段，另外支持 fact.<名称>（自定义事实）与 attr.<名称>（资产属性）
+var knownFields = map[string]bool{
+	"name": true, "cpu": true, "ram": true, "disk": true, "sn": true, "mac": true, "ip": true,
+	"up_ver": true, "comment": true, "network": true,
+	"owner": true, "department": true, "location": true, "tag": true,
+}
+
+// operators 支持的运算符
+var operators = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "contains": true, "in": true,
+}
+
+// Rule 解析后的规则
+type Rule struct {
+	src  string
+	root node
+}
+
+type node interface {
+	match(f Fields) bool
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ inner node }
+
+// condNode 单个条件
+type condNode struct {
+	field  string
+	op     string
+	value  string
+	prefix netip.Prefix
+}
+
+func (n andNode) match(f Fields) bool { return n.left.match(f) && n.right.match(f) }
+func (n orNode) match(f Fields) bool  { return n.left.match(f) || n.right.match(f) }
+func (n notNode) match(f Fields) bool { return !n.inner.match(f) }
+
+func (n condNode) match(f Fields) bool {
+	values := f[n.field]
+	if n.op == "!=" {
+		for _, v := range values {
+			if strings.EqualFold(v, n.value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if n.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchValue 判断单个取值是否满足条件；空值不参与大小比较（例如尚未上报版本的占位记录）
+func (n condNode) matchValue(v string) bool {
+	switch n.op {
+	case "==":
+		return strings.EqualFold(v, n.value)
+	case "contains":
+		return strings.Contains(strings.ToLower(v), strings.ToLower(n.value))
+	case "in":
+		addr, err := netip.ParseAddr(strings.TrimSpace(v))
+		return err == nil && n.prefix.Contains(addr.Unmap())
+	}
+	if v == "" {
+		return false
+	}
+	c := semver.CompareStrings(v, n.value)
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// Parse 解析规则表达式
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("规则为空")
+	}
+	if len(s) > MaxLength {
+		return nil, fmt.Errorf("规则超过 %d 个字符", MaxLength)
+	}
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("规则第 %d 个字符附近有多余的内容: %s", p.tokens[p.pos].at+1, p.tokens[p.pos].text)
+	}
+	return &Rule{src: s, root: root}, nil
+}
+
+// Match 判断字段取值是否满足规则
+func (r *Rule) Match(f Fields) bool {
+	return r.root.match(f)
+}
+
+// String 返回规则原文
+func (r *Rule) String() string {
+	return r.src
+}
+
+// References 返回规则中用到的字段，用于按需读取自定义事实等数据
+func (r *Rule) References() []string {
+	seen := make(map[string]bool)
+	var out []string
+	var walk func(n node)
+	walk = func(n node) {
+		switch x := n.(type) {
+		case andNode:
+			walk(x.left)
+			walk(x.right)
+		case orNode:
+			walk(x.left)
+			walk(x.right)
+		case notNode:
+			walk(x.inner)
+		case condNode:
+			if !seen[x.field] {
+				seen[x.field] = true
+				out = append(out, x.field)
+			}
+		}
+	}
+	walk(r.root)
+	return out
+}
+
+// token 词法单元，quoted 表示带引号的值（不会被当作关键字）
+type token struct {
+	text   string
+	quoted bool
+	at     int
+}
+
+// tokenize 拆分为词法单元：括号、比较运算符、带引号的字符串与不含空白的单词
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, token{text: string(r), at: i})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("规则第 %d 个字符开始的引号没有闭合", i+1)
+			}
+			tokens = append(tokens, token{text: string(runes[i+1 : j]), quoted: true, at: i})
+			i = j + 1
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(runes) && runes[j] == '=' {
+				j++
+			}
+			op := string(runes[i:j])
+			if op == "=" {
+				op = "=="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("规则第 %d 个字符: 无效的运算符 !", i+1)
+			}
+			tokens = append(tokens, token{text: op, at: i})
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()\"'=!<>", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{text: string(runes[i:j]), at: i})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword 判断下一个单元是否为指定的关键字（不区分大小写，带引号的值不是关键字）
+func (p *parser) keyword(k string) bool {
+	t, ok := p.peek()
+	return ok && !t.quoted && strings.EqualFold(t.text, k)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.keyword("not") {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	if open, ok := p.peek(); ok && !open.quoted && open.text == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.quoted || t.text != ")" {
+			return nil, fmt.Errorf("规则第 %d 个字符开始的括号没有闭合", open.at+1)
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseCond()
+}
+
+func (p *parser) parseCond() (node, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("规则不完整，条件的格式为: 字段 运算符 值")
+	}
+	ft, ot, vt := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	field := strings.ToLower(ft.text)
+	if ft.quoted || !validField(field) {
+		return nil, fmt.Errorf("规则第 %d 个字符: 未知的字段 %s", ft.at+1, ft.text)
+	}
+	op := strings.ToLower(ot.text)
+	if ot.quoted || !operators[op] {
+		return nil, fmt.Errorf("规则第 %d 个字符: 未知的运算符 %s", ot.at+1, ot.text)
+	}
+	if !vt.quoted && (vt.text == "(" || vt.text == ")") {
+		return nil, fmt.Errorf("规则第 %d 个字符: 缺少比较的值", vt.at+1)
+	}
+	n := condNode{field: field, op: op, value: vt.text}
+	switch op {
+	case "in":
+		prefix, err := parsePrefix(vt.text)
+		if err != nil {
+			return nil, fmt.Errorf("规则第 %d 个字符: in 需要网段（如 10.2.0.0/16）: %v", vt.at+1, err)
+		}
+		n.prefix = prefix
+	case "<", "<=", ">", ">=":
+		if field == "up_ver" {
+			if _, err := semver.Parse(vt.text); err != nil {
+				return nil, fmt.Errorf("规则第 %d 个字符: %v", vt.at+1, err)
+			}
+		}
+	}
+	p.pos += 3
+	return n, nil
+}
+
+// validField 判断字段名是否可用
+func validField(field string) bool {
+	if knownFields[field] {
+		return true
+	}
+	for _, prefix := range []string{"fact.", "attr."} {
+		if strings.HasPrefix(field, prefix) && len(field) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix 解析网段，单个地址视为只包含该地址的网段
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
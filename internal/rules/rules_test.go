@@ -0,0 +1,115 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	client := Fields{
+		"name":     {"PC-Finance-01"},
+		"ip":       {"10.2.3.4"},
+		"network":  {"WIFI"},
+		"up_ver":   {"1.2.5"},
+		"cpu":      {"Intel(R) Core(TM) i5-8500 CPU @ 3.00GHz"},
+		"tag":      {"财务", "重要"},
+		"location": {"总部 3F"},
+		"fact.os":  {"windows"},
+	}
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{"ip in 10.2.0.0/16", true},
+		{"IP in 10.3.0.0/16", false},
+		{"ip in 10.2.3.4", true},
+		{"Network == WIFI", true},
+		{"network = wifi", true},
+		{"network != WIFI", false},
+		{"network == ETHERNET", false},
+		{"up_ver < 1.3", true},
+		{"up_ver < 1.2.5", false},
+		{"up_ver <= 1.2.5", true},
+		{"up_ver >= v1.2", true},
+		{"up_ver > 1.2.5-rc1", true},
+		{`CPU contains "i5"`, true},
+		{`cpu contains i7`, false},
+		{"tag == 重要", true},
+		{"tag != 重要", false},
+		{"tag != 研发", true},
+		{`location == "总部 3F"`, true},
+		{"fact.os == Windows", true},
+		{"fact.arch == amd64", false},
+		{"fact.arch != amd64", true},
+		{"attr.asset_no contains A", false},
+		{"network == WIFI and up_ver < 1.3", true},
+		{"network == ETHERNET or up_ver < 1.3", true},
+		{"network == ETHERNET and up_ver < 1.3 or tag == 财务", true},
+		{"network == ETHERNET and (up_ver < 1.3 or tag == 财务)", false},
+		{"not network == ETHERNET", true},
+		{"NOT (ip in 10.2.0.0/16 AND cpu contains i5)", false},
+		{`name contains "and"`, false},
+		{`name == 'PC-Finance-01'`, true},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.rule, err)
+			continue
+		}
+		if got := r.Match(client); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestMatchEmptyVersion(t *testing.T) {
+	r, err := Parse("up_ver < 1.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []Fields{{}, {"up_ver": {""}}} {
+		if r.Match(f) {
+			t.Errorf("empty up_ver %v should not match %q", f, r)
+		}
+	}
+	if !r.Match(Fields{"up_ver": {"dev-build"}}) {
+		t.Errorf("invalid up_ver should sort below 1.3")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"ip",
+		"ip in",
+		"ip in 10.2.0.0/33",
+		"ip in banana",
+		"foo == bar",
+		"fact. == x",
+		"up_ver < latest",
+		"network ~= WIFI",
+		"network ! WIFI",
+		`name == "unterminated`,
+		"(network == WIFI",
+		"network == WIFI)",
+		"network == WIFI and",
+		"network == WIFI ip in 10.0.0.0/8",
+		"network == (",
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) should fail", s)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	r, err := Parse("fact.os == linux and (ip in 10.0.0.0/8 or not fact.os == windows) and attr.owner_id != 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fact.os", "ip", "attr.owner_id"}
+	if got := r.References(); !reflect.DeepEqual(got, want) {
+		t.Errorf("References() = %v, want %v", got, want)
+	}
+}
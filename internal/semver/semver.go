@@ -0,0 +1,214 @@
+// Package semver 语义化版本号的解析与比较，服务端与客户端共用。
+//
+// 在 SemVer 2.0 的基础上放宽了两点以兼容已有的客户端版本号：允许 "v"/"V" 前缀，
+// 主版本号之后的次版本号、修订号可以省略（视为 0），因此 "1.3"、"v1.3.0" 与 "1.3.0" 相同。
+// 先行版本（"-rc.1"）低于对应的正式版本，构建元数据（"+build.5"）不参与比较。
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxLength 版本号的最大长度
+const MaxLength = 64
+
+// Version 解析后的版本号
+type Version struct {
+	Major, Minor, Patch uint64
+	// 先行版本标识，按点分隔
+	Pre []string
+	// 构建元数据，不参与比较
+	Build string
+}
+
+// Parse 解析版本号，格式为 [v]主版本[.次版本[.修订号]][-先行版本][+构建元数据]
+func Parse(s string) (Version, error) {
+	var v Version
+	orig := s
+	if s == "" {
+		return v, fmt.Errorf("版本号为空")
+	}
+	if len(s) > MaxLength {
+		return v, fmt.Errorf("版本号 %q 超过 %d 个字符", orig, MaxLength)
+	}
+	if s[0] == 'v' || s[0] == 'V' {
+		s = s[1:]
+	}
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		v.Build = s[i+1:]
+		s = s[:i]
+		if err := checkIdentifiers(v.Build, false); err != nil {
+			return Version{}, fmt.Errorf("版本号 %q 的构建元数据无效: %v", orig, err)
+		}
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre := s[i+1:]
+		s = s[:i]
+		if err := checkIdentifiers(pre, true); err != nil {
+			return Version{}, fmt.Errorf("版本号 %q 的先行版本无效: %v", orig, err)
+		}
+		v.Pre = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("版本号 %q 最多包含主版本、次版本、修订号三段", orig)
+	}
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		if !isNumeric(p) {
+			return Version{}, fmt.Errorf("版本号 %q 的第 %d 段 %q 不是数字", orig, i+1, p)
+		}
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("版本号 %q 的第 %d 段超出范围", orig, i+1)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// MustParse 解析版本号，失败时 panic，用于常量
+func MustParse(s string) Version {
+	v, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Valid 判断版本号能否解析
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// checkIdentifiers 检查点分标识：不能为空，只能包含字母、数字与连字符；
+// 先行版本中的纯数字标识不能有前导零
+func checkIdentifiers(s string, pre bool) error {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return fmt.Errorf("包含空标识")
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return fmt.Errorf("标识 %q 包含非法字符", id)
+			}
+		}
+		if pre && len(id) > 1 && id[0] == '0' && isNumeric(id) {
+			return fmt.Errorf("数字标识 %q 不能以 0 开头", id)
+		}
+	}
+	return nil
+}
+
+// isNumeric 判断字符串是否为非空的纯数字
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String 返回规范形式（不带 "v" 前缀，三段数字）
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare 比较两个版本号，v 较新返回 1，较旧返回 -1，优先级相同返回 0
+func (v Version) Compare(o Version) int {
+	if c := cmpUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmpUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmpUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+// Less 判断 v 是否早于 o
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+// comparePre 按 SemVer 规则比较先行版本：没有先行版本的更高；数字标识按数值比较，
+// 其余按字典序比较，数字标识低于非数字标识；前缀相同时标识较多的更高
+func comparePre(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := a[i], b[i]
+		xn, yn := isNumeric(x), isNumeric(y)
+		var c int
+		switch {
+		case xn && yn:
+			// 数字标识无前导零，先比较长度即可按数值比较且不会溢出
+			if c = cmpInt(len(x), len(y)); c == 0 {
+				c = strings.Compare(x, y)
+			}
+		case xn:
+			c = -1
+		case yn:
+			c = 1
+		default:
+			c = strings.Compare(x, y)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmpInt(len(a), len(b))
+}
+
+// CompareStrings 比较两个版本号字符串。无法解析的版本号低于任何有效版本号，
+// 两者都无法解析时按字符串比较，保证排序结果稳定
+func CompareStrings(a, b string) int {
+	va, errA := Parse(a)
+	vb, errB := Parse(b)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpInt(a, b int) int {
+	return cmpUint(uint64(a), uint64(b))
+}
@@ -0,0 +1,119 @@
+package semver
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "1.2.3", want: "1.2.3"},
+		{in: "v1.2.3", want: "1.2.3"},
+		{in: "V1.4", want: "1.4.0"},
+		{in: "1.3", want: "1.3.0"},
+		{in: "2", want: "2.0.0"},
+		{in: "1.10.0-rc1", want: "1.10.0-rc1"},
+		{in: "1.3-beta", want: "1.3.0-beta"},
+		{in: "1.0.0-alpha.1+build.5", want: "1.0.0-alpha.1+build.5"},
+		{in: "1.0.0+20240101", want: "1.0.0+20240101"},
+		{in: "1.0.0-x-y-z.--", want: "1.0.0-x-y-z.--"},
+		{in: "", wantErr: true},
+		{in: "v", wantErr: true},
+		{in: "1.2.3.4", wantErr: true},
+		{in: "1..3", wantErr: true},
+		{in: "1.x", wantErr: true},
+		{in: "1.3beta", wantErr: true},
+		{in: "-1.0", wantErr: true},
+		{in: "1.0.0-", wantErr: true},
+		{in: "1.0.0-a..b", wantErr: true},
+		{in: "1.0.0-01", wantErr: true},
+		{in: "1.0.0+", wantErr: true},
+		{in: "1.0.0+a_b", wantErr: true},
+		{in: "1.0.0 ", wantErr: true},
+		{in: "99999999999999999999.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, got.String(), tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.3", "1.3.0", 0},
+		{"v1.4", "1.4", 0},
+		{"1.4", "1.3", 1},
+		{"1.10.0", "1.9.9", 1},
+		{"1.3-beta", "1.3", -1},
+		{"1.10.0-rc1", "1.10.0", -1},
+		{"1.10.0-rc1", "1.9.0", 1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-alpha+001", "1.0.0-alpha", 0},
+		// SemVer 2.0 规范中的先行版本排序示例
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"2.0.0", "10.0.0", -1},
+	}
+	for _, tt := range tests {
+		a, b := MustParse(tt.a), MustParse(tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Compare(a); got != -tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareStrings(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.4", "1.3", 1},
+		{"1.3", "unknown", 1},
+		{"", "0.0.1", -1},
+		{"abc", "abd", -1},
+		{"same", "same", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareStrings(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareStrings(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	in := []string{"1.10.0", "v1.2", "1.3-beta", "bogus", "1.3", "1.10.0-rc1", "1.9"}
+	want := []string{"bogus", "v1.2", "1.3-beta", "1.3", "1.9", "1.10.0-rc1", "1.10.0"}
+	sort.Slice(in, func(i, j int) bool { return CompareStrings(in[i], in[j]) < 0 })
+	for i := range want {
+		if in[i] != want[i] {
+			t.Fatalf("sorted = %v, want %v", in, want)
+		}
+	}
+}
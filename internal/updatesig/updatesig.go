@@ -0,0 +1,136 @@
+// Package updatesig 客户端更新清单的 Ed25519 签名与校验，服务端与客户端共用同一签名格式
+package updatesig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// payloadHeader 签名内容的格式标识，格式变化时递增
+const payloadHeader = "goup-update-manifest-v1"
+
+// Download 参与签名的单个平台下载信息
+type Download struct {
+	Platform string
+	URL      string
+	SHA256   string
+	Size     int64
+}
+
+// Manifest 参与签名的清单内容
+type Manifest struct {
+	Version     string
+	Description string
+	// 发布通道与适用的客户端版本范围，为空时不写入签名内容
+	Channel    string
+	MinVersion string
+	MaxVersion string
+	Downloads  []Download
+}
+
+// Payload 生成清单的规范化签名内容：版本、说明、通道与版本范围以及按平台排序的下载信息，
+// 字符串字段均以 Go 字面量形式转义，因此与 JSON 字段顺序和空白无关
+func Payload(m Manifest) []byte {
+	sorted := append([]Download(nil), m.Downloads...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Platform < sorted[j].Platform })
+
+	var b strings.Builder
+	b.WriteString(payloadHeader + "\n")
+	b.WriteString("version " + strconv.Quote(m.Version) + "\n")
+	b.WriteString("description " + strconv.Quote(m.Description) + "\n")
+	// 可选字段为空时省略，保证未使用这些字段的清单签名内容不变
+	for _, f := range []struct{ name, value string }{
+		{"channel", m.Channel}, {"min_version", m.MinVersion}, {"max_version", m.MaxVersion},
+	} {
+		if f.value != "" {
+			b.WriteString(f.name + " " + strconv.Quote(f.value) + "\n")
+		}
+	}
+	for _, d := range sorted {
+		fmt.Fprintf(&b, "download %s %s %s %d\n",
+			strconv.Quote(d.Platform), strconv.Quote(d.URL), strconv.Quote(strings.ToLower(d.SHA256)), d.Size)
+	}
+	return []byte(b.String())
+}
+
+// Sign 对清单签名，返回 base64 编码的签名
+func Sign(key ed25519.PrivateKey, m Manifest) string {
+	sig := ed25519.Sign(key, Payload(m))
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// Verify 校验清单签名
+func Verify(pub ed25519.PublicKey, m Manifest, signature string) error {
+	if signature == "" {
+		return errors.New("更新清单未签名")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("签名格式无效: %v", err)
+	}
+	if !ed25519.Verify(pub, Payload(m), sig) {
+		return errors.New("更新清单签名校验失败")
+	}
+	return nil
+}
+
+// GenerateKey 生成密钥对，返回 base64 编码的私钥种子与公钥
+func GenerateKey() (privateKey, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// ParsePublicKey 解析 base64 编码的 32 字节公钥
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("公钥格式无效: %v", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("公钥长度应为 %d 字节，实际为 %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// ParsePrivateKey 解析私钥：base64 编码的 32 字节种子或 64 字节私钥，
+// 也支持 openssl genpkey -algorithm ed25519 生成的 PKCS#8 PEM
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析 PEM 私钥失败: %v", err)
+		}
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("PEM 私钥不是 Ed25519 密钥")
+		}
+		return priv, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("私钥格式无效: %v", err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("私钥长度应为 %d 或 %d 字节，实际为 %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(b))
+}
+
+// PublicKeyString 返回私钥对应的 base64 编码公钥
+func PublicKeyString(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"goup-server/internal/ldapauth"
+)
+
+// LDAPConfig LDAP/Active Directory 登录配置，url 为空时不启用
+type LDAPConfig struct {
+	// ldap://dc.example.com:389 或 ldaps://dc.example.com:636
+	URL string `json:"url,omitempty"`
+	// 以 ldap:// 连接后通过 StartTLS 加密
+	StartTLS bool `json:"start_tls,omitempty"`
+	// 校验目录服务证书的 CA 证书（PEM），为空时使用系统根证书
+	CAFile string `json:"ca_file,omitempty"`
+	// 不校验目录服务证书，仅用于测试环境
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// 查找用户的服务账号，为空时匿名查找
+	BindDN       string `json:"bind_dn,omitempty"`
+	BindPassword string `json:"bind_password,omitempty"`
+	// 从文件读取服务账号密码
+	BindPasswordFile string `json:"bind_password_file,omitempty"`
+	// 在该 DN 下查找用户
+	UserBaseDN string `json:"user_base_dn,omitempty"`
+	// 查找用户的过滤器，%s 替换为登录用户名
+	UserFilter string `json:"user_filter"`
+	// 用户条目中列出所属组 DN 的属性
+	GroupAttribute string `json:"group_attribute"`
+	// 连接池大小
+	PoolSize int `json:"pool_size"`
+	// 连接与单个请求的超时时间
+	Timeout Duration `json:"timeout"`
+	// 组到角色的映射，不属于任何已映射组的目录账号不能登录
+	RoleMappings []RoleMapping `json:"role_mappings,omitempty"`
+
+	// 根据以上配置创建的认证器，未启用时为 nil
+	authenticator *ldapauth.Authenticator
+}
+
+// RoleMapping 外部组到角色的映射。group 为组名（LDAP 中为组的 DN，不区分大小写），* 匹配所有账号；
+// groups 限定可访问的分组，admin 不能限定分组
+type RoleMapping struct {
+	Group  string `json:"group"`
+	Role   string `json:"role"`
+	Groups []int  `json:"groups,omitempty"`
+}
+
+// defaultLDAPConfig 默认 LDAP 配置，过滤器与组属性适用于 Active Directory
+func defaultLDAPConfig() LDAPConfig {
+	return LDAPConfig{
+		UserFilter:     "(&(objectClass=user)(sAMAccountName=%s))",
+		GroupAttribute: "memberOf",
+		PoolSize:       4,
+		Timeout:        Duration(5 * time.Second),
+	}
+}
+
+// newAuthenticator 根据配置创建认证器
+func (c *LDAPConfig) newAuthenticator() (*ldapauth.Authenticator, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 LDAP CA 证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("LDAP CA 证书文件中没有有效的证书: %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return ldapauth.New(ldapauth.Config{
+		URL:            c.URL,
+		StartTLS:       c.StartTLS,
+		TLSConfig:      tlsConfig,
+		BindDN:         c.BindDN,
+		BindPassword:   c.BindPassword,
+		BaseDN:         c.UserBaseDN,
+		UserFilter:     c.UserFilter,
+		GroupAttribute: c.GroupAttribute,
+		PoolSize:       c.PoolSize,
+		Timeout:        time.Duration(c.Timeout),
+	})
+}
+
+// validate 检查 LDAP 配置，未启用时不检查
+func (c *LDAPConfig) validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if c.UserBaseDN == "" {
+		return fmt.Errorf("auth.ldap.user_base_dn 不能为空")
+	}
+	if c.GroupAttribute == "" {
+		return fmt.Errorf("auth.ldap.group_attribute 不能为空")
+	}
+	if c.PoolSize <= 0 {
+		return fmt.Errorf("auth.ldap.pool_size 必须大于 0")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("auth.ldap.timeout 必须大于 0")
+	}
+	if len(c.RoleMappings) == 0 {
+		return fmt.Errorf("auth.ldap.role_mappings 不能为空，否则目录账号都无法登录")
+	}
+	return validateRoleMappings("auth.ldap.role_mappings", c.RoleMappings)
+}
+
+// validateRoleMappings 检查组到角色的映射
+func validateRoleMappings(name string, mappings []RoleMapping) error {
+	for i, m := range mappings {
+		if strings.TrimSpace(m.Group) == "" {
+			return fmt.Errorf("%s[%d].group 不能为空", name, i)
+		}
+		if roleRanks[m.Role] == 0 {
+			return fmt.Errorf("%s[%d].role 无效: %s（可选 viewer、operator、admin）", name, i, m.Role)
+		}
+		if m.Role == roleAdmin && len(m.Groups) > 0 {
+			return fmt.Errorf("%s[%d]: admin 不能限定分组", name, i)
+		}
+		for _, g := range m.Groups {
+			if g <= 0 {
+				return fmt.Errorf("%s[%d].groups 包含无效的分组 ID: %d", name, i, g)
+			}
+		}
+	}
+	return nil
+}
+
+// mapRole 按账号所属的组计算角色：取匹配映射中最高的角色，同一角色的多条映射合并可访问的分组，
+// 其中任一条不限定分组时不限定。没有匹配的映射时 ok 为 false
+func mapRole(mappings []RoleMapping, memberOf []string) (role string, groups []int, ok bool) {
+	unscoped := false
+	seen := map[int]bool{}
+	for _, m := range mappings {
+		if !matchGroup(m.Group, memberOf) {
+			continue
+		}
+		if roleRanks[m.Role] > roleRanks[role] {
+			role, groups, unscoped = m.Role, nil, false
+			seen = map[int]bool{}
+		} else if m.Role != role {
+			continue
+		}
+		if len(m.Groups) == 0 {
+			unscoped = true
+		}
+		for _, g := range m.Groups {
+			if !seen[g] {
+				seen[g] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+	if role == "" {
+		return "", nil, false
+	}
+	if unscoped || role == roleAdmin {
+		groups = nil
+	}
+	return role, groups, true
+}
+
+// matchGroup 判断映射的组是否在账号所属的组中
+func matchGroup(group string, memberOf []string) bool {
+	if group == "*" {
+		return true
+	}
+	for _, g := range memberOf {
+		if strings.EqualFold(strings.TrimSpace(g), strings.TrimSpace(group)) {
+			return true
+		}
+	}
+	return false
+}
+
+// errNoRoleMapping 外部账号不属于任何已映射的组
+var errNoRoleMapping = errors.New("账号未分配角色")
+
+// ldapLogin 通过目录服务校验用户名与密码，按组映射角色并同步到本地用户。
+// 用户名或密码错误时返回 ldapauth.ErrInvalidCredentials
+func (db *Database) ldapLogin(cfg *LDAPConfig, username, password string) (*User, error) {
+	entry, err := cfg.authenticator.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	role, groups, ok := mapRole(cfg.RoleMappings, entry.Groups)
+	if !ok {
+		return nil, errNoRoleMapping
+	}
+	return db.SyncExternalUser("ldap", strings.ToLower(entry.DN), username, role, groups)
+}
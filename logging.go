@@ -0,0 +1,318 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogConfig 日志配置
+type LogConfig struct {
+	// 日志目录，为空时输出到 stderr
+	Dir string `json:"dir,omitempty"`
+	// 日志级别：debug/info/warn/error
+	Level string `json:"level"`
+	// 输出格式：json/text
+	Format string `json:"format"`
+	// 单个日志文件大小上限（MB），超过后轮转；0 表示不按大小轮转
+	MaxSizeMB int `json:"max_size_mb"`
+	// 按时间轮转的周期，0 表示不按时间轮转
+	RotateEvery Duration `json:"rotate_every"`
+	// 保留的历史文件数量，0 表示不限
+	MaxBackups int `json:"max_backups"`
+	// 历史文件保留时间，0 表示不限
+	MaxAge Duration `json:"max_age"`
+	// 是否以 gzip 压缩历史文件
+	Compress bool `json:"compress"`
+}
+
+// defaultLogConfig 默认日志配置
+func defaultLogConfig() LogConfig {
+	return LogConfig{
+		Level:       "info",
+		Format:      "json",
+		MaxSizeMB:   100,
+		RotateEvery: Duration(24 * time.Hour),
+		MaxBackups:  14,
+		MaxAge:      Duration(30 * 24 * time.Hour),
+		Compress:    true,
+	}
+}
+
+// logLevel 当前日志级别，SIGHUP 重新加载时可修改
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel 解析日志级别名称
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("无效的日志级别: %s", s)
+	}
+	return level, nil
+}
+
+// setupLogging 设置日志输出，标准库 log 的输出也会经由 slog 处理
+func setupLogging(cfg LogConfig) {
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	logLevel.Set(level)
+
+	var out io.Writer = os.Stderr
+	if cfg.Dir != "" {
+		// 确保日志目录存在
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "创建日志目录失败: %v\n", err)
+			os.Exit(1)
+		}
+		writer, err := newRotatingFile(filepath.Join(cfg.Dir, "goup-server.log"), cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "创建日志文件失败: %v\n", err)
+			os.Exit(1)
+		}
+		out = writer
+		fmt.Printf("日志将输出到: %s\n", writer.path)
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel, AddSource: cfg.Dir != ""}
+	var handler slog.Handler
+	if strings.EqualFold(cfg.Format, "text") {
+		handler = slog.NewTextHandler(out, opts)
+	} else {
+		handler = slog.NewJSONHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+}
+
+// requestIDKey 请求 ID 在 context 中的键
+type requestIDKey struct{}
+
+// RequestID 返回 context 中的请求 ID
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler 自动为日志附加 context 中的请求 ID
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// validRequestID 只接受长度适中、由可见 ASCII 字符组成的外部请求 ID
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID 生成随机请求 ID
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger 为每个请求分配请求 ID（沿用合法的 X-Request-ID），在响应头中回显，并记录访问日志
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "请求完成",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote", r.RemoteAddr)
+	})
+}
+
+// rotatingFile 按大小与时间轮转的日志文件，历史文件可压缩并按数量与时间清理
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	cfg      LogConfig
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// newRotatingFile 打开（或创建）日志文件
+func newRotatingFile(path string, cfg LogConfig) (*rotatingFile, error) {
+	w := &rotatingFile{path: path, cfg: cfg}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.prune()
+	return w, nil
+}
+
+func (w *rotatingFile) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = info.ModTime()
+	if w.size == 0 {
+		w.openedAt = time.Now()
+	}
+	return nil
+}
+
+// Write 写入日志，必要时先轮转
+func (w *rotatingFile) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	maxSize := int64(w.cfg.MaxSizeMB) << 20
+	sizeExceeded := maxSize > 0 && w.size+int64(len(p)) > maxSize && w.size > 0
+	expired := w.cfg.RotateEvery > 0 && time.Since(w.openedAt) >= time.Duration(w.cfg.RotateEvery)
+	if sizeExceeded || expired {
+		if err := w.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "日志轮转失败: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate 将当前文件重命名为带时间戳的历史文件并重新打开
+func (w *rotatingFile) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(w.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(w.path, ext), time.Now().Format("20060102-150405.000"), ext)
+	if err := os.Rename(w.path, backup); err != nil {
+		// 重命名失败时继续写原文件
+		w.open()
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	go func() {
+		if w.cfg.Compress {
+			if err := gzipFile(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "压缩日志失败: %v\n", err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+// backups 返回历史日志文件，按时间从新到旧排序
+func (w *rotatingFile) backups() []string {
+	ext := filepath.Ext(w.path)
+	pattern := strings.TrimSuffix(w.path, ext) + "-*" + ext + "*"
+	matches, _ := filepath.Glob(pattern)
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches
+}
+
+// prune 按数量与时间清理历史文件
+func (w *rotatingFile) prune() {
+	cutoff := time.Time{}
+	if w.cfg.MaxAge > 0 {
+		cutoff = time.Now().Add(-time.Duration(w.cfg.MaxAge))
+	}
+	for i, path := range w.backups() {
+		remove := w.cfg.MaxBackups > 0 && i >= w.cfg.MaxBackups
+		if !remove && !cutoff.IsZero() {
+			if info, err := os.Stat(path); err == nil && info.ModTime().Before(cutoff) {
+				remove = true
+			}
+		}
+		if remove {
+			os.Remove(path)
+		}
+	}
+}
+
+// gzipFile 压缩文件为 .gz 并删除原文件
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -3,16 +3,19 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	_ "github.com/go-sql-driver/mysql"
+
+	"goup-server/internal/updatesig"
 )
 
 // ClientInfo 客户端信息结构体
@@ -30,6 +33,19 @@ type ClientInfo struct {
 	Comment string `json:"comment"`
 	// 网络类型，判断其实不准
 	Network string `json:"Network"`
+	// 客户端各采集器执行状态（可选），不参与变更比较
+	Collectors map[string]CollectorStatus `json:"collectors,omitempty"`
+	// 采集器上报的非标准字段（可选），按采集器名称分段
+	Sections map[string]map[string]string `json:"sections,omitempty"`
+	// 自定义事实（可选），单独存储为可查询的键值属性；为 nil 时保留原有事实
+	Facts map[string]string `json:"facts,omitempty"`
+}
+
+// CollectorStatus 客户端单个采集器的执行结果
+type CollectorStatus struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
 }
 
 // Database 数据库连接结构体
@@ -112,7 +128,364 @@ func (db *Database) CreateTable() error {
         return fmt.Errorf("创建变更记录表失败: %v", err)
     }
 
-	return nil
+	// 创建采集器状态表，每个客户端每个采集器保留最近一次结果
+	collectors := `
+	CREATE TABLE IF NOT EXISTS client_collector_status (
+		client_id INT NOT NULL,
+		collector VARCHAR(64) NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		error TEXT,
+		duration_ms BIGINT NOT NULL DEFAULT 0,
+		section TEXT,
+		reported_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (client_id, collector),
+		INDEX idx_collector_status (status)
+	)`
+
+	if _, err := db.conn.Exec(collectors); err != nil {
+		return fmt.Errorf("创建采集器状态表失败: %v", err)
+	}
+
+	// 创建自定义事实表
+	facts := `
+	CREATE TABLE IF NOT EXISTS client_facts (
+		client_id INT NOT NULL,
+		fact_key VARCHAR(128) NOT NULL,
+		fact_value TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (client_id, fact_key),
+		INDEX idx_fact (fact_key, fact_value(191))
+	)`
+
+	if _, err := db.conn.Exec(facts); err != nil {
+		return fmt.Errorf("创建自定义事实表失败: %v", err)
+	}
+
+	// 创建客户端更新包表，同一版本与平台只保留一个文件
+	artifacts := `
+	CREATE TABLE IF NOT EXISTS update_artifacts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		version VARCHAR(64) NOT NULL,
+		os VARCHAR(32) NOT NULL,
+		arch VARCHAR(32) NOT NULL,
+		filename VARCHAR(255) NOT NULL,
+		size BIGINT NOT NULL,
+		sha256 CHAR(64) NOT NULL,
+		description TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_artifact (version, os, arch),
+		INDEX idx_artifact_sha256 (sha256)
+	)`
+
+	if _, err := db.conn.Exec(artifacts); err != nil {
+		return fmt.Errorf("创建更新包表失败: %v", err)
+	}
+
+	// 创建发布策略表与冻结/固定规则表
+	releases := `
+	CREATE TABLE IF NOT EXISTS update_releases (
+		version VARCHAR(64) PRIMARY KEY,
+		channel VARCHAR(16) NOT NULL DEFAULT 'stable',
+		rollout_percent INT NOT NULL DEFAULT 100,
+		min_version VARCHAR(64),
+		max_version VARCHAR(64),
+		description TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.conn.Exec(releases); err != nil {
+		return fmt.Errorf("创建发布策略表失败: %v", err)
+	}
+
+	holds := `
+	CREATE TABLE IF NOT EXISTS update_holds (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		scope VARCHAR(16) NOT NULL,
+		target VARCHAR(255) NOT NULL,
+		action VARCHAR(16) NOT NULL,
+		version VARCHAR(64),
+		note TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.conn.Exec(holds); err != nil {
+		return fmt.Errorf("创建冻结规则表失败: %v", err)
+	}
+
+	// 客户端上报的更新结果（安装、确认、失败、回滚）
+	events := `
+	CREATE TABLE IF NOT EXISTS update_events (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		client_id INT,
+		sn VARCHAR(255),
+		mac VARCHAR(64),
+		from_version VARCHAR(64),
+		to_version VARCHAR(64),
+		status VARCHAR(16) NOT NULL,
+		error TEXT,
+		event_time TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_client (client_id),
+		INDEX idx_status (status)
+	)`
+
+	if _, err := db.conn.Exec(events); err != nil {
+		return fmt.Errorf("创建更新事件表失败: %v", err)
+	}
+
+	// 资产属性：导入或管理员维护的信息（资产编号、采购日期等），与客户端上报的字段分开保存，上报不会覆盖
+	attributes := `
+	CREATE TABLE IF NOT EXISTS client_attributes (
+		client_id INT NOT NULL,
+		attr_key VARCHAR(64) NOT NULL,
+		attr_value TEXT,
+		source VARCHAR(16) NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		PRIMARY KEY (client_id, attr_key),
+		INDEX idx_attr_key (attr_key)
+	)`
+
+	if _, err := db.conn.Exec(attributes); err != nil {
+		return fmt.Errorf("创建资产属性表失败: %v", err)
+	}
+
+	// 管理员维护的元数据：客户端上报的 comment 会被 -c 参数覆盖，负责人、位置等信息单独保存
+	metadata := `
+	CREATE TABLE IF NOT EXISTS client_metadata (
+		client_id INT PRIMARY KEY,
+		owner VARCHAR(255),
+		department VARCHAR(255),
+		location VARCHAR(255),
+		tags TEXT,
+		notes TEXT,
+		updated_by VARCHAR(64),
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_owner (owner),
+		INDEX idx_department (department),
+		INDEX idx_location (location)
+	)`
+
+	if _, err := db.conn.Exec(metadata); err != nil {
+		return fmt.Errorf("创建元数据表失败: %v", err)
+	}
+
+	metadataChanges := `
+	CREATE TABLE IF NOT EXISTS client_metadata_changes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		client_id INT NOT NULL,
+		owner VARCHAR(255),
+		department VARCHAR(255),
+		location VARCHAR(255),
+		tags TEXT,
+		notes TEXT,
+		changed_by VARCHAR(64),
+		changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_client_id (client_id)
+	)`
+
+	if _, err := db.conn.Exec(metadataChanges); err != nil {
+		return fmt.Errorf("创建元数据变更表失败: %v", err)
+	}
+
+	// 客户端分组：static 由管理员指定成员，dynamic 按规则自动计算
+	groups := `
+	CREATE TABLE IF NOT EXISTS client_groups (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		type VARCHAR(16) NOT NULL,
+		rule TEXT,
+		description TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_name (name)
+	)`
+
+	if _, err := db.conn.Exec(groups); err != nil {
+		return fmt.Errorf("创建分组表失败: %v", err)
+	}
+
+	members := `
+	CREATE TABLE IF NOT EXISTS client_group_members (
+		group_id INT NOT NULL,
+		client_id INT NOT NULL,
+		source VARCHAR(16) NOT NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (group_id, client_id),
+		INDEX idx_client_id (client_id)
+	)`
+
+	if _, err := db.conn.Exec(members); err != nil {
+		return fmt.Errorf("创建分组成员表失败: %v", err)
+	}
+
+	// 分组成员变化事件，供告警与更新发布订阅
+	groupEvents := `
+	CREATE TABLE IF NOT EXISTS client_group_events (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		group_id INT NOT NULL,
+		client_id INT NOT NULL,
+		action VARCHAR(16) NOT NULL,
+		reason VARCHAR(16) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_group_id (group_id),
+		INDEX idx_client_id (client_id)
+	)`
+
+	if _, err := db.conn.Exec(groupEvents); err != nil {
+		return fmt.Errorf("创建分组事件表失败: %v", err)
+	}
+
+	// 客户端归档：reason 为 manual（管理员归档）或 inactive（长期未上报自动归档），归档的客户端默认不在列表中显示
+	archive := `
+	CREATE TABLE IF NOT EXISTS client_archive (
+		client_id INT PRIMARY KEY,
+		reason VARCHAR(16) NOT NULL,
+		note VARCHAR(255),
+		archived_by VARCHAR(64),
+		archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_archived_at (archived_at)
+	)`
+
+	if _, err := db.conn.Exec(archive); err != nil {
+		return fmt.Errorf("创建客户端归档表失败: %v", err)
+	}
+
+	// 上报心跳：每次上报（包括无变化的上报）一行。主键包含 reported_at 且没有其他唯一键，
+	// 可按 reported_at 做 RANGE 分区；同一客户端同一秒内的多次上报只记一次
+	heartbeats := `
+	CREATE TABLE IF NOT EXISTS client_heartbeats (
+		client_id INT NOT NULL,
+		reported_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		source_ip VARCHAR(45),
+		up_ver VARCHAR(64),
+		PRIMARY KEY (client_id, reported_at),
+		INDEX idx_reported_at (reported_at)
+	)`
+
+	if _, err := db.conn.Exec(heartbeats); err != nil {
+		return fmt.Errorf("创建上报心跳表失败: %v", err)
+	}
+
+	// 按小时汇总的上报次数，原始心跳清理后仍可用于统计在线率
+	heartbeatHourly := `
+	CREATE TABLE IF NOT EXISTS client_heartbeat_hourly (
+		client_id INT NOT NULL,
+		hour TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		reports INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (client_id, hour),
+		INDEX idx_hour (hour)
+	)`
+
+	if _, err := db.conn.Exec(heartbeatHourly); err != nil {
+		return fmt.Errorf("创建心跳汇总表失败: %v", err)
+	}
+
+	// 用户账号：role 为 viewer/operator/admin，scope_groups 为逗号分隔的分组 ID（为空表示不限），
+	// password_hash 为空的账号不能登录，只能使用 API 密钥
+	users := `
+	CREATE TABLE IF NOT EXISTS users (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(64) NOT NULL,
+		password_hash VARCHAR(255) NULL,
+		role VARCHAR(16) NOT NULL,
+		scope_groups VARCHAR(1024) NULL,
+		disabled TINYINT(1) NOT NULL DEFAULT 0,
+		last_login_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_username (username)
+	)`
+
+	if _, err := db.conn.Exec(users); err != nil {
+		return fmt.Errorf("创建用户表失败: %v", err)
+	}
+
+	// API 密钥只保存 SHA-256 摘要，prefix 用于查找
+	apiKeys := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		prefix CHAR(8) NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		role VARCHAR(16) NULL,
+		expires_at TIMESTAMP NULL,
+		last_used_at TIMESTAMP NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_prefix (prefix),
+		INDEX idx_user_id (user_id)
+	)`
+
+	if _, err := db.conn.Exec(apiKeys); err != nil {
+		return fmt.Errorf("创建API密钥表失败: %v", err)
+	}
+
+	// 网页登录会话，id 为会话令牌的 SHA-256 摘要
+	sessions := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id CHAR(64) PRIMARY KEY,
+		user_id INT NOT NULL,
+		remote_addr VARCHAR(64) NULL,
+		user_agent VARCHAR(255) NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		INDEX idx_user_id (user_id),
+		INDEX idx_expires_at (expires_at)
+	)`
+
+	if _, err := db.conn.Exec(sessions); err != nil {
+		return fmt.Errorf("创建会话表失败: %v", err)
+	}
+
+	// 审计日志：管理操作（修改类请求、导出与登录）的操作人、请求与结果
+	auditLog := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		actor VARCHAR(64) NOT NULL,
+		auth_method VARCHAR(16) NOT NULL,
+		role VARCHAR(16) NULL,
+		method VARCHAR(8) NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		status INT NOT NULL,
+		detail VARCHAR(255) NULL,
+		remote_addr VARCHAR(64) NULL,
+		request_id VARCHAR(64) NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_actor (actor),
+		INDEX idx_created_at (created_at)
+	)`
+
+	if _, err := db.conn.Exec(auditLog); err != nil {
+		return fmt.Errorf("创建审计日志表失败: %v", err)
+	}
+
+	// 外部身份：LDAP 等目录账号与本地用户的对应关系，subject 为目录中的唯一标识（如 DN）
+	userIdentities := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider VARCHAR(16) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INT NOT NULL,
+		synced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (provider, subject),
+		INDEX idx_user_id (user_id)
+	)`
+
+	if _, err := db.conn.Exec(userIdentities); err != nil {
+		return fmt.Errorf("创建外部身份表失败: %v", err)
+	}
+
+	// 已执行的数据库迁移，供启动时跳过已执行的迁移、就绪检查确认表结构已是最新
+	migrations := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.conn.Exec(migrations); err != nil {
+		return fmt.Errorf("创建结构版本表失败: %v", err)
+	}
+
+	return db.Migrate()
 }
 
 // CheckExistingRecord 检查是否存在相同的MAC或SN记录
@@ -122,8 +495,13 @@ func (db *Database) CheckExistingRecord(info *ClientInfo) (int, error) {
 	WHERE (mac = ? AND mac != '') OR (sn = ? AND sn != '')
 	LIMIT 1`
 	
+	// 按规范写法查找，兼容以其他写法传入的 MAC
+	mac := info.MAC
+	if canonical, err := normalizeMAC(mac); err == nil {
+		mac = canonical
+	}
 	var id int
-	err := db.conn.QueryRow(query, info.MAC, info.SN).Scan(&id)
+	err := db.conn.QueryRow(query, mac, info.SN).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, nil // 没有找到重复记录
@@ -134,22 +512,24 @@ func (db *Database) CheckExistingRecord(info *ClientInfo) (int, error) {
 	return id, nil
 }
 
-// InsertOrUpdateClientInfo 插入或更新客户端信息，返回结果类型：insert/update/nochange
-func (db *Database) InsertOrUpdateClientInfo(info *ClientInfo) (string, error) {
+// InsertOrUpdateClientInfo 插入或更新客户端信息，返回结果类型（insert/update/nochange）与客户端 ID；
+// guard 判定设备正在抖动时只更新数据、不写变更记录
+func (db *Database) InsertOrUpdateClientInfo(info *ClientInfo, guard *IngestGuard) (string, int, error) {
 	// 检查是否存在重复记录
 	existingId, err := db.CheckExistingRecord(info)
 	if err != nil {
-        return "", err
+        return "", 0, err
 	}
 	
     if existingId > 0 {
-        // 读取现有记录用于比较
+        // 读取现有记录用于比较（导入的占位记录等可能有空值的列按空字符串比较）
         var cur ClientInfo
-        sel := `SELECT name, cpu, ram, disk, sn, mac, ip, up_ver, comment, network FROM client_info WHERE id = ?`
+        sel := `SELECT IFNULL(name, ''), IFNULL(cpu, ''), IFNULL(ram, ''), IFNULL(disk, ''), IFNULL(sn, ''), IFNULL(mac, ''),
+            IFNULL(ip, ''), IFNULL(up_ver, ''), IFNULL(comment, ''), IFNULL(network, '') FROM client_info WHERE id = ?`
         if err := db.conn.QueryRow(sel, existingId).Scan(
             &cur.Name, &cur.CPU, &cur.RAM, &cur.Disk, &cur.SN, &cur.MAC, &cur.IP, &cur.UpVer, &cur.Comment, &cur.Network,
         ); err != nil {
-            return "", fmt.Errorf("读取现有数据失败: %v", err)
+            return "", 0, fmt.Errorf("读取现有数据失败: %v", err)
         }
 
         isSame := cur.Name == info.Name && cur.CPU == info.CPU && cur.RAM == info.RAM && cur.Disk == info.Disk &&
@@ -160,9 +540,12 @@ func (db *Database) InsertOrUpdateClientInfo(info *ClientInfo) (string, error) {
             // 无变化，仅更新 post_at
             onlyPostAt := `UPDATE client_info SET post_at = CURRENT_TIMESTAMP WHERE id = ?`
             if _, err := db.conn.Exec(onlyPostAt, existingId); err != nil {
-                return "", fmt.Errorf("更新post_at失败: %v", err)
+                return "", 0, fmt.Errorf("更新post_at失败: %v", err)
             }
-            return "nochange", nil
+            if err := db.saveReportDetails(existingId, info); err != nil {
+                return "", 0, err
+            }
+            return "nochange", existingId, nil
         }
 
         // 有变化：更新字段并刷新 post_at
@@ -175,13 +558,18 @@ func (db *Database) InsertOrUpdateClientInfo(info *ClientInfo) (string, error) {
         
         if _, err := db.conn.Exec(query, info.Name, info.CPU, info.RAM, info.Disk,
             info.SN, info.MAC, info.IP, info.UpVer, info.Comment, info.Network, existingId); err != nil {
-            return "", fmt.Errorf("更新数据失败: %v", err)
+            return "", 0, fmt.Errorf("更新数据失败: %v", err)
+        }
+        // 写入变更记录（设备抖动期间跳过）
+        if guard.AllowChange(existingId, time.Now()) {
+            if err := db.logChange(existingId, "update", info); err != nil {
+                return "", 0, err
+            }
         }
-        // 写入变更记录
-        if err := db.logChange(existingId, "update", info); err != nil {
-            return "", err
+        if err := db.saveReportDetails(existingId, info); err != nil {
+            return "", 0, err
         }
-        return "update", nil
+        return "update", existingId, nil
 	} else {
 		// 插入新记录
         query := `
@@ -192,17 +580,20 @@ func (db *Database) InsertOrUpdateClientInfo(info *ClientInfo) (string, error) {
             info.SN, info.MAC, info.IP, info.UpVer, info.Comment, info.Network)
 		
 		if err != nil {
-            return "", fmt.Errorf("插入数据失败: %v", err)
+            return "", 0, fmt.Errorf("插入数据失败: %v", err)
 		}
         // 获取新插入的ID并记录变更
         newId, _ := res.LastInsertId()
         if newId > 0 {
             if err := db.logChange(int(newId), "insert", info); err != nil {
-                return "", err
+                return "", 0, err
+            }
+            if err := db.saveReportDetails(int(newId), info); err != nil {
+                return "", 0, err
             }
         }
 		
-        return "insert", nil
+        return "insert", int(newId), nil
 	}
 }
 
@@ -220,13 +611,61 @@ func (db *Database) logChange(clientID int, changeType string, info *ClientInfo)
     return nil
 }
 
+// saveReportDetails 保存随上报附带的采集器状态与自定义事实，并重新判断客户端所属的动态分组；
+// 已归档的客户端重新上报后自动恢复
+func (db *Database) saveReportDetails(clientID int, info *ClientInfo) error {
+	if err := db.restoreOnReport(clientID); err != nil {
+		return err
+	}
+	if err := db.saveCollectorStatus(clientID, info); err != nil {
+		return err
+	}
+	if err := db.saveFacts(clientID, info.Facts); err != nil {
+		return err
+	}
+	return db.RefreshGroups(clientID)
+}
+
+// saveCollectorStatus 保存客户端上报的采集器状态，覆盖上一次结果
+func (db *Database) saveCollectorStatus(clientID int, info *ClientInfo) error {
+	if len(info.Collectors) == 0 {
+		return nil
+	}
+	query := `
+	INSERT INTO client_collector_status (client_id, collector, status, error, duration_ms, section, reported_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON DUPLICATE KEY UPDATE status = VALUES(status), error = VALUES(error),
+		duration_ms = VALUES(duration_ms), section = VALUES(section), reported_at = CURRENT_TIMESTAMP`
+	for name, st := range info.Collectors {
+		var section sql.NullString
+		if data, ok := info.Sections[name]; ok && len(data) > 0 {
+			b, err := json.Marshal(data)
+			if err != nil {
+				return fmt.Errorf("编码采集器分段失败: %v", err)
+			}
+			section = sql.NullString{String: string(b), Valid: true}
+		}
+		if _, err := db.conn.Exec(query, clientID, name, st.Status, st.Error, st.DurationMs, section); err != nil {
+			return fmt.Errorf("保存采集器状态失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// ApplyPoolConfig 应用连接池配置，可在运行时调用
+func (db *Database) ApplyPoolConfig(cfg DatabaseConfig) {
+	db.conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.conn.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+}
+
 // Close 关闭数据库连接
 func (db *Database) Close() error {
 	return db.conn.Close()
 }
 
 // handleClientData 处理客户端数据POST请求
-func handleClientData(db *Database) http.HandlerFunc {
+func handleClientData(db *Database, store *ConfigStore, metrics *Metrics, guard *IngestGuard) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 设置响应头
 		w.Header().Set("Content-Type", "application/json")
@@ -236,25 +675,83 @@ func handleClientData(db *Database) http.HandlerFunc {
 			http.Error(w, "只允许POST请求", http.StatusMethodNotAllowed)
 			return
 		}
+
+		// 校验上报令牌（未配置令牌时不校验）
+		if !store.CheckIngestToken(r.Header.Get("Authorization")) {
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			return
+		}
+
+		// 按来源 IP 限流
+		if !guard.allowIP(w, r) {
+			return
+		}
 		
 		// 解析JSON数据
 		var clientInfo ClientInfo
-		if err := json.NewDecoder(r.Body).Decode(&clientInfo); err != nil {
-			log.Printf("JSON解析失败: %v", err)
+		dec := json.NewDecoder(r.Body)
+		if store.Get().Ingest.RejectUnknownFields {
+			dec.DisallowUnknownFields()
+		}
+		if err := dec.Decode(&clientInfo); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				metrics.ObserveIngestRejected("body_too_large")
+				slog.WarnContext(r.Context(), "请求体过大", "limit", maxErr.Limit)
+				http.Error(w, "请求体过大", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+				metrics.ObserveIngestRejected("unknown_field")
+				slog.WarnContext(r.Context(), "上报包含未知字段", "field", field)
+				http.Error(w, "JSON数据包含未知字段: "+field, http.StatusBadRequest)
+				return
+			}
+			metrics.ObserveDecodeFailure()
+			slog.WarnContext(r.Context(), "JSON解析失败", "err", err)
 			http.Error(w, "JSON数据格式错误", http.StatusBadRequest)
 			return
 		}
-		
-		// 所有字段都是可选的，不需要验证
-		
+
+		// 规范化并校验字段，严格模式下拒绝无法规范化的上报
+		if errs := normalizeClientInfo(&clientInfo); len(errs) > 0 {
+			if store.Get().Ingest.Strict {
+				metrics.ObserveIngestRejected("invalid")
+				slog.WarnContext(r.Context(), "上报数据校验失败", "errors", errs)
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":  "error",
+					"message": "上报数据校验失败",
+					"errors":  errs,
+				})
+				return
+			}
+			slog.WarnContext(r.Context(), "上报数据未通过校验，已按宽松模式保存", "errors", errs)
+		}
+
+		// 按设备限流
+		if !guard.allowDevice(w, r, &clientInfo) {
+			return
+		}
+
         // 插入或更新数据库
-        result, err := db.InsertOrUpdateClientInfo(&clientInfo)
+        result, clientID, err := db.InsertOrUpdateClientInfo(&clientInfo, guard)
 		if err != nil {
-			log.Printf("数据库操作失败: %v", err)
+			metrics.ObserveUpsert("error")
+			slog.ErrorContext(r.Context(), "数据库操作失败", "err", err)
 			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
 			return
 		}
 		
+		metrics.ObserveUpsert(result)
+
+		// 记录每次上报的心跳，失败不影响上报结果
+		if store.Get().Heartbeat.Enabled && clientID > 0 {
+			if err := db.RecordHeartbeat(clientID, store.Get().Ingest.clientIP(r), clientInfo.UpVer); err != nil {
+				slog.ErrorContext(r.Context(), "记录上报心跳失败", "client_id", clientID, "err", err)
+			}
+		}
+
 		// 返回成功响应
 		var message string
         switch result {
@@ -276,17 +773,21 @@ func handleClientData(db *Database) http.HandlerFunc {
 		json.NewEncoder(w).Encode(response)
 		
 		// 记录操作类型
+		attrs := []any{"name", clientInfo.Name, "ip", clientInfo.IP, "mac", clientInfo.MAC, "sn", clientInfo.SN, "up_ver", clientInfo.UpVer}
         switch result {
         case "insert":
-            log.Printf("成功保存新客户端数据: %s (%s) - MAC: %s, SN: %s",
-                clientInfo.Name, clientInfo.IP, clientInfo.MAC, clientInfo.SN)
+            slog.InfoContext(r.Context(), "成功保存新客户端数据", attrs...)
         case "update":
-            log.Printf("成功更新客户端数据: %s (%s) - MAC: %s, SN: %s",
-                clientInfo.Name, clientInfo.IP, clientInfo.MAC, clientInfo.SN)
+            slog.InfoContext(r.Context(), "成功更新客户端数据", attrs...)
         case "nochange":
-            log.Printf("客户端数据无改变: %s (%s) - MAC: %s, SN: %s",
-                clientInfo.Name, clientInfo.IP, clientInfo.MAC, clientInfo.SN)
+            slog.DebugContext(r.Context(), "客户端数据无改变", attrs...)
         }
+		for name, st := range clientInfo.Collectors {
+			if st.Status != "ok" {
+				slog.WarnContext(r.Context(), "客户端采集器异常",
+					"name", clientInfo.Name, "ip", clientInfo.IP, "collector", name, "status", st.Status, "err", st.Error)
+			}
+		}
 	}
 }
 
@@ -300,89 +801,255 @@ func isDuplicateColumnError(err error) bool {
 }
 
 func main() {
+	// 子命令：export 直接从数据库导出客户端，import 导入资产表，user 管理用户账号
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExportCommand(os.Args[2:]))
+		case "import":
+			os.Exit(runImportCommand(os.Args[2:]))
+		case "user":
+			os.Exit(runUserCommand(os.Args[2:]))
+		}
+	}
+
 	// 定义命令行参数
 	var (
-		dsn     = flag.String("dsn", "", "数据库连接字符串 (必需)")
-		logDir  = flag.String("log-dir", "", "日志目录 (可选，不指定则不输出日志)")
-		port    = flag.String("port", "8080", "服务器端口")
+		configPath  = flag.String("config", "", "配置文件路径 (可选，也可通过 GOUP_CONFIG 指定)")
+		printConfig = flag.Bool("print-config", false, "输出生效配置后退出")
+		dsn         = flag.String("dsn", "", "数据库连接字符串 (必需，可来自配置文件或环境变量)")
+		logDir      = flag.String("log-dir", "", "日志目录 (可选，不指定则输出到 stderr)")
+		logLevel    = flag.String("log-level", "info", "日志级别: debug/info/warn/error")
+		logFormat   = flag.String("log-format", "json", "日志格式: json/text")
+		port        = flag.String("port", "8080", "服务器端口")
+		genKey      = flag.String("gen-update-key", "", "生成更新签名密钥，私钥写入指定文件，输出公钥后退出")
+		signFile    = flag.String("sign-manifest", "", "为独立托管的 update.json 签名后退出（需配合 -signing-key）")
+		signingKey  = flag.String("signing-key", os.Getenv("GOUP_UPDATE_SIGNING_KEY_FILE"), "签名私钥文件，用于 -sign-manifest")
 	)
 	flag.Parse()
-	
-	// 检查必需的DSN参数
-	if *dsn == "" {
-		fmt.Fprintf(os.Stderr, "错误: 必须指定数据库连接字符串\n")
+
+	// 更新签名工具，不需要数据库与配置文件
+	if *genKey != "" {
+		pub, err := generateUpdateKey(*genKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("私钥已写入 %s\n公钥: %s\n", *genKey, pub)
+		fmt.Printf("编译客户端时指定: -ldflags \"-X main.updatePublicKey=%s\"\n", pub)
+		return
+	}
+	if *signFile != "" {
+		if *signingKey == "" {
+			fmt.Fprintln(os.Stderr, "错误: -sign-manifest 需要通过 -signing-key 指定私钥文件")
+			os.Exit(1)
+		}
+		if err := signManifestFile(*signFile, *signingKey); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已签名 %s\n", *signFile)
+		return
+	}
+
+	// 只有显式指定的参数才覆盖配置文件与环境变量
+	var overrides ConfigOverrides
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dsn":
+			overrides.DSN = dsn
+		case "port":
+			overrides.Port = port
+		case "log-dir":
+			overrides.LogDir = logDir
+		case "log-level":
+			overrides.LogLevel = logLevel
+		case "log-format":
+			overrides.LogFormat = logFormat
+		}
+	})
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("GOUP_CONFIG")
+	}
+
+	cfg, err := LoadServerConfig(path, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
 		fmt.Fprintf(os.Stderr, "使用方法: %s -dsn \"user:password@tcp(host:port)/dbname\"\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "示例: %s -dsn \"root:password@tcp(localhost:3306)/goup\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "或: %s -config /etc/goup/server.json\n", os.Args[0])
+		os.Exit(1)
+	}
+	if *printConfig {
+		out, _ := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	store, err := NewConfigStore(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
 		os.Exit(1)
 	}
 	
 	// 设置日志
-	setupLogging(*logDir)
+	setupLogging(cfg.Log)
 	
 	// 连接数据库
-	db, err := NewDatabase(*dsn)
+	db, err := NewDatabase(cfg.Database.DSN)
 	if err != nil {
-		log.Fatalf("数据库连接失败: %v", err)
+		slog.Error("数据库连接失败", "err", err)
+		os.Exit(1)
 	}
 	defer db.Close()
+	db.ApplyPoolConfig(cfg.Database)
 	
 	// 创建数据表
 	if err := db.CreateTable(); err != nil {
-		log.Fatalf("创建数据表失败: %v", err)
+		slog.Error("创建数据表失败", "err", err)
+		db.Close()
+		os.Exit(1)
 	}
 	
-	log.Println("数据库连接成功，数据表已创建")
+	slog.Info("数据库连接成功，数据表已创建")
+
+	// SIGHUP 时重新加载可热更新的配置
+	watchReload(store, path, overrides, db)
 	
 	// 创建路由
 	router := mux.NewRouter()
+	metrics := NewMetrics()
+	guard := NewIngestGuard(store, metrics)
+	router.Use(metrics.Middleware)
+	// 识别请求身份（管理令牌、API 密钥、OIDC JWT、登录会话或匿名），并记录管理操作的审计日志
+	router.Use(authenticate(db, store))
+	router.Use(auditRequests(db))
 	
-	// 添加健康检查端点
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "ok",
-			"message": "服务运行正常",
-		})
-	}).Methods("GET")
+	// 添加健康检查端点：/healthz 存活检查（/health 为兼容保留），/readyz 就绪检查
+	lifecycle := &Lifecycle{}
+	workers := NewWorkers()
+	router.HandleFunc("/healthz", handleHealth(lifecycle)).Methods("GET")
+	router.HandleFunc("/health", handleHealth(lifecycle)).Methods("GET")
+	router.HandleFunc("/readyz", handleReady(NewReadiness(db, store, lifecycle, workers))).Methods("GET")
 	
 	// 添加客户端数据接收端点
-	router.HandleFunc("/api/client", handleClientData(db)).Methods("POST")
+	router.HandleFunc(ingestPath, handleClientData(db, store, metrics, guard)).Methods("POST")
+
+	// Prometheus 指标
+	router.HandleFunc("/metrics", metrics.Handler(db, store, guard)).Methods("GET")
+
+	// 登录、用户账号、API 密钥与审计日志
+	router.HandleFunc("/api/auth/login", handleLogin(db, store)).Methods("POST")
+	router.HandleFunc("/api/auth/logout", handleLogout(db)).Methods("POST")
+	router.HandleFunc("/api/auth/me", handleWhoAmI()).Methods("GET")
+	router.HandleFunc("/api/auth/password", handleChangePassword(db)).Methods("POST")
+	router.HandleFunc("/api/auth/providers", handleAuthProviders(store)).Methods("GET")
+	oidcPending := newOIDCLogins()
+	router.HandleFunc("/api/auth/oidc/login", handleOIDCLogin(store, oidcPending)).Methods("GET")
+	router.HandleFunc(oidcCallbackPath, handleOIDCCallback(db, store, oidcPending)).Methods("GET")
+	router.HandleFunc("/api/users", handleListUsers(db)).Methods("GET")
+	router.HandleFunc("/api/users", handleSaveUser(db, store)).Methods("POST")
+	router.HandleFunc("/api/users/{id:[0-9]+}", handleSaveUser(db, store)).Methods("PATCH")
+	router.HandleFunc("/api/users/{id:[0-9]+}", handleDeleteUser(db, store)).Methods("DELETE")
+	router.HandleFunc("/api/keys", handleListAPIKeys(db)).Methods("GET")
+	router.HandleFunc("/api/keys", handleCreateAPIKey(db)).Methods("POST")
+	router.HandleFunc("/api/keys/{id:[0-9]+}", handleDeleteAPIKey(db)).Methods("DELETE")
+	router.HandleFunc("/api/audit", handleListAudit(db)).Methods("GET")
+
+	// 客户端列表、详情与变更记录，以及内置的资产面板
+	router.HandleFunc("/api/clients", handleListClients(db, store)).Methods("GET")
+	router.HandleFunc("/api/clients/{id:[0-9]+}", handleGetClient(db, store)).Methods("GET")
+	router.HandleFunc("/api/clients/{id:[0-9]+}", handlePatchClient(db)).Methods("PATCH")
+	router.HandleFunc("/api/clients/{id:[0-9]+}/changes", handleClientChanges(db)).Methods("GET")
+	router.HandleFunc("/api/clients/{id:[0-9]+}/archive", handleArchiveClient(db)).Methods("POST")
+	router.HandleFunc("/api/clients/{id:[0-9]+}/restore", handleRestoreClient(db)).Methods("POST")
+
+	// 上报心跳与在线率
+	router.HandleFunc("/api/clients/{id:[0-9]+}/heartbeats", handleClientHeartbeats(db)).Methods("GET")
+	router.HandleFunc("/api/clients/{id:[0-9]+}/availability", handleClientAvailability(db, store)).Methods("GET")
+	router.HandleFunc("/api/availability", handleListAvailability(db, store)).Methods("GET")
+
+	// 上报限流与抖动检测：正在抖动（数据频繁变化）的设备
+	router.HandleFunc("/api/ingest/flapping", handleFlappingClients(db, store, guard)).Methods("GET")
+
+	// 数据保留：后台定期清理变更记录与过期心跳、归档长期未上报的客户端，并提供试运行与手动执行
+	retention := NewRetention(db, store, workers)
+	// 关闭时先等待清理任务退出，再由更早注册的 defer 关闭数据库
+	defer retention.Stop()
+	retention.Start()
+	router.HandleFunc("/api/retention", handleRetentionStatus(store, retention)).Methods("GET")
+	router.HandleFunc("/api/retention/preview", handleRetentionPreview(retention)).Methods("GET")
+	router.HandleFunc("/api/retention/run", handleRetentionRun(retention)).Methods("POST")
+
+	// 客户端分组
+	router.HandleFunc("/api/groups", handleListGroups(db)).Methods("GET")
+	router.HandleFunc("/api/groups", handleSaveGroup(db, store)).Methods("POST")
+	router.HandleFunc("/api/groups/events", handleListGroupEvents(db)).Methods("GET")
+	router.HandleFunc("/api/groups/{id:[0-9]+}", handleGetGroup(db)).Methods("GET")
+	router.HandleFunc("/api/groups/{id:[0-9]+}", handleSaveGroup(db, store)).Methods("PUT")
+	router.HandleFunc("/api/groups/{id:[0-9]+}", handleDeleteGroup(db, store)).Methods("DELETE")
+	router.HandleFunc("/api/groups/{id:[0-9]+}/clients", handleGroupClients(db, store)).Methods("GET")
+	router.HandleFunc("/api/groups/{id:[0-9]+}/members", handleGroupMembers(db)).Methods("POST", "DELETE")
+	router.PathPrefix(dashboardPath).Handler(handleDashboard()).Methods("GET", "HEAD")
+	router.Handle("/", http.RedirectHandler(dashboardPath, http.StatusFound)).Methods("GET")
+
+	router.HandleFunc("/api/export", handleExport(db, store)).Methods("GET")
+	router.HandleFunc(importPath, handleImport(db, store)).Methods("POST")
+
+	// 自定义事实查询
+	router.HandleFunc("/api/clients/{id:[0-9]+}/facts", handleClientFacts(db)).Methods("GET")
+	router.HandleFunc("/api/facts", handleFactSearch(db)).Methods("GET")
+
+	// 客户端更新包：管理接口上传/列出/删除更新包、维护发布策略与冻结规则，客户端获取更新清单与下载
+	if err := os.MkdirAll(cfg.Update.StorageDir, 0755); err != nil {
+		slog.Error("创建更新包目录失败", "dir", cfg.Update.StorageDir, "err", err)
+		retention.Stop()
+		db.Close()
+		os.Exit(1)
+	}
+	if cfg.Update.signer == nil {
+		slog.Warn("未配置更新签名私钥（update.signing_key_file），客户端将拒绝安装服务器分发的更新")
+	} else {
+		slog.Info("更新清单签名已启用", "public_key", updatesig.PublicKeyString(cfg.Update.signer))
+	}
+	router.HandleFunc(uploadPath, handleUploadArtifact(db, store)).Methods("POST")
+	router.HandleFunc(uploadPath, handleListArtifacts(db, store)).Methods("GET")
+	router.HandleFunc(uploadPath+"/{id:[0-9]+}", handleDeleteArtifact(db, store)).Methods("DELETE")
+	router.HandleFunc("/api/update/releases", handleListReleases(db, store)).Methods("GET")
+	router.HandleFunc("/api/update/releases/{version}", handlePutRelease(db, store)).Methods("PUT")
+	router.HandleFunc("/api/update/releases/{version}", handleDeleteRelease(db, store)).Methods("DELETE")
+	router.HandleFunc("/api/update/holds", handleListHolds(db, store)).Methods("GET")
+	router.HandleFunc("/api/update/holds", handleCreateHold(db, store)).Methods("POST")
+	router.HandleFunc("/api/update/holds/{id:[0-9]+}", handleDeleteHold(db, store)).Methods("DELETE")
+	router.HandleFunc("/api/update/events", handleReportUpdateEvents(db, store)).Methods("POST")
+	router.HandleFunc("/api/update/events", handleListUpdateEvents(db, store)).Methods("GET")
+	router.HandleFunc("/api/update/manifest", handleUpdateManifest(db, store)).Methods("GET")
+	router.HandleFunc("/api/update/download/{artifact}", handleDownloadArtifact(db, store)).Methods("GET", "HEAD")
 	
 	// 启动服务器
-	log.Printf("服务器启动在端口 %s", *port)
-	log.Printf("健康检查: http://localhost:%s/health", *port)
-	log.Printf("客户端数据接口: http://localhost:%s/api/client", *port)
-	
-	if err := http.ListenAndServe(":"+*port, router); err != nil {
-		log.Fatalf("服务器启动失败: %v", err)
+	scheme := "http"
+	if cfg.TLS.CertFile != "" {
+		scheme = "https"
 	}
-}
+	base := scheme + "://" + displayAddr(cfg.Listen)
+	slog.Info("服务器启动", "listen", cfg.Listen)
+	slog.Info("健康检查", "healthz", base+"/healthz", "readyz", base+"/readyz")
+	slog.Info("客户端数据接口", "url", base+"/api/client")
 
-// setupLogging 设置日志输出
-func setupLogging(logDir string) {
-	if logDir == "" {
-		// 不输出日志
-		log.SetOutput(os.Stderr) // 只输出到stderr，不输出到文件
-		return
-	}
-	
-	// 确保日志目录存在
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "创建日志目录失败: %v\n", err)
+	// 收到 SIGINT/SIGTERM 后等待处理中的请求完成，随后 defer 关闭数据库
+	server := newHTTPServer(cfg, store, requestLogger(router))
+	if err := serveUntilSignal(server, lifecycle, store); err != nil {
+		slog.Error("服务器启动失败", "err", err)
+		retention.Stop()
+		db.Close()
 		os.Exit(1)
 	}
-	
-	// 创建日志文件
-	logFile := filepath.Join(logDir, "goup-server.log")
-	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "创建日志文件失败: %v\n", err)
-		os.Exit(1)
+}
+
+// displayAddr 将 ":8080" 形式的监听地址转换为便于访问的地址
+func displayAddr(listen string) string {
+	if strings.HasPrefix(listen, ":") {
+		return "localhost" + listen
 	}
-	
-	// 设置日志输出到文件
-	log.SetOutput(file)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	
-	fmt.Printf("日志将输出到: %s\n", logFile)
+	return listen
 }
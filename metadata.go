@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 管理员元数据的长度限制
+const (
+	maxMetadataFieldLen = 255
+	maxMetadataNotesLen = 65535
+	maxTagLen           = 64
+	maxTags             = 32
+)
+
+// ClientMetadata 管理员维护的资产信息（负责人、部门、位置、标签、备注），与客户端上报的字段分开保存。
+// 客户端的 comment 来自 -c 参数，每次上报都会覆盖，管理员填写的信息应保存在这里
+type ClientMetadata struct {
+	Owner      string    `json:"owner"`
+	Department string    `json:"department"`
+	Location   string    `json:"location"`
+	Tags       []string  `json:"tags"`
+	Notes      string    `json:"notes"`
+	UpdatedBy  string    `json:"updated_by"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// MetadataPatch PATCH /api/clients/{id} 的请求体，省略的字段保持不变，空字符串或空数组表示清除
+type MetadataPatch struct {
+	Owner      *string   `json:"owner"`
+	Department *string   `json:"department"`
+	Location   *string   `json:"location"`
+	Tags       *[]string `json:"tags"`
+	Notes      *string   `json:"notes"`
+}
+
+// metadataFields 变更记录中的元数据字段，按显示顺序排列
+var metadataFields = []string{"owner", "department", "location", "tags", "notes"}
+
+// metadataSelect 读取元数据的列（client_metadata 别名为 m），与 metadataRow.dest 对应
+const metadataSelect = `IFNULL(m.owner, ''), IFNULL(m.department, ''), IFNULL(m.location, ''), IFNULL(m.tags, ''),
+	IFNULL(m.notes, ''), IFNULL(m.updated_by, ''), IFNULL(UNIX_TIMESTAMP(m.updated_at), 0)`
+
+// metadataRow 扫描 metadataSelect 的中间结果
+type metadataRow struct {
+	m       ClientMetadata
+	tags    string
+	updated int64
+}
+
+func (r *metadataRow) dest() []interface{} {
+	return []interface{}{&r.m.Owner, &r.m.Department, &r.m.Location, &r.tags, &r.m.Notes, &r.m.UpdatedBy, &r.updated}
+}
+
+func (r *metadataRow) value() ClientMetadata {
+	m := r.m
+	m.Tags = splitTags(r.tags)
+	if r.updated > 0 {
+		m.UpdatedAt = time.Unix(r.updated, 0)
+	}
+	return m
+}
+
+// splitTags 解析数据库中以逗号连接的标签
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// normalizeTags 去除空白与重复（不区分大小写）的标签并排序；标签以逗号连接保存并用 FIND_IN_SET 筛选，
+// 因此不能包含逗号
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	out := []string{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		if strings.Contains(t, ",") {
+			return nil, fmt.Errorf("标签不能包含逗号: %s", t)
+		}
+		if utf8.RuneCountInString(t) > maxTagLen {
+			return nil, fmt.Errorf("标签过长: %s", t)
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("标签数量超过 %d 个", maxTags)
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i]) < strings.ToLower(out[j]) })
+	return out, nil
+}
+
+// fields 返回用于比较与记录变更的字段值，标签以逗号连接
+func (m *ClientMetadata) fields() map[string]string {
+	return map[string]string{
+		"owner":      m.Owner,
+		"department": m.Department,
+		"location":   m.Location,
+		"tags":       strings.Join(m.Tags, ","),
+		"notes":      m.Notes,
+	}
+}
+
+// validate 校验并规范化修改内容：去除首尾空白、检查长度，标签去重排序
+func (p *MetadataPatch) validate() error {
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"owner", p.Owner},
+		{"department", p.Department},
+		{"location", p.Location},
+	} {
+		if f.value == nil {
+			continue
+		}
+		*f.value = strings.TrimSpace(*f.value)
+		if utf8.RuneCountInString(*f.value) > maxMetadataFieldLen {
+			return fmt.Errorf("%s 超过 %d 个字符", f.name, maxMetadataFieldLen)
+		}
+	}
+	if p.Notes != nil {
+		*p.Notes = strings.TrimSpace(*p.Notes)
+		if len(*p.Notes) > maxMetadataNotesLen {
+			return fmt.Errorf("notes 超过 %d 字节", maxMetadataNotesLen)
+		}
+	}
+	if p.Tags != nil {
+		tags, err := normalizeTags(*p.Tags)
+		if err != nil {
+			return err
+		}
+		*p.Tags = tags
+	}
+	return nil
+}
+
+// apply 将已校验的修改应用到元数据
+func (p *MetadataPatch) apply(m *ClientMetadata) {
+	if p.Owner != nil {
+		m.Owner = *p.Owner
+	}
+	if p.Department != nil {
+		m.Department = *p.Department
+	}
+	if p.Location != nil {
+		m.Location = *p.Location
+	}
+	if p.Tags != nil {
+		m.Tags = *p.Tags
+	}
+	if p.Notes != nil {
+		m.Notes = *p.Notes
+	}
+}
+
+// UpdateMetadata 修改客户端的管理员元数据（patch 需已通过 validate），有变化时记录变更与操作人。
+// 返回修改后的元数据以及是否发生变化，客户端不存在时返回 sql.ErrNoRows
+func (db *Database) UpdateMetadata(clientID int, patch *MetadataPatch, actor string) (ClientMetadata, bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return ClientMetadata{}, false, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow(`SELECT id FROM client_info WHERE id = ? FOR UPDATE`, clientID).Scan(&id); err != nil {
+		return ClientMetadata{}, false, err
+	}
+	var row metadataRow
+	err = tx.QueryRow(`SELECT `+metadataSelect+` FROM client_metadata m WHERE m.client_id = ? FOR UPDATE`, clientID).Scan(row.dest()...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ClientMetadata{}, false, fmt.Errorf("查询元数据失败: %v", err)
+	}
+	current := row.value()
+	before := current.fields()
+	patch.apply(&current)
+	after := current.fields()
+	changed := false
+	for _, name := range metadataFields {
+		if before[name] != after[name] {
+			changed = true
+		}
+	}
+	if !changed {
+		return current, false, nil
+	}
+
+	if _, err := tx.Exec(`INSERT INTO client_metadata (client_id, owner, department, location, tags, notes, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE owner = VALUES(owner), department = VALUES(department), location = VALUES(location),
+		tags = VALUES(tags), notes = VALUES(notes), updated_by = VALUES(updated_by), updated_at = CURRENT_TIMESTAMP`,
+		clientID, after["owner"], after["department"], after["location"], after["tags"], after["notes"], actor); err != nil {
+		return ClientMetadata{}, false, fmt.Errorf("保存元数据失败: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO client_metadata_changes (client_id, owner, department, location, tags, notes, changed_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		clientID, after["owner"], after["department"], after["location"], after["tags"], after["notes"], actor); err != nil {
+		return ClientMetadata{}, false, fmt.Errorf("记录元数据变更失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return ClientMetadata{}, false, fmt.Errorf("提交元数据失败: %v", err)
+	}
+	current.UpdatedBy = actor
+	current.UpdatedAt = time.Now().Truncate(time.Second)
+	return current, true, nil
+}
+
+// listMetadataChanges 返回元数据的变更记录（按时间先后），Changed 为相对上一次编辑变化的字段
+func (db *Database) listMetadataChanges(clientID int) ([]ClientChange, error) {
+	rows, err := db.conn.Query(`SELECT id, UNIX_TIMESTAMP(changed_at), IFNULL(changed_by, ''), IFNULL(owner, ''),
+		IFNULL(department, ''), IFNULL(location, ''), IFNULL(tags, ''), IFNULL(notes, '')
+		FROM client_metadata_changes WHERE client_id = ? ORDER BY id`, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("查询元数据变更失败: %v", err)
+	}
+	defer rows.Close()
+
+	changes := []ClientChange{}
+	var prev map[string]string
+	for rows.Next() {
+		c := ClientChange{Type: "metadata"}
+		var at int64
+		values := make([]string, len(metadataFields))
+		dest := []interface{}{&c.ID, &at, &c.ChangedBy}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("读取元数据变更失败: %v", err)
+		}
+		c.ChangedAt = time.Unix(at, 0)
+		c.Fields = make(map[string]string, len(metadataFields))
+		c.Changed = []string{}
+		for i, name := range metadataFields {
+			c.Fields[name] = values[i]
+			if prev == nil && values[i] != "" || prev != nil && prev[name] != values[i] {
+				c.Changed = append(c.Changed, name)
+			}
+		}
+		prev = c.Fields
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// handlePatchClient 修改客户端的管理员元数据（负责人、部门、位置、标签、备注），只修改请求中出现的字段
+func handlePatchClient(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, id, ok := requireClient(db, w, r, roleOperator)
+		if !ok {
+			return
+		}
+		var patch MetadataPatch
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的JSON数据: %v", err))
+			return
+		}
+		if err := patch.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		actor := adminActor(r)
+		m, changed, err := db.UpdateMetadata(id, &patch, actor)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "客户端不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "修改客户端元数据失败", "client_id", id, "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if changed {
+			slog.InfoContext(r.Context(), "已修改客户端元数据", "client_id", id, "by", actor)
+			// 负责人、位置、标签等可能被动态分组规则引用
+			if err := db.RefreshGroups(id); err != nil {
+				slog.ErrorContext(r.Context(), "重新计算动态分组失败", "client_id", id, "err", err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"goup-server/internal/semver"
+)
+
+// 请求耗时直方图的桶（秒）
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 库存统计的缓存时间，避免每次抓取都扫描 client_info
+const inventoryCacheTTL = 15 * time.Second
+
+// requestKey 请求计数的标签组合
+type requestKey struct {
+	route  string
+	method string
+	status int
+}
+
+// histogram 累积直方图
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range latencyBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// InventoryStats 库存统计
+type InventoryStats struct {
+	Total     int
+	Online    int
+	Archived  int
+	ByVersion map[string]int
+	ByNetwork map[string]int
+}
+
+// Metrics 服务端指标，以 Prometheus 文本格式输出
+type Metrics struct {
+	mu             sync.Mutex
+	requests       map[requestKey]uint64
+	durations      map[string]*histogram
+	upserts        map[string]uint64
+	decodeFailures uint64
+	// 被上报限制拒绝的请求（按原因）与抖动期间未写入的变更记录
+	ingestRejected    map[string]uint64
+	suppressedChanges uint64
+
+	inventoryMu   sync.Mutex
+	inventory     *InventoryStats
+	inventoryAt   time.Time
+	inventoryErrs uint64
+}
+
+// NewMetrics 创建指标集合
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*histogram),
+		upserts:   make(map[string]uint64),
+
+		ingestRejected: make(map[string]uint64),
+	}
+	// 预置所有结果，便于告警规则在首次上报前就能引用
+	for _, result := range []string{"insert", "update", "nochange", "error"} {
+		m.upserts[result] = 0
+	}
+	for _, reason := range []string{"ip_rate", "device_rate", "body_too_large", "unknown_field", "invalid"} {
+		m.ingestRejected[reason] = 0
+	}
+	return m
+}
+
+// ObserveRequest 记录一次 HTTP 请求
+func (m *Metrics) ObserveRequest(route, method string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{route, method, status}]++
+	h, ok := m.durations[route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.durations[route] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// ObserveUpsert 记录 InsertOrUpdateClientInfo 的结果：insert/update/nochange/error
+func (m *Metrics) ObserveUpsert(result string) {
+	m.mu.Lock()
+	m.upserts[result]++
+	m.mu.Unlock()
+}
+
+// ObserveDecodeFailure 记录一次 JSON 解析失败
+func (m *Metrics) ObserveDecodeFailure() {
+	m.mu.Lock()
+	m.decodeFailures++
+	m.mu.Unlock()
+}
+
+// ObserveIngestRejected 记录一次被上报限制拒绝的请求：ip_rate/device_rate/body_too_large/unknown_field/invalid
+func (m *Metrics) ObserveIngestRejected(reason string) {
+	m.mu.Lock()
+	m.ingestRejected[reason]++
+	m.mu.Unlock()
+}
+
+// ObserveSuppressedChange 记录一次因设备抖动而未写入的变更记录
+func (m *Metrics) ObserveSuppressedChange() {
+	m.mu.Lock()
+	m.suppressedChanges++
+	m.mu.Unlock()
+}
+
+// statusRecorder 记录响应状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap 供 http.ResponseController 访问底层连接
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush 透传流式输出
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware 按路由模板统计请求数与耗时，需通过 router.Use 注册才能取得路由模板
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		m.ObserveRequest(route, r.Method, rec.status, time.Since(start))
+	})
+}
+
+// inventoryStats 返回缓存的库存统计，过期时重新查询
+func (m *Metrics) inventoryStats(db *Database, offlineAfter time.Duration) (*InventoryStats, error) {
+	m.inventoryMu.Lock()
+	defer m.inventoryMu.Unlock()
+	if m.inventory != nil && time.Since(m.inventoryAt) < inventoryCacheTTL {
+		return m.inventory, nil
+	}
+	stats, err := db.InventoryStats(offlineAfter)
+	if err != nil {
+		m.inventoryErrs++
+		return m.inventory, err
+	}
+	m.inventory = stats
+	m.inventoryAt = time.Now()
+	return stats, nil
+}
+
+// InventoryStats 统计客户端总数、在线数以及按版本、网络类型的分布。
+// 在线指 post_at 在 offlineAfter 时间内；归档的客户端只计入 Archived。
+func (db *Database) InventoryStats(offlineAfter time.Duration) (*InventoryStats, error) {
+	stats := &InventoryStats{ByVersion: make(map[string]int), ByNetwork: make(map[string]int)}
+	cutoff := time.Now().Add(-offlineAfter)
+
+	err := db.conn.QueryRow(`
+	SELECT COUNT(*), COALESCE(SUM(post_at IS NOT NULL AND post_at >= FROM_UNIXTIME(?)), 0) FROM client_info
+	WHERE id NOT IN (SELECT client_id FROM client_archive)`, cutoff.Unix()).Scan(&stats.Total, &stats.Online)
+	if err != nil {
+		return nil, fmt.Errorf("统计客户端数量失败: %v", err)
+	}
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM client_archive`).Scan(&stats.Archived); err != nil {
+		return nil, fmt.Errorf("统计归档客户端数量失败: %v", err)
+	}
+
+	group := func(column string, dst map[string]int, label func(string) string) error {
+		rows, err := db.conn.Query(`SELECT IFNULL(` + column + `, ''), COUNT(*) FROM client_info
+			WHERE id NOT IN (SELECT client_id FROM client_archive) GROUP BY 1`)
+		if err != nil {
+			return fmt.Errorf("按 %s 统计失败: %v", column, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			var n int
+			if err := rows.Scan(&key, &n); err != nil {
+				return fmt.Errorf("按 %s 统计失败: %v", column, err)
+			}
+			dst[label(key)] += n
+		}
+		return rows.Err()
+	}
+	if err := group("up_ver", stats.ByVersion, versionLabel); err != nil {
+		return nil, err
+	}
+	if err := group("network", stats.ByNetwork, func(s string) string { return s }); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// versionLabel 客户端版本的指标标签：无法解析的版本号统一记为 invalid，避免任意上报值产生无限多的时间序列
+func versionLabel(v string) string {
+	if v == "" || semver.Valid(v) {
+		return v
+	}
+	return "invalid"
+}
+
+// Handler 输出 Prometheus 文本格式指标。需携带 auth.metrics_tokens 中的令牌，
+// 或以拥有 viewer 权限的身份（管理令牌、API 密钥、登录会话或匿名角色）访问
+func (m *Metrics) Handler(db *Database, store *ConfigStore, guard *IngestGuard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !store.CheckMetricsToken(r.Header.Get("Authorization")) {
+			if _, ok := requireRole(w, r, roleViewer); !ok {
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+
+		m.writeRequestMetrics(bw)
+		writeDBMetrics(bw, db)
+		writeHeader(bw, "goup_clients_flapping", "gauge", "Clients whose reports are currently flapping.")
+		fmt.Fprintf(bw, "goup_clients_flapping %d\n", len(guard.Flapping(time.Now())))
+
+		stats, err := m.inventoryStats(db, time.Duration(store.Get().Inventory.OfflineAfter))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "统计库存指标失败", "err", err)
+		}
+		m.inventoryMu.Lock()
+		errs := m.inventoryErrs
+		m.inventoryMu.Unlock()
+		writeHeader(bw, "goup_inventory_query_errors_total", "counter", "Failed inventory queries while serving /metrics.")
+		fmt.Fprintf(bw, "goup_inventory_query_errors_total %d\n", errs)
+		if stats != nil {
+			writeInventoryMetrics(bw, stats)
+		}
+	}
+}
+
+// writeRequestMetrics 输出请求、上报结果与解析失败指标
+func (m *Metrics) writeRequestMetrics(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "goup_http_requests_total", "counter", "HTTP requests by route, method and status.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "goup_http_requests_total{route=%s,method=%s,status=\"%d\"} %d\n",
+			quoteLabel(k.route), quoteLabel(k.method), k.status, m.requests[k])
+	}
+
+	writeHeader(w, "goup_http_request_duration_seconds", "histogram", "HTTP request latency by route.")
+	for _, route := range sortedKeys(m.durations) {
+		h := m.durations[route]
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "goup_http_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n",
+				quoteLabel(route), strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "goup_http_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", quoteLabel(route), h.count)
+		fmt.Fprintf(w, "goup_http_request_duration_seconds_sum{route=%s} %g\n", quoteLabel(route), h.sum)
+		fmt.Fprintf(w, "goup_http_request_duration_seconds_count{route=%s} %d\n", quoteLabel(route), h.count)
+	}
+
+	writeHeader(w, "goup_client_upserts_total", "counter", "Client report upsert outcomes.")
+	for _, result := range sortedKeys(m.upserts) {
+		fmt.Fprintf(w, "goup_client_upserts_total{result=%s} %d\n", quoteLabel(result), m.upserts[result])
+	}
+
+	writeHeader(w, "goup_json_decode_failures_total", "counter", "Client reports rejected because the JSON body could not be decoded.")
+	fmt.Fprintf(w, "goup_json_decode_failures_total %d\n", m.decodeFailures)
+
+	writeHeader(w, "goup_ingest_rejected_total", "counter", "Client reports rejected by ingestion limits, by reason.")
+	for _, reason := range sortedKeys(m.ingestRejected) {
+		fmt.Fprintf(w, "goup_ingest_rejected_total{reason=%s} %d\n", quoteLabel(reason), m.ingestRejected[reason])
+	}
+
+	writeHeader(w, "goup_suppressed_changes_total", "counter", "Change records not written because the client was flapping.")
+	fmt.Fprintf(w, "goup_suppressed_changes_total %d\n", m.suppressedChanges)
+}
+
+// writeDBMetrics 输出数据库连接池指标
+func writeDBMetrics(w *bufio.Writer, db *Database) {
+	st := db.conn.Stats()
+	gauge := func(name, help string, v float64) {
+		writeHeader(w, name, "gauge", help)
+		fmt.Fprintf(w, "%s %g\n", name, v)
+	}
+	counter := func(name, help string, v float64) {
+		writeHeader(w, name, "counter", help)
+		fmt.Fprintf(w, "%s %g\n", name, v)
+	}
+	gauge("goup_db_max_open_connections", "Maximum number of open connections to the database.", float64(st.MaxOpenConnections))
+	gauge("goup_db_open_connections", "Established connections, both in use and idle.", float64(st.OpenConnections))
+	gauge("goup_db_in_use_connections", "Connections currently in use.", float64(st.InUse))
+	gauge("goup_db_idle_connections", "Idle connections.", float64(st.Idle))
+	counter("goup_db_wait_count_total", "Total number of connections waited for.", float64(st.WaitCount))
+	counter("goup_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", st.WaitDuration.Seconds())
+	counter("goup_db_max_idle_closed_total", "Connections closed due to max idle connections.", float64(st.MaxIdleClosed))
+	counter("goup_db_max_idle_time_closed_total", "Connections closed due to max idle time.", float64(st.MaxIdleTimeClosed))
+	counter("goup_db_max_lifetime_closed_total", "Connections closed due to max connection lifetime.", float64(st.MaxLifetimeClosed))
+}
+
+// writeInventoryMetrics 输出库存指标
+func writeInventoryMetrics(w *bufio.Writer, stats *InventoryStats) {
+	writeHeader(w, "goup_clients", "gauge", "Known clients.")
+	fmt.Fprintf(w, "goup_clients %d\n", stats.Total)
+	writeHeader(w, "goup_clients_online", "gauge", "Clients that reported within inventory.offline_after.")
+	fmt.Fprintf(w, "goup_clients_online %d\n", stats.Online)
+	writeHeader(w, "goup_clients_offline", "gauge", "Clients that have not reported within inventory.offline_after.")
+	fmt.Fprintf(w, "goup_clients_offline %d\n", stats.Total-stats.Online)
+	writeHeader(w, "goup_clients_archived", "gauge", "Archived clients, excluded from the other client gauges.")
+	fmt.Fprintf(w, "goup_clients_archived %d\n", stats.Archived)
+
+	writeHeader(w, "goup_clients_by_version", "gauge", "Clients by agent version (up_ver).")
+	for _, v := range sortedKeys(stats.ByVersion) {
+		fmt.Fprintf(w, "goup_clients_by_version{up_ver=%s} %d\n", quoteLabel(v), stats.ByVersion[v])
+	}
+	writeHeader(w, "goup_clients_by_network", "gauge", "Clients by network type.")
+	for _, n := range sortedKeys(stats.ByNetwork) {
+		fmt.Fprintf(w, "goup_clients_by_network{network=%s} %d\n", quoteLabel(n), stats.ByNetwork[n])
+	}
+}
+
+// writeHeader 输出 HELP 与 TYPE 行
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// quoteLabel 按 Prometheus 文本格式转义标签值
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
+
+// sortedKeys 返回排序后的 map 键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInventoryStatsOnline(t *testing.T) {
+	db := testDatabase(t)
+	before, err := db.InventoryStats(6 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与客户端列表的在线筛选一致：12 小时前上报的客户端不计入在线数
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"online": 2 * time.Hour, "offline": 12 * time.Hour} {
+		id := testClient(t, db, t.Name()+"-"+name)
+		if _, err := db.conn.Exec(`UPDATE client_info SET post_at = FROM_UNIXTIME(?) WHERE id = ?`, now.Add(-age).Unix(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, err := db.InventoryStats(6 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Total-before.Total != 2 || after.Online-before.Online != 1 {
+		t.Fatalf("total %d -> %d, online %d -> %d; want +2 total and +1 online",
+			before.Total, after.Total, before.Online, after.Online)
+	}
+}
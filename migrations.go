@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// migrationLock 多个实例同时启动时，只有一个实例执行迁移
+const (
+	migrationLock        = "goup_schema_migrations"
+	migrationLockTimeout = time.Minute
+)
+
+// migration 一次数据库结构变更。版本号按顺序递增，已发布的迁移不能修改，
+// 修改表结构时在 migrations 末尾追加新的版本
+type migration struct {
+	version     int
+	description string
+	// apply 为 nil 表示只记录版本
+	apply func(db *Database) error
+}
+
+// migrations 全部数据库迁移，按版本排列。
+// 版本 11 之前的表结构由 CreateTable 以 CREATE TABLE IF NOT EXISTS 建立
+var migrations = []migration{
+	{version: 11, description: "基础表结构"},
+	{version: 12, description: "统一已保存的 MAC 地址写法", apply: normalizeStoredMACs},
+}
+
+// schemaVersion 当前代码期望的数据库结构版本
+var schemaVersion = migrations[len(migrations)-1].version
+
+// Migrate 依次执行 schema_migrations 中尚未记录的迁移，每个迁移成功后记录其版本
+func (db *Database) Migrate() error {
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("获取数据库连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLock, int(migrationLockTimeout.Seconds())).Scan(&locked); err != nil {
+		return fmt.Errorf("获取迁移锁失败: %v", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("等待其他实例完成数据库迁移超时")
+	}
+	defer conn.ExecContext(ctx, `DO RELEASE_LOCK(?)`, migrationLock)
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("读取数据库结构版本失败: %v", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return fmt.Errorf("读取数据库结构版本失败: %v", err)
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("读取数据库结构版本失败: %v", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if m.apply != nil {
+			if err := m.apply(db); err != nil {
+				return fmt.Errorf("执行数据库迁移 %d（%s）失败: %v", m.version, m.description, err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, m.version); err != nil {
+			return fmt.Errorf("记录数据库结构版本 %d 失败: %v", m.version, err)
+		}
+		slog.Info("已执行数据库迁移", "version", m.version, "description", m.description)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"goup-server/internal/oidcauth"
+)
+
+// OIDCConfig OpenID Connect 登录配置，issuer 为空时不启用
+type OIDCConfig struct {
+	// 签发者地址，如 https://login.example.com/realms/it
+	Issuer       string `json:"issuer,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// 从文件读取客户端密钥
+	ClientSecretFile string `json:"client_secret_file,omitempty"`
+	// 回调地址，需在身份提供方登记，路径为 /api/auth/oidc/callback
+	RedirectURL string `json:"redirect_url,omitempty"`
+	// 请求的 scope
+	Scopes []string `json:"scopes"`
+	// 作为用户名的声明
+	UsernameClaim string `json:"username_claim"`
+	// 列出所属组或角色的声明，可用 . 访问嵌套字段，如 realm_access.roles
+	GroupsClaim string `json:"groups_claim"`
+	// 组到角色的映射，与 LDAP 相同
+	RoleMappings []RoleMapping `json:"role_mappings,omitempty"`
+	// 接受的 Bearer JWT 受众（aud），为空时不接受以 JWT 访问 API
+	BearerAudiences []string `json:"bearer_audiences,omitempty"`
+	// JWKS 公钥的缓存时间
+	JWKSCacheTTL Duration `json:"jwks_cache_ttl"`
+
+	// 根据以上配置创建的身份提供方，未启用时为 nil
+	provider *oidcauth.Provider
+}
+
+// oidcCallbackPath 授权完成后身份提供方跳转回的路径
+const oidcCallbackPath = "/api/auth/oidc/callback"
+
+// oidcStateCookie 保存授权请求 state 的 Cookie，回调是跨站跳转，需使用 SameSite=Lax
+const oidcStateCookie = "goup_oidc"
+
+// oidcLoginTimeout 从跳转到身份提供方到回调的最长时间
+const oidcLoginTimeout = 10 * time.Minute
+
+// maxPendingOIDCLogins 同时进行中的授权请求上限，防止未完成的请求占用过多内存
+const maxPendingOIDCLogins = 10000
+
+// defaultOIDCConfig 默认 OIDC 配置
+func defaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Scopes:        []string{"openid", "profile", "email"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		JWKSCacheTTL:  Duration(time.Hour),
+	}
+}
+
+// newProvider 根据配置创建身份提供方
+func (c *OIDCConfig) newProvider() (*oidcauth.Provider, error) {
+	if c.ClientSecretFile != "" {
+		data, err := os.ReadFile(c.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 OIDC 客户端密钥失败: %v", err)
+		}
+		c.ClientSecret = strings.TrimSpace(string(data))
+	}
+	p, err := oidcauth.New(oidcauth.Config{
+		Issuer:       c.Issuer,
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Scopes:       c.Scopes,
+		JWKSCacheTTL: time.Duration(c.JWKSCacheTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("auth.oidc 配置无效: %v", err)
+	}
+	return p, nil
+}
+
+// validate 检查 OIDC 配置，未启用时不检查
+func (c *OIDCConfig) validate() error {
+	if c.Issuer == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.RedirectURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("auth.oidc.redirect_url 必须是完整的回调地址，如 https://goup.example.com%s", oidcCallbackPath)
+	}
+	if c.UsernameClaim == "" || c.GroupsClaim == "" {
+		return fmt.Errorf("auth.oidc.username_claim 与 groups_claim 不能为空")
+	}
+	if c.JWKSCacheTTL <= 0 {
+		return fmt.Errorf("auth.oidc.jwks_cache_ttl 必须大于 0")
+	}
+	if len(c.RoleMappings) == 0 {
+		return fmt.Errorf("auth.oidc.role_mappings 不能为空，否则外部账号都无法登录")
+	}
+	return validateRoleMappings("auth.oidc.role_mappings", c.RoleMappings)
+}
+
+// pendingOIDCLogin 进行中的授权请求
+type pendingOIDCLogin struct {
+	req      *oidcauth.AuthRequest
+	returnTo string
+	expires  time.Time
+}
+
+// oidcLogins 按 state 保存进行中的授权请求，只保存在内存中，重启后需要重新登录
+type oidcLogins struct {
+	mu      sync.Mutex
+	pending map[string]*pendingOIDCLogin
+}
+
+func newOIDCLogins() *oidcLogins {
+	return &oidcLogins{pending: make(map[string]*pendingOIDCLogin)}
+}
+
+// add 保存授权请求，同时清理过期的请求；进行中的请求过多时返回 false
+func (l *oidcLogins) add(req *oidcauth.AuthRequest, returnTo string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for state, p := range l.pending {
+		if now.After(p.expires) {
+			delete(l.pending, state)
+		}
+	}
+	if len(l.pending) >= maxPendingOIDCLogins {
+		return false
+	}
+	l.pending[req.State] = &pendingOIDCLogin{req: req, returnTo: returnTo, expires: now.Add(oidcLoginTimeout)}
+	return true
+}
+
+// take 取出并删除授权请求，每个 state 只能使用一次
+func (l *oidcLogins) take(state string) *pendingOIDCLogin {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p := l.pending[state]
+	delete(l.pending, state)
+	if p == nil || time.Now().After(p.expires) {
+		return nil
+	}
+	return p
+}
+
+// localPath 只允许跳转到本站的路径，防止开放重定向
+func localPath(s string) string {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return "/"
+	}
+	return s
+}
+
+// setOIDCStateCookie 写入（state 为空时清除）授权请求的 state，将回调与发起登录的浏览器绑定
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string) {
+	c := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcLoginTimeout / time.Second),
+	}
+	if state == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// handleOIDCLogin 生成 state、nonce 与 PKCE 参数并跳转到身份提供方，return_to 为登录后返回的本站路径
+func handleOIDCLogin(store *ConfigStore, logins *oidcLogins) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := store.Get().Auth.OIDC.provider
+		if provider == nil {
+			http.NotFound(w, r)
+			return
+		}
+		req, err := oidcauth.NewAuthRequest()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "生成授权请求失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		target, err := provider.AuthCodeURL(r.Context(), req)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "读取 OIDC 发现文档失败", "err", err)
+			http.Error(w, "身份提供方不可用，请稍后重试", http.StatusServiceUnavailable)
+			return
+		}
+		if !logins.add(req, localPath(r.URL.Query().Get("return_to"))) {
+			http.Error(w, "登录请求过多，请稍后重试", http.StatusServiceUnavailable)
+			return
+		}
+		setOIDCStateCookie(w, r, req.State)
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// handleOIDCCallback 校验 state 后以授权码换取 ID 令牌，按声明映射角色并同步本地用户，成功后写入会话 Cookie 并跳转回原页面
+func handleOIDCCallback(db *Database, store *ConfigStore, logins *oidcLogins) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Get()
+		oc := &cfg.Auth.OIDC
+		if oc.provider == nil {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		state := q.Get("state")
+		c, err := r.Cookie(oidcStateCookie)
+		setOIDCStateCookie(w, r, "")
+		if err != nil || state == "" || c.Value != state {
+			auditNote(r, "", "登录失败: oidc state 不匹配")
+			http.Error(w, "登录请求无效或已过期，请重新登录", http.StatusBadRequest)
+			return
+		}
+		pending := logins.take(state)
+		if pending == nil {
+			auditNote(r, "", "登录失败: oidc 登录请求已过期")
+			http.Error(w, "登录请求无效或已过期，请重新登录", http.StatusBadRequest)
+			return
+		}
+		if e := q.Get("error"); e != "" {
+			auditNote(r, "", "登录失败: oidc "+e)
+			http.Error(w, "身份提供方拒绝了登录: "+e+" "+q.Get("error_description"), http.StatusUnauthorized)
+			return
+		}
+		claims, err := oc.provider.Exchange(r.Context(), q.Get("code"), pending.req)
+		if err != nil {
+			if errors.Is(err, oidcauth.ErrInvalidToken) {
+				slog.WarnContext(r.Context(), "OIDC 登录失败", "err", err)
+				auditNote(r, "", "登录失败: oidc 令牌无效")
+				http.Error(w, "登录失败，请重新登录", http.StatusUnauthorized)
+				return
+			}
+			slog.ErrorContext(r.Context(), "OIDC 登录失败", "err", err)
+			auditNote(r, "", "登录失败: 身份提供方不可用")
+			http.Error(w, "身份提供方不可用，请稍后重试", http.StatusServiceUnavailable)
+			return
+		}
+		username := claims.String(oc.UsernameClaim)
+		auditNote(r, username, "")
+		if !usernamePattern.MatchString(username) {
+			slog.WarnContext(r.Context(), "OIDC 令牌中的用户名无效", "claim", oc.UsernameClaim, "sub", claims.String("sub"))
+			auditNote(r, "sub:"+claims.String("sub"), "登录失败: oidc 用户名无效")
+			http.Error(w, "身份令牌中没有有效的用户名（"+oc.UsernameClaim+"）", http.StatusForbidden)
+			return
+		}
+		role, groups, ok := mapRole(oc.RoleMappings, claims.Strings(oc.GroupsClaim))
+		if !ok {
+			auditNote(r, "", "登录失败: oidc "+errNoRoleMapping.Error())
+			http.Error(w, errNoRoleMapping.Error(), http.StatusForbidden)
+			return
+		}
+		u, err := db.SyncExternalUser("oidc", claims.String("sub"), username, role, groups)
+		if errors.Is(err, errIdentityConflict) {
+			auditNote(r, "", "登录失败: oidc "+err.Error())
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "同步 OIDC 用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if u.Disabled {
+			auditNote(r, "", "登录失败: 账号已停用")
+			http.Error(w, "账号已停用", http.StatusForbidden)
+			return
+		}
+		ttl := time.Duration(cfg.Auth.SessionTTL)
+		token, err := db.CreateSession(u.ID, ttl, r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "创建会话失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "用户已登录", "username", u.Username, "method", "oidc")
+		auditNote(r, "", "oidc")
+		setSessionCookie(w, r, token, ttl)
+		http.Redirect(w, r, pending.returnTo, http.StatusFound)
+	}
+}
+
+// oidcBearerPrincipal 校验 Bearer JWT（服务账号）并按声明映射角色，令牌无效或没有匹配的角色时返回 nil。
+// 服务账号不创建本地用户
+func oidcBearerPrincipal(ctx context.Context, oc *OIDCConfig, token string) *Principal {
+	claims, err := oc.provider.Verify(ctx, token, oc.BearerAudiences...)
+	if err != nil {
+		if errors.Is(err, oidcauth.ErrInvalidToken) {
+			slog.DebugContext(ctx, "Bearer JWT 无效", "err", err)
+		} else {
+			slog.WarnContext(ctx, "校验 Bearer JWT 失败", "err", err)
+		}
+		return nil
+	}
+	role, groups, ok := mapRole(oc.RoleMappings, claims.Strings(oc.GroupsClaim))
+	if !ok {
+		slog.WarnContext(ctx, "Bearer JWT 未分配角色", "sub", claims.String("sub"))
+		return nil
+	}
+	name := claims.String(oc.UsernameClaim)
+	if name == "" {
+		name = claims.String("azp")
+	}
+	if name == "" {
+		name = claims.String("sub")
+	}
+	return &Principal{Name: truncate(name, maxActorLen), Role: role, Groups: groups, Method: "jwt"}
+}
+
+// isJWT 粗略判断令牌是否为 JWT（三段以 . 分隔）
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
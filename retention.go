@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retentionWorker 清理任务在 Workers 中登记的名称
+const retentionWorker = "retention"
+
+// clientDataTables 以 client_id 关联客户端的表，删除客户端时一并清理
+var clientDataTables = []string{
+	"client_changes", "client_collector_status", "client_facts", "client_attributes", "client_metadata",
+	"client_metadata_changes", "client_group_members", "client_group_events", "update_events",
+	"client_heartbeats", "client_heartbeat_hourly",
+}
+
+// RetentionConfig 数据保留策略，保留条数与时长为 0 表示不限制
+type RetentionConfig struct {
+	// 清理任务的执行周期
+	Interval Duration `json:"interval"`
+	// 每个客户端保留最近的变更记录条数
+	KeepChanges int `json:"keep_changes"`
+	// 变更记录的保留时长；与 keep_changes 同时配置时，只删除既不在最近 N 条之内、又超过保留时长的记录
+	MaxChangeAge Duration `json:"max_change_age"`
+	// 超过该时长未上报的客户端自动归档，从未上报的（如导入的）客户端按登记时间计算
+	ArchiveAfter Duration `json:"archive_after"`
+	// 归档超过该时长的客户端连同其全部记录一并删除
+	PurgeArchivedAfter Duration `json:"purge_archived_after"`
+	// 每批删除或归档的行数，以及批次之间的间隔，避免长时间锁表
+	BatchSize  int      `json:"batch_size"`
+	BatchPause Duration `json:"batch_pause"`
+	// 只统计并记录将要归档与删除的数据，不做修改
+	DryRun bool `json:"dry_run"`
+}
+
+// defaultRetentionConfig 默认不清理任何数据
+func defaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		Interval:   Duration(time.Hour),
+		BatchSize:  1000,
+		BatchPause: Duration(100 * time.Millisecond),
+	}
+}
+
+// validate 检查保留策略
+func (c *RetentionConfig) validate() error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("retention.interval 必须大于 0")
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("retention.batch_size 必须大于 0")
+	}
+	if c.KeepChanges < 0 || c.MaxChangeAge < 0 || c.ArchiveAfter < 0 || c.PurgeArchivedAfter < 0 || c.BatchPause < 0 {
+		return fmt.Errorf("retention 的保留条数与时长不能为负数")
+	}
+	return nil
+}
+
+// RetentionClient 清理报告中被归档或删除的客户端
+type RetentionClient struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	SN     string    `json:"sn"`
+	MAC    string    `json:"mac"`
+	PostAt time.Time `json:"post_at"`
+}
+
+// RetentionReport 一轮清理的结果；DryRun 为 true 时为将要归档与删除的数据
+type RetentionReport struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	// 删除的变更记录条数，不含随客户端一起删除的记录
+	Changes int64 `json:"changes"`
+	// 超过 heartbeat.raw_retention 的原始心跳与超过 heartbeat.rollup_retention 的按小时汇总
+	Heartbeats       int64             `json:"heartbeats"`
+	HeartbeatRollups int64             `json:"heartbeat_rollups"`
+	Archived         []RetentionClient `json:"archived"`
+	Purged           []RetentionClient `json:"purged"`
+	Error            string            `json:"error,omitempty"`
+}
+
+var (
+	errRetentionRunning = errors.New("清理任务正在执行")
+	errRetentionStopped = errors.New("服务正在关闭")
+)
+
+// Retention 按 retention 配置定期清理变更记录、归档长期未上报的客户端并删除过期的归档客户端
+type Retention struct {
+	db      *Database
+	store   *ConfigStore
+	workers *Workers
+
+	// 同一时间只执行一轮实际清理，试运行不受限制
+	running sync.Mutex
+
+	// Stop 时取消后台任务与正在执行的清理（包括手动执行），并等待它们退出
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	last    *RetentionReport
+	stopped bool
+}
+
+// NewRetention 创建清理任务
+func NewRetention(db *Database, store *ConfigStore, workers *Workers) *Retention {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Retention{db: db, store: store, workers: workers, ctx: ctx, cancel: cancel}
+}
+
+// Start 在后台按 retention.interval 周期执行清理，周期随配置热更新；Stop 后停止
+func (rt *Retention) Start() {
+	ctx := rt.ctx
+	interval := time.Duration(rt.store.Get().Retention.Interval)
+	rt.workers.Register(retentionWorker, interval)
+	if !rt.enter() {
+		return
+	}
+	go func() {
+		defer rt.wg.Done()
+		// 启动后稍等片刻再执行第一轮，避免与启动时的其他查询争用数据库
+		timer := time.NewTimer(min(interval, time.Minute))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			cfg := rt.store.Get().Retention
+			if d := time.Duration(cfg.Interval); d != interval {
+				interval = d
+				rt.workers.Register(retentionWorker, interval)
+			}
+			_, err := rt.Run(ctx, cfg.DryRun)
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, errRetentionRunning) {
+				err = nil
+			}
+			if errors.Is(err, errRetentionStopped) {
+				return
+			}
+			rt.workers.Beat(retentionWorker, err)
+			timer.Reset(interval)
+		}
+	}()
+}
+
+// Run 按当前配置执行一轮清理并记录结果，dryRun 为 true 时只统计不修改
+func (rt *Retention) Run(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	if !dryRun {
+		if !rt.running.TryLock() {
+			return nil, errRetentionRunning
+		}
+		defer rt.running.Unlock()
+	}
+	report, err := rt.execute(ctx, dryRun)
+	if errors.Is(err, errRetentionStopped) {
+		return nil, err
+	}
+	rt.mu.Lock()
+	rt.last = report
+	rt.mu.Unlock()
+
+	attrs := []any{"dry_run", dryRun, "changes", report.Changes, "heartbeats", report.Heartbeats,
+		"heartbeat_rollups", report.HeartbeatRollups, "archived", len(report.Archived),
+		"purged", len(report.Purged), "duration_ms", report.DurationMs}
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("数据清理失败", append(attrs, "err", err)...)
+		}
+	} else if report.Changes > 0 || report.Heartbeats > 0 || report.HeartbeatRollups > 0 ||
+		len(report.Archived) > 0 || len(report.Purged) > 0 {
+		slog.Info("数据清理完成", attrs...)
+	}
+	return report, err
+}
+
+// Preview 按当前配置试运行，返回将要归档与删除的数据，不记录为最近一次执行结果
+func (rt *Retention) Preview(ctx context.Context) (*RetentionReport, error) {
+	return rt.execute(ctx, true)
+}
+
+// Last 最近一次执行结果，尚未执行时返回 nil
+func (rt *Retention) Last() *RetentionReport {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.last
+}
+
+// Stop 停止后台任务、取消正在执行的清理并等待其退出，返回后才能关闭数据库
+func (rt *Retention) Stop() {
+	rt.mu.Lock()
+	rt.stopped = true
+	rt.mu.Unlock()
+	rt.cancel()
+	rt.wg.Wait()
+}
+
+// enter 登记一个执行中的清理，Stop 之后返回 false
+func (rt *Retention) enter() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.stopped {
+		return false
+	}
+	rt.wg.Add(1)
+	return true
+}
+
+func (rt *Retention) execute(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	if !rt.enter() {
+		return nil, errRetentionStopped
+	}
+	defer rt.wg.Done()
+	// 手动执行的清理不随请求取消，但在服务关闭时取消
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(rt.ctx, cancel)()
+
+	cfg := rt.store.Get()
+	job := &retentionJob{db: rt.db, policy: cfg.Retention, heartbeat: cfg.Heartbeat, dryRun: dryRun, now: time.Now()}
+	report := &RetentionReport{DryRun: dryRun, StartedAt: job.now, Archived: []RetentionClient{}, Purged: []RetentionClient{}}
+	err := job.run(ctx, report)
+	report.DurationMs = time.Since(job.now).Milliseconds()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report, err
+}
+
+// retentionJob 一轮清理：先归档长期未上报的客户端，再清理变更记录，最后删除过期的归档客户端。
+// 变更记录先于客户端清理，因此试运行与实际执行统计的变更记录条数一致
+type retentionJob struct {
+	db        *Database
+	policy    RetentionConfig
+	heartbeat HeartbeatConfig
+	dryRun    bool
+	now       time.Time
+}
+
+func (j *retentionJob) run(ctx context.Context, report *RetentionReport) error {
+	if j.policy.ArchiveAfter > 0 {
+		archived, err := j.archiveInactive(ctx)
+		if archived != nil {
+			report.Archived = archived
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if j.policy.KeepChanges > 0 || j.policy.MaxChangeAge > 0 {
+		n, err := j.pruneChanges(ctx)
+		report.Changes = n
+		if err != nil {
+			return err
+		}
+	}
+	if err := j.pruneHeartbeats(ctx, report); err != nil {
+		return err
+	}
+	if j.policy.PurgeArchivedAfter > 0 {
+		purged, err := j.purgeArchived(ctx)
+		if purged != nil {
+			report.Purged = purged
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveInactive 归档超过 archive_after 未上报的客户端
+func (j *retentionJob) archiveInactive(ctx context.Context) ([]RetentionClient, error) {
+	cutoff := j.cutoff(j.policy.ArchiveAfter)
+	rows, err := j.db.conn.QueryContext(ctx, `SELECT c.id, IFNULL(c.name, ''), IFNULL(c.sn, ''), IFNULL(c.mac, ''),
+		IFNULL(UNIX_TIMESTAMP(c.post_at), 0) FROM client_info c LEFT JOIN client_archive a ON a.client_id = c.id
+		WHERE a.client_id IS NULL AND IFNULL(c.post_at, c.created_at) < FROM_UNIXTIME(?) ORDER BY c.id`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("查询未上报的客户端失败: %v", err)
+	}
+	clients, err := scanRetentionClients(rows)
+	if err != nil || j.dryRun {
+		return clients, err
+	}
+
+	for start := 0; start < len(clients); start += j.policy.BatchSize {
+		batch := clients[start:min(start+j.policy.BatchSize, len(clients))]
+		args := []interface{}{archiveInactive}
+		for _, c := range batch {
+			args = append(args, c.ID)
+		}
+		// 查询之后才上报的客户端不再归档
+		args = append(args, cutoff)
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		if _, err := j.db.conn.ExecContext(ctx, `INSERT IGNORE INTO client_archive (client_id, reason, archived_by)
+			SELECT id, ?, 'retention' FROM client_info WHERE id IN (`+placeholders+`) AND IFNULL(post_at, created_at) < FROM_UNIXTIME(?)`,
+			args...); err != nil {
+			return clients[:start], fmt.Errorf("归档客户端失败: %v", err)
+		}
+		if err := j.pause(ctx); err != nil {
+			return clients[:start+len(batch)], err
+		}
+	}
+	return clients, nil
+}
+
+// pruneChanges 清理超出 keep_changes 或 max_change_age 的变更记录，返回删除（或将删除）的条数
+func (j *retentionJob) pruneChanges(ctx context.Context) (int64, error) {
+	var ageCutoff int64
+	if j.policy.MaxChangeAge > 0 {
+		ageCutoff = j.cutoff(j.policy.MaxChangeAge)
+	}
+
+	if j.policy.KeepChanges == 0 {
+		// 只按时间清理：较早的记录 id 较小，先找到边界再按主键范围分批删除
+		var maxID sql.NullInt64
+		if err := j.db.conn.QueryRowContext(ctx, `SELECT MAX(id) FROM client_changes WHERE changed_at < FROM_UNIXTIME(?)`, ageCutoff).Scan(&maxID); err != nil {
+			return 0, fmt.Errorf("查询过期变更记录失败: %v", err)
+		}
+		if !maxID.Valid {
+			return 0, nil
+		}
+		n, err := j.deleteOrCount(ctx, "client_changes", `id <= ? AND changed_at < FROM_UNIXTIME(?)`, "id", maxID.Int64, ageCutoff)
+		if err != nil {
+			return n, fmt.Errorf("清理变更记录失败: %v", err)
+		}
+		return n, nil
+	}
+
+	rows, err := j.db.conn.QueryContext(ctx, `SELECT client_id FROM client_changes GROUP BY client_id HAVING COUNT(*) > ?`, j.policy.KeepChanges)
+	if err != nil {
+		return 0, fmt.Errorf("统计变更记录失败: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("统计变更记录失败: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("统计变更记录失败: %v", err)
+	}
+
+	var total int64
+	for _, id := range ids {
+		// 第 keep_changes+1 新的记录及更早的记录超出保留条数
+		var boundary int64
+		err := j.db.conn.QueryRowContext(ctx, `SELECT id FROM client_changes WHERE client_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?`,
+			id, j.policy.KeepChanges).Scan(&boundary)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return total, fmt.Errorf("查询变更记录失败: %v", err)
+		}
+		where, args := `client_id = ? AND id <= ?`, []interface{}{id, boundary}
+		if ageCutoff > 0 {
+			where, args = where+` AND changed_at < FROM_UNIXTIME(?)`, append(args, ageCutoff)
+		}
+		n, err := j.deleteOrCount(ctx, "client_changes", where, "id", args...)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("清理客户端 %d 的变更记录失败: %v", id, err)
+		}
+	}
+	return total, nil
+}
+
+// pruneHeartbeats 清理超过 heartbeat.raw_retention 的原始心跳与超过 heartbeat.rollup_retention 的按小时汇总
+func (j *retentionJob) pruneHeartbeats(ctx context.Context, report *RetentionReport) error {
+	if j.heartbeat.RawRetention > 0 {
+		n, err := j.deleteOrCount(ctx, "client_heartbeats", `reported_at < FROM_UNIXTIME(?)`, "", j.cutoff(j.heartbeat.RawRetention))
+		report.Heartbeats = n
+		if err != nil {
+			return fmt.Errorf("清理心跳失败: %v", err)
+		}
+	}
+	if j.heartbeat.RollupRetention > 0 {
+		n, err := j.deleteOrCount(ctx, "client_heartbeat_hourly", `hour < FROM_UNIXTIME(?)`, "", j.cutoff(j.heartbeat.RollupRetention))
+		report.HeartbeatRollups = n
+		if err != nil {
+			return fmt.Errorf("清理心跳汇总失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// purgeArchived 删除归档超过 purge_archived_after 的客户端及其全部记录
+func (j *retentionJob) purgeArchived(ctx context.Context) ([]RetentionClient, error) {
+	cutoff := j.cutoff(j.policy.PurgeArchivedAfter)
+	rows, err := j.db.conn.QueryContext(ctx, `SELECT a.client_id, IFNULL(c.name, ''), IFNULL(c.sn, ''), IFNULL(c.mac, ''),
+		IFNULL(UNIX_TIMESTAMP(c.post_at), 0) FROM client_archive a LEFT JOIN client_info c ON c.id = a.client_id
+		WHERE a.archived_at < FROM_UNIXTIME(?) ORDER BY a.client_id`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("查询过期的归档客户端失败: %v", err)
+	}
+	clients, err := scanRetentionClients(rows)
+	if err != nil || j.dryRun {
+		return clients, err
+	}
+
+	done := []RetentionClient{}
+	for _, c := range clients {
+		ok, err := j.deleteArchivedClient(ctx, c.ID, cutoff)
+		if err != nil {
+			return done, err
+		}
+		if !ok {
+			continue
+		}
+		// 客户端已删除，不会再被恢复或上报；归档记录保留到关联记录删除完成，中途失败时下一轮继续清理
+		for _, table := range clientDataTables {
+			if _, err := j.deleteBatches(ctx, `DELETE FROM `+table+` WHERE client_id = ?`, c.ID); err != nil {
+				return done, fmt.Errorf("删除客户端 %d 的 %s 记录失败: %v", c.ID, table, err)
+			}
+		}
+		if _, err := j.db.conn.ExecContext(ctx, `DELETE FROM client_archive WHERE client_id = ?`, c.ID); err != nil {
+			return done, fmt.Errorf("删除客户端 %d 的归档记录失败: %v", c.ID, err)
+		}
+		slog.Info("已删除过期的归档客户端", "client_id", c.ID, "name", c.Name, "sn", c.SN, "mac", c.MAC)
+		done = append(done, c)
+		if err := j.pause(ctx); err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// deleteArchivedClient 在事务中锁定归档记录，确认客户端仍在 cutoff 之前归档后删除客户端。
+// 查询之后被恢复（或重新上报）的客户端返回 false；恢复与上报删除归档记录时会等待锁释放
+func (j *retentionJob) deleteArchivedClient(ctx context.Context, id int, cutoff int64) (bool, error) {
+	tx, err := j.db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+	var archived int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM client_archive WHERE client_id = ? AND archived_at < FROM_UNIXTIME(?) FOR UPDATE`,
+		id, cutoff).Scan(&archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("查询归档状态失败: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM client_info WHERE id = ?`, id); err != nil {
+		return false, fmt.Errorf("删除客户端 %d 失败: %v", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("删除客户端 %d 失败: %v", id, err)
+	}
+	return true, nil
+}
+
+// cutoff 返回本轮清理时间 d 之前的 Unix 秒，以 FROM_UNIXTIME 绑定后与 TIMESTAMP 列比较
+func (j *retentionJob) cutoff(d Duration) int64 {
+	return j.now.Add(-time.Duration(d)).Unix()
+}
+
+// deleteOrCount 分批删除 table 中满足 where 的记录，order 非空时按该列顺序删除；试运行时只统计条数
+func (j *retentionJob) deleteOrCount(ctx context.Context, table, where, order string, args ...interface{}) (int64, error) {
+	if j.dryRun {
+		var n int64
+		err := j.db.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE `+where, args...).Scan(&n)
+		return n, err
+	}
+	query := `DELETE FROM ` + table + ` WHERE ` + where
+	if order != "" {
+		query += ` ORDER BY ` + order
+	}
+	return j.deleteBatches(ctx, query, args...)
+}
+
+// deleteBatches 每次最多删除 batch_size 行（query 不含 LIMIT），批次之间暂停 batch_pause，返回删除的总行数
+func (j *retentionJob) deleteBatches(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	args = append(args[:len(args):len(args)], j.policy.BatchSize)
+	var total int64
+	for {
+		res, err := j.db.conn.ExecContext(ctx, query+` LIMIT ?`, args...)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < int64(j.policy.BatchSize) {
+			return total, nil
+		}
+		if err := j.pause(ctx); err != nil {
+			return total, err
+		}
+	}
+}
+
+// pause 批次之间暂停，让其他请求有机会获得锁
+func (j *retentionJob) pause(ctx context.Context) error {
+	if j.policy.BatchPause <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(time.Duration(j.policy.BatchPause))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// scanRetentionClients 读取 id、name、sn、mac、post_at 列并关闭 rows
+func scanRetentionClients(rows *sql.Rows) ([]RetentionClient, error) {
+	defer rows.Close()
+	clients := []RetentionClient{}
+	for rows.Next() {
+		var c RetentionClient
+		var postAt int64
+		if err := rows.Scan(&c.ID, &c.Name, &c.SN, &c.MAC, &postAt); err != nil {
+			return nil, fmt.Errorf("读取客户端失败: %v", err)
+		}
+		if postAt > 0 {
+			c.PostAt = time.Unix(postAt, 0)
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+// handleRetentionStatus 返回当前保留策略与最近一次执行结果
+func handleRetentionStatus(store *ConfigStore, rt *Retention) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleAdmin)
+		if !ok || !requireUnscoped(w, p) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"policy":   store.Get().Retention,
+			"last_run": rt.Last(),
+		})
+	}
+}
+
+// handleRetentionPreview 试运行当前保留策略，返回将要归档与删除的客户端及变更记录条数
+func handleRetentionPreview(rt *Retention) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleAdmin)
+		if !ok || !requireUnscoped(w, p) {
+			return
+		}
+		report, err := rt.Preview(r.Context())
+		if errors.Is(err, errRetentionStopped) {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "试运行数据清理失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// handleRetentionRun 立即执行一轮清理，dry_run=1 时只统计；执行结果记为最近一次执行结果
+func handleRetentionRun(rt *Retention) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleAdmin)
+		if !ok || !requireUnscoped(w, p) {
+			return
+		}
+		dryRun := false
+		if v := r.URL.Query().Get("dry_run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的 dry_run: %s", v))
+				return
+			}
+			dryRun = b
+		}
+		// 清理可能持续较长时间，不随请求取消而中断
+		report, err := rt.Run(context.WithoutCancel(r.Context()), dryRun)
+		if errors.Is(err, errRetentionRunning) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, errRetentionStopped) {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "数据清理失败", "err", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(report)
+			return
+		}
+		auditNote(r, "", fmt.Sprintf("dry_run=%t changes=%d archived=%d purged=%d",
+			dryRun, report.Changes, len(report.Archived), len(report.Purged)))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
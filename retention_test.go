@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPurgeArchived(t *testing.T) {
+	db := testDatabase(t)
+	now := time.Now()
+	archive := func(name string, age time.Duration) int {
+		id := testClient(t, db, name)
+		if _, err := db.conn.Exec(`INSERT INTO client_archive (client_id, reason, archived_at) VALUES (?, ?, FROM_UNIXTIME(?))`,
+			id, archiveManual, now.Add(-age).Unix()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.conn.Exec(`INSERT INTO client_heartbeats (client_id, reported_at) VALUES (?, FROM_UNIXTIME(?))`,
+			id, now.Add(-age).Unix()); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	// 只比保留时长多两小时：cutoff 按驱动时区绑定时会偏移会话时区的 8 小时而漏删
+	expired := archive(t.Name()+"-expired", 50*time.Hour)
+	recent := archive(t.Name()+"-recent", 47*time.Hour)
+
+	policy := defaultRetentionConfig()
+	policy.PurgeArchivedAfter = Duration(48 * time.Hour)
+	policy.BatchPause = 0
+	job := &retentionJob{db: db, policy: policy, now: now}
+	cutoff := job.cutoff(policy.PurgeArchivedAfter)
+
+	// 恢复之后不再删除
+	restored := archive(t.Name()+"-restored", 50*time.Hour)
+	if _, err := db.RestoreClient(restored); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{recent, restored} {
+		if ok, err := job.deleteArchivedClient(context.Background(), id, cutoff); err != nil || ok {
+			t.Fatalf("deleteArchivedClient(%d) = %v, %v; want false", id, ok, err)
+		}
+	}
+
+	purged, err := job.purgeArchived(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range purged {
+		found = found || c.ID == expired
+		if c.ID == recent || c.ID == restored {
+			t.Fatalf("client %d purged", c.ID)
+		}
+	}
+	if !found {
+		t.Fatalf("purged = %+v, want client %d", purged, expired)
+	}
+
+	var n int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM client_heartbeats WHERE client_id = ?`, expired).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("heartbeats of purged client = %d, %v; want 0", n, err)
+	}
+	if _, err := db.GetClientArchive(expired); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("archive of purged client: %v, want sql.ErrNoRows", err)
+	}
+	for _, id := range []int{recent, restored} {
+		if err := db.conn.QueryRow(`SELECT id FROM client_info WHERE id = ?`, id).Scan(&n); err != nil {
+			t.Fatalf("client %d: %v", id, err)
+		}
+	}
+}
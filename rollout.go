@@ -0,0 +1,549 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"goup-server/internal/semver"
+)
+
+// Release 某个版本的发布策略；没有策略的版本视为 stable 通道、全量发布
+type Release struct {
+	Version string `json:"version"`
+	// 发布通道：stable 对所有客户端可见，其他通道（如 beta）只对同一通道的客户端可见
+	Channel string `json:"channel"`
+	// 分批发布比例（0-100），按设备标识哈希分桶
+	RolloutPercent int `json:"rollout_percent"`
+	// 适用的客户端当前版本范围，为空表示不限
+	MinVersion  string    `json:"min_version,omitempty"`
+	MaxVersion  string    `json:"max_version,omitempty"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// defaultRelease 没有配置发布策略的版本
+func defaultRelease(version string) Release {
+	return Release{Version: version, Channel: "stable", RolloutPercent: 100}
+}
+
+// UpdateHold 针对单个主机或一组主机的冻结/固定规则
+type UpdateHold struct {
+	ID int `json:"id"`
+	// 匹配范围：client（客户端 ID）、name（主机名）、fact（自定义事实 key=value）、group（分组 ID）
+	Scope  string `json:"scope"`
+	Target string `json:"target"`
+	// hold 不提供任何更新；pin 只提供指定版本
+	Action    string    `json:"action"`
+	Version   string    `json:"version,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UpdateRequest 请求更新清单的客户端
+type UpdateRequest struct {
+	// 在库存中匹配到的客户端 ID，未匹配时为 0
+	ClientID int
+	Name     string
+	// 设备标识哈希，用于分批发布分桶
+	Identity string
+	Version  string
+	Channel  string
+}
+
+// UpdateDecision 为客户端选择的版本，Release.Version 为空表示不提供更新
+type UpdateDecision struct {
+	Release   Release
+	Artifacts []Artifact
+	Reason    string
+}
+
+var channelPattern = regexp.MustCompile(`^[a-z0-9_-]{1,16}$`)
+
+// deviceIdentity 根据 SN 与 MAC 计算设备标识哈希
+func deviceIdentity(sn, mac string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(sn)) + "|" + strings.ToLower(strings.TrimSpace(mac))))
+	return hex.EncodeToString(sum[:])
+}
+
+// rolloutBucket 返回设备在某个版本分批发布中的桶号（0-99），同一设备在同一版本中的桶号固定，
+// 不同版本之间相互独立，避免每次都是同一批设备先升级
+func rolloutBucket(identity, version string) int {
+	sum := sha256.Sum256([]byte(identity + "/" + version))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
+
+// channelAllows 判断客户端通道能否看到某个发布通道的版本
+func channelAllows(client, release string) bool {
+	if release == "" || release == "stable" {
+		return true
+	}
+	return client == release
+}
+
+// inVersionRange 判断客户端版本是否在发布策略的版本范围内；客户端未上报版本时不限制
+func inVersionRange(version string, rel Release) bool {
+	if version == "" {
+		return true
+	}
+	if rel.MinVersion != "" && semver.CompareStrings(version, rel.MinVersion) < 0 {
+		return false
+	}
+	if rel.MaxVersion != "" && semver.CompareStrings(version, rel.MaxVersion) > 0 {
+		return false
+	}
+	return true
+}
+
+// selectRelease 按版本从新到旧选择第一个对客户端可用的版本：
+// hold 规则直接不提供更新，pin 规则只提供指定版本（忽略通道与分批比例）；
+// 其余情况下需满足通道、版本范围与分批比例。最新版本尚未覆盖到该设备时会回退到较旧的可用版本。
+func selectRelease(artifacts []Artifact, releases map[string]Release, holds []UpdateHold, req UpdateRequest) UpdateDecision {
+	pin := ""
+	for _, h := range holds {
+		switch h.Action {
+		case "hold":
+			return UpdateDecision{Reason: fmt.Sprintf("hold #%d", h.ID)}
+		case "pin":
+			pin = h.Version
+		}
+	}
+
+	byVersion := make(map[string][]Artifact)
+	var versions []string
+	for _, a := range artifacts {
+		if _, ok := byVersion[a.Version]; !ok {
+			versions = append(versions, a.Version)
+		}
+		byVersion[a.Version] = append(byVersion[a.Version], a)
+	}
+	sort.Slice(versions, func(i, j int) bool { return semver.CompareStrings(versions[i], versions[j]) > 0 })
+
+	if pin != "" {
+		if arts, ok := byVersion[pin]; ok {
+			rel, ok := releases[pin]
+			if !ok {
+				rel = defaultRelease(pin)
+			}
+			// 固定版本不受通道与版本范围限制：清单中不带这些条件，客户端也不会再按通道拒绝
+			rel.Channel, rel.MinVersion, rel.MaxVersion = "", "", ""
+			return UpdateDecision{Release: rel, Artifacts: arts, Reason: "pinned"}
+		}
+		return UpdateDecision{Reason: fmt.Sprintf("pinned to %s (no artifact)", pin)}
+	}
+
+	reason := "no eligible release"
+	for _, v := range versions {
+		rel, ok := releases[v]
+		if !ok {
+			rel = defaultRelease(v)
+		}
+		switch {
+		case !channelAllows(req.Channel, rel.Channel):
+			reason = fmt.Sprintf("%s: channel %s", v, rel.Channel)
+		case !inVersionRange(req.Version, rel):
+			reason = fmt.Sprintf("%s: version out of range", v)
+		case rolloutBucket(req.Identity, v) >= rel.RolloutPercent:
+			reason = fmt.Sprintf("%s: outside rollout %d%%", v, rel.RolloutPercent)
+		default:
+			return UpdateDecision{Release: rel, Artifacts: byVersion[v], Reason: "eligible"}
+		}
+	}
+	return UpdateDecision{Reason: reason}
+}
+
+// updateRequestFromQuery 解析更新清单请求参数，并根据 mac/sn 在库存中查找客户端
+func (db *Database) updateRequestFromQuery(q url.Values) (UpdateRequest, error) {
+	req := UpdateRequest{
+		Version: strings.TrimSpace(q.Get("version")),
+		Channel: strings.ToLower(strings.TrimSpace(q.Get("channel"))),
+	}
+	if req.Channel == "" {
+		req.Channel = "stable"
+	}
+	mac, sn := strings.TrimSpace(q.Get("mac")), strings.TrimSpace(q.Get("sn"))
+	req.Identity = deviceIdentity(sn, mac)
+	if mac == "" && sn == "" {
+		return req, nil
+	}
+	id, err := db.CheckExistingRecord(&ClientInfo{MAC: mac, SN: sn})
+	if err != nil || id == 0 {
+		return req, err
+	}
+	req.ClientID = id
+	var name sql.NullString
+	if err := db.conn.QueryRow(`SELECT name FROM client_info WHERE id = ?`, id).Scan(&name); err != nil {
+		return req, fmt.Errorf("读取客户端信息失败: %v", err)
+	}
+	req.Name = name.String
+	return req, nil
+}
+
+// DecideUpdate 读取发布策略与冻结/固定规则，为客户端选择版本
+func (db *Database) DecideUpdate(artifacts []Artifact, req UpdateRequest) (UpdateDecision, error) {
+	releases, err := db.ListReleases()
+	if err != nil {
+		return UpdateDecision{}, err
+	}
+	byVersion := make(map[string]Release, len(releases))
+	for _, rel := range releases {
+		byVersion[rel.Version] = rel
+	}
+	holds, err := db.matchingHolds(req)
+	if err != nil {
+		return UpdateDecision{}, err
+	}
+	return selectRelease(artifacts, byVersion, holds, req), nil
+}
+
+// matchingHolds 返回适用于该客户端的冻结/固定规则，按 ID 排序（后建立的 pin 优先）
+func (db *Database) matchingHolds(req UpdateRequest) ([]UpdateHold, error) {
+	if req.ClientID == 0 {
+		return nil, nil
+	}
+	holds, err := db.ListHolds()
+	if err != nil {
+		return nil, err
+	}
+	var facts map[string]string
+	var groups map[string]bool
+	var out []UpdateHold
+	for _, h := range holds {
+		match := false
+		switch h.Scope {
+		case "client":
+			match = h.Target == strconv.Itoa(req.ClientID)
+		case "name":
+			match = req.Name != "" && strings.EqualFold(h.Target, req.Name)
+		case "fact":
+			if facts == nil {
+				if facts, err = db.GetFacts(req.ClientID); err != nil {
+					return nil, err
+				}
+			}
+			key, value, _ := strings.Cut(h.Target, "=")
+			v, ok := facts[key]
+			match = ok && v == value
+		case "group":
+			if groups == nil {
+				refs, err := db.GroupsOf(req.ClientID)
+				if err != nil {
+					return nil, err
+				}
+				groups = make(map[string]bool, len(refs))
+				for _, g := range refs {
+					groups[strconv.Itoa(g.ID)] = true
+				}
+			}
+			match = groups[h.Target]
+		}
+		if match {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+// ListReleases 列出全部发布策略
+func (db *Database) ListReleases() ([]Release, error) {
+	rows, err := db.conn.Query(`SELECT version, channel, rollout_percent, IFNULL(min_version, ''), IFNULL(max_version, ''),
+		IFNULL(description, ''), UNIX_TIMESTAMP(updated_at) FROM update_releases ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("查询发布策略失败: %v", err)
+	}
+	defer rows.Close()
+
+	releases := []Release{}
+	for rows.Next() {
+		var rel Release
+		var updated int64
+		if err := rows.Scan(&rel.Version, &rel.Channel, &rel.RolloutPercent, &rel.MinVersion, &rel.MaxVersion, &rel.Description, &updated); err != nil {
+			return nil, fmt.Errorf("读取发布策略失败: %v", err)
+		}
+		rel.UpdatedAt = time.Unix(updated, 0)
+		releases = append(releases, rel)
+	}
+	return releases, rows.Err()
+}
+
+// SaveRelease 新建或更新发布策略
+func (db *Database) SaveRelease(rel *Release) error {
+	_, err := db.conn.Exec(`
+	INSERT INTO update_releases (version, channel, rollout_percent, min_version, max_version, description)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE channel = VALUES(channel), rollout_percent = VALUES(rollout_percent),
+		min_version = VALUES(min_version), max_version = VALUES(max_version), description = VALUES(description),
+		updated_at = CURRENT_TIMESTAMP`,
+		rel.Version, rel.Channel, rel.RolloutPercent, rel.MinVersion, rel.MaxVersion, rel.Description)
+	if err != nil {
+		return fmt.Errorf("保存发布策略失败: %v", err)
+	}
+	rel.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeleteRelease 删除发布策略，返回是否存在
+func (db *Database) DeleteRelease(version string) (bool, error) {
+	res, err := db.conn.Exec(`DELETE FROM update_releases WHERE version = ?`, version)
+	if err != nil {
+		return false, fmt.Errorf("删除发布策略失败: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListHolds 列出全部冻结/固定规则
+func (db *Database) ListHolds() ([]UpdateHold, error) {
+	rows, err := db.conn.Query(`SELECT id, scope, target, action, IFNULL(version, ''), IFNULL(note, ''), UNIX_TIMESTAMP(created_at)
+		FROM update_holds ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("查询冻结规则失败: %v", err)
+	}
+	defer rows.Close()
+
+	holds := []UpdateHold{}
+	for rows.Next() {
+		var h UpdateHold
+		var created int64
+		if err := rows.Scan(&h.ID, &h.Scope, &h.Target, &h.Action, &h.Version, &h.Note, &created); err != nil {
+			return nil, fmt.Errorf("读取冻结规则失败: %v", err)
+		}
+		h.CreatedAt = time.Unix(created, 0)
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+// SaveHold 新建冻结/固定规则
+func (db *Database) SaveHold(h *UpdateHold) error {
+	res, err := db.conn.Exec(`INSERT INTO update_holds (scope, target, action, version, note) VALUES (?, ?, ?, ?, ?)`,
+		h.Scope, h.Target, h.Action, h.Version, h.Note)
+	if err != nil {
+		return fmt.Errorf("保存冻结规则失败: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	h.ID = int(id)
+	h.CreatedAt = time.Now()
+	return nil
+}
+
+// DeleteHold 删除冻结/固定规则，返回是否存在
+func (db *Database) DeleteHold(id int) (bool, error) {
+	res, err := db.conn.Exec(`DELETE FROM update_holds WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("删除冻结规则失败: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// validate 检查发布策略
+func (rel *Release) validate() error {
+	if _, err := semver.Parse(rel.Version); err != nil {
+		return err
+	}
+	if rel.Channel == "" {
+		rel.Channel = "stable"
+	}
+	if !channelPattern.MatchString(rel.Channel) {
+		return fmt.Errorf("无效的通道: %s", rel.Channel)
+	}
+	if rel.RolloutPercent < 0 || rel.RolloutPercent > 100 {
+		return fmt.Errorf("rollout_percent 必须在 0-100 之间")
+	}
+	for _, v := range []string{rel.MinVersion, rel.MaxVersion} {
+		if v == "" {
+			continue
+		}
+		if _, err := semver.Parse(v); err != nil {
+			return err
+		}
+	}
+	if rel.MinVersion != "" && rel.MaxVersion != "" && semver.CompareStrings(rel.MinVersion, rel.MaxVersion) > 0 {
+		return fmt.Errorf("min_version 不能大于 max_version")
+	}
+	return nil
+}
+
+// validate 检查冻结/固定规则
+func (h *UpdateHold) validate() error {
+	h.Target = strings.TrimSpace(h.Target)
+	switch h.Scope {
+	case "client":
+		if _, err := strconv.Atoi(h.Target); err != nil {
+			return fmt.Errorf("scope 为 client 时 target 必须是客户端 ID")
+		}
+	case "group":
+		if _, err := strconv.Atoi(h.Target); err != nil {
+			return fmt.Errorf("scope 为 group 时 target 必须是分组 ID")
+		}
+	case "name":
+		if h.Target == "" {
+			return fmt.Errorf("target 不能为空")
+		}
+	case "fact":
+		if key, _, ok := strings.Cut(h.Target, "="); !ok || key == "" {
+			return fmt.Errorf("scope 为 fact 时 target 格式为 key=value")
+		}
+	default:
+		return fmt.Errorf("无效的 scope: %s（可选 client、name、fact、group）", h.Scope)
+	}
+	switch h.Action {
+	case "hold":
+		h.Version = ""
+	case "pin":
+		if _, err := semver.Parse(h.Version); err != nil {
+			return fmt.Errorf("pin 规则需要有效的 version: %v", err)
+		}
+	default:
+		return fmt.Errorf("无效的 action: %s（可选 hold、pin）", h.Action)
+	}
+	return nil
+}
+
+// handleListReleases 列出发布策略
+func handleListReleases(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		releases, err := db.ListReleases()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询发布策略失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(releases)
+	}
+}
+
+// handlePutRelease 新建或更新某个版本的发布策略
+func handlePutRelease(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		rel := Release{RolloutPercent: 100}
+		if err := json.NewDecoder(r.Body).Decode(&rel); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "无效的JSON数据")
+			return
+		}
+		rel.Version = mux.Vars(r)["version"]
+		if err := rel.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SaveRelease(&rel); err != nil {
+			slog.ErrorContext(r.Context(), "保存发布策略失败", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "服务器内部错误")
+			return
+		}
+		slog.InfoContext(r.Context(), "已更新发布策略",
+			"version", rel.Version, "channel", rel.Channel, "rollout_percent", rel.RolloutPercent,
+			"min_version", rel.MinVersion, "max_version", rel.MaxVersion)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rel)
+	}
+}
+
+// handleDeleteRelease 删除发布策略，该版本恢复为 stable 全量发布
+func handleDeleteRelease(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		version := mux.Vars(r)["version"]
+		found, err := db.DeleteRelease(version)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除发布策略失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "发布策略不存在", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "已删除发布策略", "version", version)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListHolds 列出冻结/固定规则
+func handleListHolds(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		holds, err := db.ListHolds()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询冻结规则失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(holds)
+	}
+}
+
+// handleCreateHold 新建冻结/固定规则
+func handleCreateHold(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		var h UpdateHold
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "无效的JSON数据")
+			return
+		}
+		if err := h.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := db.SaveHold(&h); err != nil {
+			slog.ErrorContext(r.Context(), "保存冻结规则失败", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "服务器内部错误")
+			return
+		}
+		slog.InfoContext(r.Context(), "已添加冻结规则", "id", h.ID, "scope", h.Scope, "target", h.Target, "action", h.Action, "version", h.Version)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+	}
+}
+
+// handleDeleteHold 删除冻结/固定规则
+func handleDeleteHold(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		found, err := db.DeleteHold(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除冻结规则失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "冻结规则不存在", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "已删除冻结规则", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestSelectReleasePinnedToOtherChannel(t *testing.T) {
+	artifacts := []Artifact{
+		{ID: 1, Version: "1.4", OS: "windows", Arch: "amd64"},
+		{ID: 2, Version: "1.5", OS: "windows", Arch: "amd64"},
+	}
+	releases := map[string]Release{
+		"1.5": {Version: "1.5", Channel: "beta", RolloutPercent: 100, MinVersion: "1.4"},
+	}
+	req := UpdateRequest{Identity: "host", Version: "1.3", Channel: "stable"}
+
+	// 未固定时 stable 主机只能获得 stable 版本
+	if d := selectRelease(artifacts, releases, nil, req); d.Release.Version != "1.4" {
+		t.Fatalf("unpinned decision = %+v, want 1.4", d)
+	}
+
+	// 固定到 beta 版本：决策与清单都不带通道和版本范围，客户端不会按通道拒绝
+	d := selectRelease(artifacts, releases, []UpdateHold{{ID: 1, Action: "pin", Version: "1.5"}}, req)
+	if d.Release.Version != "1.5" || d.Reason != "pinned" || len(d.Artifacts) != 1 {
+		t.Fatalf("pinned decision = %+v, want 1.5", d)
+	}
+	if rel := d.Release; rel.Channel != "" || rel.MinVersion != "" || rel.MaxVersion != "" {
+		t.Fatalf("pinned release = %+v, want no channel or version range", rel)
+	}
+}
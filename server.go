@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// HTTPConfig HTTP 服务器超时与大小限制
+type HTTPConfig struct {
+	ReadTimeout       Duration `json:"read_timeout"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	MaxHeaderBytes    int      `json:"max_header_bytes"`
+	// 请求体大小上限（字节）
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// 资产表导入（/api/import）的请求体大小上限（字节）
+	MaxImportBytes int64 `json:"max_import_bytes"`
+	// 收到退出信号后等待处理中请求完成的最长时间
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// defaultHTTPConfig 默认的 HTTP 服务器配置
+func defaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		ReadTimeout:       Duration(15 * time.Second),
+		ReadHeaderTimeout: Duration(5 * time.Second),
+		WriteTimeout:      Duration(30 * time.Second),
+		IdleTimeout:       Duration(60 * time.Second),
+		MaxHeaderBytes:    64 << 10,
+		MaxBodyBytes:      1 << 20,
+		MaxImportBytes:    32 << 20,
+		ShutdownTimeout:   Duration(20 * time.Second),
+	}
+}
+
+// Lifecycle 服务运行状态，关闭过程中健康检查返回 shutting down
+type Lifecycle struct {
+	draining atomic.Bool
+}
+
+// Draining 是否正在关闭
+func (l *Lifecycle) Draining() bool {
+	return l.draining.Load()
+}
+
+// newHTTPServer 根据配置创建 HTTP 服务器
+func newHTTPServer(cfg *ServerConfig, store *ConfigStore, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           limitBody(store, handler),
+		ReadTimeout:       time.Duration(cfg.HTTP.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(cfg.HTTP.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(cfg.HTTP.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.HTTP.IdleTimeout),
+		MaxHeaderBytes:    cfg.HTTP.MaxHeaderBytes,
+	}
+	if cfg.TLS.CertFile != "" {
+		// 证书通过 GetCertificate 获取，SIGHUP 后无需重启即可使用新证书
+		server.TLSConfig = &tls.Config{GetCertificate: store.GetCertificate}
+	}
+	return server
+}
+
+// limitBody 限制请求体大小，上限可随配置热更新；上传更新包、导入与客户端上报使用单独的上限
+func limitBody(store *ConfigStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Get()
+		max := cfg.HTTP.MaxBodyBytes
+		switch r.URL.Path {
+		case uploadPath:
+			max = cfg.Update.MaxUploadBytes
+		case importPath:
+			max = cfg.HTTP.MaxImportBytes
+		case ingestPath:
+			if cfg.Ingest.MaxBodyBytes > 0 {
+				max = cfg.Ingest.MaxBodyBytes
+			}
+		}
+		if max > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveUntilSignal 启动服务器，收到 SIGINT/SIGTERM 后停止接受新连接，
+// 并在超时时间内等待处理中的请求完成
+func serveUntilSignal(server *http.Server, lifecycle *Lifecycle, store *ConfigStore) error {
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		errCh <- err
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	var shutdownTimeout time.Duration
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-sigCh:
+		shutdownTimeout = time.Duration(store.Get().HTTP.ShutdownTimeout)
+		slog.Info("收到信号，开始关闭服务器", "signal", sig.String(), "timeout", shutdownTimeout)
+	}
+
+	lifecycle.draining.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("等待请求完成超时，强制关闭", "err", err)
+		server.Close()
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("服务器已关闭")
+	return nil
+}
+
+// handleHealth 健康检查，关闭过程中返回 503
+func handleHealth(lifecycle *Lifecycle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if lifecycle.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":  "shutting down",
+				"message": "服务正在关闭",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "ok",
+			"message": "服务运行正常",
+		})
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"goup-server/internal/semver"
+	"goup-server/internal/updatesig"
+)
+
+// UpdateConfig 客户端更新包分发配置
+type UpdateConfig struct {
+	// 更新包存储目录，文件按 SHA-256 命名
+	StorageDir string `json:"storage_dir"`
+	// 上传更新包的大小上限（字节）
+	MaxUploadBytes int64 `json:"max_upload_bytes"`
+	// 上传与下载单个更新包允许的最长传输时间，覆盖 HTTP 读写超时
+	TransferTimeout Duration `json:"transfer_timeout"`
+	// 更新清单签名私钥（Ed25519，base64 种子或 PKCS#8 PEM），与 signing_key_file 二选一
+	SigningKey string `json:"signing_key,omitempty"`
+	// 从文件读取签名私钥
+	SigningKeyFile string `json:"signing_key_file,omitempty"`
+
+	// 解析后的签名私钥，未配置时清单不签名
+	signer ed25519.PrivateKey
+}
+
+// defaultUpdateConfig 默认更新包分发配置
+func defaultUpdateConfig() UpdateConfig {
+	return UpdateConfig{
+		StorageDir:      "./artifacts",
+		MaxUploadBytes:  200 << 20,
+		TransferTimeout: Duration(10 * time.Minute),
+	}
+}
+
+// uploadPath 上传更新包的接口路径，请求体大小按 MaxUploadBytes 限制
+const uploadPath = "/api/update/artifacts"
+
+// Artifact 已上传的客户端更新包
+type Artifact struct {
+	ID          int       `json:"id"`
+	Version     string    `json:"version"`
+	OS          string    `json:"os"`
+	Arch        string    `json:"arch"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Platform 返回 "os-arch" 形式的平台标识，与客户端更新清单的键一致
+func (a *Artifact) Platform() string {
+	return a.OS + "-" + a.Arch
+}
+
+// UpdateManifest 更新清单，格式与客户端的 UpdateInfo 兼容
+type UpdateManifest struct {
+	Version     string                      `json:"version"`
+	Description string                      `json:"description,omitempty"`
+	Downloads   map[string]ManifestDownload `json:"downloads"`
+	// 发布通道与适用的客户端版本范围
+	Channel    string `json:"channel,omitempty"`
+	MinVersion string `json:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty"`
+	// Ed25519 签名（base64），签名内容见 updatesig.Payload
+	Signature string `json:"signature,omitempty"`
+}
+
+// ManifestDownload 更新清单中单个平台的下载信息
+type ManifestDownload struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Sign 使用私钥为清单签名
+func (m *UpdateManifest) Sign(key ed25519.PrivateKey) {
+	downloads := make([]updatesig.Download, 0, len(m.Downloads))
+	for platform, d := range m.Downloads {
+		downloads = append(downloads, updatesig.Download{Platform: platform, URL: d.URL, SHA256: d.SHA256, Size: d.Size})
+	}
+	m.Signature = updatesig.Sign(key, updatesig.Manifest{
+		Version:     m.Version,
+		Description: m.Description,
+		Channel:     m.Channel,
+		MinVersion:  m.MinVersion,
+		MaxVersion:  m.MaxVersion,
+		Downloads:   downloads,
+	})
+}
+
+var (
+	// platformPattern 操作系统与架构名称只允许小写字母、数字与下划线
+	platformPattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+)
+
+// SaveArtifact 保存更新包记录，同一版本与平台重复上传时覆盖原记录。
+// 被覆盖的文件不再被任何记录引用时返回其 SHA-256，由调用方删除文件
+func (db *Database) SaveArtifact(a *Artifact) (string, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	var replaced string
+	err = tx.QueryRow(`SELECT sha256 FROM update_artifacts WHERE version = ? AND os = ? AND arch = ? FOR UPDATE`,
+		a.Version, a.OS, a.Arch).Scan(&replaced)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("查询更新包记录失败: %v", err)
+	}
+	_, err = tx.Exec(`
+	INSERT INTO update_artifacts (version, os, arch, filename, size, sha256, description)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE filename = VALUES(filename), size = VALUES(size), sha256 = VALUES(sha256),
+		description = VALUES(description), created_at = CURRENT_TIMESTAMP`,
+		a.Version, a.OS, a.Arch, a.Filename, a.Size, a.SHA256, a.Description)
+	if err != nil {
+		return "", fmt.Errorf("保存更新包记录失败: %v", err)
+	}
+	var created int64
+	if err := tx.QueryRow(`SELECT id, UNIX_TIMESTAMP(created_at) FROM update_artifacts WHERE version = ? AND os = ? AND arch = ?`,
+		a.Version, a.OS, a.Arch).Scan(&a.ID, &created); err != nil {
+		return "", fmt.Errorf("读取更新包记录失败: %v", err)
+	}
+	a.CreatedAt = time.Unix(created, 0)
+
+	// 内容相同或仍被其他版本、平台引用的文件保留
+	if replaced == a.SHA256 {
+		replaced = ""
+	}
+	if replaced != "" {
+		var refs int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM update_artifacts WHERE sha256 = ?`, replaced).Scan(&refs); err != nil {
+			return "", fmt.Errorf("查询更新包引用失败: %v", err)
+		}
+		if refs > 0 {
+			replaced = ""
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("保存更新包记录失败: %v", err)
+	}
+	return replaced, nil
+}
+
+// artifactColumns 查询更新包时使用的列，与 scanArtifact 对应；DSN 未设置 parseTime 时时间列以 Unix 时间读取
+const artifactColumns = `id, version, os, arch, filename, size, sha256, IFNULL(description, ''), UNIX_TIMESTAMP(created_at)`
+
+// scanArtifact 读取一行更新包记录
+func scanArtifact(row interface{ Scan(...interface{}) error }) (*Artifact, error) {
+	var a Artifact
+	var created int64
+	if err := row.Scan(&a.ID, &a.Version, &a.OS, &a.Arch, &a.Filename, &a.Size, &a.SHA256, &a.Description, &created); err != nil {
+		return nil, err
+	}
+	a.CreatedAt = time.Unix(created, 0)
+	return &a, nil
+}
+
+// ListArtifacts 列出更新包，os/arch 为空时不过滤
+func (db *Database) ListArtifacts(osName, arch string) ([]Artifact, error) {
+	query := `SELECT ` + artifactColumns + ` FROM update_artifacts WHERE 1=1`
+	var args []interface{}
+	if osName != "" {
+		query += ` AND os = ?`
+		args = append(args, osName)
+	}
+	if arch != "" {
+		query += ` AND arch = ?`
+		args = append(args, arch)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询更新包失败: %v", err)
+	}
+	defer rows.Close()
+
+	artifacts := []Artifact{}
+	for rows.Next() {
+		a, err := scanArtifact(rows)
+		if err != nil {
+			return nil, fmt.Errorf("读取更新包记录失败: %v", err)
+		}
+		artifacts = append(artifacts, *a)
+	}
+	return artifacts, rows.Err()
+}
+
+// GetArtifact 按 ID 读取更新包，不存在时返回 sql.ErrNoRows
+func (db *Database) GetArtifact(id int) (*Artifact, error) {
+	return scanArtifact(db.conn.QueryRow(`SELECT `+artifactColumns+` FROM update_artifacts WHERE id = ?`, id))
+}
+
+// DeleteArtifact 删除更新包记录，返回该文件是否已无其他记录引用
+func (db *Database) DeleteArtifact(a *Artifact) (bool, error) {
+	if _, err := db.conn.Exec(`DELETE FROM update_artifacts WHERE id = ?`, a.ID); err != nil {
+		return false, fmt.Errorf("删除更新包记录失败: %v", err)
+	}
+	var refs int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM update_artifacts WHERE sha256 = ?`, a.SHA256).Scan(&refs); err != nil {
+		return false, fmt.Errorf("查询更新包引用失败: %v", err)
+	}
+	return refs == 0, nil
+}
+
+// artifactPath 返回更新包文件在存储目录中的路径
+func artifactPath(dir, sum string) string {
+	return filepath.Join(dir, sum)
+}
+
+// removeArtifactFile 删除不再被任何记录引用的更新包文件，失败时只记录警告
+func removeArtifactFile(r *http.Request, dir, sum string) {
+	if err := os.Remove(artifactPath(dir, sum)); err != nil && !os.IsNotExist(err) {
+		slog.WarnContext(r.Context(), "删除更新包文件失败", "sha256", sum, "err", err)
+	}
+}
+
+// storeArtifact 将数据流写入存储目录，同时计算 SHA-256 与大小
+func storeArtifact(dir string, src io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("创建更新包目录失败: %v", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, fmt.Errorf("写入更新包失败: %v", err)
+	}
+	if size == 0 {
+		return "", 0, fmt.Errorf("更新包为空")
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if err := os.Rename(tmp.Name(), artifactPath(dir, sum)); err != nil {
+		return "", 0, fmt.Errorf("保存更新包失败: %v", err)
+	}
+	return sum, size, nil
+}
+
+// extendDeadlines 为大文件传输放宽连接读写超时
+func extendDeadlines(w http.ResponseWriter, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(timeout)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+}
+
+// writeJSONError 以 JSON 格式返回错误信息
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": message})
+}
+
+// handleUploadArtifact 上传更新包。支持 multipart/form-data（file 字段与 version/os/arch/description
+// 表单字段，表单字段需位于文件之前）或原始请求体（参数通过查询字符串传递）。
+func handleUploadArtifact(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		cfg := store.Get().Update
+		extendDeadlines(w, time.Duration(cfg.TransferTimeout))
+
+		params := r.URL.Query()
+		var body io.Reader = r.Body
+		var mr *multipart.Reader
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			var err error
+			if mr, err = r.MultipartReader(); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "无效的 multipart 请求")
+				return
+			}
+			body = nil
+			for body == nil {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, "读取上传内容失败")
+					return
+				}
+				if part.FormName() == "file" {
+					if params.Get("filename") == "" && part.FileName() != "" {
+						params.Set("filename", part.FileName())
+					}
+					body = part
+					continue
+				}
+				value, _ := io.ReadAll(io.LimitReader(part, 4096))
+				params.Set(part.FormName(), strings.TrimSpace(string(value)))
+			}
+			if body == nil {
+				writeJSONError(w, http.StatusBadRequest, "缺少 file 字段")
+				return
+			}
+		}
+
+		a := &Artifact{
+			Version:     strings.TrimSpace(params.Get("version")),
+			OS:          strings.ToLower(strings.TrimSpace(params.Get("os"))),
+			Arch:        strings.ToLower(strings.TrimSpace(params.Get("arch"))),
+			Filename:    filepath.Base(strings.TrimSpace(params.Get("filename"))),
+			Description: params.Get("description"),
+		}
+		if _, err := semver.Parse(a.Version); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "缺少或无效的 version 参数: "+err.Error())
+			return
+		}
+		if !platformPattern.MatchString(a.OS) || !platformPattern.MatchString(a.Arch) {
+			writeJSONError(w, http.StatusBadRequest, "缺少或无效的 os/arch 参数")
+			return
+		}
+		if a.Filename == "" || a.Filename == "." || a.Filename == string(filepath.Separator) {
+			a.Filename = "client-" + a.Platform()
+			if a.OS == "windows" {
+				a.Filename += ".exe"
+			}
+		}
+
+		sum, size, err := storeArtifact(cfg.StorageDir, body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("更新包超过大小上限 %d 字节", maxErr.Limit))
+				return
+			}
+			slog.ErrorContext(r.Context(), "保存更新包失败", "err", err)
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.SHA256, a.Size = sum, size
+		unused, err := db.SaveArtifact(a)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "保存更新包记录失败", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "服务器内部错误")
+			return
+		}
+		// 重新上传同一版本与平台时，被覆盖的文件不再被引用
+		if unused != "" {
+			removeArtifactFile(r, cfg.StorageDir, unused)
+		}
+
+		slog.InfoContext(r.Context(), "已上传更新包",
+			"id", a.ID, "version", a.Version, "platform", a.Platform(), "size", a.Size, "sha256", a.SHA256)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// handleListArtifacts 列出已上传的更新包，可按 os/arch 过滤
+func handleListArtifacts(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		q := r.URL.Query()
+		artifacts, err := db.ListArtifacts(q.Get("os"), q.Get("arch"))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询更新包失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(artifacts)
+	}
+}
+
+// handleDeleteArtifact 删除更新包，文件不再被引用时一并删除
+func handleDeleteArtifact(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		a, err := db.GetArtifact(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "更新包不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询更新包失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		unused, err := db.DeleteArtifact(a)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除更新包失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if unused {
+			removeArtifactFile(r, store.Get().Update.StorageDir, a.SHA256)
+		}
+		slog.InfoContext(r.Context(), "已删除更新包", "id", a.ID, "version", a.Version, "platform", a.Platform())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleUpdateManifest 返回客户端更新清单。指定 os 与 arch 时只考虑该平台的更新包；
+// 根据通道、分批比例、版本范围以及冻结/固定规则为客户端选择版本（见 selectRelease），
+// 没有可提供的版本或 version 已是最新时返回 204，没有任何更新包时返回 404。
+func handleUpdateManifest(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !store.CheckIngestToken(r.Header.Get("Authorization")) {
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		osName, arch := strings.ToLower(q.Get("os")), strings.ToLower(q.Get("arch"))
+		artifacts, err := db.ListArtifacts(osName, arch)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询更新包失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if len(artifacts) == 0 {
+			http.Error(w, "没有可用的更新包", http.StatusNotFound)
+			return
+		}
+
+		req, err := db.updateRequestFromQuery(q)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询客户端信息失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		decision, err := db.DecideUpdate(artifacts, req)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "计算更新策略失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.DebugContext(r.Context(), "更新决策",
+			"client_id", req.ClientID, "version", req.Version, "channel", req.Channel,
+			"offer", decision.Release.Version, "reason", decision.Reason)
+		if decision.Release.Version == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if req.Version != "" && semver.CompareStrings(decision.Release.Version, req.Version) <= 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		rel := decision.Release
+		manifest := UpdateManifest{
+			Version:     rel.Version,
+			Downloads:   make(map[string]ManifestDownload),
+			Channel:     rel.Channel,
+			MinVersion:  rel.MinVersion,
+			MaxVersion:  rel.MaxVersion,
+			Description: rel.Description,
+		}
+		for _, a := range decision.Artifacts {
+			if manifest.Description == "" {
+				manifest.Description = a.Description
+			}
+			// 使用相对地址，客户端以清单地址为基准解析，反向代理后同样可用
+			manifest.Downloads[a.Platform()] = ManifestDownload{
+				URL:    "download/" + strconv.Itoa(a.ID) + "?name=" + url.QueryEscape(a.Filename),
+				SHA256: a.SHA256,
+				Size:   a.Size,
+			}
+		}
+		if signer := store.Get().Update.signer; signer != nil {
+			manifest.Sign(signer)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(manifest)
+	}
+}
+
+// handleDownloadArtifact 下载更新包，支持 Range 断点续传
+func handleDownloadArtifact(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !store.CheckIngestToken(r.Header.Get("Authorization")) {
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["artifact"])
+		if err != nil {
+			http.Error(w, "更新包不存在", http.StatusNotFound)
+			return
+		}
+		a, err := db.GetArtifact(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "更新包不存在", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询更新包失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+
+		cfg := store.Get().Update
+		f, err := os.Open(artifactPath(cfg.StorageDir, a.SHA256))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "打开更新包文件失败", "id", a.ID, "err", err)
+			http.Error(w, "更新包文件不存在", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+
+		extendDeadlines(w, time.Duration(cfg.TransferTimeout))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", a.Filename))
+		w.Header().Set("ETag", `"`+a.SHA256+`"`)
+		http.ServeContent(w, r, a.Filename, a.CreatedAt, f)
+	}
+}
+
+// generateUpdateKey 生成更新签名密钥对，私钥写入 path，返回需编译进客户端的公钥
+func generateUpdateKey(path string) (string, error) {
+	priv, pub, err := updatesig.GenerateKey()
+	if err != nil {
+		return "", fmt.Errorf("生成密钥失败: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("写入私钥失败: %v", err)
+	}
+	if _, err := f.WriteString(priv + "\n"); err != nil {
+		f.Close()
+		return "", fmt.Errorf("写入私钥失败: %v", err)
+	}
+	return pub, f.Close()
+}
+
+// signManifestFile 为独立托管的 update.json 签名并写回原文件，清单中需已包含各平台的 sha256 与 size
+func signManifestFile(path, keyPath string) error {
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("读取签名私钥失败: %v", err)
+	}
+	key, err := updatesig.ParsePrivateKey(keyData)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取更新清单失败: %v", err)
+	}
+	var manifest UpdateManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("解析更新清单失败: %v", err)
+	}
+	if manifest.Version == "" || len(manifest.Downloads) == 0 {
+		return fmt.Errorf("更新清单缺少 version 或 downloads")
+	}
+	for platform, d := range manifest.Downloads {
+		if sum, err := hex.DecodeString(d.SHA256); err != nil || len(sum) != sha256.Size || d.Size <= 0 {
+			return fmt.Errorf("平台 %s 缺少有效的 sha256 或 size", platform)
+		}
+	}
+	manifest.Sign(key)
+	out, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
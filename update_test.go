@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSaveArtifactReplaced(t *testing.T) {
+	db := testDatabase(t)
+	version := "0.0.1-test." + t.Name()
+	save := func(arch, sum string) string {
+		t.Helper()
+		a := &Artifact{Version: version, OS: "linux", Arch: arch, Filename: "client", Size: 1, SHA256: sum}
+		unused, err := db.SaveArtifact(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.conn.Exec(`DELETE FROM update_artifacts WHERE id = ?`, a.ID) })
+		return unused
+	}
+
+	if unused := save("amd64", "aaaa"); unused != "" {
+		t.Fatalf("first upload returned %q", unused)
+	}
+	// 重新上传相同内容不删除文件
+	if unused := save("amd64", "aaaa"); unused != "" {
+		t.Fatalf("same content returned %q", unused)
+	}
+	// 覆盖后不再被引用的文件返回给调用方删除
+	if unused := save("amd64", "bbbb"); unused != "aaaa" {
+		t.Fatalf("replaced upload returned %q, want aaaa", unused)
+	}
+	// 仍被其他平台引用的文件保留
+	save("arm64", "bbbb")
+	if unused := save("amd64", "cccc"); unused != "" {
+		t.Fatalf("shared content returned %q, want none", unused)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEventsPerReport 单次上报最多接受的更新事件数
+const maxEventsPerReport = 100
+
+// updateEventStatuses 客户端可上报的更新结果
+var updateEventStatuses = map[string]bool{"installed": true, "confirmed": true, "failed": true, "reverted": true}
+
+// UpdateEvent 客户端上报的一次更新结果
+type UpdateEvent struct {
+	ID          int       `json:"id"`
+	ClientID    int       `json:"client_id,omitempty"`
+	SN          string    `json:"sn,omitempty"`
+	MAC         string    `json:"mac,omitempty"`
+	Status      string    `json:"status"`
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// updateEventReport 客户端上报更新事件的请求体
+type updateEventReport struct {
+	SN     string        `json:"sn"`
+	MAC    string        `json:"mac"`
+	Events []UpdateEvent `json:"events"`
+}
+
+// UpdateEventFilter 更新事件查询条件，零值表示不限
+type UpdateEventFilter struct {
+	ClientID int
+	Status   string
+	Version  string
+	Limit    int
+}
+
+// SaveUpdateEvents 保存客户端上报的更新事件
+func (db *Database) SaveUpdateEvents(clientID int, sn, mac string, events []UpdateEvent) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+	for _, ev := range events {
+		var id interface{}
+		if clientID > 0 {
+			id = clientID
+		}
+		if _, err := tx.Exec(`INSERT INTO update_events (client_id, sn, mac, status, from_version, to_version, error, event_time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?))`,
+			id, sn, mac, ev.Status, ev.FromVersion, ev.ToVersion, ev.Error, ev.Time.Unix()); err != nil {
+			return fmt.Errorf("保存更新事件失败: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListUpdateEvents 按条件查询更新事件，最新的在前
+func (db *Database) ListUpdateEvents(f UpdateEventFilter) ([]UpdateEvent, error) {
+	query := `SELECT id, IFNULL(client_id, 0), IFNULL(sn, ''), IFNULL(mac, ''), status, IFNULL(from_version, ''),
+		IFNULL(to_version, ''), IFNULL(error, ''), UNIX_TIMESTAMP(event_time), UNIX_TIMESTAMP(created_at)
+		FROM update_events WHERE 1 = 1`
+	var args []interface{}
+	if f.ClientID > 0 {
+		query += ` AND client_id = ?`
+		args = append(args, f.ClientID)
+	}
+	if f.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, f.Status)
+	}
+	if f.Version != "" {
+		query += ` AND (to_version = ? OR from_version = ?)`
+		args = append(args, f.Version, f.Version)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询更新事件失败: %v", err)
+	}
+	defer rows.Close()
+
+	events := []UpdateEvent{}
+	for rows.Next() {
+		var ev UpdateEvent
+		var at, created int64
+		if err := rows.Scan(&ev.ID, &ev.ClientID, &ev.SN, &ev.MAC, &ev.Status, &ev.FromVersion, &ev.ToVersion,
+			&ev.Error, &at, &created); err != nil {
+			return nil, fmt.Errorf("读取更新事件失败: %v", err)
+		}
+		ev.Time, ev.CreatedAt = time.Unix(at, 0), time.Unix(created, 0)
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// handleReportUpdateEvents 接收客户端上报的更新结果（安装、确认、失败、回滚）
+func handleReportUpdateEvents(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !store.CheckIngestToken(r.Header.Get("Authorization")) {
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			return
+		}
+		var report updateEventReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "无效的JSON数据")
+			return
+		}
+		if len(report.Events) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "缺少更新事件")
+			return
+		}
+		if len(report.Events) > maxEventsPerReport {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("单次最多上报 %d 条更新事件", maxEventsPerReport))
+			return
+		}
+		for i := range report.Events {
+			ev := &report.Events[i]
+			ev.Status = strings.ToLower(strings.TrimSpace(ev.Status))
+			if !updateEventStatuses[ev.Status] {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的事件状态: %q", ev.Status))
+				return
+			}
+			if ev.Time.IsZero() {
+				ev.Time = time.Now()
+			}
+		}
+
+		sn, mac := strings.TrimSpace(report.SN), strings.TrimSpace(report.MAC)
+		clientID := 0
+		if sn != "" || mac != "" {
+			id, err := db.CheckExistingRecord(&ClientInfo{SN: sn, MAC: mac})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "查询客户端失败", "err", err)
+				http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+				return
+			}
+			clientID = id
+		}
+		if err := db.SaveUpdateEvents(clientID, sn, mac, report.Events); err != nil {
+			slog.ErrorContext(r.Context(), "保存更新事件失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		for _, ev := range report.Events {
+			attrs := []any{"client_id", clientID, "sn", sn, "mac", mac, "status", ev.Status,
+				"from_version", ev.FromVersion, "to_version", ev.ToVersion}
+			if ev.Status == "failed" || ev.Status == "reverted" {
+				slog.WarnContext(r.Context(), "客户端更新失败", append(attrs, "error", ev.Error)...)
+			} else {
+				slog.InfoContext(r.Context(), "客户端更新事件", attrs...)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListUpdateEvents 查询更新事件，支持 client_id、status、version、limit 参数
+func handleListUpdateEvents(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		q := r.URL.Query()
+		f := UpdateEventFilter{
+			Status:  strings.ToLower(strings.TrimSpace(q.Get("status"))),
+			Version: strings.TrimSpace(q.Get("version")),
+			Limit:   100,
+		}
+		if v := q.Get("client_id"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "无效的 client_id")
+				return
+			}
+			f.ClientID = id
+		}
+		if f.Status != "" && !updateEventStatuses[f.Status] {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的事件状态: %q", f.Status))
+			return
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				writeJSONError(w, http.StatusBadRequest, "limit 需在 1-1000 之间")
+				return
+			}
+			f.Limit = n
+		}
+		events, err := db.ListUpdateEvents(f)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询更新事件失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
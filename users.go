@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// User 用户账号
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// 账号来源：local 或外部身份提供方（如 ldap），外部账号的角色与分组在每次登录时同步
+	Source string `json:"source"`
+	// 可访问的分组，为空表示不限；admin 不能限定分组
+	Groups      []int      `json:"groups"`
+	Disabled    bool       `json:"disabled"`
+	HasPassword bool       `json:"has_password"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// UserRequest 新建或修改用户的请求体，修改时省略的字段保持不变；password 为空字符串表示清除密码（只能使用 API 密钥）
+type UserRequest struct {
+	Username *string `json:"username"`
+	Password *string `json:"password"`
+	Role     *string `json:"role"`
+	Groups   *[]int  `json:"groups"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// APIKey API 密钥，Key 只在创建时返回一次
+type APIKey struct {
+	ID       int    `json:"id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Prefix   string `json:"prefix"`
+	// 限定的角色，为空表示与用户相同
+	Role       string     `json:"role,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// usernamePattern 用户名只能包含字母、数字与 . _ @ -
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// validate 校验新建（create 为 true）或修改用户的请求，并计算密码哈希（passwordHash 为 nil 表示不修改）
+func (req *UserRequest) validate(create bool) (passwordHash *string, err error) {
+	if req.Username != nil {
+		*req.Username = strings.TrimSpace(*req.Username)
+		if !usernamePattern.MatchString(*req.Username) {
+			return nil, fmt.Errorf("用户名只能包含字母、数字与 . _ @ -，最长 64 个字符")
+		}
+	} else if create {
+		return nil, fmt.Errorf("缺少 username")
+	}
+	if req.Role != nil {
+		if roleRanks[*req.Role] == 0 {
+			return nil, fmt.Errorf("无效的角色: %s（可选 viewer、operator、admin）", *req.Role)
+		}
+	} else if create {
+		return nil, fmt.Errorf("缺少 role")
+	}
+	if req.Groups != nil {
+		for _, g := range *req.Groups {
+			if g <= 0 {
+				return nil, fmt.Errorf("无效的分组 ID: %d", g)
+			}
+		}
+	}
+	if req.Password != nil {
+		hash := ""
+		if *req.Password != "" {
+			if hash, err = hashPassword(*req.Password); err != nil {
+				return nil, err
+			}
+		}
+		passwordHash = &hash
+	}
+	return passwordHash, nil
+}
+
+// apply 将修改应用到用户
+func (req *UserRequest) apply(u *User) {
+	if req.Username != nil {
+		u.Username = *req.Username
+	}
+	if req.Role != nil {
+		u.Role = *req.Role
+	}
+	if req.Groups != nil {
+		u.Groups = *req.Groups
+	}
+	if req.Disabled != nil {
+		u.Disabled = *req.Disabled
+	}
+}
+
+// principal 返回用户的身份
+func (u *User) principal(method string) *Principal {
+	return &Principal{UserID: u.ID, Name: u.Username, Role: u.Role, Groups: u.Groups, Method: method}
+}
+
+// userSelect 读取用户的列，与 scanUser 对应
+const userSelect = `SELECT id, username, role, IFNULL(scope_groups, ''), disabled, IFNULL(password_hash, ''),
+	IFNULL((SELECT MIN(provider) FROM user_identities WHERE user_id = users.id), 'local'),
+	IFNULL(UNIX_TIMESTAMP(last_login_at), 0), UNIX_TIMESTAMP(created_at), UNIX_TIMESTAMP(updated_at) FROM users`
+
+// scanUser 读取用户及其密码哈希
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, string, error) {
+	var u User
+	var scope, hash string
+	var lastLogin, created, updated int64
+	if err := row.Scan(&u.ID, &u.Username, &u.Role, &scope, &u.Disabled, &hash, &u.Source, &lastLogin, &created, &updated); err != nil {
+		return nil, "", err
+	}
+	u.Groups = parseScope(scope)
+	if u.Groups == nil {
+		u.Groups = []int{}
+	}
+	u.HasPassword = hash != ""
+	if lastLogin > 0 {
+		t := time.Unix(lastLogin, 0)
+		u.LastLoginAt = &t
+	}
+	u.CreatedAt = time.Unix(created, 0)
+	u.UpdatedAt = time.Unix(updated, 0)
+	return &u, hash, nil
+}
+
+// ListUsers 列出所有用户
+func (db *Database) ListUsers() ([]User, error) {
+	rows, err := db.conn.Query(userSelect + ` ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		u, _, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("读取用户失败: %v", err)
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// GetUser 按 ID 查询用户，不存在时返回 sql.ErrNoRows
+func (db *Database) GetUser(id int) (*User, error) {
+	u, _, err := scanUser(db.conn.QueryRow(userSelect+` WHERE id = ?`, id))
+	return u, err
+}
+
+// userCredentials 按用户名查询用户及密码哈希，用户不存在时返回 nil
+func (db *Database) userCredentials(username string) (*User, string, error) {
+	u, hash, err := scanUser(db.conn.QueryRow(userSelect+` WHERE username = ?`, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("查询用户失败: %v", err)
+	}
+	return u, hash, nil
+}
+
+// SaveUser 新建（ID 为 0）或修改用户；passwordHash 为 nil 时不修改密码。停用用户时删除其会话
+func (db *Database) SaveUser(u *User, passwordHash *string) error {
+	var hash interface{}
+	if passwordHash != nil && *passwordHash != "" {
+		hash = *passwordHash
+	}
+	if u.ID == 0 {
+		res, err := db.conn.Exec(`INSERT INTO users (username, password_hash, role, scope_groups, disabled) VALUES (?, ?, ?, ?, ?)`,
+			u.Username, hash, u.Role, formatScope(u.Groups), u.Disabled)
+		if err != nil {
+			return err
+		}
+		id, _ := res.LastInsertId()
+		u.ID = int(id)
+		return nil
+	}
+	query := `UPDATE users SET username = ?, role = ?, scope_groups = ?, disabled = ?`
+	args := []interface{}{u.Username, u.Role, formatScope(u.Groups), u.Disabled}
+	if passwordHash != nil {
+		query += `, password_hash = ?`
+		args = append(args, hash)
+	}
+	if _, err := db.conn.Exec(query+` WHERE id = ?`, append(args, u.ID)...); err != nil {
+		return err
+	}
+	if u.Disabled || passwordHash != nil {
+		if _, err := db.conn.Exec(`DELETE FROM sessions WHERE user_id = ?`, u.ID); err != nil {
+			return fmt.Errorf("删除会话失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// SetPassword 修改密码并删除该用户除 keepSession（会话 ID）以外的会话
+func (db *Database) SetPassword(userID int, hash, keepSession string) error {
+	if _, err := db.conn.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, userID); err != nil {
+		return fmt.Errorf("保存密码失败: %v", err)
+	}
+	if _, err := db.conn.Exec(`DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, keepSession); err != nil {
+		return fmt.Errorf("删除会话失败: %v", err)
+	}
+	return nil
+}
+
+// DeleteUser 删除用户及其会话与 API 密钥
+func (db *Database) DeleteUser(id int) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("删除用户失败: %v", err)
+	}
+	for _, q := range []string{`DELETE FROM sessions WHERE user_id = ?`, `DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return false, fmt.Errorf("删除用户凭据失败: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("提交删除用户失败: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// errIdentityConflict 外部账号的用户名已被未关联的本地用户占用
+var errIdentityConflict = errors.New("用户名已被本地账号占用")
+
+// SyncExternalUser 按外部身份（provider 与 subject）查找关联的本地用户并同步角色与分组，未关联时以 username 新建用户。
+// 已停用的用户保持停用；username 已被其他本地用户占用时返回 errIdentityConflict
+func (db *Database) SyncExternalUser(provider, subject, username, role string, groups []int) (*User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("开启事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM user_identities WHERE provider = ? AND subject = ? FOR UPDATE`,
+		provider, subject).Scan(&userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		var exists int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("查询用户失败: %v", err)
+		}
+		if exists > 0 {
+			return nil, errIdentityConflict
+		}
+		res, err := tx.Exec(`INSERT INTO users (username, role, scope_groups) VALUES (?, ?, ?)`,
+			username, role, formatScope(groups))
+		if err != nil {
+			return nil, fmt.Errorf("创建用户失败: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		userID = int(id)
+		if _, err := tx.Exec(`INSERT INTO user_identities (provider, subject, user_id) VALUES (?, ?, ?)`,
+			provider, subject, userID); err != nil {
+			return nil, fmt.Errorf("保存外部身份失败: %v", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("查询外部身份失败: %v", err)
+	default:
+		if _, err := tx.Exec(`UPDATE users SET role = ?, scope_groups = ? WHERE id = ?`,
+			role, formatScope(groups), userID); err != nil {
+			return nil, fmt.Errorf("同步用户失败: %v", err)
+		}
+		if _, err := tx.Exec(`UPDATE user_identities SET synced_at = NOW() WHERE provider = ? AND subject = ?`,
+			provider, subject); err != nil {
+			return nil, fmt.Errorf("更新外部身份失败: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("提交用户同步失败: %v", err)
+	}
+	return db.GetUser(userID)
+}
+
+// otherActiveAdmins 返回除指定用户外仍可用的管理员账号数量
+func (db *Database) otherActiveAdmins(excludeID int) (int, error) {
+	var n int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0 AND id <> ?`, roleAdmin, excludeID).Scan(&n)
+	return n, err
+}
+
+// ListAPIKeys 列出 API 密钥，userID 为 0 时列出所有用户的密钥
+func (db *Database) ListAPIKeys(userID int) ([]APIKey, error) {
+	query := `SELECT k.id, k.user_id, IFNULL(u.username, ''), k.name, k.prefix, IFNULL(k.role, ''),
+		IFNULL(UNIX_TIMESTAMP(k.expires_at), 0), IFNULL(UNIX_TIMESTAMP(k.last_used_at), 0), UNIX_TIMESTAMP(k.created_at)
+		FROM api_keys k LEFT JOIN users u ON u.id = k.user_id`
+	var args []interface{}
+	if userID > 0 {
+		query += ` WHERE k.user_id = ?`
+		args = append(args, userID)
+	}
+	rows, err := db.conn.Query(query+` ORDER BY k.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询API密钥失败: %v", err)
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var expires, used, created int64
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Username, &k.Name, &k.Prefix, &k.Role, &expires, &used, &created); err != nil {
+			return nil, fmt.Errorf("读取API密钥失败: %v", err)
+		}
+		if expires > 0 {
+			t := time.Unix(expires, 0)
+			k.ExpiresAt = &t
+		}
+		if used > 0 {
+			t := time.Unix(used, 0)
+			k.LastUsedAt = &t
+		}
+		k.CreatedAt = time.Unix(created, 0)
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// CreateAPIKey 生成并保存 API 密钥，返回的 Key 为完整密钥。ttl 为 0 表示永不过期，
+// 过期时间与会话一样由数据库按 NOW() 计算，与校验时的 expires_at > NOW() 使用同一时钟与时区
+func (db *Database) CreateAPIKey(k *APIKey, ttl time.Duration) error {
+	prefix, err := newSecret(4)
+	if err != nil {
+		return err
+	}
+	secret, err := newSecret(24)
+	if err != nil {
+		return err
+	}
+	k.Prefix = prefix
+	k.Key = apiKeyPrefix + prefix + "_" + secret
+	var role, seconds interface{}
+	if k.Role != "" {
+		role = k.Role
+	}
+	if ttl > 0 {
+		seconds = int64(ttl / time.Second)
+	}
+	res, err := db.conn.Exec(`INSERT INTO api_keys (user_id, name, prefix, key_hash, role, expires_at)
+		VALUES (?, ?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))`,
+		k.UserID, k.Name, k.Prefix, hashSecret(k.Key), role, seconds)
+	if err != nil {
+		return fmt.Errorf("保存API密钥失败: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	k.ID = int(id)
+	k.CreatedAt = time.Now().Truncate(time.Second)
+	if ttl > 0 {
+		t := k.CreatedAt.Add(ttl).Truncate(time.Second)
+		k.ExpiresAt = &t
+	}
+	return nil
+}
+
+// DeleteAPIKey 吊销 API 密钥，userID 非 0 时只能删除该用户的密钥
+func (db *Database) DeleteAPIKey(id, userID int) (bool, error) {
+	query := `DELETE FROM api_keys WHERE id = ?`
+	args := []interface{}{id}
+	if userID > 0 {
+		query += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	res, err := db.conn.Exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("删除API密钥失败: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// handleListUsers 列出用户
+func handleListUsers(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		users, err := db.ListUsers()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	}
+}
+
+// handleSaveUser 新建（POST /api/users）或修改（PATCH /api/users/{id}）用户。
+// 不允许停用、降级最后一个可用的管理员账号（除非配置了管理令牌）
+func handleSaveUser(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		var req UserRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的JSON数据: %v", err))
+			return
+		}
+		create := r.Method == http.MethodPost
+		hash, err := req.validate(create)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		u := &User{Groups: []int{}}
+		if !create {
+			id, _ := strconv.Atoi(mux.Vars(r)["id"])
+			u, err = db.GetUser(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "用户不存在")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+				http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+				return
+			}
+		}
+		wasAdmin := !create && u.Role == roleAdmin && !u.Disabled
+		req.apply(u)
+		if u.Role == roleAdmin && len(u.Groups) > 0 {
+			writeJSONError(w, http.StatusBadRequest, "admin 角色不能限定分组")
+			return
+		}
+		if wasAdmin && (u.Role != roleAdmin || u.Disabled) && len(store.Get().Auth.AdminTokens) == 0 {
+			n, err := db.otherActiveAdmins(u.ID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "查询管理员失败", "err", err)
+				http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+				return
+			}
+			if n == 0 {
+				writeJSONError(w, http.StatusConflict, "不能停用或降级最后一个管理员")
+				return
+			}
+		}
+		if err := db.SaveUser(u, hash); err != nil {
+			if isDuplicateKeyError(err) {
+				writeJSONError(w, http.StatusConflict, "用户名已存在: "+u.Username)
+				return
+			}
+			slog.ErrorContext(r.Context(), "保存用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "已保存用户", "id", u.ID, "username", u.Username, "role", u.Role, "by", adminActor(r))
+		saved, err := db.GetUser(u.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if create {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(saved)
+	}
+}
+
+// handleDeleteUser 删除用户，同时吊销其会话与 API 密钥
+func handleDeleteUser(db *Database, store *ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireRole(w, r, roleAdmin); !ok {
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		u, err := db.GetUser(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "用户不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if u.Role == roleAdmin && !u.Disabled && len(store.Get().Auth.AdminTokens) == 0 {
+			if n, err := db.otherActiveAdmins(id); err != nil || n == 0 {
+				if err != nil {
+					slog.ErrorContext(r.Context(), "查询管理员失败", "err", err)
+					http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+					return
+				}
+				writeJSONError(w, http.StatusConflict, "不能删除最后一个管理员")
+				return
+			}
+		}
+		if _, err := db.DeleteUser(id); err != nil {
+			slog.ErrorContext(r.Context(), "删除用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "已删除用户", "id", id, "username", u.Username, "by", adminActor(r))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListAPIKeys 列出 API 密钥：管理员可列出所有密钥（?user_id= 筛选），其他用户只能列出自己的密钥
+func handleListAPIKeys(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		userID := p.UserID
+		if p.Has(roleAdmin) {
+			userID, _ = strconv.Atoi(r.URL.Query().Get("user_id"))
+		} else if userID == 0 {
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			return
+		}
+		keys, err := db.ListAPIKeys(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询API密钥失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// handleCreateAPIKey 为自己（管理员可通过 user_id 为其他用户）创建 API 密钥，
+// 请求体为 {"name": "...", "role": "viewer", "expires_in": "720h"}，完整密钥只在响应中出现一次
+func handleCreateAPIKey(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		var req struct {
+			UserID    int      `json:"user_id"`
+			Name      string   `json:"name"`
+			Role      string   `json:"role"`
+			ExpiresIn Duration `json:"expires_in"`
+		}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("无效的JSON数据: %v", err))
+			return
+		}
+		userID := p.UserID
+		if req.UserID != 0 && req.UserID != p.UserID {
+			if !p.Has(roleAdmin) {
+				http.Error(w, "权限不足，需要 admin 角色", http.StatusForbidden)
+				return
+			}
+			userID = req.UserID
+		}
+		if userID == 0 {
+			writeJSONError(w, http.StatusBadRequest, "当前身份不是用户账号，需指定 user_id")
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len([]rune(req.Name)) > 64 {
+			writeJSONError(w, http.StatusBadRequest, "name 不能为空且不超过 64 个字符")
+			return
+		}
+		if req.Role != "" && roleRanks[req.Role] == 0 {
+			writeJSONError(w, http.StatusBadRequest, "无效的角色: "+req.Role)
+			return
+		}
+		// 新密钥不能高于当前身份的角色：以角色受限的 API 密钥或 JWT 创建时默认沿用当前角色，
+		// 否则省略的角色会按所属用户的角色生效
+		if req.Role == "" && (p.Method == "api_key" || p.Method == "jwt") {
+			req.Role = p.Role
+		}
+		if req.Role != "" && !p.Has(req.Role) {
+			http.Error(w, "权限不足，不能创建高于当前角色（"+p.Role+"）的密钥", http.StatusForbidden)
+			return
+		}
+		if req.ExpiresIn < 0 {
+			writeJSONError(w, http.StatusBadRequest, "expires_in 不能为负数")
+			return
+		}
+		u, err := db.GetUser(userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "用户不存在")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "查询用户失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		k := &APIKey{UserID: u.ID, Username: u.Username, Name: req.Name, Role: req.Role}
+		if err := db.CreateAPIKey(k, time.Duration(req.ExpiresIn)); err != nil {
+			slog.ErrorContext(r.Context(), "创建API密钥失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		auditNote(r, "", "key "+k.Prefix+" for "+u.Username)
+		slog.InfoContext(r.Context(), "已创建API密钥", "id", k.ID, "prefix", k.Prefix, "user", u.Username, "by", p.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(k)
+	}
+}
+
+// handleDeleteAPIKey 吊销 API 密钥，非管理员只能吊销自己的密钥
+func handleDeleteAPIKey(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := requireRole(w, r, roleViewer)
+		if !ok {
+			return
+		}
+		owner := p.UserID
+		if p.Has(roleAdmin) {
+			owner = 0
+		} else if owner == 0 {
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			return
+		}
+		id, _ := strconv.Atoi(mux.Vars(r)["id"])
+		found, err := db.DeleteAPIKey(id, owner)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "删除API密钥失败", "err", err)
+			http.Error(w, "服务器内部错误", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "API密钥不存在")
+			return
+		}
+		slog.InfoContext(r.Context(), "已吊销API密钥", "id", id, "by", p.Name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// runUserCommand 直接在数据库中管理用户，用于创建第一个管理员或找回密码。密码从标准输入读取一行
+func runUserCommand(args []string) int {
+	fs := flag.NewFlagSet("user", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("GOUP_CONFIG"), "配置文件路径")
+	dsn := fs.String("dsn", "", "数据库连接字符串（默认取自配置文件或环境变量）")
+	role := fs.String("role", roleAdmin, "角色: viewer/operator/admin（add 时使用）")
+	groups := fs.String("groups", "", "限定可访问的分组 ID，逗号分隔（add 时使用）")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s user [参数] add|passwd|disable|enable <用户名>\n       %s user [参数] list\n", os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	action := fs.Arg(0)
+	if action != "list" && fs.NArg() != 2 || action == "list" && fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var overrides ConfigOverrides
+	if *dsn != "" {
+		overrides.DSN = dsn
+	}
+	cfg, err := LoadServerConfig(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 1
+	}
+	db, err := NewDatabase(cfg.Database.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "数据库连接失败: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	if err := db.CreateTable(); err != nil {
+		fmt.Fprintf(os.Stderr, "创建数据表失败: %v\n", err)
+		return 1
+	}
+
+	if action == "list" {
+		users, err := db.ListUsers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\t用户名\t角色\t分组\t来源\t状态")
+		for _, u := range users {
+			state := "启用"
+			if u.Disabled {
+				state = "停用"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Role, formatScope(u.Groups), u.Source, state)
+		}
+		tw.Flush()
+		return 0
+	}
+
+	username := fs.Arg(1)
+	u, _, err := db.userCredentials(username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 1
+	}
+	req := UserRequest{}
+	switch action {
+	case "add":
+		if u != nil {
+			fmt.Fprintf(os.Stderr, "错误: 用户已存在: %s\n", username)
+			return 1
+		}
+		scope := parseScope(*groups)
+		req = UserRequest{Username: &username, Role: role, Groups: &scope}
+		u = &User{}
+	case "passwd", "disable", "enable":
+		if u == nil {
+			fmt.Fprintf(os.Stderr, "错误: 用户不存在: %s\n", username)
+			return 1
+		}
+		disabled := action == "disable"
+		if action != "passwd" {
+			req.Disabled = &disabled
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+	if action == "add" || action == "passwd" {
+		fmt.Fprint(os.Stderr, "密码: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "\n读取密码失败: %v\n", err)
+			return 1
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			fmt.Fprintln(os.Stderr, "错误: 密码不能为空")
+			return 2
+		}
+		req.Password = &password
+	}
+	hash, err := req.validate(action == "add")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		return 2
+	}
+	req.apply(u)
+	if u.Role == roleAdmin && len(u.Groups) > 0 {
+		fmt.Fprintln(os.Stderr, "错误: admin 角色不能限定分组")
+		return 2
+	}
+	if err := db.SaveUser(u, hash); err != nil {
+		fmt.Fprintf(os.Stderr, "保存用户失败: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "已保存用户 %s（%s）\n", u.Username, u.Role)
+	return 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPIKeyExpiry(t *testing.T) {
+	db := testDatabase(t)
+	res, err := db.conn.Exec(`INSERT INTO users (username, role) VALUES (?, ?)`, "test-"+t.Name(), roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _ := res.LastInsertId()
+	t.Cleanup(func() {
+		db.conn.Exec(`DELETE FROM api_keys WHERE user_id = ?`, userID)
+		db.conn.Exec(`DELETE FROM users WHERE id = ?`, userID)
+	})
+
+	// 会话时区与驱动不一致时，一小时后过期的密钥仍然立即可用
+	k := &APIKey{UserID: int(userID), Name: "expiring"}
+	if err := db.CreateAPIKey(k, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	p, err := db.apiKeyPrincipal(k.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.KeyID != k.ID {
+		t.Fatalf("principal = %+v, want key %d", p, k.ID)
+	}
+
+	keys, err := db.ListAPIKeys(int(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ExpiresAt == nil {
+		t.Fatalf("keys = %+v, want one expiring key", keys)
+	}
+	if d := keys[0].ExpiresAt.Sub(time.Now().Add(time.Hour)); d < -time.Minute || d > time.Minute {
+		t.Fatalf("expires_at = %v, want about an hour from now", keys[0].ExpiresAt)
+	}
+
+	// 不过期的密钥 expires_at 为 NULL
+	forever := &APIKey{UserID: int(userID), Name: "forever"}
+	if err := db.CreateAPIKey(forever, 0); err != nil {
+		t.Fatal(err)
+	}
+	if forever.ExpiresAt != nil {
+		t.Fatalf("expires_at = %v, want nil", forever.ExpiresAt)
+	}
+	if p, err := db.apiKeyPrincipal(forever.Key); err != nil || p == nil {
+		t.Fatalf("principal = %+v, %v; want key %d", p, err, forever.ID)
+	}
+}
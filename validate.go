@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"goup-server/internal/semver"
+)
+
+// 上报字段的长度上限（字符数），与 client_info 的列宽一致；comment 为 TEXT，另行限制
+const (
+	maxReportFieldLen   = 255
+	maxReportCommentLen = 4096
+)
+
+// FieldErrors 未通过校验的上报字段，键为上报 JSON 中的字段名
+type FieldErrors map[string]string
+
+// reportField 上报中需要规范化的文本字段
+type reportField struct {
+	name string
+	ptr  *string
+	max  int
+}
+
+// normalizeClientInfo 规范化上报数据：去除首尾空白与控制字符，主机名做 Unicode NFKC 规范化，
+// MAC 统一为客户端 formatMacXXXX 的 xxxx.xxxx.xxxx 形式，IP 统一为标准写法。
+// 返回无法规范化的字段：无法识别的 MAC、IP 与版本号原样保留，超长的字段截断
+func normalizeClientInfo(info *ClientInfo) FieldErrors {
+	errs := FieldErrors{}
+	fields := []reportField{
+		{"Name", &info.Name, maxReportFieldLen},
+		{"CPU", &info.CPU, maxReportFieldLen},
+		{"RAM", &info.RAM, maxReportFieldLen},
+		{"Disk", &info.Disk, maxReportFieldLen},
+		{"SN", &info.SN, maxReportFieldLen},
+		{"MAC", &info.MAC, maxReportFieldLen},
+		{"IP", &info.IP, maxReportFieldLen},
+		{"up_ver", &info.UpVer, semver.MaxLength},
+		{"comment", &info.Comment, maxReportCommentLen},
+		{"Network", &info.Network, maxReportFieldLen},
+	}
+	for _, f := range fields {
+		*f.ptr = cleanReportText(*f.ptr, f.name == "comment")
+	}
+	// 全角字符、兼容字符与组合字符统一写法，避免同一主机名出现多种形式
+	info.Name = norm.NFKC.String(info.Name)
+
+	if info.MAC != "" {
+		if mac, err := normalizeMAC(info.MAC); err == nil {
+			info.MAC = mac
+		} else {
+			errs["MAC"] = "无效的 MAC 地址"
+		}
+	}
+	if info.IP != "" {
+		if addr, err := netip.ParseAddr(info.IP); err == nil {
+			info.IP = addr.Unmap().String()
+		} else {
+			errs["IP"] = "无效的 IP 地址"
+		}
+	}
+	if info.UpVer != "" {
+		if _, err := semver.Parse(info.UpVer); err != nil {
+			errs["up_ver"] = "无效的版本号"
+		}
+	}
+
+	for _, f := range fields {
+		if utf8.RuneCountInString(*f.ptr) > f.max {
+			if _, ok := errs[f.name]; !ok {
+				errs[f.name] = fmt.Sprintf("不能超过 %d 个字符", f.max)
+			}
+			*f.ptr = string([]rune(*f.ptr)[:f.max])
+		}
+	}
+	return errs
+}
+
+// cleanReportText 去除首尾空白与控制字符，multiline 为 true 时保留换行与制表符
+func cleanReportText(s string, multiline bool) string {
+	s = strings.Map(func(r rune) rune {
+		if multiline && (r == '\n' || r == '\t') {
+			return r
+		}
+		if unicode.IsControl(r) || r == '\uFEFF' {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// normalizeStoredMACs 将已保存的非标准写法的 MAC 统一为 xxxx.xxxx.xxxx，
+// 使规范化之后的上报仍能匹配到原有记录；无法识别的 MAC 保持不变。作为数据库迁移只执行一次
+func normalizeStoredMACs(db *Database) error {
+	rows, err := db.conn.Query(`SELECT id, mac FROM client_info WHERE mac != ''`)
+	if err != nil {
+		return fmt.Errorf("读取 MAC 地址失败: %v", err)
+	}
+	type change struct {
+		id  int
+		mac string
+	}
+	var changes []change
+	for rows.Next() {
+		var id int
+		var mac string
+		if err := rows.Scan(&id, &mac); err != nil {
+			rows.Close()
+			return fmt.Errorf("读取 MAC 地址失败: %v", err)
+		}
+		if canonical, err := normalizeMAC(mac); err == nil && canonical != mac {
+			changes = append(changes, change{id, canonical})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("读取 MAC 地址失败: %v", err)
+	}
+	for _, c := range changes {
+		if _, err := db.conn.Exec(`UPDATE client_info SET mac = ? WHERE id = ?`, c.mac, c.id); err != nil {
+			return fmt.Errorf("规范化 MAC 地址失败: %v", err)
+		}
+	}
+	if len(changes) > 0 {
+		slog.Info("已规范化客户端的 MAC 地址", "count", len(changes))
+	}
+	return nil
+}
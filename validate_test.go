@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeClientInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		in   ClientInfo
+		want ClientInfo
+		errs FieldErrors
+	}{
+		{
+			name: "colon mac",
+			in:   ClientInfo{MAC: "00:11:22:AA:BB:CC"},
+			want: ClientInfo{MAC: "0011.22aa.bbcc"},
+		},
+		{
+			name: "dash mac with spaces",
+			in:   ClientInfo{MAC: " 00-11-22-aa-bb-cc\r\n"},
+			want: ClientInfo{MAC: "0011.22aa.bbcc"},
+		},
+		{
+			name: "canonical mac",
+			in:   ClientInfo{MAC: "0011.22AA.BBCC"},
+			want: ClientInfo{MAC: "0011.22aa.bbcc"},
+		},
+		{
+			name: "invalid mac kept",
+			in:   ClientInfo{MAC: "00:11:22:zz"},
+			want: ClientInfo{MAC: "00:11:22:zz"},
+			errs: FieldErrors{"MAC": "无效的 MAC 地址"},
+		},
+		{
+			name: "ipv4-mapped ip",
+			in:   ClientInfo{IP: "::ffff:10.0.0.1"},
+			want: ClientInfo{IP: "10.0.0.1"},
+		},
+		{
+			name: "ipv6 ip",
+			in:   ClientInfo{IP: "2001:DB8:0:0::1"},
+			want: ClientInfo{IP: "2001:db8::1"},
+		},
+		{
+			name: "invalid ip kept",
+			in:   ClientInfo{IP: "10.0.0.300"},
+			want: ClientInfo{IP: "10.0.0.300"},
+			errs: FieldErrors{"IP": "无效的 IP 地址"},
+		},
+		{
+			name: "semver",
+			in:   ClientInfo{UpVer: " v1.4.0-rc.1 "},
+			want: ClientInfo{UpVer: "v1.4.0-rc.1"},
+		},
+		{
+			name: "invalid semver kept",
+			in:   ClientInfo{UpVer: "1.x"},
+			want: ClientInfo{UpVer: "1.x"},
+			errs: FieldErrors{"up_ver": "无效的版本号"},
+		},
+		{
+			name: "overlong semver truncated",
+			in:   ClientInfo{UpVer: strings.Repeat("1", 70)},
+			want: ClientInfo{UpVer: strings.Repeat("1", 64)},
+			errs: FieldErrors{"up_ver": "无效的版本号"},
+		},
+		{
+			name: "name nfkc and control characters",
+			in:   ClientInfo{Name: "\uFEFF ＰＣ－０１\x00\t"},
+			want: ClientInfo{Name: "PC-01"},
+		},
+		{
+			name: "comment keeps newlines and tabs",
+			in:   ClientInfo{Comment: "\n第一行\n\t第二行\x07 "},
+			want: ClientInfo{Comment: "第一行\n\t第二行"},
+		},
+		{
+			name: "overlong fields truncated by rune",
+			in:   ClientInfo{CPU: strings.Repeat("中", 300), Comment: strings.Repeat("a", 5000)},
+			want: ClientInfo{CPU: strings.Repeat("中", 255), Comment: strings.Repeat("a", 4096)},
+			errs: FieldErrors{"CPU": "不能超过 255 个字符", "comment": "不能超过 4096 个字符"},
+		},
+		{
+			name: "invalid and overlong mac reports invalid",
+			in:   ClientInfo{MAC: strings.Repeat("z", 300)},
+			want: ClientInfo{MAC: strings.Repeat("z", 255)},
+			errs: FieldErrors{"MAC": "无效的 MAC 地址"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tt.in
+			errs := normalizeClientInfo(&info)
+			if !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("info = %+v, want %+v", info, tt.want)
+			}
+			if tt.errs == nil {
+				tt.errs = FieldErrors{}
+			}
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("errs = %v, want %v", errs, tt.errs)
+			}
+		})
+	}
+}
+
+func TestCleanReportText(t *testing.T) {
+	tests := []struct {
+		in        string
+		multiline bool
+		want      string
+	}{
+		{"  desk-01  ", false, "desk-01"},
+		{"desk\x00-01\x1b", false, "desk-01"},
+		{"\uFEFFdesk-01", false, "desk-01"},
+		{"a\nb\tc", false, "abc"},
+		{"a\nb\tc", true, "a\nb\tc"},
+		{"a\r\nb", true, "a\nb"},
+		{"\n\n a \n", true, "a"},
+		{"主机 ", false, "主机"},
+		{"", true, ""},
+	}
+	for _, tt := range tests {
+		if got := cleanReportText(tt.in, tt.multiline); got != tt.want {
+			t.Errorf("cleanReportText(%q, %v) = %q, want %q", tt.in, tt.multiline, got, tt.want)
+		}
+	}
+}
+
+// postReport 向上报接口提交 body，返回响应
+func postReport(t *testing.T, db *Database, strict bool, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	cfg := defaultServerConfig()
+	cfg.Ingest.Strict = strict
+	store, err := NewConfigStore(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	h := handleClientData(db, store, metrics, NewIngestGuard(store, metrics))
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, ingestPath, strings.NewReader(body)))
+	return w
+}
+
+// invalidReport 含有无法规范化的 MAC 与 IP 的上报
+func invalidReport(sn string) string {
+	return `{"Name":"desk-01","SN":"` + sn + `","MAC":"not-a-mac","IP":"10.0.0.300","up_ver":"1.4.0"}`
+}
+
+func TestClientDataStrict(t *testing.T) {
+	// 严格模式在写入数据库之前拒绝，返回各字段的错误
+	w := postReport(t, nil, true, invalidReport("SN-1"))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("strict status = %d, want 422: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Status string      `json:"status"`
+		Errors FieldErrors `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode 422 body: %v", err)
+	}
+	want := FieldErrors{"MAC": "无效的 MAC 地址", "IP": "无效的 IP 地址"}
+	if resp.Status != "error" || !reflect.DeepEqual(resp.Errors, want) {
+		t.Fatalf("422 body = %+v, want errors %v", resp, want)
+	}
+}
+
+func TestClientDataLenient(t *testing.T) {
+	db := testDatabase(t)
+	sn := "SN-" + t.Name()
+
+	// 宽松模式原样保存无法规范化的字段
+	w := postReport(t, db, false, invalidReport(sn))
+	if w.Code != http.StatusOK {
+		t.Fatalf("lenient status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var id int
+	var mac, ip string
+	if err := db.conn.QueryRow(`SELECT id, mac, ip FROM client_info WHERE sn = ?`, sn).Scan(&id, &mac, &ip); err != nil {
+		t.Fatal(err)
+	}
+	cleanupClient(t, db, id)
+	if mac != "not-a-mac" || ip != "10.0.0.300" {
+		t.Fatalf("stored mac %q ip %q, want the reported values", mac, ip)
+	}
+}
@@ -0,0 +1,434 @@
+// 客户端资产面板：列表页 #/?q=&preset=&sort=，详情页 #/clients/{id}，分组 #/groups，登录 #/login
+(function () {
+  'use strict';
+
+  var app = document.getElementById('app');
+  var summary = document.getElementById('summary');
+  var userBox = document.getElementById('user');
+
+  // 筛选预设，参数与 /api/clients 一致
+  var presets = [
+    { id: 'all', label: '全部', params: {} },
+    { id: 'online', label: '在线', params: { status: 'online' } },
+    { id: 'offline', label: '离线', params: { status: 'offline' } },
+    { id: 'wifi', label: 'WIFI 客户端', params: { network: 'WIFI' } },
+    { id: 'ethernet', label: '有线客户端', params: { network: 'ETHERNET' } },
+    { id: 'outdated', label: '客户端版本过旧', params: { outdated: '1' } },
+    { id: 'archived', label: '已归档', params: { archived: 'only' } }
+  ];
+
+  var columns = [
+    { key: 'name', label: '主机名' },
+    { key: 'ip', label: 'IP', mono: true },
+    { key: 'mac', label: 'MAC', mono: true },
+    { key: 'sn', label: 'SN', mono: true },
+    { key: 'up_ver', label: '客户端版本' },
+    { key: 'network', label: '网络' },
+    { key: 'comment', label: '备注' },
+    { key: 'owner', label: '负责人', meta: true },
+    { key: 'location', label: '位置', meta: true },
+    { key: 'tags', label: '标签', meta: true, nosort: true },
+    { key: 'post_at', label: '最近上报' }
+  ];
+
+  // 按管理员元数据筛选的参数，与 /api/clients 同名，点击标签等链接时写入地址
+  var metaFilters = [
+    { key: 'owner', label: '负责人' },
+    { key: 'department', label: '部门' },
+    { key: 'location', label: '位置' },
+    { key: 'tag', label: '标签' },
+    { key: 'group', label: '分组' }
+  ];
+
+  var fieldLabels = {
+    name: '主机名', cpu: 'CPU', ram: '内存', disk: '硬盘', sn: 'SN', mac: 'MAC', ip: 'IP',
+    up_ver: '客户端版本', comment: '备注', network: '网络',
+    owner: '负责人', department: '部门', location: '位置', tags: '标签', notes: '管理备注'
+  };
+
+  function esc(s) {
+    return String(s == null ? '' : s).replace(/[&<>"']/g, function (c) {
+      return { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c];
+    });
+  }
+
+  function fmtTime(s) {
+    if (!s || s.indexOf('0001-') === 0) return '';
+    var d = new Date(s);
+    var pad = function (n) { return (n < 10 ? '0' : '') + n; };
+    return d.getFullYear() + '-' + pad(d.getMonth() + 1) + '-' + pad(d.getDate()) + ' ' +
+      pad(d.getHours()) + ':' + pad(d.getMinutes()) + ':' + pad(d.getSeconds());
+  }
+
+  function badge(online) {
+    return online ? '<span class="badge online">在线</span>' : '<span class="badge offline">离线</span>';
+  }
+
+  function getJSON(url) {
+    return fetch(url, { credentials: 'same-origin' }).then(function (resp) {
+      if (resp.status === 401) {
+        showLogin();
+        var err = new Error('需要登录');
+        err.login = true;
+        throw err;
+      }
+      if (!resp.ok) {
+        return resp.text().then(function (t) { throw new Error(resp.status + ' ' + t); });
+      }
+      return resp.json();
+    });
+  }
+
+  // 修改类请求需带 X-Requested-With，服务端据此确认请求来自本页面而不是跨站表单
+  function postJSON(url, body) {
+    return fetch(url, {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: { 'Content-Type': 'application/json', 'X-Requested-With': 'goup-dashboard' },
+      body: body == null ? null : JSON.stringify(body)
+    });
+  }
+
+  function showError(err) {
+    if (err.login) return;
+    app.innerHTML = '<div class="error-msg">加载失败：' + esc(err.message) + '</div>';
+  }
+
+  // 解析 location.hash 为路径与参数
+  function parseHash() {
+    var h = location.hash.replace(/^#/, '') || '/';
+    var i = h.indexOf('?');
+    return { path: i < 0 ? h : h.slice(0, i), params: new URLSearchParams(i < 0 ? '' : h.slice(i + 1)) };
+  }
+
+  function tagLinks(tags) {
+    return (tags || []).map(function (t) {
+      return '<a class="tag" href="#/?tag=' + encodeURIComponent(t) + '">' + esc(t) + '</a>';
+    }).join(' ');
+  }
+
+  function setListState(params) {
+    var s = params.toString();
+    location.hash = '#/' + (s ? '?' + s : '');
+  }
+
+  // ---- 列表页 ----
+
+  var searchTimer = null;
+
+  function renderList(params) {
+    var preset = presets.filter(function (p) { return p.id === params.get('preset'); })[0] || presets[0];
+    var sort = params.get('sort') || 'name';
+    var q = params.get('q') || '';
+
+    var api = new URLSearchParams(preset.params);
+    if (q) api.set('q', q);
+    metaFilters.forEach(function (f) {
+      params.getAll(f.key).forEach(function (v) { api.append(f.key, v); });
+    });
+    api.set('sort', sort);
+
+    var html = '<div class="toolbar">' +
+      '<input type="search" id="search" placeholder="搜索主机名、SN、MAC、IP、备注" value="' + esc(q) + '">';
+    presets.forEach(function (p) {
+      html += '<button class="preset' + (p === preset ? ' active' : '') + '" data-preset="' + p.id + '">' + esc(p.label) + '</button>';
+    });
+    // 按当前筛选条件导出
+    html += '<span style="flex:1"></span>';
+    ['csv', 'xlsx'].forEach(function (fmt) {
+      var p = new URLSearchParams(api);
+      p.set('format', fmt);
+      html += '<a class="preset" href="../api/export?' + esc(p.toString()) + '">导出 ' + fmt.toUpperCase() + '</a>';
+    });
+    html += '</div>';
+    // 当前生效的元数据筛选，点击移除
+    var chips = '';
+    metaFilters.forEach(function (f) {
+      params.getAll(f.key).forEach(function (v) {
+        chips += '<button class="preset active" data-filter="' + f.key + '" data-value="' + esc(v) + '">' +
+          esc(f.label) + '：' + esc(v) + ' ×</button> ';
+      });
+    });
+    if (chips) html += '<div class="toolbar">' + chips + '</div>';
+    html += '<div id="table">加载中…</div>';
+    app.innerHTML = html;
+
+    var search = document.getElementById('search');
+    search.addEventListener('input', function () {
+      clearTimeout(searchTimer);
+      searchTimer = setTimeout(function () {
+        if (search.value) params.set('q', search.value); else params.delete('q');
+        setListState(params);
+      }, 300);
+    });
+    if (q) {
+      search.focus();
+      search.setSelectionRange(q.length, q.length);
+    }
+    Array.prototype.forEach.call(app.querySelectorAll('[data-filter]'), function (b) {
+      b.addEventListener('click', function () {
+        var rest = params.getAll(b.dataset.filter).filter(function (v) { return v !== b.dataset.value; });
+        params.delete(b.dataset.filter);
+        rest.forEach(function (v) { params.append(b.dataset.filter, v); });
+        setListState(params);
+      });
+    });
+    Array.prototype.forEach.call(app.querySelectorAll('[data-preset]'), function (b) {
+      b.addEventListener('click', function () {
+        if (b.dataset.preset === 'all') params.delete('preset'); else params.set('preset', b.dataset.preset);
+        setListState(params);
+      });
+    });
+
+    getJSON('../api/clients?' + api.toString()).then(function (clients) {
+      renderTable(clients, params, sort);
+    }).catch(function (err) {
+      document.getElementById('table').innerHTML = '<div class="error-msg">加载失败：' + esc(err.message) + '</div>';
+    });
+  }
+
+  function renderTable(clients, params, sort) {
+    var desc = sort.charAt(0) === '-';
+    var key = desc ? sort.slice(1) : sort;
+    var online = clients.filter(function (c) { return c.online; }).length;
+    summary.textContent = '共 ' + clients.length + ' 台，在线 ' + online + ' 台';
+
+    var html = '<table><thead><tr><th>状态</th>';
+    columns.forEach(function (col) {
+      var arrow = col.key === key ? (desc ? '▼' : '▲') : '';
+      html += col.nosort ? '<th>' + esc(col.label) + '</th>' :
+        '<th class="sortable" data-key="' + col.key + '">' + esc(col.label) + '<span class="arrow">' + arrow + '</span></th>';
+    });
+    html += '</tr></thead><tbody>';
+    if (clients.length === 0) {
+      html += '<tr><td colspan="' + (columns.length + 1) + '" class="empty">没有符合条件的客户端</td></tr>';
+    }
+    clients.forEach(function (c) {
+      html += '<tr><td>' + badge(c.online) + '</td>';
+      columns.forEach(function (col) {
+        var v = col.key === 'post_at' ? fmtTime(c.post_at) : col.meta ? c.metadata[col.key] : c[col.key];
+        if (col.key === 'tags') {
+          v = tagLinks(v);
+        } else if (col.key === 'name') {
+          v = '<a href="#/clients/' + c.id + '">' + esc(v || '(未命名 #' + c.id + ')') + '</a>';
+        } else {
+          v = esc(v);
+        }
+        html += '<td' + (col.mono ? ' class="mono"' : '') + '>' + v + '</td>';
+      });
+      html += '</tr>';
+    });
+    html += '</tbody></table>';
+
+    var table = document.getElementById('table');
+    table.innerHTML = html;
+    Array.prototype.forEach.call(table.querySelectorAll('th.sortable'), function (th) {
+      th.addEventListener('click', function () {
+        var k = th.dataset.key;
+        params.set('sort', k === key && !desc ? '-' + k : k);
+        setListState(params);
+      });
+    });
+  }
+
+  // ---- 详情页 ----
+
+  function kvTable(rows) {
+    var html = '<table>';
+    rows.forEach(function (r) {
+      html += '<tr><th>' + esc(r[0]) + '</th><td' + (r[2] ? ' class="mono"' : '') + '>' + r[1] + '</td></tr>';
+    });
+    return html + '</table>';
+  }
+
+  function renderDetail(id) {
+    summary.textContent = '';
+    Promise.all([getJSON('../api/clients/' + id), getJSON('../api/clients/' + id + '/changes')]).then(function (res) {
+      var c = res[0], changes = res[1];
+      summary.textContent = c.name || '#' + c.id;
+
+      var html = '<p><a href="#/">← 返回列表</a></p><div class="grid">';
+      var archived = c.archived ? ' <span class="badge offline">已归档</span>' : '';
+      html += '<div class="card"><h2>' + esc(c.name || '(未命名)') + ' ' + badge(c.online) + archived + '</h2>' + kvTable([
+        ['ID', esc(c.id)],
+        ['IP', esc(c.ip), true],
+        ['MAC', esc(c.mac), true],
+        ['SN', esc(c.sn), true],
+        ['CPU', esc(c.cpu)],
+        ['内存', esc(c.ram)],
+        ['硬盘', esc(c.disk)],
+        ['网络', esc(c.network)],
+        ['客户端版本', esc(c.up_ver)],
+        ['备注', esc(c.comment)],
+        ['最近上报', esc(fmtTime(c.post_at))],
+        ['首次登记', esc(fmtTime(c.created_at))],
+        ['最近变更', esc(fmtTime(c.updated_at))]
+      ].concat(c.archived ? [
+        ['归档', esc(fmtTime(c.archived.archived_at)) + ' ' +
+          esc(c.archived.reason === 'inactive' ? '长期未上报，自动归档' : '由 ' + c.archived.archived_by + ' 归档')],
+        ['归档说明', esc(c.archived.note)]
+      ] : [])) + '</div>';
+
+      var m = c.metadata;
+      var filterLink = function (key, v) {
+        return v ? '<a href="#/?' + key + '=' + encodeURIComponent(v) + '">' + esc(v) + '</a>' : '';
+      };
+      html += '<div class="card"><h2>管理信息</h2>' + kvTable([
+        ['负责人', filterLink('owner', m.owner)],
+        ['部门', filterLink('department', m.department)],
+        ['位置', filterLink('location', m.location)],
+        ['标签', tagLinks(m.tags)],
+        ['管理备注', esc(m.notes).replace(/\n/g, '<br>')],
+        ['分组', (c.groups || []).map(function (g) {
+          return '<a class="tag" href="#/?group=' + g.id + '">' + esc(g.name) + '</a>';
+        }).join(' ')],
+        ['最近编辑', m.updated_by ? esc(fmtTime(m.updated_at)) + ' · ' + esc(m.updated_by) : '']
+      ]) + '</div>';
+
+      var attrs = Object.keys(c.attributes || {}).sort();
+      if (attrs.length) {
+        html += '<div class="card"><h2>资产属性</h2>' + kvTable(attrs.map(function (k) {
+          return [k, esc(c.attributes[k])];
+        })) + '</div>';
+      }
+
+      var facts = Object.keys(c.facts || {}).sort();
+      html += '<div class="card"><h2>自定义事实</h2>' + (facts.length ? kvTable(facts.map(function (k) {
+        return [k, esc(c.facts[k]), true];
+      })) : '<div class="empty">无</div>');
+
+      var names = Object.keys(c.collectors || {}).sort();
+      html += '<h2 style="margin-top:16px">采集器状态</h2>' + (names.length ? kvTable(names.map(function (n) {
+        var st = c.collectors[n];
+        return [n, '<span class="badge ' + esc(st.status) + '">' + esc(st.status) + '</span> ' +
+          esc(st.duration_ms) + ' ms ' + esc(st.error || '')];
+      })) : '<div class="empty">无</div>') + '</div>';
+      html += '</div>';
+
+      html += '<div class="card"><h2>变更记录</h2>' + renderTimeline(changes) + '</div>';
+      app.innerHTML = html;
+    }).catch(showError);
+  }
+
+  // renderTimeline 变更记录按时间倒序排列，列出每条记录相对同类上一条（上报或管理员编辑）变化的字段
+  function renderTimeline(changes) {
+    if (!changes.length) return '<div class="empty">暂无变更记录</div>';
+    var isMeta = function (ch) { return ch.change_type === 'metadata'; };
+    var html = '<ul class="timeline">';
+    changes.forEach(function (ch, i) {
+      var prev = changes.slice(i + 1).filter(function (p) { return isMeta(p) === isMeta(ch); })[0];
+      html += '<li class="' + esc(ch.change_type) + '"><div class="when">' + esc(fmtTime(ch.changed_at)) + ' · ' +
+        ({ insert: '首次登记', import: '导入登记', metadata: '管理员编辑' }[ch.change_type] || '更新') +
+        (ch.changed_by ? ' · ' + esc(ch.changed_by) : '') + '</div>';
+      if (!ch.changed.length) {
+        html += '<div class="empty">无字段变化</div></li>';
+        return;
+      }
+      html += '<table class="diff">';
+      ch.changed.forEach(function (f) {
+        var oldV = prev ? prev.fields[f] : '';
+        html += '<tr><th>' + esc(fieldLabels[f] || f) + '</th><td class="mono">' +
+          (oldV ? '<del>' + esc(oldV) + '</del> → ' : '') + '<ins>' + esc(ch.fields[f] || '(空)') + '</ins></td></tr>';
+      });
+      html += '</table></li>';
+    });
+    return html + '</ul>';
+  }
+
+  // ---- 分组 ----
+
+  function renderGroups() {
+    summary.textContent = '';
+    getJSON('../api/groups').then(function (groups) {
+      summary.textContent = '共 ' + groups.length + ' 个分组';
+      var html = '<table><thead><tr><th>名称</th><th>类型</th><th>规则</th><th>成员</th><th>说明</th></tr></thead><tbody>';
+      if (!groups.length) {
+        html += '<tr><td colspan="5" class="empty">暂无分组</td></tr>';
+      }
+      groups.forEach(function (g) {
+        html += '<tr><td><a href="#/?group=' + g.id + '">' + esc(g.name) + '</a></td><td>' +
+          (g.type === 'dynamic' ? '动态' : '静态') + '</td><td class="mono">' + esc(g.rule || '') + '</td><td>' +
+          esc(g.members) + '</td><td>' + esc(g.description || '') + '</td></tr>';
+      });
+      app.innerHTML = html + '</tbody></table>';
+    }).catch(showError);
+  }
+
+  // ---- 登录 ----
+
+  function showLogin() {
+    summary.textContent = '';
+    app.innerHTML = '<form class="login card"><h2>登录</h2>' +
+      '<label>用户名<input name="username" autocomplete="username" required></label>' +
+      '<label>密码<input name="password" type="password" autocomplete="current-password" required></label>' +
+      '<div class="error-msg" hidden></div><button type="submit">登录</button>' +
+      '<a class="sso" hidden>使用单点登录</a></form>';
+    var form = app.querySelector('form');
+    form.username.focus();
+    // 启用 OIDC 时显示单点登录入口，登录后返回当前页面
+    fetch('/api/auth/providers', { credentials: 'same-origin' }).then(function (resp) {
+      return resp.ok ? resp.json() : {};
+    }).then(function (p) {
+      if (!p.oidc) return;
+      var sso = form.querySelector('.sso');
+      var back = parseHash().path === '/login' ? '/' : '/' + location.hash;
+      sso.href = '/api/auth/oidc/login?return_to=' + encodeURIComponent(back);
+      sso.hidden = false;
+    });
+    form.addEventListener('submit', function (e) {
+      e.preventDefault();
+      var msg = form.querySelector('.error-msg');
+      postJSON('/api/auth/login', { username: form.username.value, password: form.password.value }).then(function (resp) {
+        if (!resp.ok) {
+          // 403（目录账号未分配角色等）与 503（目录服务不可用）显示服务端的说明
+          return resp.json().catch(function () { return {}; }).then(function (body) {
+            msg.textContent = resp.status === 401 ? '用户名或密码错误' : (body.message || '登录失败：' + resp.status);
+            msg.hidden = false;
+          });
+        }
+        loadUser();
+        route();
+      });
+    });
+  }
+
+  function loadUser() {
+    fetch('/api/auth/me', { credentials: 'same-origin' }).then(function (resp) {
+      return resp.ok ? resp.json() : null;
+    }).then(function (me) {
+      if (!me || me.method === 'anonymous') {
+        userBox.innerHTML = '<a href="#/login">登录</a>';
+        return;
+      }
+      userBox.innerHTML = esc(me.name) + '（' + esc(me.role) + '）' +
+        (me.method === 'session' ? ' <a href="#" id="logout">退出</a>' : '');
+      var out = document.getElementById('logout');
+      if (out) {
+        out.addEventListener('click', function (e) {
+          e.preventDefault();
+          postJSON('/api/auth/logout').then(function () { location.hash = '#/'; location.reload(); });
+        });
+      }
+    });
+  }
+
+  // ---- 路由 ----
+
+  function route() {
+    var h = parseHash();
+    var m = h.path.match(/^\/clients\/(\d+)$/);
+    if (m) {
+      renderDetail(m[1]);
+    } else if (h.path === '/groups') {
+      renderGroups();
+    } else if (h.path === '/login') {
+      showLogin();
+    } else {
+      renderList(h.params);
+    }
+  }
+
+  window.addEventListener('hashchange', route);
+  loadUser();
+  route();
+})();